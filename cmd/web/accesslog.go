@@ -0,0 +1,88 @@
+// Filename: cmd/web/accesslog.go
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/alexdev404/ws-main/internal/ws"
+)
+
+// statusRecorder remembers the status a handler sends. It passes Hijack
+// through, since the websocket upgrade takes over the connection and
+// writes its 101 there, out of the wrapper's sight.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int // 0 until the handler responds
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// A hijack is only asked for to switch protocols, so it counts as a 101
+func (w *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking not supported")
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil && w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+func (w *statusRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+// For http.ResponseController
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Log one line per request to logger once next is done with it: method,
+// path, status, duration, client address, Origin, User-Agent and, for an
+// upgrade, the subprotocol. A websocket's line comes when it closes, so its
+// duration is the connection's.
+func accessLog(next http.Handler, logger *log.Logger, trusted []netip.Prefix) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK // nothing written at all
+		}
+		upgrade := ""
+		if status == http.StatusSwitchingProtocols {
+			upgrade = fmt.Sprintf(" subprotocol=%q", rec.Header().Get("Sec-WebSocket-Protocol"))
+		}
+		logger.Printf("access method=%s path=%s status=%d duration=%s remote=%s origin=%q user_agent=%q%s",
+			r.Method, r.URL.Path, status, time.Since(start).Round(time.Microsecond),
+			ws.ClientAddr(r, trusted), r.Header.Get("Origin"), r.UserAgent(), upgrade)
+	})
+}
@@ -0,0 +1,91 @@
+// Filename: cmd/web/accesslog_test.go
+
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/alexdev404/ws-main/internal/ws"
+)
+
+// A bytes.Buffer the server's goroutines can log to while the test reads it
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// Wait for a logged line containing every one of parts
+func waitForLine(t *testing.T, out *lockedBuffer, parts ...string) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+	lines:
+		for _, line := range strings.Split(out.String(), "\n") {
+			for _, p := range parts {
+				if !strings.Contains(line, p) {
+					continue lines
+				}
+			}
+			return
+		}
+	}
+	t.Fatalf("no line with %q in:\n%s", parts, out.String())
+}
+
+func TestAccessLog(t *testing.T) {
+	var out lockedBuffer
+	h := ws.NewHandler(ws.Options{})
+	t.Cleanup(h.Close)
+	srv := httptest.NewServer(accessLog(routes(h, ""), log.New(&out, "", 0), nil))
+	t.Cleanup(srv.Close)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+
+	// The upgrade still works through the wrapper, and is logged as a 101 once it closes
+	dialer := websocket.Dialer{Subprotocols: []string{"commands.v1"}}
+	conn, _, err := dialer.Dial(url, http.Header{"Origin": {"http://localhost:4000"}, "User-Agent": {"access-test"}})
+	if err != nil {
+		t.Fatalf("dial through the access log: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := conn.ReadMessage(); err != nil { // welcome
+		t.Fatalf("read welcome: %v", err)
+	}
+	conn.Close()
+	waitForLine(t, &out, "method=GET path=/ws status=101", `origin="http://localhost:4000"`,
+		`user_agent="access-test"`, `subprotocol="commands.v1"`, "remote=127.0.0.1:")
+
+	// A refused origin gets a record of its own
+	_, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"http://evil.example"}})
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("bad origin: got %v, %v expected 403", resp, err)
+	}
+	waitForLine(t, &out, "path=/ws status=403", `origin="http://evil.example"`)
+
+	// So does a plain page
+	res, err := http.Get(srv.URL + "/test")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	res.Body.Close()
+	waitForLine(t, &out, "method=GET path=/test status=200")
+}
@@ -0,0 +1,66 @@
+// Filename: cmd/web/client.go
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+	"path"
+	"time"
+)
+
+// The browser test client: a page, its script and its styles
+//
+//go:embed client
+var clientFiles embed.FS
+
+// One embedded file, read once, with the ETag its content gets
+type clientFile struct {
+	data []byte
+	etag string
+}
+
+// Serve the test client: GET /client is the page, /client/{file} the rest.
+// Embedded files have no modification time, so each gets an ETag from its
+// content instead, and browsers are told to check it before reusing a copy.
+func clientRoutes(mux *http.ServeMux) {
+	files := make(map[string]clientFile)
+	err := fs.WalkDir(clientFiles, "client", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := clientFiles.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		files[path.Base(name)] = clientFile{data: data, etag: `"` + hex.EncodeToString(sum[:8]) + `"`}
+		return nil
+	})
+	if err != nil {
+		panic(err) // the files are compiled in
+	}
+
+	serve := func(w http.ResponseWriter, r *http.Request, name string) {
+		f, ok := files[name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("ETag", f.etag)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		// Content-Type comes from the extension
+		http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(f.data))
+	}
+	mux.HandleFunc("GET /client", func(w http.ResponseWriter, r *http.Request) {
+		serve(w, r, "index.html")
+	})
+	mux.HandleFunc("GET /client/{file}", func(w http.ResponseWriter, r *http.Request) {
+		serve(w, r, r.PathValue("file"))
+	})
+}
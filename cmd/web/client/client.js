@@ -0,0 +1,174 @@
+// Test client for ws-main, served at /client. It talks to /ws on whatever
+// host and port the page came from, so the origin allowlist applies as it
+// does to any page served here.
+"use strict";
+
+(() => {
+  const $ = (id) => document.getElementById(id);
+  const logEl = $("log");
+  const stateEl = $("state");
+  const urlEl = $("url");
+  const connectBtn = $("connect");
+  const disconnectBtn = $("disconnect");
+
+  // The basics, until help says what the server has
+  const fallbackCommands = ["add", "subtract", "multiply", "divide"].map((name) => ({
+    name,
+    params: ["a", "b"],
+    description: "",
+  }));
+
+  let socket = null;
+
+  urlEl.value = (location.protocol === "https:" ? "wss://" : "ws://") + location.host + "/ws";
+
+  const timestamp = () => {
+    const d = new Date();
+    return d.toLocaleTimeString([], { hour12: false }) + "." + String(d.getMilliseconds()).padStart(3, "0");
+  };
+
+  // kind is "in", "out" or "state"
+  const log = (kind, text) => {
+    const arrow = { in: "<-", out: "->", state: "--" }[kind];
+    const li = document.createElement("li");
+    li.className = kind;
+    li.textContent = `${timestamp()} ${arrow} ${text}`;
+    const atBottom = logEl.scrollTop + logEl.clientHeight >= logEl.scrollHeight - 4;
+    logEl.appendChild(li);
+    if (atBottom) {
+      logEl.scrollTop = logEl.scrollHeight;
+    }
+  };
+
+  const setState = (state, detail) => {
+    stateEl.className = "state " + state;
+    stateEl.textContent = detail ? `${state} (${detail})` : state;
+    connectBtn.disabled = state !== "closed";
+    disconnectBtn.disabled = state === "closed";
+  };
+
+  const send = (text) => {
+    if (!socket || socket.readyState !== WebSocket.OPEN) {
+      log("state", "not connected");
+      return;
+    }
+    socket.send(text);
+    log("out", text);
+  };
+
+  // A field's value as JSON would have it: numbers and booleans as such,
+  // anything else as a string
+  const fieldValue = (raw) => {
+    if (raw === "true" || raw === "false") {
+      return raw === "true";
+    }
+    if (raw.trim() !== "" && !Number.isNaN(Number(raw))) {
+      return Number(raw);
+    }
+    return raw;
+  };
+
+  const renderCommands = (commands) => {
+    const box = $("commands");
+    box.replaceChildren();
+    for (const cmd of commands) {
+      const form = document.createElement("form");
+      form.className = "command";
+      form.title = cmd.description || "";
+
+      const button = document.createElement("button");
+      button.type = "submit";
+      button.textContent = cmd.name;
+      form.appendChild(button);
+
+      const inputs = (cmd.params || []).map((param) => {
+        const input = document.createElement("input");
+        input.name = param;
+        input.placeholder = param;
+        input.autocomplete = "off";
+        form.appendChild(input);
+        return input;
+      });
+
+      form.addEventListener("submit", (event) => {
+        event.preventDefault();
+        const request = { command: cmd.name };
+        for (const input of inputs) {
+          if (input.value !== "") {
+            request[input.name] = fieldValue(input.value);
+          }
+        }
+        send(JSON.stringify(request));
+      });
+      box.appendChild(form);
+    }
+  };
+
+  // The first help response, if any, replaces the fallback buttons
+  let helpPending = false;
+  const maybeHelp = (data) => {
+    if (!helpPending) {
+      return;
+    }
+    let frame;
+    try {
+      frame = JSON.parse(data);
+    } catch {
+      return;
+    }
+    if (frame && frame.command === "help" && frame.data && Array.isArray(frame.data.commands)) {
+      helpPending = false;
+      renderCommands(frame.data.commands);
+    }
+  };
+
+  const connect = () => {
+    const url = urlEl.value;
+    setState("connecting");
+    log("state", `connecting to ${url}`);
+    socket = new WebSocket(url);
+
+    socket.onopen = () => {
+      setState("open");
+      log("state", "open");
+      helpPending = true;
+      send(JSON.stringify({ command: "help" }));
+    };
+    socket.onmessage = (event) => {
+      if (typeof event.data === "string") {
+        log("in", event.data);
+        maybeHelp(event.data);
+      } else {
+        log("in", `[binary frame, ${event.data.size} bytes]`);
+      }
+    };
+    socket.onerror = () => {
+      log("state", "error (the close that follows has the code)");
+    };
+    socket.onclose = (event) => {
+      const detail = event.reason ? `${event.code} ${event.reason}` : String(event.code);
+      setState("closed", detail);
+      log("state", `closed: ${detail}${event.wasClean ? "" : ", not cleanly"}`);
+      socket = null;
+    };
+  };
+
+  connectBtn.addEventListener("click", connect);
+  disconnectBtn.addEventListener("click", () => {
+    if (socket) {
+      socket.close(1000, "bye");
+    }
+  });
+  $("raw-form").addEventListener("submit", (event) => {
+    event.preventDefault();
+    const input = $("raw-text");
+    if (input.value !== "") {
+      send(input.value);
+      input.value = "";
+    }
+  });
+  $("clear").addEventListener("click", () => logEl.replaceChildren());
+
+  renderCommands(fallbackCommands);
+  setState("closed");
+})();
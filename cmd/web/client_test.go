@@ -0,0 +1,83 @@
+// Filename: cmd/web/client_test.go
+
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClientServed(t *testing.T) {
+	mux := http.NewServeMux()
+	clientRoutes(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	get := func(path string, header http.Header) (*http.Response, string) {
+		t.Helper()
+		req, _ := http.NewRequest("GET", srv.URL+path, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		return res, string(body)
+	}
+
+	res, page := get("/client", nil)
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/html; charset=utf-8" ||
+		!strings.Contains(page, "<title>ws-main test client</title>") || !strings.Contains(page, `src="/client/client.js"`) {
+		t.Fatalf("/client: got %d %q\n%s", res.StatusCode, res.Header.Get("Content-Type"), page)
+	}
+	etag := res.Header.Get("ETag")
+	if etag == "" || res.Header.Get("Cache-Control") != "no-cache" {
+		t.Errorf("caching headers: got %v", res.Header)
+	}
+	if res, _ := get("/client", http.Header{"If-None-Match": {etag}}); res.StatusCode != http.StatusNotModified {
+		t.Errorf("revalidation: got %d expected 304", res.StatusCode)
+	}
+
+	// The script connects back to wherever the page came from
+	res, script := get("/client/client.js", nil)
+	if res.StatusCode != http.StatusOK || !strings.HasPrefix(res.Header.Get("Content-Type"), "text/javascript") ||
+		!strings.Contains(script, `location.host + "/ws"`) || strings.Contains(script, ":4000") {
+		t.Errorf("client.js: got %d %q", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+	if res, _ := get("/client/client.css", nil); !strings.HasPrefix(res.Header.Get("Content-Type"), "text/css") {
+		t.Errorf("client.css: got %q", res.Header.Get("Content-Type"))
+	}
+	if res, _ := get("/client/main.go", nil); res.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown file: got %d expected 404", res.StatusCode)
+	}
+}
+
+func TestClientFlag(t *testing.T) {
+	for _, tt := range []struct {
+		args     []string
+		expected int
+	}{
+		{nil, http.StatusOK},
+		{[]string{"--client=false"}, http.StatusNotFound},
+	} {
+		ctx, cancel := context.WithCancel(context.Background())
+		s, done := startServer(t, ctx, tt.args...)
+		res, err := http.Get("http://" + s.Addr().String() + "/client")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tt.expected {
+			t.Errorf("%v: got %d expected %d", tt.args, res.StatusCode, tt.expected)
+		}
+		cancel()
+		<-done
+	}
+}
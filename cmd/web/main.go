@@ -3,22 +3,304 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
+	"net/netip"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/alexdev404/ws-main/internal/ws"
 )
 
+// Command-line settings
+type config struct {
+	addr           string
+	httpAddr       string
+	redirectHTTP   bool
+	tlsCert        string
+	tlsKey         string
+	autocertDomain string
+	autocertCache  string
+	ticks          bool
+	tickInterval   time.Duration
+	adminToken     string
+	connectHook    string
+	disconnectHook string
+	webhookSecret  string
+	redisAddr      string
+	auditDB        string
+	auditCap       int
+	counterFile    string
+	banFile        string
+	drainDelay     time.Duration
+	usageInterval  time.Duration
+	messageQuota   int
+	bandwidthLimit int64
+	jsonDepth      int
+	jsonBan        time.Duration
+	idleTimeout    time.Duration
+	slowGrace      time.Duration
+	slowPolicy     string
+	uploadDir      string
+	maxUpload      int64
+	maxMessage     int64
+	maxReadLimit   int64
+	streamAbove    int64
+	trustedProxies []netip.Prefix
+	allowIPs       []netip.Prefix
+	metadataKeys   []string
+	strictQuery    bool
+	whoAddrs       bool
+	logCommands    bool
+	requireFields  bool
+	denyIPs        []netip.Prefix
+	originPolicies map[string]ws.OriginPolicy
+	configFile     string
+	tenants        map[string]ws.TenantConfig
+	chaos          *ws.ChaosConfig
+	client         bool
+	debugDump      int
+	otlpEndpoint   string
+
+	messageLog         string
+	messageLogMaxBytes int64
+	messageLogFiles    int
+	messageLogPayload  int
+}
+
+func (cfg config) tlsEnabled() bool {
+	return cfg.tlsCert != "" || cfg.autocertDomain != ""
+}
+
+func parseFlags(args []string) (config, error) {
+	var cfg config
+	fs := flag.NewFlagSet("web", flag.ContinueOnError)
+	fs.StringVar(&cfg.addr, "addr", ":4000", "listen address")
+	fs.StringVar(&cfg.httpAddr, "http-addr", "", "also serve plain HTTP on this address while --addr serves TLS")
+	fs.BoolVar(&cfg.redirectHTTP, "redirect-http", false, "on --http-addr serve only /healthz and redirect everything else to https")
+	fs.StringVar(&cfg.tlsCert, "tls-cert", "", "TLS certificate file (requires --tls-key)")
+	fs.StringVar(&cfg.tlsKey, "tls-key", "", "TLS private key file (requires --tls-cert)")
+	fs.StringVar(&cfg.autocertDomain, "autocert-domain", "", "get certificates for this domain from Let's Encrypt")
+	fs.StringVar(&cfg.autocertCache, "autocert-cache", "autocert-cache", "directory for autocert certificates")
+	fs.BoolVar(&cfg.ticks, "ticks", false, "broadcast a tick frame to every client periodically")
+	fs.DurationVar(&cfg.tickInterval, "tick-interval", 30*time.Second, "time between tick broadcasts")
+	fs.StringVar(&cfg.adminToken, "admin-token", os.Getenv("WS_ADMIN_TOKEN"), "bearer token for /admin/ and /ws/admin (default $WS_ADMIN_TOKEN); empty disables it")
+	fs.StringVar(&cfg.connectHook, "connect-webhook", "", "URL to POST to when a client connects")
+	fs.StringVar(&cfg.disconnectHook, "disconnect-webhook", "", "URL to POST to when a client disconnects")
+	fs.StringVar(&cfg.webhookSecret, "webhook-secret", os.Getenv("WS_WEBHOOK_SECRET"), "HMAC key for signing webhook bodies (default $WS_WEBHOOK_SECRET)")
+	fs.StringVar(&cfg.redisAddr, "redis-addr", os.Getenv("WS_REDIS_ADDR"), "Redis address for relaying broadcasts between instances (default $WS_REDIS_ADDR)")
+	fs.StringVar(&cfg.auditDB, "audit-db", "", "record every message in this SQLite database")
+	fs.IntVar(&cfg.auditCap, "audit-payload-cap", 1024, "bytes of each message kept in the audit log")
+	fs.Func("trusted-proxies", "comma-separated CIDRs of proxies whose X-Forwarded-For is believed", func(s string) error {
+		var err error
+		cfg.trustedProxies, err = ws.ParseIPList(s)
+		return err
+	})
+	fs.Func("allow-ips", "comma-separated CIDRs allowed to connect to /ws; empty allows all", func(s string) error {
+		var err error
+		cfg.allowIPs, err = ws.ParseIPList(s)
+		return err
+	})
+	fs.Func("deny-ips", "comma-separated CIDRs refused at /ws, even if allowed", func(s string) error {
+		var err error
+		cfg.denyIPs, err = ws.ParseIPList(s)
+		return err
+	})
+	fs.Func("metadata-keys", "comma-separated /ws query parameters kept as connection metadata", func(s string) error {
+		cfg.metadataKeys = strings.Split(s, ",")
+		return nil
+	})
+	fs.BoolVar(&cfg.strictQuery, "strict-query", false, "refuse /ws upgrades with query parameters that aren't understood or in --metadata-keys")
+	fs.BoolVar(&cfg.logCommands, "log-commands", false, "log every command with its connection, duration and error code")
+	fs.BoolVar(&cfg.requireFields, "require-fields", false, "refuse built-in commands missing a field they need with ERR_MISSING_FIELD before they run")
+	fs.Func("origin-policies", "JSON file of per-origin limits, keyed by origin pattern (https://*.example.com)", func(s string) error {
+		data, err := os.ReadFile(s)
+		if err != nil {
+			return err
+		}
+		cfg.originPolicies, err = ws.ParseOriginPolicies(data)
+		return err
+	})
+	fs.IntVar(&cfg.debugDump, "debug-dump-bytes", 256, "bytes of each frame logged for a connection in debug mode")
+	fs.BoolVar(&cfg.client, "client", true, "serve the browser test client at /client; --client=false turns it off in production")
+	fs.Func("tenants", "JSON file of tenants served at /ws/{tenant}, each with its own origins, limits and auth_token", func(s string) error {
+		var err error
+		cfg.tenants, err = ws.LoadTenants(s)
+		return err
+	})
+	fs.Func("chaos", "JSON file of faults to inject into some connections, for testing clients; never set it in production", func(s string) error {
+		var err error
+		cfg.chaos, err = ws.LoadChaos(s)
+		return err
+	})
+	fs.StringVar(&cfg.configFile, "config", "", "JSON file of allowed_origins and origin_policies, reread on SIGHUP and POST /admin/reload")
+	fs.StringVar(&cfg.otlpEndpoint, "otlp-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "OTLP/HTTP collector URL to send traces to (default $OTEL_EXPORTER_OTLP_ENDPOINT); empty disables tracing")
+	fs.DurationVar(&cfg.idleTimeout, "idle-timeout", 0, "close connections that send no data for this long (e.g. 10m), pongs or not; 0 disables")
+	fs.DurationVar(&cfg.slowGrace, "slow-consumer-grace", 5*time.Second, "how long a client's outbound queue may stay full")
+	fs.StringVar(&cfg.slowPolicy, "slow-consumer-policy", ws.SlowConsumerDisconnect, `what to do then: "disconnect" or "drop-oldest"`)
+	fs.StringVar(&cfg.uploadDir, "upload-dir", "", `accept "upload" commands and write the files here; empty disables uploads`)
+	fs.Int64Var(&cfg.maxUpload, "max-upload-size", 10<<20, "largest upload accepted, in bytes")
+	fs.Int64Var(&cfg.maxMessage, "max-message-size", 4<<10, "largest message a client may send, in bytes, unless its origin policy says otherwise")
+	fs.Int64Var(&cfg.maxReadLimit, "max-read-limit", 1<<20, "how far a client may raise its own message size limit with set_limit, in bytes")
+	fs.Int64Var(&cfg.streamAbove, "stream-threshold", 64<<10, "handle text messages longer than this, in bytes, as they are read instead of reading them whole first")
+	fs.IntVar(&cfg.messageQuota, "message-quota", 0, "close a connection with 1008 after this many messages; 0 is unlimited")
+	fs.Int64Var(&cfg.bandwidthLimit, "bandwidth-limit", 0, "bytes a connection may send per minute, unless its origin policy says otherwise; 0 is unlimited")
+	fs.BoolVar(&cfg.whoAddrs, "who-remote-addrs", false, `list each client's remote address in "who" replies; off keeps addresses to the admin API`)
+	fs.IntVar(&cfg.jsonDepth, "max-json-depth", 32, "how deeply a JSON command may nest objects and arrays")
+	fs.DurationVar(&cfg.jsonBan, "json-violation-ban", 0, "ban the address of a client closed for repeatedly breaking the JSON limits for this long (e.g. 1h); 0 only closes it")
+	fs.DurationVar(&cfg.drainDelay, "drain-delay", 0, "on shutdown, report not ready for this long before closing the listener")
+	fs.DurationVar(&cfg.usageInterval, "usage-log-interval", 0, "log per-command counts and durations this often (e.g. 1m); 0 disables")
+	fs.StringVar(&cfg.counterFile, "counter-file", "", "keep the global message number in this file across restarts")
+	fs.StringVar(&cfg.banFile, "ban-file", "", "keep the bans made through /admin/bans in this file across restarts")
+	fs.StringVar(&cfg.messageLog, "message-log", "", "write every message as JSON lines to this file")
+	fs.Int64Var(&cfg.messageLogMaxBytes, "message-log-max-bytes", 10<<20, "rotate the message log at this size")
+	fs.IntVar(&cfg.messageLogFiles, "message-log-files", 5, "rotated message logs to keep")
+	fs.IntVar(&cfg.messageLogPayload, "message-log-payload", 1024, "bytes of each message kept in the message log")
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	if (cfg.tlsCert == "") != (cfg.tlsKey == "") {
+		return cfg, errors.New("--tls-cert and --tls-key must be given together")
+	}
+	if cfg.tlsCert != "" && cfg.autocertDomain != "" {
+		return cfg, errors.New("--autocert-domain cannot be combined with --tls-cert/--tls-key")
+	}
+	if cfg.httpAddr != "" && !cfg.tlsEnabled() {
+		return cfg, errors.New("--http-addr needs --tls-cert/--tls-key or --autocert-domain; --addr already serves plain HTTP")
+	}
+	if cfg.redirectHTTP && cfg.httpAddr == "" {
+		return cfg, errors.New("--redirect-http needs --http-addr")
+	}
+	if cfg.slowPolicy != ws.SlowConsumerDisconnect && cfg.slowPolicy != ws.SlowConsumerDropOldest {
+		return cfg, fmt.Errorf("--slow-consumer-policy must be %q or %q", ws.SlowConsumerDisconnect, ws.SlowConsumerDropOldest)
+	}
+	if cfg.configFile != "" {
+		if cfg.originPolicies != nil {
+			return cfg, errors.New("--origin-policies cannot be combined with --config; put them in its origin_policies")
+		}
+		// Reloads keep the old settings on a bad file, but there are none yet
+		if _, err := ws.LoadOriginConfig(cfg.configFile); err != nil {
+			return cfg, err
+		}
+	}
+	return cfg, nil
+}
+
+// TLS 1.2+ with forward-secret AEAD suites only. TLS 1.3 suites aren't
+// configurable and are already modern.
+func newTLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+	}
+}
+
+// Pages served over https have https origins
+func httpsOrigins(origins []string) []string {
+	out := append([]string(nil), origins...)
+	for _, o := range origins {
+		if rest, ok := strings.CutPrefix(o, "http://"); ok {
+			out = append(out, "https://"+rest)
+		}
+	}
+	return out
+}
+
 func handlerHome(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("WebSockets!\n"))
 }
 
-func main() {
+// The admin API, event stream, /notify and /send are only served when
+// adminToken is set
+func routes(wsHandler *ws.Handler, adminToken string) *http.ServeMux {
 	mux := http.NewServeMux()
 	mux.Handle("/", http.FileServer(http.Dir("./web")))
 	mux.HandleFunc("/test", handlerHome)
-	mux.HandleFunc("/ws", ws.HandleWebSocket)
-	log.Print("Starting server on :4000")
-	err := http.ListenAndServe(":4000", mux)
-	log.Fatal(err)
+	mux.HandleFunc("GET /healthz", wsHandler.Healthz)
+	mux.HandleFunc("GET /readyz", wsHandler.Readyz)
+	mux.HandleFunc("GET /version", wsHandler.ServeVersion)
+	mux.Handle("/ws", wsHandler)
+	mux.Handle("GET /events", wsHandler.SSEHandler())
+	if adminToken != "" {
+		mux.Handle("/admin/", wsHandler.AdminHandler(adminToken))
+		mux.Handle("/ws/admin", wsHandler.AdminStream(adminToken))
+		mux.Handle("POST /notify", wsHandler.NotifyHandler(adminToken))
+		push := wsHandler.PushHandler(adminToken)
+		mux.Handle("/send", push)
+		mux.Handle("/send/", push)
+	}
+	return mux
+}
+
+// Serve each tenant at /ws/{tenant}, its /events, /notify and /send under
+// /tenants/{tenant}/, and its admin API under /admin/tenants/ when
+// adminToken is set. The unprefixed /events, /notify and /send are the
+// default handler's.
+func tenantRoutes(mux *http.ServeMux, tenants *ws.Tenants, adminToken string) {
+	mux.Handle("/ws/{tenant}", tenants)
+	for _, name := range tenants.Names() {
+		h, prefix := tenants.Handler(name), "/tenants/"+name
+		mux.Handle("GET "+prefix+"/events", http.StripPrefix(prefix, h.SSEHandler()))
+		if adminToken != "" {
+			mux.Handle("POST "+prefix+"/notify", http.StripPrefix(prefix, h.NotifyHandler(adminToken)))
+			push := http.StripPrefix(prefix, h.PushHandler(adminToken))
+			mux.Handle(prefix+"/send", push)
+			mux.Handle(prefix+"/send/", push)
+		}
+	}
+	if adminToken != "" {
+		admin := tenants.AdminHandler(adminToken)
+		mux.Handle("/admin/tenants", admin)
+		mux.Handle("/admin/tenants/", admin)
+	}
+}
+
+// Reread the config file each time the process gets SIGHUP. Reload logs
+// its own failures.
+func reloadOnHangup(h *ws.Handler) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			_ = h.Reload()
+		}
+	}()
+}
+
+func main() {
+	cfg, err := parseFlags(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("ws-main %s", ws.CurrentBuild())
+	srv, err := NewServer(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if cfg.configFile != "" {
+		reloadOnHangup(srv.handler)
+	}
+	if err := srv.Run(ctx); err != nil {
+		log.Print(err)
+		stop()
+		os.Exit(1)
+	}
 }
@@ -3,9 +3,17 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/alexdev404/ws-main/internal/ws"
 )
 
 func TestHandlerWS(t *testing.T) {
@@ -21,4 +29,73 @@ func TestHandlerWS(t *testing.T) {
 	if got := rr.Body.String(); got != expected {
 		t.Errorf("handler returned unexpected body: got %q expected %q", got, expected)
 	}
-}
\ No newline at end of file
+}
+func TestParseFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+		wantTLS bool
+	}{
+		{"plain", nil, false, false},
+		{"cert and key", []string{"--tls-cert", "c.pem", "--tls-key", "k.pem"}, false, true},
+		{"cert only", []string{"--tls-cert", "c.pem"}, true, false},
+		{"key only", []string{"--tls-key", "k.pem"}, true, false},
+		{"autocert", []string{"--autocert-domain", "example.com"}, false, true},
+		{"autocert and cert", []string{"--autocert-domain", "example.com", "--tls-cert", "c.pem", "--tls-key", "k.pem"}, true, false},
+		{"trusted proxies", []string{"--trusted-proxies", "10.0.0.0/8, 192.168.1.1,::1"}, false, false},
+		{"bad trusted proxy", []string{"--trusted-proxies", "10.0.0.0/33"}, true, false},
+		{"ip lists", []string{"--allow-ips", "10.0.0.0/8,fd00::/8", "--deny-ips", "10.6.6.6"}, false, false},
+		{"bad deny list", []string{"--deny-ips", "10.6.6"}, true, false},
+		{"drop-oldest", []string{"--slow-consumer-policy", "drop-oldest"}, false, false},
+		{"bad slow consumer policy", []string{"--slow-consumer-policy", "ignore"}, true, false},
+		{"missing config", []string{"--config", "no-such-file.json"}, true, false},
+		{"http and https", []string{"--tls-cert", "c.pem", "--tls-key", "k.pem", "--http-addr", ":8080", "--redirect-http"}, false, true},
+		{"http-addr without tls", []string{"--http-addr", ":8080"}, true, false},
+		{"redirect without http-addr", []string{"--tls-cert", "c.pem", "--tls-key", "k.pem", "--redirect-http"}, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := parseFlags(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got err %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && cfg.tlsEnabled() != tt.wantTLS {
+				t.Errorf("tlsEnabled: got %v expected %v", cfg.tlsEnabled(), tt.wantTLS)
+			}
+		})
+	}
+}
+
+func TestWSSWithTLSConfig(t *testing.T) {
+	opts := ws.DefaultOptions()
+	opts.AllowedOrigins = httpsOrigins(opts.AllowedOrigins)
+
+	srv := httptest.NewUnstartedServer(routes(ws.NewHandler(opts), ""))
+	srv.TLS = newTLSConfig()
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	d := &websocket.Dialer{TLSClientConfig: &tls.Config{RootCAs: roots, MaxVersion: tls.VersionTLS12}}
+
+	url := "wss" + strings.TrimPrefix(srv.URL, "https") + "/ws"
+	conn, _, err := d.Dial(url, http.Header{"Origin": {"https://localhost:4000"}})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := conn.ReadMessage(); err != nil { // welcome
+		t.Fatalf("read welcome: %v", err)
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("hello")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, reply, err := conn.ReadMessage(); err != nil || !strings.HasSuffix(string(reply), "] hello") {
+		t.Errorf("got %q, %v", reply, err)
+	}
+}
@@ -0,0 +1,360 @@
+// Filename: cmd/web/server.go
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/alexdev404/ws-main/internal/ws"
+)
+
+// How long Shutdown waits for in-flight HTTP requests
+const defaultShutdownTimeout = 10 * time.Second
+
+// Server is the web process: the websocket handler and the rest of the
+// routes behind one http.Server (two with --http-addr or --autocert-domain),
+// plus the sinks to flush once it stops
+type Server struct {
+	cfg        config
+	handler    *ws.Handler
+	tenants    *ws.Tenants // nil without --tenants
+	routes     http.Handler
+	plain      http.Handler             // served on --http-addr; nil without it
+	acme       *autocert.Manager        // nil without --autocert-domain
+	messageLog *ws.FileLogger           // nil without --message-log
+	audit      *ws.AuditLog             // nil without --audit-db
+	tracing    *sdktrace.TracerProvider // nil without --otlp-endpoint
+
+	// ReadHeaderTimeout bounds reading a request's headers; the websocket
+	// handler may lower it to its handshake timeout
+	ReadHeaderTimeout time.Duration
+	// ShutdownTimeout bounds waiting for in-flight HTTP requests on the way out
+	ShutdownTimeout time.Duration
+
+	listening chan struct{} // closed once addr and httpAddr are set
+	addr      net.Addr
+	httpAddr  net.Addr
+}
+
+// NewServer opens the sinks cfg asks for and builds the websocket handler.
+// Nothing listens until Run.
+func NewServer(cfg config) (*Server, error) {
+	opts := ws.DefaultOptions()
+	if cfg.tlsEnabled() {
+		opts.AllowedOrigins = httpsOrigins(opts.AllowedOrigins)
+	}
+	opts.Ticks, opts.TickInterval = cfg.ticks, cfg.tickInterval
+	opts.RedisAddr = cfg.redisAddr
+	opts.CounterFile = cfg.counterFile
+	opts.BanFile = cfg.banFile
+	opts.MessageQuota, opts.BandwidthLimit = cfg.messageQuota, cfg.bandwidthLimit
+	opts.UploadDir, opts.MaxUploadSize = cfg.uploadDir, cfg.maxUpload
+	opts.MaxMessageSize, opts.MaxReadLimit = cfg.maxMessage, cfg.maxReadLimit
+	opts.StreamThreshold = cfg.streamAbove
+	opts.MaxJSONDepth, opts.JSONViolationBan = cfg.jsonDepth, cfg.jsonBan
+	opts.IdleTimeout = cfg.idleTimeout
+	opts.SlowConsumerGrace, opts.SlowConsumerPolicy = cfg.slowGrace, cfg.slowPolicy
+	opts.TrustedProxies = cfg.trustedProxies
+	opts.AllowIPs, opts.DenyIPs = cfg.allowIPs, cfg.denyIPs
+	opts.MetadataKeys, opts.StrictQuery = cfg.metadataKeys, cfg.strictQuery
+	opts.WhoRemoteAddrs = cfg.whoAddrs
+	opts.OriginPolicies, opts.ConfigFile = cfg.originPolicies, cfg.configFile
+	opts.UsageLogInterval = cfg.usageInterval
+	opts.DebugDumpBytes = cfg.debugDump
+	opts.Chaos = cfg.chaos
+	opts.ConnectWebhook, opts.DisconnectWebhook, opts.WebhookSecret = cfg.connectHook, cfg.disconnectHook, cfg.webhookSecret
+	if cfg.logCommands || cfg.requireFields {
+		opts.Registry = ws.NewCommandRegistry()
+		if cfg.logCommands {
+			opts.Registry.Use(ws.LogCommands(log.Default()))
+		}
+		if cfg.requireFields {
+			opts.Registry.Use(ws.RequireFields(ws.RequiredFields))
+		}
+	}
+	if cfg.autocertDomain != "" {
+		opts.AllowedOrigins = append(opts.AllowedOrigins, "https://"+cfg.autocertDomain)
+	}
+
+	s := &Server{cfg: cfg, ShutdownTimeout: defaultShutdownTimeout, listening: make(chan struct{})}
+	var err error
+	if cfg.auditDB != "" {
+		if s.audit, err = ws.OpenAuditLog(cfg.auditDB, cfg.auditCap); err != nil {
+			return nil, err
+		}
+		opts.Audit = s.audit
+	}
+	if cfg.otlpEndpoint != "" {
+		if s.tracing, err = newTracerProvider(cfg.otlpEndpoint); err != nil {
+			s.closeSinks()
+			return nil, err
+		}
+		opts.TracerProvider = s.tracing
+	}
+	if cfg.messageLog != "" {
+		s.messageLog, err = ws.NewFileLogger(cfg.messageLog, ws.FileLoggerOptions{
+			MaxBytes:   cfg.messageLogMaxBytes,
+			MaxFiles:   cfg.messageLogFiles,
+			MaxPayload: cfg.messageLogPayload,
+		})
+		if err != nil {
+			s.closeSinks()
+			return nil, err
+		}
+		opts.MessageLogger = s.messageLog
+	}
+
+	s.handler = ws.NewHandler(opts)
+	mux := routes(s.handler, cfg.adminToken)
+	if cfg.client {
+		clientRoutes(mux)
+	}
+	if cfg.tenants != nil {
+		s.tenants = ws.NewTenants(opts, cfg.tenants)
+		tenantRoutes(mux, s.tenants, cfg.adminToken)
+	}
+	s.routes = accessLog(mux, log.Default(), cfg.trustedProxies)
+	if cfg.autocertDomain != "" {
+		s.acme = &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.autocertDomain),
+			Cache:      autocert.DirCache(cfg.autocertCache),
+		}
+	}
+	if cfg.httpAddr != "" {
+		s.plain = s.routes
+		if cfg.redirectHTTP {
+			s.plain = accessLog(s.redirectRoutes(), log.Default(), cfg.trustedProxies)
+		}
+		if s.acme != nil {
+			// HTTP-01 challenges come in on the plain port too
+			s.plain = s.acme.HTTPHandler(s.plain)
+		}
+	}
+	return s, nil
+}
+
+// What --redirect-http leaves on the plain listener: health checks, which
+// load balancers often make over http, and a redirect to https for the rest
+func (s *Server) redirectRoutes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", s.handler.Healthz)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, s.httpsURL(r), http.StatusMovedPermanently)
+	})
+	return mux
+}
+
+// r's URL on the TLS listener. The port is left out when it's 443.
+func (s *Server) httpsURL(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if tcp, ok := s.addr.(*net.TCPAddr); ok && tcp.Port != 443 {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), fmt.Sprint(tcp.Port))
+	}
+	return "https://" + host + r.URL.RequestURI()
+}
+
+// Listening is closed once Run has its listener open
+func (s *Server) Listening() <-chan struct{} {
+	return s.listening
+}
+
+// Addr is the address Run is listening on; nil before Listening is closed
+func (s *Server) Addr() net.Addr {
+	select {
+	case <-s.listening:
+		return s.addr
+	default:
+		return nil
+	}
+}
+
+// HTTPAddr is the address of the --http-addr listener; nil without one or
+// before Listening is closed
+func (s *Server) HTTPAddr() net.Addr {
+	select {
+	case <-s.listening:
+		return s.httpAddr
+	default:
+		return nil
+	}
+}
+
+// Run serves until ctx is cancelled or a listener fails, then shuts down
+// and closes the sinks. It returns nil after a shutdown asked for by ctx.
+// A Server runs once.
+func (s *Server) Run(ctx context.Context) error {
+	defer s.closeSinks()
+	defer s.handler.Close()
+	if s.tenants != nil {
+		defer s.tenants.Close()
+	}
+
+	ln, err := net.Listen("tcp", s.cfg.addr)
+	if err != nil {
+		return err
+	}
+	var plainLn net.Listener
+	if s.plain != nil {
+		if plainLn, err = net.Listen("tcp", s.cfg.httpAddr); err != nil {
+			ln.Close()
+			return fmt.Errorf("--http-addr: %w", err)
+		}
+	}
+	// HTTP-01 challenges arrive on port 80; everything else there is
+	// redirected to https. With --http-addr that listener answers them.
+	var challengeLn net.Listener
+	if s.acme != nil && s.plain == nil {
+		if challengeLn, err = net.Listen("tcp", ":80"); err != nil {
+			ln.Close()
+			return fmt.Errorf("autocert: %w", err)
+		}
+	}
+
+	// Every request's context derives from this one, so cancelling it
+	// closes the open websockets with 1001
+	connCtx, closeConns := context.WithCancel(context.Background())
+	defer closeConns()
+	newServer := func(h http.Handler) *http.Server {
+		srv := &http.Server{
+			Handler:           h,
+			ReadHeaderTimeout: s.ReadHeaderTimeout,
+			BaseContext:       func(net.Listener) context.Context { return connCtx },
+		}
+		s.handler.ConfigureServer(srv)
+		return srv
+	}
+	srv := newServer(s.routes)
+	servers := []*http.Server{srv}
+
+	s.addr = ln.Addr()
+	errc := make(chan error, 3)
+	servePlain := func(h http.Handler, ln net.Listener, what string) {
+		plainSrv := newServer(h)
+		servers = append(servers, plainSrv)
+		go func() {
+			log.Printf("Listening on %s (%s)", ln.Addr(), what)
+			if err := plainSrv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+				errc <- err
+			}
+		}()
+	}
+	if plainLn != nil {
+		s.httpAddr = plainLn.Addr()
+		servePlain(s.plain, plainLn, "plain HTTP")
+	}
+	if challengeLn != nil {
+		servePlain(s.acme.HTTPHandler(nil), challengeLn, "ACME challenges")
+	}
+	close(s.listening)
+	go func() { errc <- s.serve(srv, ln) }()
+
+	var runErr error
+	select {
+	case runErr = <-errc:
+		log.Printf("server error: %v", runErr)
+	case <-ctx.Done():
+		log.Printf("shutting down")
+	}
+
+	// Fail readiness first so the load balancer stops sending new clients
+	// while the listener is still up, then stop accepting and flush the
+	// sinks. Websockets are hijacked, so Shutdown doesn't wait for them:
+	// they are told to go away first, and anything they log after this is
+	// dropped.
+	s.handler.Drain()
+	if s.tenants != nil {
+		s.tenants.Drain()
+	}
+	if runErr == nil {
+		time.Sleep(s.cfg.drainDelay)
+	}
+	closeConns()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.ShutdownTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := srv.Shutdown(shutdownCtx); err != nil {
+				log.Printf("shutdown: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	return runErr
+}
+
+// Serve srv on ln, over TLS if configured, until srv is shut down
+func (s *Server) serve(srv *http.Server, ln net.Listener) error {
+	var err error
+	switch cfg := s.cfg; {
+	case cfg.autocertDomain != "":
+		srv.TLSConfig = newTLSConfig()
+		srv.TLSConfig.GetCertificate = s.acme.GetCertificate
+		log.Printf("Listening on %s (autocert for %s)", ln.Addr(), cfg.autocertDomain)
+		err = srv.ServeTLS(ln, "", "")
+	case cfg.tlsCert != "":
+		srv.TLSConfig = newTLSConfig()
+		log.Printf("Listening on %s (TLS)", ln.Addr())
+		err = srv.ServeTLS(ln, cfg.tlsCert, cfg.tlsKey)
+	default:
+		log.Printf("Listening on %s", ln.Addr())
+		err = srv.Serve(ln)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Batch spans to the OTLP/HTTP collector at endpoint. Nothing is sent
+// until the first batch, so a collector that's down only costs log lines.
+func newTracerProvider(endpoint string) (*sdktrace.TracerProvider, error) {
+	exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, err
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "ws-main"))),
+	), nil
+}
+
+func (s *Server) closeSinks() {
+	if s.tracing != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), s.ShutdownTimeout)
+		if err := s.tracing.Shutdown(ctx); err != nil {
+			log.Printf("tracing: %v", err)
+		}
+		cancel()
+	}
+	if s.messageLog != nil {
+		if err := s.messageLog.Close(); err != nil {
+			log.Printf("message log: %v", err)
+		}
+	}
+	if s.audit != nil {
+		if err := s.audit.Close(); err != nil {
+			log.Printf("audit log: %v", err)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+// Filename: cmd/web/server_test.go
+
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Run a Server for args on an ephemeral port; the returned channel gets Run's result
+func startServer(t *testing.T, ctx context.Context, args ...string) (*Server, <-chan error) {
+	t.Helper()
+	cfg, err := parseFlags(append([]string{"--addr", "127.0.0.1:0"}, args...))
+	if err != nil {
+		t.Fatalf("flags: %v", err)
+	}
+	s, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+	select {
+	case <-s.Listening():
+	case err := <-done:
+		t.Fatalf("run: %v", err)
+	}
+	return s, done
+}
+
+func TestServerRunAndShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s, done := startServer(t, ctx)
+
+	res, err := http.Get("http://" + s.Addr().String() + "/healthz")
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("healthz: %v %v", res, err)
+	}
+	res.Body.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+s.Addr().String()+"/ws", http.Header{"Origin": {"http://localhost:4000"}})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := conn.ReadMessage(); err != nil { // welcome
+		t.Fatalf("read welcome: %v", err)
+	}
+
+	// Cancelling sends the websocket away with 1001 and stops Run cleanly
+	cancel()
+	_, _, err = conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseGoingAway {
+		t.Errorf("got %v expected a 1001 close", err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("run: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run still going after cancel")
+	}
+	if _, err := net.DialTimeout("tcp", s.Addr().String(), time.Second); err == nil {
+		t.Error("listener still open after Run returned")
+	}
+}
+
+func TestServerListenError(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	cfg, _ := parseFlags([]string{"--addr", taken.Addr().String()})
+	s, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	if err := s.Run(context.Background()); err == nil {
+		t.Error("Run on a taken port returned nil")
+	}
+}
+
+// Write a self-signed certificate for 127.0.0.1 to dir
+func writeCert(t *testing.T, dir string) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile, cert
+}
+
+func TestServerHTTPAndHTTPS(t *testing.T) {
+	certFile, keyFile, cert := writeCert(t, t.TempDir())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s, done := startServer(t, ctx, "--tls-cert", certFile, "--tls-key", keyFile, "--http-addr", "127.0.0.1:0", "--redirect-http")
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	d := &websocket.Dialer{TLSClientConfig: &tls.Config{RootCAs: roots}}
+	conn, _, err := d.Dial("wss://"+s.Addr().String()+"/ws", http.Header{"Origin": {"https://localhost:4000"}})
+	if err != nil {
+		t.Fatalf("dial wss: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := conn.ReadMessage(); err != nil { // welcome
+		t.Fatalf("read welcome: %v", err)
+	}
+
+	// The plain port redirects everything but health checks
+	noFollow := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	res, err := noFollow.Get("http://" + s.HTTPAddr().String() + "/?x=1")
+	if err != nil {
+		t.Fatalf("get /: %v", err)
+	}
+	res.Body.Close()
+	expected := "https://" + s.Addr().String() + "/?x=1"
+	if res.StatusCode != http.StatusMovedPermanently || res.Header.Get("Location") != expected {
+		t.Errorf("got %d to %q expected 301 to %q", res.StatusCode, res.Header.Get("Location"), expected)
+	}
+	res, err = noFollow.Get("http://" + s.HTTPAddr().String() + "/healthz")
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("healthz over http: %v %v", res, err)
+	}
+	res.Body.Close()
+
+	// Both listeners go down together
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("run: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run still going after cancel")
+	}
+	for _, addr := range []net.Addr{s.Addr(), s.HTTPAddr()} {
+		if _, err := net.DialTimeout("tcp", addr.String(), time.Second); err == nil {
+			t.Errorf("listener %s still open after Run returned", addr)
+		}
+	}
+}
+
+func TestServerHTTPListenError(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := free.Addr().String()
+	free.Close()
+
+	certFile, keyFile, _ := writeCert(t, t.TempDir())
+	cfg, err := parseFlags([]string{"--addr", addr, "--tls-cert", certFile, "--tls-key", keyFile, "--http-addr", taken.Addr().String()})
+	if err != nil {
+		t.Fatalf("flags: %v", err)
+	}
+	s, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	if err := s.Run(context.Background()); err == nil || !strings.Contains(err.Error(), "--http-addr") {
+		t.Errorf("got %v expected an --http-addr error", err)
+	}
+	// The TLS listener that did open was closed again
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("%s still taken: %v", addr, err)
+	}
+	ln.Close()
+}
+
+func TestServerReloadOnHangup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "origins.json")
+	if err := os.WriteFile(path, []byte(`{"allowed_origins": ["https://old.example.com"]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s, _ := startServer(t, ctx, "--config", path)
+	reloadOnHangup(s.handler)
+	url := "ws://" + s.Addr().String() + "/ws"
+
+	dialFrom := func(origin string) error {
+		conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {origin}})
+		if err == nil {
+			conn.Close()
+		}
+		return err
+	}
+	if err := dialFrom("https://new.example.com"); err == nil {
+		t.Fatal("new origin accepted before the reload")
+	}
+
+	if err := os.WriteFile(path, []byte(`{"allowed_origins": ["https://new.example.com"]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for dialFrom("https://new.example.com") != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("new origin still refused after SIGHUP")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServerExportsTraces(t *testing.T) {
+	posts := make(chan string, 10)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posts <- r.URL.Path
+	}))
+	defer collector.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s, done := startServer(t, ctx, "--otlp-endpoint", collector.URL+"/v1/traces")
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+s.Addr().String()+"/ws", http.Header{"Origin": {"http://localhost:4000"}})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	conn.Close()
+
+	// Shutting down flushes the batch
+	cancel()
+	<-done
+	select {
+	case path := <-posts:
+		if path != "/v1/traces" {
+			t.Errorf("got POST to %s", path)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("no spans exported")
+	}
+}
@@ -0,0 +1,311 @@
+// Filename: cmd/wsclient/main.go
+
+// Command wsclient is an interactive client for the websocket server.
+// Each line read from stdin is sent as a frame: lines starting with "/"
+// become JSON commands ("/add 2 3" sends {"command":"add","a":2,"b":3}),
+// "//" escapes a leading slash, and anything else goes out as plain text.
+// Every frame in either direction is printed with a timestamp.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/alexdev404/ws-main/pkg/wsclient"
+)
+
+// Exit statuses
+const (
+	exitOK       = 0
+	exitAbnormal = 1 // the connection failed or closed with anything but 1000
+	exitUsage    = 2
+)
+
+// Command-line settings
+type config struct {
+	url    string
+	origin string
+	token  string
+	json   bool
+	wait   time.Duration
+}
+
+func parseFlags(args []string, stderr io.Writer) (config, error) {
+	var cfg config
+	fs := flag.NewFlagSet("wsclient", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&cfg.origin, "origin", "http://localhost:4000", "Origin header sent with the upgrade")
+	fs.StringVar(&cfg.token, "token", "", `sent as "Authorization: Bearer <token>" (for /ws/admin)`)
+	fs.BoolVar(&cfg.json, "json", false, "print every frame as a JSON line, for scripting")
+	fs.DurationVar(&cfg.wait, "wait", time.Second, "once stdin ends, keep printing replies this long before closing")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: wsclient [flags] [url]  (default ws://localhost:4000/ws)")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+	switch fs.NArg() {
+	case 0:
+		cfg.url = "ws://localhost:4000/ws"
+	case 1:
+		cfg.url = fs.Arg(0)
+	default:
+		return cfg, errors.New("at most one url")
+	}
+	return cfg, nil
+}
+
+// Parameters filled by position for the built-in commands; a parameter
+// named "text" takes the rest of the line. Anything can also be given as
+// key=value.
+var positional = map[string][]string{
+	"add":        {"a", "b"},
+	"subtract":   {"a", "b"},
+	"multiply":   {"a", "b"},
+	"divide":     {"a", "b"},
+	"set":        {"name", "a"},
+	"get":        {"name"},
+	"history":    {"limit"},
+	"count":      {"from", "to", "interval_ms"},
+	"cancel":     {"id"},
+	"nick":       {"name"},
+	"ticks":      {"enabled"},
+	"broadcast":  {"text"},
+	"dm":         {"to", "text"},
+	"transform":  {"op", "text"},
+	"hash":       {"algo", "text"},
+	"hmac":       {"algo", "key", "text"},
+	"b64encode":  {"text"},
+	"b64decode":  {"text"},
+	"wordcount":  {"text"},
+	"charcount":  {"text"},
+	"palindrome": {"text"},
+	"convert":    {"a", "from", "to"},
+	"randint":    {"a", "b"},
+	"time":       {"tz", "format"},
+	"delay":      {"a"},
+	"sin":        {"a", "unit"},
+	"cos":        {"a", "unit"},
+	"tan":        {"a", "unit"},
+	"log":        {"a"},
+	"log10":      {"a"},
+	"exp":        {"a"},
+}
+
+// Parameters that are always sent as strings, even when they look like numbers
+var stringParams = map[string]bool{
+	"name": true, "text": true, "op": true, "algo": true, "key": true, "id": true, "a_var": true, "b_var": true, "sha256": true,
+	"tz": true, "format": true, "a_str": true, "b_str": true, "mode": true,
+}
+
+// Turn one input line into the frame to send: a JSON command for a line
+// starting with "/", otherwise the line itself
+func parseLine(line string) ([]byte, error) {
+	if !strings.HasPrefix(line, "/") {
+		return []byte(line), nil
+	}
+	if strings.HasPrefix(line, "//") {
+		return []byte(line[1:]), nil
+	}
+
+	name, rest, _ := strings.Cut(line[1:], " ")
+	if name == "" {
+		return nil, errors.New("missing command name after /")
+	}
+	buf := []byte(`{"command":`)
+	buf = strconv.AppendQuote(buf, name)
+
+	params := positional[name]
+	for rest = strings.TrimSpace(rest); rest != ""; rest = strings.TrimSpace(rest) {
+		var field string
+		field, rest, _ = strings.Cut(rest, " ")
+		key, value, named := strings.Cut(field, "=")
+		if !named || key == "" {
+			if len(params) == 0 {
+				return nil, fmt.Errorf("/%s: unexpected %q (use key=value)", name, field)
+			}
+			key, value, params = params[0], field, params[1:]
+			if key == "text" {
+				value, rest = strings.TrimSpace(field+" "+rest), ""
+			}
+		}
+		v, err := paramValue(key, value)
+		if err != nil {
+			return nil, fmt.Errorf("/%s: %v", name, err)
+		}
+		buf = append(buf, ',')
+		buf = strconv.AppendQuote(buf, key)
+		buf = append(buf, ':')
+		buf = append(buf, v...)
+	}
+	return append(buf, '}'), nil
+}
+
+// Parameters that are booleans
+var boolParams = map[string]bool{"enabled": true, "urlsafe": true, "raw": true, "timing": true}
+
+// Encode one parameter: numbers as numbers (operands may also name a
+// session value such as "ans"), flags as bools, the rest as strings
+func paramValue(key, value string) (json.RawMessage, error) {
+	switch {
+	case boolParams[key]:
+		switch strings.ToLower(value) {
+		case "on", "true", "yes", "1":
+			return json.RawMessage("true"), nil
+		case "off", "false", "no", "0":
+			return json.RawMessage("false"), nil
+		}
+		return nil, fmt.Errorf("%s must be on or off, not %q", key, value)
+	case stringParams[key]:
+		return json.Marshal(value)
+	}
+	// Whole numbers go as they are, so int mode gets them exactly
+	if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return json.Marshal(i)
+	}
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		return json.Marshal(f)
+	}
+	return json.Marshal(value)
+}
+
+// Prints frames for a person or, with asJSON, one JSON object per line
+type printer struct {
+	mu     sync.Mutex
+	w      io.Writer
+	asJSON bool
+}
+
+// One frame as --json prints it
+type frameLine struct {
+	Time      string `json:"time"`
+	Direction string `json:"dir"`  // "in" or "out"
+	Type      string `json:"type"` // "text", "binary" or "close"
+	Data      string `json:"data,omitempty"`
+	Code      int    `json:"code,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+func (p *printer) frame(dir string, messageType int, data []byte) {
+	line := frameLine{Time: time.Now().Format(time.RFC3339Nano), Direction: dir, Type: "text", Data: string(data)}
+	if messageType == websocket.BinaryMessage {
+		line.Type, line.Data = "binary", fmt.Sprintf("%x", data)
+	}
+	p.print(line)
+}
+
+func (p *printer) closed(code int, reason string) {
+	p.print(frameLine{Time: time.Now().Format(time.RFC3339Nano), Direction: "in", Type: "close", Code: code, Reason: reason})
+}
+
+func (p *printer) print(line frameLine) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.asJSON {
+		b, _ := json.Marshal(line)
+		fmt.Fprintf(p.w, "%s\n", b)
+		return
+	}
+	ts := time.Now().Format("15:04:05.000")
+	arrow := "←"
+	if line.Direction == "out" {
+		arrow = "→"
+	}
+	switch line.Type {
+	case "close":
+		fmt.Fprintf(p.w, "%s × closed %d %s\n", ts, line.Code, line.Reason)
+	case "binary":
+		fmt.Fprintf(p.w, "%s %s [binary %d bytes] %s\n", ts, arrow, len(line.Data)/2, line.Data)
+	default:
+		fmt.Fprintf(p.w, "%s %s %s\n", ts, arrow, line.Data)
+	}
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	cfg, err := parseFlags(args, stderr)
+	if err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(stderr, err)
+		}
+		return exitUsage
+	}
+
+	header := http.Header{}
+	if cfg.origin != "" {
+		header.Set("Origin", cfg.origin)
+	}
+	if cfg.token != "" {
+		header.Set("Authorization", "Bearer "+cfg.token)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	client, err := wsclient.Dial(ctx, cfg.url, wsclient.Options{Header: header, PushBuffer: 1024})
+	cancel()
+	if err != nil {
+		fmt.Fprintf(stderr, "wsclient: %v\n", err)
+		return exitAbnormal
+	}
+
+	out := &printer{w: stdout, asJSON: cfg.json}
+	go func() {
+		// Hang up normally once stdin runs out and the replies are in
+		defer func() {
+			select {
+			case <-client.Done():
+			case <-time.After(cfg.wait):
+				client.Close()
+			}
+		}()
+		scanner := bufio.NewScanner(stdin)
+		for scanner.Scan() {
+			if scanner.Text() == "" {
+				continue
+			}
+			payload, err := parseLine(scanner.Text())
+			if err != nil {
+				fmt.Fprintln(stderr, err)
+				continue
+			}
+			// Printed first so a fast reply can't appear above it
+			out.frame("out", websocket.TextMessage, payload)
+			if err := client.SendText(context.Background(), string(payload)); err != nil {
+				return
+			}
+		}
+	}()
+
+	for p := range client.Pushes() {
+		out.frame("in", p.MessageType, p.Data)
+	}
+	var closeErr *websocket.CloseError
+	switch err := client.Err(); {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &closeErr):
+		out.closed(closeErr.Code, closeErr.Text)
+		if closeErr.Code == websocket.CloseNormalClosure {
+			return exitOK
+		}
+		return exitAbnormal
+	default:
+		fmt.Fprintf(stderr, "wsclient: %v\n", err)
+		return exitAbnormal
+	}
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
@@ -0,0 +1,99 @@
+// Filename: cmd/wsclient/main_test.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alexdev404/ws-main/internal/ws"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"hello", "hello"},
+		{"//etc/hosts", "/etc/hosts"},
+		{"/add 2 3", `{"command":"add","a":2,"b":3}`},
+		{"/multiply ans -1.5", `{"command":"multiply","a":"ans","b":-1.5}`},
+		{"/help", `{"command":"help"}`},
+		{"/stats", `{"command":"stats"}`},
+		{"/set x 7", `{"command":"set","name":"x","a":7}`},
+		{"/add a_var=x 1", `{"command":"add","a_var":"x","a":1}`},
+		{"/nick 42", `{"command":"nick","name":"42"}`},
+		{"/count 1 5 id=c1 100", `{"command":"count","from":1,"to":5,"id":"c1","interval_ms":100}`},
+		{"/ticks off", `{"command":"ticks","enabled":false}`},
+		{"/broadcast  hello   there ", `{"command":"broadcast","text":"hello   there"}`},
+		{`/dm bob say "hi"`, `{"command":"dm","to":"bob","text":"say \"hi\""}`},
+		{"/transform title école normale", `{"command":"transform","op":"title","text":"école normale"}`},
+		{"/b64decode urlsafe=on raw=yes _-8", `{"command":"b64decode","urlsafe":true,"raw":true,"text":"_-8"}`},
+		{"/add 9007199254740993 1 mode=int", `{"command":"add","a":9007199254740993,"b":1,"mode":"int"}`},
+		{"/sin 90 deg", `{"command":"sin","a":90,"unit":"deg"}`},
+		{"/convert 100 celsius fahrenheit", `{"command":"convert","a":100,"from":"celsius","to":"fahrenheit"}`},
+		{"/palindrome Was it a car or a cat I saw?", `{"command":"palindrome","text":"Was it a car or a cat I saw?"}`},
+		{"/upload name=a.bin size=10 chunks=2", `{"command":"upload","name":"a.bin","size":10,"chunks":2}`},
+	}
+	for _, tt := range tests {
+		got, err := parseLine(tt.line)
+		if err != nil || string(got) != tt.want {
+			t.Errorf("%q: got %s, %v expected %s", tt.line, got, err, tt.want)
+			continue
+		}
+		if strings.HasPrefix(tt.line, "/") && !strings.HasPrefix(tt.line, "//") && !json.Valid(got) {
+			t.Errorf("%q: %s is not valid JSON", tt.line, got)
+		}
+	}
+}
+
+func TestParseLineErrors(t *testing.T) {
+	for _, line := range []string{"/", "/ add", "/add 1 2 3", "/stats now", "/ticks maybe"} {
+		if got, err := parseLine(line); err == nil {
+			t.Errorf("%q: got %s expected an error", line, got)
+		}
+	}
+}
+
+// Run the client against a real handler with stdin as input
+func runAgainstServer(t *testing.T, stdin string, args ...string) (int, string) {
+	t.Helper()
+	srv := httptest.NewServer(ws.NewHandler(ws.Options{}))
+	t.Cleanup(srv.Close)
+	var stdout bytes.Buffer
+	args = append(args, "ws"+strings.TrimPrefix(srv.URL, "http"))
+	code := run(args, strings.NewReader(stdin), &stdout, io.Discard)
+	return code, stdout.String()
+}
+
+func TestRunJSONOutput(t *testing.T) {
+	code, out := runAgainstServer(t, "/add 2 3\n", "--json", "--wait", "100ms")
+	if code != exitOK {
+		t.Errorf("exit %d expected %d", code, exitOK)
+	}
+	var sawWelcome, sawSent bool
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		var f frameLine
+		if err := json.Unmarshal([]byte(line), &f); err != nil {
+			t.Fatalf("line %q: %v", line, err)
+		}
+		sawWelcome = sawWelcome || (f.Direction == "in" && strings.Contains(f.Data, `"type":"welcome"`))
+		sawSent = sawSent || (f.Direction == "out" && f.Data == `{"command":"add","a":2,"b":3}`)
+	}
+	if !sawWelcome || !sawSent {
+		t.Errorf("welcome=%v sent=%v in:\n%s", sawWelcome, sawSent, out)
+	}
+}
+
+func TestRunAbnormalClose(t *testing.T) {
+	// Over the server's 4KB read limit, so it closes with 1009
+	code, out := runAgainstServer(t, strings.Repeat("x", 8<<10)+"\n")
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if last := lines[len(lines)-1]; code != exitAbnormal || !strings.Contains(last, "closed 1009") {
+		t.Errorf("exit %d, last line %.100q", code, last)
+	}
+}
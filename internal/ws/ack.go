@@ -0,0 +1,242 @@
+package ws
+
+// Filename: internal/ws/ack.go
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Error codes for delivery acknowledgments
+const (
+	ErrCodeTooManyPendingAcks = "ERR_TOO_MANY_PENDING_ACKS"
+	ErrCodeUnknownMessage     = "ERR_UNKNOWN_MSG_ID"
+)
+
+const (
+	defaultAckWindow = 5 * time.Second // how long recipients get to ack
+	maxPendingAcks   = 16              // acked messages waiting at once per sender
+)
+
+var errUnknownMessage = errors.New("unknown msg_id")
+
+// Sent to the sender of an acked broadcast or dm once every recipient has
+// acked or the window is up
+type ackReport struct {
+	Type      string `json:"type"`
+	MsgID     string `json:"msg_id"`
+	Delivered int    `json:"delivered"` // recipients the message was queued for
+	Acked     int    `json:"acked"`
+}
+
+// One message whose acks are being counted
+type pendingAck struct {
+	t         *ackTracker
+	msgID     string
+	sender    string          // conn_id the report goes to
+	waiting   map[string]bool // conn_ids delivered to that haven't acked
+	delivered int
+	acked     int
+	sealed    bool // every recipient has been counted; see seal
+	timer     *time.Timer
+}
+
+// ackTracker counts the acks for messages sent with "ack":true. A sender
+// has at most maxPendingAcks of them outstanding; each ends with a report
+// when the last recipient acks (or leaves) or after window, whichever is
+// first, and is dropped unreported if the sender leaves.
+type ackTracker struct {
+	mu      sync.Mutex
+	window  time.Duration
+	pending map[string]*pendingAck // msg_id -> its acks
+	perConn map[string]int         // sender conn_id -> its pending messages
+	report  func(sender string, r ackReport)
+}
+
+func newAckTracker(window time.Duration, report func(sender string, r ackReport)) *ackTracker {
+	return &ackTracker{window: window, pending: make(map[string]*pendingAck), perConn: make(map[string]int), report: report}
+}
+
+// A fresh random msg_id
+func newMsgID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Start counting acks for a message from sender. Recipients are added with
+// expect as it goes out, then seal starts the window.
+func (t *ackTracker) open(sender string) (*pendingAck, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.perConn[sender] >= maxPendingAcks {
+		return nil, false
+	}
+	p := &pendingAck{t: t, msgID: newMsgID(), sender: sender, waiting: make(map[string]bool)}
+	t.pending[p.msgID] = p
+	t.perConn[sender]++
+	return p, true
+}
+
+// Give up on p without a report, for a message that never went out
+func (p *pendingAck) cancel() {
+	if p == nil {
+		return
+	}
+	p.t.mu.Lock()
+	defer p.t.mu.Unlock()
+	if p.t.pending[p.msgID] == p {
+		p.t.finish(p)
+	}
+}
+
+// The msg_id to stamp on the message; "" without acks
+func (p *pendingAck) id() string {
+	if p == nil {
+		return ""
+	}
+	return p.msgID
+}
+
+// Count id as a recipient. Called before the message is queued for it, so
+// its ack can't arrive first; unexpect takes it back if queueing fails.
+// Both do nothing on a nil p, for messages sent without "ack".
+func (p *pendingAck) expect(id string) {
+	if p == nil {
+		return
+	}
+	p.t.mu.Lock()
+	defer p.t.mu.Unlock()
+	p.waiting[id] = true
+	p.delivered++
+}
+
+func (p *pendingAck) unexpect(id string) {
+	if p == nil {
+		return
+	}
+	p.t.mu.Lock()
+	defer p.t.mu.Unlock()
+	if p.waiting[id] {
+		delete(p.waiting, id)
+		p.delivered--
+	}
+}
+
+// Every recipient is counted: report now if they have all acked already,
+// or when the window is up
+func (p *pendingAck) seal() {
+	if p == nil {
+		return
+	}
+	t := p.t
+	t.mu.Lock()
+	p.sealed = true
+	if len(p.waiting) > 0 {
+		p.timer = time.AfterFunc(t.window, func() { t.expire(p) })
+		t.mu.Unlock()
+		return
+	}
+	r := t.finish(p)
+	t.mu.Unlock()
+	t.report(p.sender, r)
+}
+
+// Record from's ack of msgID
+func (t *ackTracker) ack(msgID, from string) error {
+	t.mu.Lock()
+	p, ok := t.pending[msgID]
+	if !ok || !p.waiting[from] {
+		t.mu.Unlock()
+		return errUnknownMessage
+	}
+	delete(p.waiting, from)
+	p.acked++
+	if !p.sealed || len(p.waiting) > 0 {
+		t.mu.Unlock()
+		return nil
+	}
+	r := t.finish(p)
+	t.mu.Unlock()
+	t.report(p.sender, r)
+	return nil
+}
+
+func (t *ackTracker) expire(p *pendingAck) {
+	t.mu.Lock()
+	if t.pending[p.msgID] != p {
+		t.mu.Unlock()
+		return
+	}
+	r := t.finish(p)
+	t.mu.Unlock()
+	t.report(p.sender, r)
+}
+
+// The connection id is gone: its own messages are dropped, and it won't be
+// acking anyone else's
+func (t *ackTracker) forget(id string) {
+	var done []*pendingAck
+	var reports []ackReport
+	t.mu.Lock()
+	for _, p := range t.pending {
+		switch {
+		case p.sender == id:
+			t.finish(p)
+		case p.waiting[id]:
+			delete(p.waiting, id)
+			if p.sealed && len(p.waiting) == 0 {
+				done = append(done, p)
+				reports = append(reports, t.finish(p))
+			}
+		}
+	}
+	t.mu.Unlock()
+	for i, p := range done {
+		t.report(p.sender, reports[i])
+	}
+}
+
+// Stop tracking p and return its report. Caller holds t.mu and sends the
+// report after letting go of it.
+func (t *ackTracker) finish(p *pendingAck) ackReport {
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	delete(t.pending, p.msgID)
+	if t.perConn[p.sender]--; t.perConn[p.sender] == 0 {
+		delete(t.perConn, p.sender)
+	}
+	return ackReport{Type: "ack_report", MsgID: p.msgID, Delivered: p.delivered, Acked: p.acked}
+}
+
+// Acknowledge a broadcast or dm that asked for acks, by its msg_id
+func runAck(s *Session, req CommandRequest) CommandResponse {
+	if req.MsgID == "" {
+		return errorResponse(req.Command, ErrCodeInvalidOperand, "msg_id must not be empty")
+	}
+	if s.hub == nil || s.hub.acks.ack(req.MsgID, s.conn.ID) != nil {
+		return errorResponse(req.Command, ErrCodeUnknownMessage, fmt.Sprintf("No message %q waiting for your ack", req.MsgID))
+	}
+	return CommandResponse{Command: req.Command, Done: true}
+}
+
+// Start counting acks for a message s is sending, if req asks for them; a
+// nil pendingAck means it doesn't. The error response is for a sender with
+// too many outstanding.
+func (s *Session) openAck(req CommandRequest) (*pendingAck, *CommandResponse) {
+	if !req.Ack || s.hub == nil {
+		return nil, nil
+	}
+	p, ok := s.hub.acks.open(s.conn.ID)
+	if !ok {
+		resp := errorResponse(req.Command, ErrCodeTooManyPendingAcks,
+			fmt.Sprintf("Too many messages waiting for acks (max %d)", maxPendingAcks))
+		return nil, &resp
+	}
+	return p, nil
+}
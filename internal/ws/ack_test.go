@@ -0,0 +1,152 @@
+// Filename: internal/ws/ack_test.go
+
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Read the next frame on conn, ignoring presence, into v
+func readInto(t *testing.T, conn *websocket.Conn, v interface{}) {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, msg, err := readData(conn)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if err := json.Unmarshal(msg, v); err != nil {
+		t.Fatalf("unmarshal %s: %v", msg, err)
+	}
+}
+
+// Send an acked broadcast from sender and return its msg_id as the
+// recipients see it
+func ackedBroadcast(t *testing.T, sender *websocket.Conn, recipients ...*websocket.Conn) string {
+	t.Helper()
+	var reply struct {
+		Data broadcastResult `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(roundTrip(t, sender, `{"command":"broadcast","text":"hi","ack":true}`)), &reply); err != nil || reply.Data.MsgID == "" {
+		t.Fatalf("broadcast reply: %+v, %v", reply, err)
+	}
+	for i, r := range recipients {
+		var frame broadcastFrame
+		readInto(t, r, &frame)
+		if frame.MsgID != reply.Data.MsgID {
+			t.Errorf("recipient %d: got msg_id %q expected %q", i, frame.MsgID, reply.Data.MsgID)
+		}
+	}
+	return reply.Data.MsgID
+}
+
+func ack(t *testing.T, conn *websocket.Conn, msgID string) string {
+	t.Helper()
+	return roundTrip(t, conn, fmt.Sprintf(`{"command":"ack","msg_id":%q}`, msgID))
+}
+
+func TestAckReportAfterEveryAck(t *testing.T) {
+	url := startServer(t, NewHandler(Options{AckWindow: time.Minute}))
+	sender, a, b := dial(t, url), dial(t, url), dial(t, url)
+
+	id := ackedBroadcast(t, sender, a, b)
+	for _, r := range []*websocket.Conn{a, b} {
+		if got := ack(t, r, id); got != `{"command":"ack","done":true}` {
+			t.Errorf("ack: got %s", got)
+		}
+	}
+	// Well inside the window, since everyone acked
+	var report ackReport
+	readInto(t, sender, &report)
+	if report != (ackReport{Type: "ack_report", MsgID: id, Delivered: 2, Acked: 2}) {
+		t.Errorf("report: got %+v", report)
+	}
+	if got := ack(t, a, id); !strings.HasSuffix(got, `"code":"ERR_UNKNOWN_MSG_ID"}`) {
+		t.Errorf("second ack: got %s", got)
+	}
+
+	// A dm counts its one recipient
+	roundTrip(t, a, "NICK:ada")
+	var reply struct {
+		Data dmResult `json:"data"`
+	}
+	_ = json.Unmarshal([]byte(roundTrip(t, sender, `{"command":"dm","to":"ada","text":"psst","ack":true}`)), &reply)
+	var dm dmFrame
+	readInto(t, a, &dm)
+	if dm.MsgID == "" || dm.MsgID != reply.Data.MsgID || reply.Data.Nick != "ada" {
+		t.Fatalf("dm: got %+v, reply %+v", dm, reply)
+	}
+	ack(t, a, dm.MsgID)
+	readInto(t, sender, &report)
+	if report != (ackReport{Type: "ack_report", MsgID: dm.MsgID, Delivered: 1, Acked: 1}) {
+		t.Errorf("dm report: got %+v", report)
+	}
+}
+
+func TestAckReportAfterWindow(t *testing.T) {
+	url := startServer(t, NewHandler(Options{AckWindow: 200 * time.Millisecond}))
+	sender, a, b := dial(t, url), dial(t, url), dial(t, url)
+
+	id := ackedBroadcast(t, sender, a, b)
+	ack(t, a, id)
+	var report ackReport
+	readInto(t, sender, &report)
+	if report != (ackReport{Type: "ack_report", MsgID: id, Delivered: 2, Acked: 1}) {
+		t.Errorf("report: got %+v", report)
+	}
+	// Too late now
+	if got := ack(t, b, id); got == `{"command":"ack","done":true}` {
+		t.Errorf("late ack accepted: %s", got)
+	}
+}
+
+func TestAckReportWhenRecipientLeaves(t *testing.T) {
+	url := startServer(t, NewHandler(Options{AckWindow: time.Minute}))
+	sender, a, b := dial(t, url), dial(t, url), dial(t, url)
+
+	id := ackedBroadcast(t, sender, a, b)
+	ack(t, a, id)
+	b.Close()
+	var report ackReport
+	readInto(t, sender, &report)
+	if report != (ackReport{Type: "ack_report", MsgID: id, Delivered: 2, Acked: 1}) {
+		t.Errorf("report: got %+v", report)
+	}
+}
+
+func TestAckTrackerBounds(t *testing.T) {
+	reports := 0
+	tracker := newAckTracker(time.Minute, func(string, ackReport) { reports++ })
+	var open []*pendingAck
+	for range maxPendingAcks {
+		p, ok := tracker.open("c1")
+		if !ok {
+			t.Fatal("open refused under the limit")
+		}
+		p.expect("c2")
+		p.seal()
+		open = append(open, p)
+	}
+	if _, ok := tracker.open("c1"); ok {
+		t.Error("open allowed past the limit")
+	}
+	if _, ok := tracker.open("c2"); !ok {
+		t.Error("the limit is per sender")
+	}
+
+	// The sender leaving drops its messages, unreported
+	tracker.forget("c1")
+	if reports != 0 || len(tracker.perConn) != 1 {
+		t.Errorf("after forget: %d reports, %v pending", reports, tracker.perConn)
+	}
+	for _, p := range open {
+		if p.timer.Stop() {
+			t.Error("timer still running after forget")
+		}
+	}
+}
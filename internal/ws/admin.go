@@ -0,0 +1,249 @@
+package ws
+
+// Filename: internal/ws/admin.go
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// How long a kicked client gets to answer our close frame before the read
+// loop gives up on it
+const kickGrace = time.Second
+
+// One live connection as the admin API reports it
+type connectionInfo struct {
+	ConnID      string   `json:"conn_id"`
+	Tenant      string   `json:"tenant,omitempty"`
+	RemoteAddr  string   `json:"remote_addr"`
+	Origin      string   `json:"origin"`
+	Nick        string   `json:"nick,omitempty"`
+	ConnectedAt string   `json:"connected_at"`
+	Received    uint64   `json:"messages_received"`
+	Sent        uint64   `json:"messages_sent"`
+	BytesIn     uint64   `json:"bytes_received"`
+	BytesOut    uint64   `json:"bytes_sent"`
+	Debug       bool     `json:"debug,omitempty"`
+	Chaos       bool     `json:"chaos,omitempty"`
+	LastRTTMS   *float64 `json:"last_rtt_ms,omitempty"`
+
+	Room     string            `json:"room,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+func (c *client) info() connectionInfo {
+	s := c.session
+	out := connectionInfo{
+		ConnID:      s.conn.ID,
+		Tenant:      s.conn.Tenant,
+		RemoteAddr:  s.conn.RemoteAddr,
+		Origin:      s.conn.Origin,
+		Nick:        s.Nick(),
+		ConnectedAt: s.conn.ConnectedAt.UTC().Format(serverTimeFormat),
+		Received:    atomic.LoadUint64(&s.seq),
+		Sent:        atomic.LoadUint64(&c.sent),
+		BytesIn:     s.bytesIn.Load(),
+		BytesOut:    s.bytesOut.Load(),
+		Debug:       s.debugging(),
+		Chaos:       c.chaos.Load() != nil,
+		Room:        s.conn.Room,
+		Metadata:    s.conn.Metadata,
+	}
+	if rtt := s.RTT(); rtt.Samples > 0 {
+		out.LastRTTMS = &rtt.LastMS
+	}
+	return out
+}
+
+// Close c with code and reason on the server's initiative. The read loop
+// stops handling frames right away and, if the peer never answers the
+// close, gives up after kickGrace; its normal teardown does the rest.
+func (c *client) kick(code int, reason string) {
+	if !c.closing.CompareAndSwap(false, true) {
+		return
+	}
+	c.closeWith(code, reason)
+	_ = c.conn.SetReadDeadline(time.Now().Add(kickGrace))
+}
+
+// Switch debug mode for the live client with this conn_id; false if there
+// is none
+func (h *hub) setDebug(id string, enabled bool) bool {
+	h.mu.Lock()
+	c, ok := h.lookup(id)
+	h.mu.Unlock()
+	if ok {
+		c.session.setDebug(enabled)
+	}
+	return ok
+}
+
+// Kick the live client with this conn_id; false if there is none
+func (h *hub) kick(id string, code int, reason string) bool {
+	h.mu.Lock()
+	c, ok := h.lookup(id)
+	h.mu.Unlock()
+	if ok {
+		c.kick(code, reason)
+	}
+	return ok
+}
+
+// AdminHandler serves the connection admin API:
+//
+//	GET    /admin/connections       every live connection, oldest first
+//	DELETE /admin/connections/{id}  close that connection with 1008
+//	PUT    /admin/connections/{id}/debug  {"enabled"} switches its debug mode
+//	PUT    /admin/connections/{id}/chaos  {"enabled"} puts it under Options.Chaos, if set
+//	GET    /admin/audit?conn_id=&limit= latest audit entries, with Options.Audit
+//	POST   /admin/drain             stop accepting new connections; see Drain, or
+//	                                with {"seconds"} close the rest after that; see DrainIn
+//	DELETE /admin/drain             accept connections again; see CancelDrain
+//	POST   /admin/reload            reread Options.ConfigFile; see Reload
+//	GET    /admin/commands          per-command counts and durations
+//	GET    /admin/bans              every ban in force
+//	POST   /admin/bans              ban {"ip"} or {"conn_id"}, optionally for "duration"
+//	DELETE /admin/bans?ip=          lift the ban on that address or CIDR
+//
+// A ban closes the live connections it covers with 1008 "banned", and
+// their address is refused with 403 at upgrade time until it runs out or
+// is lifted. Banning a conn_id bans the address it came from, as resolved
+// through Options.TrustedProxies. Options.BanFile keeps bans across
+// restarts.
+//
+// Requests must carry "Authorization: Bearer <token>".
+func (h *Handler) AdminHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/connections", h.listConnections)
+	mux.HandleFunc("DELETE /admin/connections/{id}", h.kickConnection)
+	mux.HandleFunc("PUT /admin/connections/{id}/debug", h.debugConnection)
+	mux.HandleFunc("POST /admin/drain", h.drain)
+	mux.HandleFunc("DELETE /admin/drain", h.cancelDrain)
+	mux.HandleFunc("POST /admin/reload", h.reloadConfig)
+	mux.HandleFunc("GET /admin/commands", h.commandUsage)
+	mux.HandleFunc("GET /admin/bans", h.listBans)
+	mux.HandleFunc("POST /admin/bans", h.addBan)
+	mux.HandleFunc("DELETE /admin/bans", h.removeBan)
+	if h.opts.Audit != nil {
+		mux.HandleFunc("GET /admin/audit", h.auditEntries)
+	}
+	if h.opts.Chaos != nil {
+		mux.HandleFunc("PUT /admin/connections/{id}/chaos", h.chaosConnection)
+	}
+	return requireToken(token, mux)
+}
+
+// Does r carry Options.AuthToken, if there is one? Compared in constant time.
+func (h *Handler) authorized(r *http.Request) bool {
+	if h.opts.AuthToken == "" {
+		return true
+	}
+	got := r.URL.Query().Get("token")
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		got = bearer
+	}
+	return subtle.ConstantTimeCompare([]byte(got), []byte(h.opts.AuthToken)) == 1
+}
+
+// Reject requests without the bearer token with 401
+func requireToken(token string, next http.Handler) http.Handler {
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := []byte(r.Header.Get("Authorization"))
+		if token == "" || subtle.ConstantTimeCompare(got, want) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *Handler) listConnections(w http.ResponseWriter, r *http.Request) {
+	clients := h.hub.snapshot()
+	out := make([]connectionInfo, 0, len(clients))
+	for _, c := range clients {
+		out = append(out, c.info())
+	}
+	sort.Slice(out, func(i, j int) bool { return connIDLess(out[i].ConnID, out[j].ConnID) })
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(out); err != nil {
+		h.opts.Logger.Printf("admin: encode connections: %v", err)
+	}
+}
+
+func (h *Handler) kickConnection(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !h.hub.kick(id, websocket.ClosePolicyViolation, "kicked by admin") {
+		http.Error(w, "no such connection", http.StatusNotFound)
+		return
+	}
+	h.opts.Logger.Printf("admin: kicked %s", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) debugConnection(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if req.Enabled == nil {
+		http.Error(w, "enabled must be true or false", http.StatusBadRequest)
+		return
+	}
+	id := r.PathValue("id")
+	if !h.hub.setDebug(id, *req.Enabled) {
+		http.Error(w, "no such connection", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) commandUsage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.stats.usage.snapshot()); err != nil {
+		h.opts.Logger.Printf("admin: encode command usage: %v", err)
+	}
+}
+
+// Most entries one audit request returns
+const maxAuditLimit = 1000
+
+func (h *Handler) auditEntries(w http.ResponseWriter, r *http.Request) {
+	connID := r.URL.Query().Get("conn_id")
+	if connID == "" {
+		http.Error(w, "conn_id is required", http.StatusBadRequest)
+		return
+	}
+	limit := 50
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxAuditLimit)
+	}
+
+	entries, err := h.opts.Audit.Recent(connID, limit)
+	if err != nil {
+		h.opts.Logger.Printf("admin: audit query: %v", err)
+		http.Error(w, "audit query failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(entries); err != nil {
+		h.opts.Logger.Printf("admin: encode audit entries: %v", err)
+	}
+}
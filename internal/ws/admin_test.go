@@ -0,0 +1,93 @@
+// Filename: internal/ws/admin_test.go
+
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestAdminListAndKick(t *testing.T) {
+	h := NewHandler(Options{})
+	mux := http.NewServeMux()
+	mux.Handle("/ws", h)
+	mux.Handle("/admin/", h.AdminHandler("secret"))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+	stay, kicked := dial(t, url), dial(t, url)
+	roundTrip(t, kicked, "NICK:rowdy")
+
+	admin := func(method, path, token string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+	list := func() []connectionInfo {
+		t.Helper()
+		var out []connectionInfo
+		if err := json.NewDecoder(admin("GET", "/admin/connections", "secret").Body).Decode(&out); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return out
+	}
+
+	for _, token := range []string{"", "wrong"} {
+		if resp := admin("GET", "/admin/connections", token); resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("token %q: got %d expected 401", token, resp.StatusCode)
+		}
+	}
+
+	conns := list()
+	if len(conns) != 2 {
+		t.Fatalf("connections: got %+v", conns)
+	}
+	target := conns[1]
+	if target.Nick != "rowdy" || target.Origin != allowedOrigins[0] || target.RemoteAddr == "" || target.Received != 1 || target.Sent < 2 {
+		t.Errorf("connection: got %+v", target)
+	}
+
+	if resp := admin("DELETE", "/admin/connections/nope", "secret"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown id: got %d expected 404", resp.StatusCode)
+	}
+	if resp := admin("DELETE", "/admin/connections/"+target.ConnID, "wrong"); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("kick without token: got %d expected 401", resp.StatusCode)
+	}
+	if resp := admin("DELETE", "/admin/connections/"+target.ConnID, "secret"); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("kick: got %d expected 204", resp.StatusCode)
+	}
+
+	_ = kicked.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err := readData(kicked)
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.ClosePolicyViolation || closeErr.Text != "kicked by admin" {
+		t.Errorf("kicked client: got %v expected 1008", err)
+	}
+
+	// The connection is torn down through the usual path (after the join
+	// and rename stay heard earlier)
+	for _, event := range []string{"join", "rename", "leave"} {
+		if got := readPresence(t, stay); got.Event != event || got.ConnID != target.ConnID {
+			t.Fatalf("%s: got %+v", event, got)
+		}
+	}
+	if conns := list(); len(conns) != 1 {
+		t.Errorf("after kick: got %+v", conns)
+	}
+}
@@ -0,0 +1,252 @@
+package ws
+
+// Filename: internal/ws/audit.go
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	_ "modernc.org/sqlite" // registers the "sqlite" driver
+)
+
+// Audit log settings
+const (
+	defaultAuditPayloadCap = 1024 // bytes of each payload kept
+	auditQueueSize         = 4096 // entries waiting for the writer
+	auditBatchSize         = 256  // most entries written per transaction
+)
+
+const auditSchema = `CREATE TABLE IF NOT EXISTS audit (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	ts          TEXT NOT NULL,
+	conn_id     TEXT NOT NULL,
+	remote_addr TEXT NOT NULL,
+	direction   TEXT NOT NULL,
+	msg_type    TEXT NOT NULL,
+	payload     BLOB NOT NULL,
+	truncated   INTEGER NOT NULL,
+	command     TEXT NOT NULL DEFAULT '',
+	error_code  TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS audit_conn ON audit (conn_id, id);`
+
+// AuditEntry is one frame as the audit log records it
+type AuditEntry struct {
+	Time       time.Time `json:"ts"`
+	ConnID     string    `json:"conn_id"`
+	RemoteAddr string    `json:"remote_addr"`
+	Direction  string    `json:"direction"` // directionIn or directionOut
+	MsgType    string    `json:"msg_type"`  // "text" or "binary"
+	Payload    []byte    `json:"payload"`   // at most the payload cap
+	Truncated  bool      `json:"truncated"`
+	Command    string    `json:"command,omitempty"`    // JSON commands and replies only
+	ErrorCode  string    `json:"error_code,omitempty"` // replies only
+}
+
+// AuditLog records every data frame in a SQLite database. Frames are
+// handed to a single writer goroutine through a buffered channel and
+// written in batches, so connections never wait on the disk. When the
+// buffer is full the frame is dropped, not queued: Dropped counts those,
+// and a gap is logged once the writer catches up.
+type AuditLog struct {
+	db         *sql.DB
+	payloadCap int
+	entries    chan AuditEntry
+	dropped    atomic.Uint64
+	done       chan struct{} // closed when the writer has finished
+
+	mu     sync.RWMutex // record holds it shared, Close exclusively
+	closed bool
+
+	log atomic.Pointer[log.Logger] // set by NewHandler; log.Default() until then
+}
+
+// OpenAuditLog opens (creating if needed) the database at path and starts
+// its writer. Payloads longer than payloadCap bytes are cut short; 0 means
+// 1 KiB.
+func OpenAuditLog(path string, payloadCap int) (*AuditLog, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1) // one writer; SQLite serializes anyway
+	if _, err := db.Exec(auditSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("audit schema: %w", err)
+	}
+	if payloadCap <= 0 {
+		payloadCap = defaultAuditPayloadCap
+	}
+	a := &AuditLog{
+		db:         db,
+		payloadCap: payloadCap,
+		entries:    make(chan AuditEntry, auditQueueSize),
+		done:       make(chan struct{}),
+	}
+	go a.writeLoop()
+	return a, nil
+}
+
+// Send the writer's errors to logger. a may be nil (auditing off).
+func (a *AuditLog) setLogger(logger *log.Logger) {
+	if a != nil {
+		a.log.Store(logger)
+	}
+}
+
+func (a *AuditLog) logger() *log.Logger {
+	if l := a.log.Load(); l != nil {
+		return l
+	}
+	return log.Default()
+}
+
+// Close writes what is already queued and closes the database. Frames
+// recorded after Close are dropped.
+func (a *AuditLog) Close() error {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.entries)
+	}
+	a.mu.Unlock()
+	<-a.done
+	return a.db.Close()
+}
+
+// Dropped reports how many frames were not recorded because the buffer was full
+func (a *AuditLog) Dropped() uint64 {
+	return a.dropped.Load()
+}
+
+// Queue a frame for writing without waiting. a may be nil (auditing off).
+// The command name and error code are parsed here and only the first
+// payloadCap bytes are copied, so a full queue holds no more than that of
+// each frame.
+func (a *AuditLog) record(s *Session, direction string, messageType int, payload []byte) {
+	if a == nil {
+		return
+	}
+	e := AuditEntry{
+		Time:       time.Now().UTC(),
+		ConnID:     s.conn.ID,
+		RemoteAddr: s.conn.RemoteAddr,
+		Direction:  direction,
+		MsgType:    "text",
+		Truncated:  len(payload) > a.payloadCap,
+	}
+	if messageType == websocket.BinaryMessage {
+		e.MsgType = "binary"
+	}
+	e.Command, e.ErrorCode = auditCommand(e.MsgType, payload)
+	e.Payload = bytes.Clone(payload[:min(len(payload), a.payloadCap)])
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		a.dropped.Add(1)
+		return
+	}
+	select {
+	case a.entries <- e:
+	default:
+		a.dropped.Add(1)
+	}
+}
+
+func (a *AuditLog) writeLoop() {
+	defer close(a.done)
+	var reported uint64
+	batch := make([]AuditEntry, 0, auditBatchSize)
+	for e := range a.entries {
+		batch = append(batch[:0], e)
+	fill:
+		for len(batch) < auditBatchSize {
+			select {
+			case e, ok := <-a.entries:
+				if !ok {
+					break fill
+				}
+				batch = append(batch, e)
+			default:
+				break fill
+			}
+		}
+		if err := a.write(batch); err != nil {
+			a.logger().Printf("audit: write %d entries: %v", len(batch), err)
+		}
+		if d := a.dropped.Load(); d != reported {
+			a.logger().Printf("audit: %d frames dropped so far (buffer full)", d)
+			reported = d
+		}
+	}
+}
+
+// Write a batch in one transaction
+func (a *AuditLog) write(batch []AuditEntry) error {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(`INSERT INTO audit
+		(ts, conn_id, remote_addr, direction, msg_type, payload, truncated, command, error_code)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	for _, e := range batch {
+		if _, err := stmt.Exec(e.Time.Format(serverTimeFormat), e.ConnID, e.RemoteAddr, e.Direction,
+			e.MsgType, e.Payload, e.Truncated, e.Command, e.ErrorCode); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// The command name and error code of a JSON text frame, if it is one
+func auditCommand(msgType string, payload []byte) (command, code string) {
+	payload = trimJSONPrefix(payload)
+	if msgType != "text" || len(payload) == 0 || payload[0] != '{' {
+		return "", ""
+	}
+	var fields struct {
+		Command string `json:"command"`
+		Code    string `json:"code"`
+	}
+	if json.Unmarshal(payload, &fields) != nil {
+		return "", ""
+	}
+	return fields.Command, fields.Code
+}
+
+// Recent returns up to n of the latest entries for connID, newest first
+func (a *AuditLog) Recent(connID string, n int) ([]AuditEntry, error) {
+	rows, err := a.db.Query(`SELECT ts, conn_id, remote_addr, direction, msg_type, payload, truncated, command, error_code
+		FROM audit WHERE conn_id = ? ORDER BY id DESC LIMIT ?`, connID, n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		var ts string
+		if err := rows.Scan(&ts, &e.ConnID, &e.RemoteAddr, &e.Direction, &e.MsgType,
+			&e.Payload, &e.Truncated, &e.Command, &e.ErrorCode); err != nil {
+			return nil, err
+		}
+		e.Time, _ = time.Parse(serverTimeFormat, ts)
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,117 @@
+// Filename: internal/ws/audit_test.go
+
+package ws
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Poll until connID has n audit entries, returning them oldest first
+func waitAudit(t *testing.T, a *AuditLog, connID string, n int) []AuditEntry {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		entries, err := a.Recent(connID, 100)
+		if err != nil {
+			t.Fatalf("recent: %v", err)
+		}
+		if len(entries) >= n {
+			for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+				entries[i], entries[j] = entries[j], entries[i]
+			}
+			return entries
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d entries expected %d", len(entries), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAuditLogRecordsTraffic(t *testing.T) {
+	audit, err := OpenAuditLog(filepath.Join(t.TempDir(), "audit.db"), 16)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { audit.Close() })
+
+	h := NewHandler(Options{Audit: audit})
+	mux := http.NewServeMux()
+	mux.Handle("/ws", h)
+	mux.Handle("/admin/", h.AdminHandler("secret"))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	conn, welcome := dialWelcome(t, websocket.DefaultDialer, "ws"+strings.TrimPrefix(srv.URL, "http")+"/ws")
+	var w welcomeFrame
+	if err := json.Unmarshal(welcome, &w); err != nil {
+		t.Fatalf("welcome: %v", err)
+	}
+	roundTrip(t, conn, `{"command":"divide","a":1,"b":0}`)
+	roundTrip(t, conn, "a message longer than sixteen bytes")
+
+	entries := waitAudit(t, audit, w.ConnID, 5)
+	expected := []struct {
+		direction, command, code string
+		truncated                bool
+	}{
+		{directionOut, "", "", true}, // welcome
+		{directionIn, "divide", "", true},
+		{directionOut, "divide", ErrCodeDivByZero, true},
+		{directionIn, "", "", true},
+		{directionOut, "", "", true},
+	}
+	for i, e := range entries {
+		want := expected[i]
+		if e.ConnID != w.ConnID || e.RemoteAddr == "" || e.MsgType != "text" || e.Time.IsZero() ||
+			e.Direction != want.direction || e.Command != want.command || e.ErrorCode != want.code ||
+			e.Truncated != want.truncated || len(e.Payload) != 16 {
+			t.Errorf("entry %d: got %+v expected %+v", i, e, want)
+		}
+	}
+	if got := string(entries[3].Payload); got != "a message longer" {
+		t.Errorf("truncated payload: got %q", got)
+	}
+
+	req, _ := http.NewRequest("GET", srv.URL+"/admin/audit?conn_id="+w.ConnID+"&limit=2", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("admin audit: %v", err)
+	}
+	defer resp.Body.Close()
+	var latest []AuditEntry
+	if err := json.NewDecoder(resp.Body).Decode(&latest); err != nil || len(latest) != 2 || latest[0].Direction != directionOut {
+		t.Errorf("admin audit: got %+v (%v)", latest, err)
+	}
+}
+
+func TestAuditDropsAfterClose(t *testing.T) {
+	audit, err := OpenAuditLog(filepath.Join(t.TempDir(), "audit.db"), 0)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	audit.Close()
+	audit.record(newSession(defaultHistorySize), directionIn, websocket.TextMessage, []byte("late"))
+	if audit.Dropped() != 1 {
+		t.Errorf("dropped: got %d expected 1", audit.Dropped())
+	}
+}
+
+func TestAuditQueuesOnlyThePayloadCap(t *testing.T) {
+	audit := &AuditLog{payloadCap: 8, entries: make(chan AuditEntry, 1)}
+	payload := []byte(`{"command":"echo","text":"` + strings.Repeat("x", 1<<16) + `"}`)
+	audit.record(newSession(defaultHistorySize), directionIn, websocket.TextMessage, payload)
+	e := <-audit.entries
+	if string(e.Payload) != `{"comman` || cap(e.Payload) != 8 || !e.Truncated || e.Command != "echo" {
+		t.Errorf("got %q (cap %d), truncated %v, command %q", e.Payload, cap(e.Payload), e.Truncated, e.Command)
+	}
+}
@@ -0,0 +1,95 @@
+package ws
+
+// Filename: internal/ws/bandwidth.go
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// The span Options.BandwidthLimit and OriginPolicy.BytesPerMinute cover
+const bandwidthWindow = time.Minute
+
+// Count n payload bytes read from the client. Only the read loop calls
+// this, but stats and the admin listing read the totals from elsewhere.
+func (s *Session) countIn(n int) {
+	s.bytesIn.Add(uint64(n))
+	s.stats.bytesIn.Add(uint64(n))
+}
+
+// Count n payload bytes written to the client, from the write pump
+func (s *Session) countOut(n int) {
+	s.bytesOut.Add(uint64(n))
+	s.stats.bytesOut.Add(uint64(n))
+}
+
+// Bytes received over a sliding window, for one connection. Only its read
+// loop uses it, so there's no lock.
+type byteWindow struct {
+	limit  int64
+	window time.Duration
+	reads  []byteRead // within the window, oldest first
+	total  int64      // sum of reads
+	warned bool       // went over and hasn't dropped back under since
+}
+
+type byteRead struct {
+	at time.Time
+	n  int64
+}
+
+func newByteWindow(limit int64, window time.Duration) *byteWindow {
+	return &byteWindow{limit: limit, window: window}
+}
+
+// Record n bytes read at now and say whether the window now holds more
+// than the limit
+func (w *byteWindow) add(now time.Time, n int) (total int64, over bool) {
+	cutoff := now.Add(-w.window)
+	i := 0
+	for i < len(w.reads) && !w.reads[i].at.After(cutoff) {
+		w.total -= w.reads[i].n
+		i++
+	}
+	w.reads = append(w.reads[i:], byteRead{at: now, n: int64(n)})
+	w.total += int64(n)
+	return w.total, w.total > w.limit
+}
+
+// Notice sent the first time a connection goes over its byte rate
+type bandwidthFrame struct {
+	Type          string `json:"type"` // always "bandwidth"
+	Used          int64  `json:"used"`
+	Limit         int64  `json:"limit"`
+	WindowSeconds int    `json:"window_s"`
+	Message       string `json:"message"`
+}
+
+// Check an n-byte frame against the connection's byte rate before it is
+// handled. A frame that takes the connection over is refused with a
+// warning; one that arrives while it is still over closes it with 1008.
+// A connection that drops back under is warned afresh next time. Returns
+// whether to handle the frame, and false for keepReading once closed.
+func (c *client) enforceBandwidth(now time.Time, n int) (handle, keepReading bool) {
+	if c.bandwidth == nil {
+		return true, true
+	}
+	used, over := c.bandwidth.add(now, n)
+	if !over {
+		c.bandwidth.warned = false
+		return true, true
+	}
+	if c.bandwidth.warned {
+		c.closeWith(websocket.ClosePolicyViolation, "bandwidth limit exceeded")
+		return false, false
+	}
+	c.bandwidth.warned = true
+	return false, c.sendSystem(bandwidthFrame{
+		Type:          "bandwidth",
+		Used:          used,
+		Limit:         c.bandwidth.limit,
+		WindowSeconds: int(c.bandwidth.window / time.Second),
+		Message:       "Bandwidth limit reached; slow down or be disconnected",
+	})
+}
@@ -0,0 +1,129 @@
+// Filename: internal/ws/bandwidth_test.go
+
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestBandwidthLimitWarnsThenCloses(t *testing.T) {
+	h := NewHandler(Options{BandwidthLimit: 1000})
+	mux := http.NewServeMux()
+	mux.Handle("/ws", h)
+	mux.Handle("/admin/", h.AdminHandler("secret"))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+
+	conn, welcome := dialWelcome(t, websocket.DefaultDialer, url)
+	sent := uint64(len(welcome))
+	big := strings.Repeat("x", 600)
+
+	// 600 bytes is within the limit and echoed
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(big)); err != nil {
+		t.Fatal(err)
+	}
+	_, echo, err := readData(conn)
+	if err != nil || !strings.HasSuffix(string(echo), big) {
+		t.Fatalf("echo: got %q, %v", echo, err)
+	}
+	sent += uint64(len(echo))
+
+	// 1200 isn't: the frame is refused with a warning
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(big)); err != nil {
+		t.Fatal(err)
+	}
+	_, msg, err := readData(conn)
+	if err != nil {
+		t.Fatalf("read warning: %v", err)
+	}
+	sent += uint64(len(msg))
+	var warning bandwidthFrame
+	if err := json.Unmarshal(msg, &warning); err != nil || warning.Type != "bandwidth" ||
+		warning.Used != 1200 || warning.Limit != 1000 || warning.WindowSeconds != 60 {
+		t.Fatalf("warning: got %s (%v)", msg, err)
+	}
+
+	// The admin listing has both directions, once the pump has counted
+	// the warning
+	var info connectionInfo
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		req, _ := http.NewRequest("GET", srv.URL+"/admin/connections", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var list []connectionInfo
+		err = json.NewDecoder(resp.Body).Decode(&list)
+		resp.Body.Close()
+		if err != nil || len(list) != 1 {
+			t.Fatalf("list: got %+v (%v)", list, err)
+		}
+		info = list[0]
+		if info.BytesOut == sent || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if info.BytesIn != 1200 || info.BytesOut != sent {
+		t.Errorf("admin listing: got %d in, %d out expected 1200 in, %d out", info.BytesIn, info.BytesOut, sent)
+	}
+
+	// Still over: closed
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(big)); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err = readData(conn)
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.ClosePolicyViolation || closeErr.Text != "bandwidth limit exceeded" {
+		t.Errorf("got %v expected 1008 bandwidth limit exceeded", err)
+	}
+}
+
+func TestByteWindowSlides(t *testing.T) {
+	w := newByteWindow(100, time.Minute)
+	now := time.Now()
+	if _, over := w.add(now, 60); over {
+		t.Fatal("60 of 100: expected under")
+	}
+	if used, over := w.add(now.Add(30*time.Second), 60); !over || used != 120 {
+		t.Fatalf("120 of 100: got %d, over=%t", used, over)
+	}
+	// The first read has left the window
+	if used, over := w.add(now.Add(time.Minute), 10); over || used != 70 {
+		t.Errorf("after a minute: got %d, over=%t expected 70 under", used, over)
+	}
+}
+
+func TestOriginPolicyBytesPerMinute(t *testing.T) {
+	o := &originConfig{
+		policies: map[string]OriginPolicy{
+			"https://big.example.com":   {BytesPerMinute: 1 << 20},
+			"https://other.example.com": {MessagesPerSecond: 5},
+		},
+		fallback: OriginPolicy{BytesPerMinute: 4096},
+	}
+	for origin, expected := range map[string]int64{
+		"https://big.example.com":   1 << 20,
+		"https://other.example.com": 4096,
+		"https://nobody.example":    4096,
+	} {
+		if got := o.policyFor(origin).BytesPerMinute; got != expected {
+			t.Errorf("%s: got %d expected %d", origin, got, expected)
+		}
+	}
+	if _, err := ParseOriginPolicies([]byte(`{"https://a.example": {"bytes_per_minute": -1}}`)); err == nil {
+		t.Error("negative bytes_per_minute: expected an error")
+	}
+}
@@ -0,0 +1,281 @@
+package ws
+
+// Filename: internal/ws/bans.go
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// One banned address or range
+type ban struct {
+	Prefix    netip.Prefix `json:"cidr"`
+	CreatedAt time.Time    `json:"created_at"`
+	ExpiresAt *time.Time   `json:"expires_at,omitempty"` // nil for a permanent ban
+	Reason    string       `json:"reason,omitempty"`
+}
+
+func (b ban) expired(now time.Time) bool {
+	return b.ExpiresAt != nil && !now.Before(*b.ExpiresAt)
+}
+
+// banList holds the bans checked at upgrade time, one per prefix. With a
+// path, every change is written there and NewHandler reads it back, so bans
+// survive a restart. An expired ban is dropped as soon as it is noticed;
+// the file catches up with the next change, and skips it when read back.
+type banList struct {
+	mu   sync.Mutex
+	path string
+	bans map[netip.Prefix]ban
+}
+
+func newBanList(path string) *banList {
+	return &banList{path: path, bans: make(map[netip.Prefix]ban)}
+}
+
+// Read the bans saved in l.path. A missing file is an empty list.
+func (l *banList) load(now time.Time) error {
+	data, err := os.ReadFile(l.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var saved []ban
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("parse %s: %w", l.path, err)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, b := range saved {
+		if !b.expired(now) {
+			l.bans[b.Prefix.Masked()] = b
+		}
+	}
+	return nil
+}
+
+// Write every ban to l.path, if there is one. Caller holds l.mu.
+func (l *banList) save() error {
+	if l.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(l.sorted(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(l.path, append(data, '\n'))
+}
+
+// Drop the bans that have run out. Caller holds l.mu.
+func (l *banList) purge(now time.Time) {
+	for p, b := range l.bans {
+		if b.expired(now) {
+			delete(l.bans, p)
+		}
+	}
+}
+
+// The bans in force, narrowest address first. Caller holds l.mu.
+func (l *banList) sorted() []ban {
+	out := make([]ban, 0, len(l.bans))
+	for _, b := range l.bans {
+		out = append(out, b)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if c := out[i].Prefix.Addr().Compare(out[j].Prefix.Addr()); c != 0 {
+			return c < 0
+		}
+		return out[i].Prefix.Bits() > out[j].Prefix.Bits()
+	})
+	return out
+}
+
+// Add b, replacing any ban on the same prefix. It stays in force even if
+// it can't be saved; the error says so.
+func (l *banList) add(b ban, now time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.purge(now)
+	l.bans[b.Prefix] = b
+	return l.save()
+}
+
+// Lift the ban on p; false if there is none
+func (l *banList) remove(p netip.Prefix, now time.Time) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.purge(now)
+	if _, ok := l.bans[p]; !ok {
+		return false, nil
+	}
+	delete(l.bans, p)
+	return true, l.save()
+}
+
+func (l *banList) list(now time.Time) []ban {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.purge(now)
+	return l.sorted()
+}
+
+// Is a client at remote (as clientAddr resolved it) banned? An address
+// that can't be parsed never is.
+func (l *banList) banned(remote string, now time.Time) bool {
+	addr, ok := parseHop(remote)
+	if !ok {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for p, b := range l.bans {
+		if !p.Contains(addr) {
+			continue
+		}
+		if !b.expired(now) {
+			return true
+		}
+		delete(l.bans, p)
+	}
+	return false
+}
+
+// Read Options.BanFile. An unreadable file is only a warning: the server
+// starts with no bans rather than not at all.
+func (h *Handler) restoreBans() {
+	if err := h.bans.load(h.opts.Clock.Now()); err != nil {
+		h.opts.Logger.Printf("warning: ban list %s: %v; starting with no bans", h.opts.BanFile, err)
+	}
+}
+
+// Body of POST /admin/bans: exactly one of IP and ConnID
+type banRequest struct {
+	IP       string `json:"ip"`       // address or CIDR
+	ConnID   string `json:"conn_id"`  // ban the address this connection came from
+	Duration string `json:"duration"` // e.g. "1h"; empty for a permanent ban
+	Reason   string `json:"reason"`
+}
+
+// What POST /admin/bans answers with
+type banResult struct {
+	ban
+	Kicked int `json:"kicked"` // live connections closed by the ban
+}
+
+var errNoSuchConnection = errors.New("no such connection")
+
+// The address a live connection came from, as a single-address prefix
+func (h *hub) connPrefix(id string) (netip.Prefix, error) {
+	h.mu.Lock()
+	c, ok := h.lookup(id)
+	h.mu.Unlock()
+	if !ok {
+		return netip.Prefix{}, errNoSuchConnection
+	}
+	addr, ok := parseHop(c.session.conn.RemoteAddr)
+	if !ok {
+		return netip.Prefix{}, fmt.Errorf("connection address %q can't be banned", c.session.conn.RemoteAddr)
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// Close every live connection from inside p with 1008 "banned"
+func (h *hub) kickBanned(p netip.Prefix) int {
+	n := 0
+	for _, c := range h.snapshot() {
+		if addr, ok := parseHop(c.session.conn.RemoteAddr); ok && p.Contains(addr) {
+			c.kick(websocket.ClosePolicyViolation, "banned")
+			n++
+		}
+	}
+	return n
+}
+
+func (h *Handler) addBan(w http.ResponseWriter, r *http.Request) {
+	var req banRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	now := h.opts.Clock.Now()
+	b := ban{CreatedAt: now.UTC(), Reason: req.Reason}
+	switch {
+	case (req.IP == "") == (req.ConnID == ""):
+		http.Error(w, "exactly one of ip and conn_id is required", http.StatusBadRequest)
+		return
+	case req.IP != "":
+		list, err := ParseIPList(req.IP)
+		if err != nil || len(list) != 1 {
+			http.Error(w, "ip must be one address or CIDR", http.StatusBadRequest)
+			return
+		}
+		b.Prefix = list[0]
+	default:
+		p, err := h.hub.connPrefix(req.ConnID)
+		if errors.Is(err, errNoSuchConnection) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		b.Prefix = p
+	}
+	if req.Duration != "" {
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil || d <= 0 {
+			http.Error(w, "duration must be a positive duration such as \"1h\"", http.StatusBadRequest)
+			return
+		}
+		until := now.Add(d).UTC()
+		b.ExpiresAt = &until
+	}
+
+	if err := h.bans.add(b, now); err != nil {
+		h.opts.Logger.Printf("admin: save ban list: %v", err)
+	}
+	res := banResult{ban: b, Kicked: h.hub.kickBanned(b.Prefix)}
+	h.opts.Logger.Printf("admin: banned %s (kicked %d)", b.Prefix, res.Kicked)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		h.opts.Logger.Printf("admin: encode ban: %v", err)
+	}
+}
+
+func (h *Handler) removeBan(w http.ResponseWriter, r *http.Request) {
+	list, err := ParseIPList(r.URL.Query().Get("ip"))
+	if err != nil || len(list) != 1 {
+		http.Error(w, "ip must be one address or CIDR", http.StatusBadRequest)
+		return
+	}
+	ok, err := h.bans.remove(list[0], h.opts.Clock.Now())
+	if err != nil {
+		h.opts.Logger.Printf("admin: save ban list: %v", err)
+	}
+	if !ok {
+		http.Error(w, "no such ban", http.StatusNotFound)
+		return
+	}
+	h.opts.Logger.Printf("admin: unbanned %s", list[0])
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) listBans(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.bans.list(h.opts.Clock.Now())); err != nil {
+		h.opts.Logger.Printf("admin: encode bans: %v", err)
+	}
+}
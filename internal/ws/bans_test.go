@@ -0,0 +1,126 @@
+// Filename: internal/ws/bans_test.go
+
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestBanKicksAndRefusesUntilLifted(t *testing.T) {
+	banFile := filepath.Join(t.TempDir(), "bans.json")
+	h := NewHandler(Options{BanFile: banFile})
+	mux := http.NewServeMux()
+	mux.Handle("/ws", h)
+	mux.Handle("/admin/", h.AdminHandler("secret"))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+
+	admin := func(method, path, body string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+	redial := func() (*http.Response, error) {
+		conn, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {allowedOrigins[0]}})
+		if err == nil {
+			conn.Close()
+		}
+		return resp, err
+	}
+
+	conn, welcome := dialDecoded(t, url)
+
+	for _, body := range []string{`{}`, `{"ip":"127.0.0.1","conn_id":"x"}`, `{"ip":"localhost"}`, `{"ip":"127.0.0.1","duration":"-1m"}`} {
+		if resp := admin("POST", "/admin/bans", body); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: got %d expected 400", body, resp.StatusCode)
+		}
+	}
+	if resp := admin("POST", "/admin/bans", `{"conn_id":"nope"}`); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown conn_id: got %d expected 404", resp.StatusCode)
+	}
+
+	resp := admin("POST", "/admin/bans", `{"conn_id":"`+welcome.ConnID+`","reason":"spam"}`)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("ban: got %d expected 201", resp.StatusCode)
+	}
+	var res banResult
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if res.Prefix.String() != "127.0.0.1/32" || res.Kicked != 1 || res.Reason != "spam" || res.ExpiresAt != nil {
+		t.Errorf("ban: got %+v", res)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err := readData(conn)
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.ClosePolicyViolation || closeErr.Text != "banned" {
+		t.Errorf("banned client: got %v expected 1008 banned", err)
+	}
+	if resp, err := redial(); err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("reconnect while banned: got %v, %v expected 403", resp, err)
+	}
+
+	// The ban outlives the handler
+	again := NewHandler(Options{BanFile: banFile})
+	t.Cleanup(again.Close)
+	if !again.bans.banned("127.0.0.1:5000", time.Now()) {
+		t.Errorf("ban not read back from %s", banFile)
+	}
+
+	var list []ban
+	if err := json.NewDecoder(admin("GET", "/admin/bans", "").Body).Decode(&list); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(list) != 1 || list[0].Prefix != res.Prefix {
+		t.Errorf("list: got %+v", list)
+	}
+
+	if resp := admin("DELETE", "/admin/bans?ip=10.0.0.1", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unban unknown: got %d expected 404", resp.StatusCode)
+	}
+	if resp := admin("DELETE", "/admin/bans?ip=127.0.0.1", ""); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("unban: got %d expected 204", resp.StatusCode)
+	}
+	if resp, err := redial(); err != nil {
+		t.Errorf("reconnect after unban: %v (%v)", err, resp)
+	}
+}
+
+func TestTemporaryBanExpires(t *testing.T) {
+	l := newBanList("")
+	now := time.Now()
+	until := now.Add(time.Minute)
+	if err := l.add(ban{Prefix: netip.MustParsePrefix("203.0.113.0/24"), ExpiresAt: &until}, now); err != nil {
+		t.Fatal(err)
+	}
+	if !l.banned("203.0.113.7:5000", now.Add(59*time.Second)) {
+		t.Errorf("inside the range before expiry: expected banned")
+	}
+	if l.banned("198.51.100.1:5000", now) {
+		t.Errorf("outside the range: expected not banned")
+	}
+	if l.banned("203.0.113.7:5000", until) {
+		t.Errorf("at expiry: expected not banned")
+	}
+	if got := l.list(until); len(got) != 0 {
+		t.Errorf("after expiry: got %+v expected no bans", got)
+	}
+}
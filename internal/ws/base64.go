@@ -0,0 +1,53 @@
+package ws
+
+// Filename: internal/ws/base64.go
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"unicode/utf8"
+)
+
+// Error codes for b64decode
+const (
+	ErrCodeInvalidBase64 = "ERR_INVALID_BASE64"
+	ErrCodeNotUTF8       = "ERR_NOT_UTF8"
+)
+
+// The alphabet req asks for, without padding; padding is added on encode
+// and optional on decode
+func base64Encoding(req CommandRequest) *base64.Encoding {
+	if req.URLSafe {
+		return base64.RawURLEncoding
+	}
+	return base64.RawStdEncoding
+}
+
+// Return the padded base64 of text's UTF-8 bytes
+func runB64Encode(_ *Session, req CommandRequest) CommandResponse {
+	if req.Text == "" {
+		return errorResponse(req.Command, ErrCodeMissingText, "Missing text")
+	}
+	enc := base64Encoding(req).WithPadding(base64.StdPadding)
+	return CommandResponse{Command: req.Command, Text: enc.EncodeToString([]byte(req.Text))}
+}
+
+// Return the string text decodes to, or with raw its bytes in hex. Input
+// may leave out its padding.
+func runB64Decode(_ *Session, req CommandRequest) CommandResponse {
+	if req.Text == "" {
+		return errorResponse(req.Command, ErrCodeMissingText, "Missing text")
+	}
+	b, err := base64Encoding(req).DecodeString(strings.TrimRight(req.Text, "="))
+	if err != nil {
+		return errorResponse(req.Command, ErrCodeInvalidBase64, "Invalid base64: "+err.Error())
+	}
+	if req.Raw {
+		return CommandResponse{Command: req.Command, Text: hex.EncodeToString(b)}
+	}
+	if !utf8.Valid(b) {
+		return errorResponse(req.Command, ErrCodeNotUTF8, `Decoded bytes aren't UTF-8; send "raw":true for hex`)
+	}
+	return CommandResponse{Command: req.Command, Text: string(b)}
+}
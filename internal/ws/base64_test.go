@@ -0,0 +1,84 @@
+// Filename: internal/ws/base64_test.go
+
+package ws
+
+import (
+	"testing"
+)
+
+func b64(command, text string, urlsafe, raw bool) CommandResponse {
+	req := CommandRequest{Command: command, Text: text, URLSafe: urlsafe, Raw: raw}
+	return processCommand(DefaultRegistry, newSession(defaultHistorySize), req)
+}
+
+func TestB64Encode(t *testing.T) {
+	tests := []struct {
+		text    string
+		urlsafe bool
+		want    string
+	}{
+		{"hello", false, "aGVsbG8="},
+		{"a", false, "YQ=="},
+		{"ab", false, "YWI="},
+		{"abc", false, "YWJj"},
+		{"é", false, "w6k="},
+		{"?>>", false, "Pz4+"},
+		{"?>>", true, "Pz4-"},
+		{"ÿÿ", false, "w7/Dvw=="},
+		{"ÿÿ", true, "w7_Dvw=="},
+	}
+	for _, tt := range tests {
+		if resp := b64("b64encode", tt.text, tt.urlsafe, false); resp.Text != tt.want || resp.Code != "" {
+			t.Errorf("%q urlsafe=%v: got %+v expected %s", tt.text, tt.urlsafe, resp, tt.want)
+		}
+	}
+	if resp := b64("b64encode", "", false, false); resp.Code != ErrCodeMissingText {
+		t.Errorf("no text: got %+v", resp)
+	}
+}
+
+func TestB64Decode(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		urlsafe bool
+		raw     bool
+		want    string
+		code    string
+	}{
+		{"padded", "aGVsbG8=", false, false, "hello", ""},
+		{"unpadded", "aGVsbG8", false, false, "hello", ""},
+		{"two pad chars", "YQ==", false, false, "a", ""},
+		{"two pad chars left out", "YQ", false, false, "a", ""},
+		{"no padding needed", "YWJj", false, false, "abc", ""},
+		{"urlsafe", "w7_Dvw==", true, false, "ÿÿ", ""},
+		{"raw", "AAEC/w==", false, true, "000102ff", ""},
+		{"raw urlsafe", "AAEC_w", true, true, "000102ff", ""},
+
+		{"urlsafe alphabet without the flag", "w7_Dvw==", false, false, "", ErrCodeInvalidBase64},
+		{"std alphabet with the flag", "w7/Dvw==", true, false, "", ErrCodeInvalidBase64},
+		{"lone char", "a", false, false, "", ErrCodeInvalidBase64},
+		{"padding mid-text", "YQ=x", false, false, "", ErrCodeInvalidBase64},
+		{"not base64", "hello world!", false, false, "", ErrCodeInvalidBase64},
+		{"not UTF-8", "////", false, false, "", ErrCodeNotUTF8},
+		{"missing text", "", false, false, "", ErrCodeMissingText},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if resp := b64("b64decode", tt.text, tt.urlsafe, tt.raw); resp.Text != tt.want || resp.Code != tt.code {
+				t.Errorf("got %+v expected %q %s", resp, tt.want, tt.code)
+			}
+		})
+	}
+}
+
+func TestB64RoundTrip(t *testing.T) {
+	for _, text := range []string{"\x00\x01\x02\x7f", "tab\tnew\nline\r\n", " é日本👋🏽", "~~~???>>>"} {
+		for _, urlsafe := range []bool{false, true} {
+			enc := b64("b64encode", text, urlsafe, false)
+			if dec := b64("b64decode", enc.Text, urlsafe, false); dec.Text != text {
+				t.Errorf("%q urlsafe=%v: encoded %+v decoded %+v", text, urlsafe, enc, dec)
+			}
+		}
+	}
+}
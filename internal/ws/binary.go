@@ -0,0 +1,33 @@
+package ws
+
+// Filename: internal/ws/binary.go
+
+import (
+	"encoding/binary"
+
+	"github.com/gorilla/websocket"
+)
+
+// Size of the message-number header on echoed binary frames
+const binaryHeaderSize = 8
+
+// Echo a binary frame back as binary, prefixed with its message number as
+// a big-endian uint64, run it as a protobuf command, or close with 1003
+// when binary is turned off for the connection's origin.
+// Returns false once the connection is closing.
+func (h *Handler) handleBinaryFrame(c *client, remote string, n uint64, payload []byte) bool {
+	if c.policy.RejectBinary {
+		h.opts.Logger.Printf("binary frame rejected from %s (%d bytes)", remote, len(payload))
+		c.closeWith(websocket.CloseUnsupportedData, "binary frames not supported")
+		return false
+	}
+	if h.opts.ProtobufBinary {
+		return h.handleProtobufFrame(c, remote, payload)
+	}
+
+	h.opts.Logger.Printf("binary frame #%d from %s (%d bytes)", n, remote, len(payload))
+	reply := make([]byte, binaryHeaderSize+len(payload))
+	binary.BigEndian.PutUint64(reply, n)
+	copy(reply[binaryHeaderSize:], payload)
+	return c.enqueue(websocket.BinaryMessage, reply, laneData)
+}
@@ -0,0 +1,74 @@
+// Filename: internal/ws/binary_test.go
+
+package ws
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Send a binary frame and return the binary reply
+func binaryRoundTrip(t *testing.T, conn *websocket.Conn, payload []byte) []byte {
+	t.Helper()
+	if err := conn.WriteMessage(websocket.BinaryMessage, payload); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	msgType, reply, err := readData(conn)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if msgType != websocket.BinaryMessage {
+		t.Fatalf("got message type %d expected binary", msgType)
+	}
+	return reply
+}
+
+func TestBinaryEcho(t *testing.T) {
+	conn := dial(t, startServer(t, NewHandler(Options{})))
+
+	var prev uint64
+	for _, payload := range [][]byte{
+		{},
+		{0x00, 0xff, 0x10},
+		bytes.Repeat([]byte{0xab}, maxMessageSize),
+	} {
+		reply := binaryRoundTrip(t, conn, payload)
+		if len(reply) != binaryHeaderSize+len(payload) {
+			t.Fatalf("got %d bytes expected %d", len(reply), binaryHeaderSize+len(payload))
+		}
+		n := binary.BigEndian.Uint64(reply)
+		if n <= prev {
+			t.Errorf("message number %d did not advance past %d", n, prev)
+		}
+		prev = n
+		if !bytes.Equal(reply[binaryHeaderSize:], payload) {
+			t.Errorf("payload of %d bytes not echoed intact", len(payload))
+		}
+	}
+}
+
+func TestBinaryReject(t *testing.T) {
+	conn := dial(t, startServer(t, NewHandler(Options{RejectBinary: true})))
+
+	// Text still works in reject mode
+	if got := roundTrip(t, conn, "hello"); got != "hello" {
+		t.Fatalf("text echo: got %q", got)
+	}
+
+	if err := conn.WriteMessage(websocket.BinaryMessage, []byte{1, 2, 3}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err := conn.ReadMessage()
+
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseUnsupportedData {
+		t.Errorf("got %v expected close %d", err, websocket.CloseUnsupportedData)
+	}
+}
@@ -0,0 +1,143 @@
+package ws
+
+// Filename: internal/ws/broadcast.go
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Defaults for the broadcast command's guardrails
+const (
+	defaultMaxBroadcastBytes = 1024             // longest text one broadcast may carry
+	defaultBroadcastLimit    = 5                // broadcasts allowed per window
+	defaultBroadcastWindow   = 10 * time.Second // window the limit applies to
+)
+
+// Error codes for broadcasts
+const (
+	ErrCodeTextTooLong = "ERR_TEXT_TOO_LONG"
+	ErrCodeRateLimited = "ERR_RATE_LIMITED"
+)
+
+// Frame pushed to every other client by "broadcast"
+type broadcastFrame struct {
+	Type  string `json:"type"`
+	From  string `json:"from"` // sender's nickname, or conn_id if it has none
+	Text  string `json:"text"`
+	BSeq  uint64 `json:"bseq"`             // numbers this server's broadcasts from 1; see gapFrame
+	MsgID string `json:"msg_id,omitempty"` // with "ack"; see runAck
+}
+
+// What the sender of a broadcast gets back
+type broadcastResult struct {
+	Delivered int    `json:"delivered"`
+	Dropped   int    `json:"dropped"` // recipients whose queue was full
+	MsgID     string `json:"msg_id,omitempty"`
+}
+
+// rateWindow allows at most limit events in any window-long span
+type rateWindow struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	times  []time.Time // recent events, oldest first
+}
+
+func newRateWindow(limit int, window time.Duration) *rateWindow {
+	return &rateWindow{limit: limit, window: window}
+}
+
+// Record an event at now unless that would exceed the limit
+func (w *rateWindow) allow(now time.Time) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	cutoff := now.Add(-w.window)
+	i := 0
+	for i < len(w.times) && !w.times[i].After(cutoff) {
+		i++
+	}
+	w.times = w.times[i:]
+	if len(w.times) >= w.limit {
+		return false
+	}
+	w.times = append(w.times, now)
+	return true
+}
+
+// Queue frame for every client but the sender, never waiting on a queue.
+// Each one it's queued for is counted as a recipient of ack, if not nil.
+func (h *hub) broadcastFrom(sender *Session, frame broadcastFrame, ack *pendingAck) broadcastResult {
+	if h.relay != nil {
+		h.relay.publish("broadcast", frame)
+	}
+	var res broadcastResult
+	var from string
+	if sender != nil {
+		from = sender.conn.ID
+	}
+	out, clients := h.numbered(frame, from)
+	for _, c := range clients {
+		if c.session == sender {
+			continue
+		}
+		ack.expect(c.session.conn.ID)
+		if c.trySendBroadcast(out) {
+			res.Delivered++
+		} else {
+			ack.unexpect(c.session.conn.ID)
+			res.Dropped++
+		}
+	}
+	return res
+}
+
+// Number frame as the next broadcast, buffer it for replay and mirror it
+// to /events, returning it with the clients to send it to. Frames relayed
+// from other instances are renumbered, so a client sees one unbroken
+// sequence from the server it's connected to. sender is the conn_id that
+// doesn't get it back, if any. See joinAfter for why this happens under
+// the lock.
+func (h *hub) numbered(frame broadcastFrame, sender string) (*fanout, []*client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	frame.BSeq = h.bseq.Add(1)
+	h.sse.publish(frame.Type, frame)
+	out := newFanout(frame)
+	out.bseq = frame.BSeq
+	h.replay.add(out, sender, time.Now())
+	clients := make([]*client, 0, len(h.clients))
+	for c := range h.clients {
+		clients = append(clients, c)
+	}
+	return out, clients
+}
+
+// Fan text out to every other connection on this handler
+func runBroadcast(s *Session, req CommandRequest) CommandResponse {
+	if req.Text == "" {
+		return errorResponse(req.Command, ErrCodeInvalidOperand, "text must not be empty")
+	}
+	if len(req.Text) > s.maxBroadcast {
+		return errorResponse(req.Command, ErrCodeTextTooLong,
+			fmt.Sprintf("Broadcast text longer than %d bytes", s.maxBroadcast))
+	}
+	if s.hub == nil {
+		return CommandResponse{Command: req.Command, Data: broadcastResult{}}
+	}
+	if !s.broadcasts.allow(s.clock.Now()) {
+		s.events.publish(serverEvent{Event: "rate_limit", ConnID: s.conn.ID, Command: req.Command})
+		return errorResponse(req.Command, ErrCodeRateLimited,
+			fmt.Sprintf("Too many broadcasts (max %d per %s)", s.broadcasts.limit, s.broadcasts.window))
+	}
+
+	ack, errResp := s.openAck(req)
+	if errResp != nil {
+		return *errResp
+	}
+	res := s.hub.broadcastFrom(s, broadcastFrame{Type: "broadcast", From: s.name(), Text: req.Text, MsgID: ack.id()}, ack)
+	res.MsgID = ack.id()
+	ack.seal()
+	return CommandResponse{Command: req.Command, Data: res}
+}
@@ -0,0 +1,80 @@
+// Filename: internal/ws/broadcast_test.go
+
+package ws
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestBroadcastDeliveryCount(t *testing.T) {
+	url := startServer(t, NewHandler(Options{}))
+	sender, a, b := dial(t, url), dial(t, url), dial(t, url)
+	roundTrip(t, sender, "NICK:loud")
+
+	if got := roundTrip(t, sender, `{"command":"broadcast","text":"hi all"}`); got != `{"command":"broadcast","data":{"delivered":2,"dropped":0}}` {
+		t.Errorf("ack: got %s", got)
+	}
+	for i, conn := range []*websocket.Conn{a, b} {
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, msg, err := readData(conn)
+		var frame broadcastFrame
+		if err != nil || json.Unmarshal(msg, &frame) != nil || frame != (broadcastFrame{Type: "broadcast", From: "loud", Text: "hi all", BSeq: 1}) {
+			t.Errorf("recipient %d: got %s, %v", i, msg, err)
+		}
+	}
+
+	// The sender's next frame is its own reply, not the broadcast
+	if got := roundTrip(t, sender, "after"); got != "after" {
+		t.Errorf("sender: got %s", got)
+	}
+}
+
+func TestBroadcastLimits(t *testing.T) {
+	clock := newFakeClock(time.Now())
+	conn := dial(t, startServer(t, NewHandler(Options{MaxBroadcastBytes: 8, BroadcastLimit: 2, BroadcastWindow: time.Second, Clock: clock})))
+
+	tests := []struct {
+		send     string
+		expected string
+	}{
+		{`{"command":"broadcast","text":"` + strings.Repeat("x", 9) + `"}`, `{"command":"broadcast","error":"Broadcast text longer than 8 bytes","code":"ERR_TEXT_TOO_LONG"}`},
+		{`{"command":"broadcast","text":"one"}`, `{"command":"broadcast","data":{"delivered":0,"dropped":0}}`},
+		{`{"command":"broadcast","text":"two"}`, `{"command":"broadcast","data":{"delivered":0,"dropped":0}}`},
+		{`{"command":"broadcast","text":"three"}`, `{"command":"broadcast","error":"Too many broadcasts (max 2 per 1s)","code":"ERR_RATE_LIMITED"}`},
+	}
+	for _, tt := range tests {
+		if got := roundTrip(t, conn, tt.send); got != tt.expected {
+			t.Errorf("send %s: got %s expected %s", tt.send, got, tt.expected)
+		}
+	}
+
+	// The window goes by the handler's clock
+	clock.Advance(time.Second)
+	if got := roundTrip(t, conn, `{"command":"broadcast","text":"four"}`); got != `{"command":"broadcast","data":{"delivered":0,"dropped":0}}` {
+		t.Errorf("after the window: got %s", got)
+	}
+}
+
+func TestRateWindowSlides(t *testing.T) {
+	w := newRateWindow(2, time.Second)
+	start := time.Now()
+	for i, tt := range []struct {
+		at      time.Duration
+		allowed bool
+	}{
+		{0, true},
+		{100 * time.Millisecond, true},
+		{500 * time.Millisecond, false},
+		{time.Second, true}, // the first event has aged out
+		{1050 * time.Millisecond, false},
+	} {
+		if got := w.allow(start.Add(tt.at)); got != tt.allowed {
+			t.Errorf("event %d at %s: got %v expected %v", i, tt.at, got, tt.allowed)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package ws
+
+// Filename: internal/ws/bseq.go
+
+import "sync"
+
+// Frame telling a client which broadcasts it missed while its queue was
+// full, sent ahead of the next one that fits
+type gapFrame struct {
+	Type string `json:"type"`
+	From uint64 `json:"from"` // first bseq missed
+	To   uint64 `json:"to"`   // last bseq missed
+}
+
+// What "bseq" returns
+type bseqResult struct {
+	BSeq uint64 `json:"bseq"`
+}
+
+// The broadcasts a client missed and hasn't been told about yet
+type gapRange struct {
+	mu       sync.Mutex
+	from, to uint64 // from is 0 when nothing was missed
+}
+
+// Add the broadcast numbered seq to the range
+func (g *gapRange) add(seq uint64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.from == 0 || seq < g.from {
+		g.from = seq
+	}
+	if seq > g.to {
+		g.to = seq
+	}
+}
+
+// Empty the range, returning what it held
+func (g *gapRange) take() (from, to uint64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	from, to = g.from, g.to
+	g.from, g.to = 0, 0
+	return from, to
+}
+
+// Queue the broadcast out for c if there is room; see tryQueue. Once c
+// has caught up, meaning its queue has room without discarding anything,
+// it is first told about the broadcasts it missed. A broadcast that doesn't
+// fit joins those.
+func (c *client) trySendBroadcast(out *fanout) bool {
+	if from, to := c.gap.take(); from != 0 {
+		messageType, data, ok := c.encode(gapFrame{Type: "gap", From: from, To: to})
+		if !ok || !c.offer(outbound{messageType: messageType, data: data}) {
+			// Still behind; tell it later
+			c.gap.add(from)
+			c.gap.add(to)
+		}
+	}
+	if out.trySend(c) {
+		return true
+	}
+	c.gap.add(out.bseq)
+	return false
+}
+
+// Queue m only if there is room for it as things are
+func (c *client) offer(m outbound) bool {
+	if c.dropOldest {
+		c.sendMu.Lock()
+		defer c.sendMu.Unlock()
+	}
+	select {
+	case c.send <- m:
+		return true
+	default:
+		return false
+	}
+}
+
+// Report the number of the latest broadcast, to compare with the last
+// one seen
+func runBSeq(s *Session, req CommandRequest) CommandResponse {
+	var res bseqResult
+	if s.hub != nil {
+		res.BSeq = s.hub.bseq.Load()
+	}
+	return CommandResponse{Command: req.Command, Data: res}
+}
@@ -0,0 +1,89 @@
+// Filename: internal/ws/bseq_test.go
+
+package ws
+
+import (
+	"log"
+	"strings"
+	"testing"
+)
+
+// A client with no connection behind it and room for size frames, so
+// broadcasts to it are dropped once it's full
+func tinyQueueClient(h *hub, id string, size int) *client {
+	s := newSession(1)
+	s.conn.ID = id
+	c := &client{session: s, log: log.Default(), send: make(chan outbound, size), room: make(chan struct{}, 1), done: make(chan struct{})}
+	h.join(c)
+	return c
+}
+
+// Take everything queued for c, as the JSON text of each frame
+func drainQueue(c *client) []string {
+	var out []string
+	for len(c.send) > 0 {
+		out = append(out, string((<-c.send).data))
+	}
+	return out
+}
+
+func TestBroadcastGapNotice(t *testing.T) {
+	for _, dropOldest := range []bool{false, true} {
+		h := newHub(log.Default())
+		c := tinyQueueClient(h, "c1", 2)
+		c.dropOldest = dropOldest
+		for range 4 {
+			h.broadcastFrom(nil, broadcastFrame{Type: "broadcast", From: "x", Text: "hi"}, nil)
+		}
+
+		// Waiting for c: 1 and 2, or under drop-oldest 3 and 4 in their place
+		expected := []string{
+			`{"type":"broadcast","from":"x","text":"hi","bseq":1}`,
+			`{"type":"broadcast","from":"x","text":"hi","bseq":2}`,
+		}
+		gap := `{"type":"gap","from":3,"to":4}`
+		if dropOldest {
+			expected = []string{
+				`{"type":"broadcast","from":"x","text":"hi","bseq":3}`,
+				`{"type":"broadcast","from":"x","text":"hi","bseq":4}`,
+			}
+			gap = `{"type":"gap","from":1,"to":2}`
+		}
+		if got := drainQueue(c); len(got) != 2 || got[0] != expected[0] || got[1] != expected[1] {
+			t.Errorf("dropOldest %v: queued %q expected %q", dropOldest, got, expected)
+		}
+
+		// Caught up: the next broadcast comes after the notice, once
+		h.broadcastFrom(nil, broadcastFrame{Type: "broadcast", From: "x", Text: "hi"}, nil)
+		got := drainQueue(c)
+		expected = []string{gap, `{"type":"broadcast","from":"x","text":"hi","bseq":5}`}
+		if len(got) != 2 || got[0] != expected[0] || got[1] != expected[1] {
+			t.Errorf("dropOldest %v: after catching up queued %q expected %q", dropOldest, got, expected)
+		}
+		if got := drainQueue(c); len(got) != 0 {
+			t.Errorf("dropOldest %v: leftover %q", dropOldest, got)
+		}
+		h.broadcastFrom(nil, broadcastFrame{Type: "broadcast", From: "x", Text: "hi"}, nil)
+		if got := drainQueue(c); len(got) != 1 || got[0] != `{"type":"broadcast","from":"x","text":"hi","bseq":6}` {
+			t.Errorf("dropOldest %v: then queued %q", dropOldest, got)
+		}
+	}
+}
+
+func TestBSeqCommand(t *testing.T) {
+	url := startServer(t, NewHandler(Options{}))
+	conn, other := dial(t, url), dial(t, url)
+	if got := roundTrip(t, conn, `{"command":"bseq"}`); got != `{"command":"bseq","data":{"bseq":0}}` {
+		t.Errorf("before: got %s", got)
+	}
+	roundTrip(t, other, `{"command":"broadcast","text":"one"}`)
+	roundTrip(t, other, `{"command":"broadcast","text":"two"}`)
+	for _, expected := range []string{`"text":"one","bseq":1}`, `"text":"two","bseq":2}`} {
+		if _, msg, err := readData(conn); err != nil || !strings.HasSuffix(string(msg), expected) {
+			t.Errorf("got %s, %v expected ...%s", msg, err, expected)
+		}
+	}
+	if got := roundTrip(t, conn, `{"command":"bseq"}`); got != `{"command":"bseq","data":{"bseq":2}}` {
+		t.Errorf("after: got %s", got)
+	}
+}
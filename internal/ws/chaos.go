@@ -0,0 +1,194 @@
+package ws
+
+// Filename: internal/ws/chaos.go
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ChaosConfig turns on fault injection, for testing how clients cope with
+// a server that misbehaves the way real networks do. It applies to a
+// connection chosen at random with Probability as it opens, or picked
+// afterwards through PUT /admin/connections/{id}/chaos; every other
+// connection is left alone. Each fault injected is logged and counted in
+// the stats command's chaos_faults.
+type ChaosConfig struct {
+	Probability   float64 `json:"probability"`    // share of new connections chosen, from 0 to 1
+	LatencyMinMS  int     `json:"latency_min_ms"` // delay before each write, in milliseconds...
+	LatencyMaxMS  int     `json:"latency_max_ms"` // ...or a random one up to this; 0 for a fixed delay
+	DropRate      float64 `json:"drop_rate"`      // share of broadcast, tick and presence frames dropped, from 0 to 1
+	SuppressPings bool    `json:"suppress_pings"` // send no heartbeat pings, so the client's own timeout fires
+	KillAfter     int     `json:"kill_after"`     // cut the connection, with no close frame, at this many messages received; 0 never
+}
+
+// ValidateChaos rejects shares outside 0 to 1 and negative counts
+func ValidateChaos(cfg *ChaosConfig) error {
+	switch {
+	case cfg.Probability < 0 || cfg.Probability > 1 || cfg.DropRate < 0 || cfg.DropRate > 1:
+		return errors.New("chaos: probability and drop_rate must be between 0 and 1")
+	case cfg.LatencyMinMS < 0 || cfg.LatencyMaxMS < 0 || cfg.KillAfter < 0:
+		return errors.New("chaos: latency and kill_after must not be negative")
+	case cfg.LatencyMaxMS != 0 && cfg.LatencyMaxMS < cfg.LatencyMinMS:
+		return errors.New("chaos: latency_max_ms must not be below latency_min_ms")
+	}
+	return nil
+}
+
+// LoadChaos reads and validates a ChaosConfig from the JSON file at path:
+//
+//	{"probability": 0.1, "latency_min_ms": 50, "latency_max_ms": 500, "drop_rate": 0.05}
+func LoadChaos(path string) (*ChaosConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var cfg ChaosConfig
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := ValidateChaos(&cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// Faults injected, by kind, across all connections
+var (
+	chaosDelayCounter uint64
+	chaosDropCounter  uint64
+	chaosPingCounter  uint64
+	chaosKillCounter  uint64
+)
+
+// The chaos_faults stats: nil until a fault has been injected
+func chaosFaultCounts() map[string]uint64 {
+	counts := map[string]uint64{
+		"delayed_writes":   atomic.LoadUint64(&chaosDelayCounter),
+		"dropped_frames":   atomic.LoadUint64(&chaosDropCounter),
+		"suppressed_pings": atomic.LoadUint64(&chaosPingCounter),
+		"killed":           atomic.LoadUint64(&chaosKillCounter),
+	}
+	for _, n := range counts {
+		if n > 0 {
+			return counts
+		}
+	}
+	return nil
+}
+
+// Put c under cfg, or take it out with nil
+func (c *client) setChaos(cfg *ChaosConfig) {
+	if c.chaos.Swap(cfg) != cfg {
+		state := "off"
+		if cfg != nil {
+			state = "on"
+		}
+		c.log.Printf("chaos %s for %s", state, c.session.label())
+	}
+}
+
+// Apply the write faults to m before writePump writes it: wait out the
+// latency, then maybe drop it. A dropped broadcast is owed to the client
+// as a gap, as if it hadn't fit in the queue. False means don't write m,
+// because it was dropped or the connection closed while waiting.
+func (c *client) chaosWrite(cfg *ChaosConfig, m outbound) bool {
+	if delay := cfg.latency(c.session.rand); delay > 0 {
+		atomic.AddUint64(&chaosDelayCounter, 1)
+		c.log.Printf("chaos: delaying write to %s by %s", c.session.label(), delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-c.done:
+			timer.Stop()
+			return false
+		}
+	}
+	if m.droppable && cfg.DropRate > 0 && c.session.rand.Float64() < cfg.DropRate {
+		atomic.AddUint64(&chaosDropCounter, 1)
+		c.log.Printf("chaos: dropped a frame to %s", c.session.label())
+		if m.bseq != 0 {
+			c.gap.add(m.bseq)
+		}
+		return false
+	}
+	return true
+}
+
+// One write's delay: LatencyMinMS, or a random one up to LatencyMaxMS
+func (cfg *ChaosConfig) latency(r *rand.Rand) time.Duration {
+	ms := cfg.LatencyMinMS
+	if cfg.LatencyMaxMS > ms {
+		ms += r.IntN(cfg.LatencyMaxMS - ms + 1)
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// Should the heartbeat skip this ping? Counts and logs it if so.
+func (c *client) chaosSkipPing() bool {
+	cfg := c.chaos.Load()
+	if cfg == nil || !cfg.SuppressPings {
+		return false
+	}
+	atomic.AddUint64(&chaosPingCounter, 1)
+	c.log.Printf("chaos: suppressed a ping to %s", c.session.label())
+	return true
+}
+
+// Cut the connection, without a close frame, once seq reaches KillAfter.
+// True if it was cut.
+func (c *client) chaosKill(seq uint64) bool {
+	cfg := c.chaos.Load()
+	if cfg == nil || cfg.KillAfter == 0 || seq < uint64(cfg.KillAfter) {
+		return false
+	}
+	atomic.AddUint64(&chaosKillCounter, 1)
+	c.log.Printf("chaos: killing %s after %d messages", c.session.label(), seq)
+	c.Close(websocket.CloseAbnormalClosure, "")
+	return true
+}
+
+// Put the live client with this conn_id under cfg, or nil to take it out;
+// false if there is none
+func (h *hub) setChaos(id string, cfg *ChaosConfig) bool {
+	h.mu.Lock()
+	c, ok := h.lookup(id)
+	h.mu.Unlock()
+	if ok {
+		c.setChaos(cfg)
+	}
+	return ok
+}
+
+func (h *Handler) chaosConnection(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if req.Enabled == nil {
+		http.Error(w, "enabled must be true or false", http.StatusBadRequest)
+		return
+	}
+	var cfg *ChaosConfig
+	if *req.Enabled {
+		cfg = h.opts.Chaos
+	}
+	if !h.hub.setChaos(r.PathValue("id"), cfg) {
+		http.Error(w, "no such connection", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,162 @@
+// Filename: internal/ws/chaos_test.go
+
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestChaosLatencyTargetedByAdmin(t *testing.T) {
+	h := NewHandler(Options{Chaos: &ChaosConfig{LatencyMinMS: 150}})
+	mux := http.NewServeMux()
+	mux.Handle("/ws", h)
+	mux.Handle("/admin/", h.AdminHandler("secret"))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	conn, welcome := dialDecoded(t, "ws"+strings.TrimPrefix(srv.URL, "http")+"/ws")
+
+	echo := func() time.Duration {
+		t.Helper()
+		start := time.Now()
+		if got := roundTrip(t, conn, "hello"); got != "hello" {
+			t.Fatalf("echo: got %q", got)
+		}
+		return time.Since(start)
+	}
+	if took := echo(); took >= 150*time.Millisecond {
+		t.Fatalf("echo before chaos took %s", took)
+	}
+
+	req, _ := http.NewRequest("PUT", srv.URL+"/admin/connections/"+welcome.ConnID+"/chaos", strings.NewReader(`{"enabled":true}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("PUT chaos: got %v, %v", resp, err)
+	}
+	resp.Body.Close()
+
+	before := atomic.LoadUint64(&chaosDelayCounter)
+	if took := echo(); took < 150*time.Millisecond {
+		t.Errorf("echo under chaos took %s expected at least 150ms", took)
+	}
+	if atomic.LoadUint64(&chaosDelayCounter) == before {
+		t.Error("delayed write not counted")
+	}
+}
+
+func TestChaosAdminRouteNeedsChaos(t *testing.T) {
+	srv := httptest.NewServer(NewHandler(Options{}).AdminHandler("secret"))
+	t.Cleanup(srv.Close)
+	req, _ := http.NewRequest("PUT", srv.URL+"/admin/connections/c1/chaos", strings.NewReader(`{"enabled":true}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound && resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("PUT chaos without Options.Chaos: got %d", resp.StatusCode)
+	}
+}
+
+func TestChaosDropLeavesGap(t *testing.T) {
+	h := NewHandler(Options{Chaos: &ChaosConfig{Probability: 1, DropRate: 1}})
+	conn := dial(t, startServer(t, h))
+
+	// Every broadcast is dropped, but the gap notice the next one brings isn't
+	frame := broadcastFrame{Type: "broadcast", From: "test", Text: "hi"}
+	got := make(chan []byte, 1)
+	go func() {
+		_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		_, msg, _ := readData(conn)
+		got <- msg
+	}()
+	for deadline := time.Now().Add(3 * time.Second); time.Now().Before(deadline) && len(got) == 0; {
+		h.hub.broadcastFrom(nil, frame, nil)
+		time.Sleep(20 * time.Millisecond)
+	}
+	msg := <-got
+	var gap gapFrame
+	if err := json.Unmarshal(msg, &gap); err != nil || gap.Type != "gap" || gap.From == 0 || gap.To < gap.From {
+		t.Fatalf("got %s expected a gap notice", msg)
+	}
+	if faults := chaosFaultCounts(); faults["dropped_frames"] == 0 {
+		t.Errorf("dropped frames not counted: %v", faults)
+	}
+}
+
+func TestChaosSuppressesPings(t *testing.T) {
+	before := atomic.LoadUint64(&chaosPingCounter)
+	h := NewHandler(Options{
+		PongWait:   400 * time.Millisecond,
+		PingPeriod: 50 * time.Millisecond,
+		Chaos:      &ChaosConfig{Probability: 1, SuppressPings: true},
+	})
+	conn := dial(t, startServer(t, h))
+	var pings atomic.Int32
+	conn.SetPingHandler(func(string) error {
+		pings.Add(1)
+		return nil
+	})
+	_ = conn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	_, _, _ = conn.ReadMessage() // times out, handling any ping meanwhile
+	if n := pings.Load(); n != 0 {
+		t.Errorf("got %d pings expected none", n)
+	}
+	if atomic.LoadUint64(&chaosPingCounter) == before {
+		t.Error("suppressed pings not counted")
+	}
+}
+
+func TestChaosKillAfter(t *testing.T) {
+	conn := dial(t, startServer(t, NewHandler(Options{Chaos: &ChaosConfig{Probability: 1, KillAfter: 2}})))
+	if got := roundTrip(t, conn, "one"); got != "one" {
+		t.Fatalf("first message: got %q", got)
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("two")); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err := readData(conn)
+	var ce *websocket.CloseError
+	if !errors.As(err, &ce) || ce.Code != websocket.CloseAbnormalClosure {
+		t.Fatalf("after the second message: got %v expected 1006", err)
+	}
+}
+
+func TestValidateChaos(t *testing.T) {
+	for _, cfg := range []ChaosConfig{
+		{Probability: 1.5},
+		{DropRate: -0.1},
+		{LatencyMinMS: -1},
+		{LatencyMinMS: 100, LatencyMaxMS: 50},
+		{KillAfter: -1},
+	} {
+		if ValidateChaos(&cfg) == nil {
+			t.Errorf("%+v: accepted", cfg)
+		}
+	}
+	if err := ValidateChaos(&ChaosConfig{Probability: 0.5, LatencyMinMS: 10, LatencyMaxMS: 50, DropRate: 0.1}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestChaosLatencyFollowsRandSource(t *testing.T) {
+	cfg := &ChaosConfig{LatencyMinMS: 10, LatencyMaxMS: 5000}
+	a, b := rand.New(rand.NewPCG(1, 2)), rand.New(rand.NewPCG(1, 2))
+	for range 10 {
+		if da, db := cfg.latency(a), cfg.latency(b); da != db {
+			t.Fatalf("same source, different delays: %s and %s", da, db)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package ws
+
+// Filename: internal/ws/clock.go
+
+import "time"
+
+// Clock is where the heartbeat and idle timeout get the time and their
+// tickers from. Tests swap in a fake one to drive pings and timeouts
+// without waiting for them.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker is the part of a time.Ticker the handler uses
+type Ticker interface {
+	C() <-chan time.Time
+	Reset(d time.Duration)
+	Stop()
+}
+
+// The wall clock, used when Options.Clock is nil
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTicker(d time.Duration) Ticker { return realTicker{time.NewTicker(d)} }
+
+type realTicker struct{ t *time.Ticker }
+
+func (r realTicker) C() <-chan time.Time   { return r.t.C }
+func (r realTicker) Reset(d time.Duration) { r.t.Reset(d) }
+func (r realTicker) Stop()                 { r.t.Stop() }
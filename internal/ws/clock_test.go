@@ -0,0 +1,331 @@
+// Filename: internal/ws/clock_test.go
+
+package ws
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// A Clock that only moves when the test advances it; its tickers fire
+// from Advance
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+type fakeTicker struct {
+	clock  *fakeClock
+	c      chan time.Time
+	period time.Duration
+	next   time.Time
+	active bool
+}
+
+func newFakeClock(now time.Time) *fakeClock { return &fakeClock{now: now} }
+
+func (f *fakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) NewTicker(d time.Duration) Ticker {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t := &fakeTicker{clock: f, c: make(chan time.Time, 1), period: d, next: f.now.Add(d), active: true}
+	f.tickers = append(f.tickers, t)
+	return t
+}
+
+// Move the clock on by d, firing every ticker that comes due (at most
+// once each, dropping the tick if the last is unread, like time.Ticker)
+func (f *fakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+	for _, t := range f.tickers {
+		if !t.active || t.next.After(f.now) {
+			continue
+		}
+		select {
+		case t.c <- f.now:
+		default:
+		}
+		t.next = f.now.Add(t.period)
+	}
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.c }
+
+func (t *fakeTicker) Reset(d time.Duration) {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.period, t.next, t.active = d, t.clock.now.Add(d), true
+}
+
+func (t *fakeTicker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.active = false
+}
+
+// Wait until the clock has handed out n tickers, so Advance has something to fire
+func (f *fakeClock) waitTickers(t *testing.T, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		f.mu.Lock()
+		got := len(f.tickers)
+		f.mu.Unlock()
+		if got >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d tickers expected %d", got, n)
+		}
+	}
+}
+
+func assertClosedWith(t *testing.T, conn *websocket.Conn, code int, text string) {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, msg, err := readData(conn)
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != code || closeErr.Text != text {
+		t.Fatalf("got %q, %v expected close %d %q", msg, err, code, text)
+	}
+}
+
+func TestPongTimeout(t *testing.T) {
+	clock := newFakeClock(time.Now())
+	conn := dial(t, startServer(t, NewHandler(Options{Clock: clock})))
+	conn.SetPingHandler(func(string) error { return nil }) // never pong
+
+	// Ping and pong-timeout tickers
+	clock.waitTickers(t, 2)
+	clock.Advance(pongWait - time.Second)
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("still here")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := readData(conn); err != nil {
+		t.Fatalf("closed before pongWait: %v", err)
+	}
+
+	// The frame counts as a sign of life, so the wait starts again from it
+	clock.Advance(pongWait - time.Second)
+	clock.Advance(time.Second)
+	assertClosedWith(t, conn, websocket.CloseNormalClosure, "idle timeout")
+}
+
+func TestHeartbeat(t *testing.T) {
+	clock := newFakeClock(time.Now())
+	conn := dial(t, startServer(t, NewHandler(Options{Clock: clock})))
+
+	// The client answers pings while this goroutine reads
+	pings := make(chan string, 4)
+	conn.SetPingHandler(func(data string) error {
+		pings <- data
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	replies := make(chan string, 4)
+	go func() {
+		defer close(replies)
+		for {
+			_, msg, err := readData(conn)
+			if err != nil {
+				return
+			}
+			replies <- unstamped(string(msg))
+		}
+	}()
+
+	clock.waitTickers(t, 2)
+	clock.Advance(pingPeriod - time.Second)
+	select {
+	case data := <-pings:
+		t.Fatalf("ping %q before the period was up", data)
+	case <-time.After(20 * time.Millisecond):
+	}
+	clock.Advance(time.Second)
+	select {
+	case data := <-pings:
+		if want := string(pingPayload(clock.Now())); data != want {
+			t.Errorf("ping payload %q expected %q", data, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no ping after the period")
+	}
+
+	// The server reads in order, so once this is answered the pong before
+	// it has been seen
+	add := `{"command":"add","a":1,"b":1}`
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(add)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if got := <-replies; got != `{"command":"add","result":2}` {
+		t.Fatalf("after the pong: got %q", got)
+	}
+
+	// pongWait after the connection opened, but not after the pong
+	clock.Advance(pongWait - pingPeriod + time.Second)
+	select {
+	case got, ok := <-replies:
+		t.Fatalf("got %q (open %v) after the pong pushed the timeout out", got, ok)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestClientPingsKeepAlive(t *testing.T) {
+	clock := newFakeClock(time.Now())
+	conn := dial(t, startServer(t, NewHandler(Options{Clock: clock})))
+	conn.SetPingHandler(func(string) error { return nil }) // never pong
+	pongs := make(chan string, 1)
+	conn.SetPongHandler(func(data string) error {
+		pongs <- data
+		return nil
+	})
+	replies := make(chan string, 1)
+	go func() {
+		defer close(replies)
+		for {
+			_, msg, err := readData(conn)
+			if err != nil {
+				return
+			}
+			replies <- unstamped(string(msg))
+		}
+	}()
+
+	// Only the client's pings for twice pongWait
+	clock.waitTickers(t, 2)
+	for i := 0; i < 4; i++ {
+		if err := conn.WriteControl(websocket.PingMessage, []byte("keepalive"), time.Now().Add(time.Second)); err != nil {
+			t.Fatalf("ping: %v", err)
+		}
+		select {
+		case <-pongs:
+		case <-time.After(time.Second):
+			t.Fatalf("no pong for ping %d", i+1)
+		}
+		clock.Advance(pongWait / 2)
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"command":"add","a":1,"b":1}`)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if got, ok := <-replies; !ok || got != `{"command":"add","result":2}` {
+		t.Fatalf("after %s of client pings: got %q (open %v)", 2*pongWait, got, ok)
+	}
+}
+
+func TestClientPingsAnswered(t *testing.T) {
+	conn := dial(t, startServer(t, NewHandler(Options{})))
+	var pongs []string
+	conn.SetPongHandler(func(data string) error {
+		pongs = append(pongs, data)
+		return nil
+	})
+
+	payloads := []string{"", "hello", string([]byte{0x00, 0xff, 0xfe, '\n'}), strings.Repeat("x", 125)}
+	total := 0
+	for _, p := range payloads {
+		if err := conn.WriteControl(websocket.PingMessage, []byte(p), time.Now().Add(time.Second)); err != nil {
+			t.Fatalf("ping %q: %v", p, err)
+		}
+		total += len(p)
+	}
+	// Replies come in order, so the pongs are in by the time this is
+	var resp struct {
+		Data statsInfo `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(rawRoundTrip(t, conn, `{"command":"stats"}`)), &resp); err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	if len(pongs) != len(payloads) {
+		t.Fatalf("got %d pongs expected %d", len(pongs), len(payloads))
+	}
+	for i, p := range payloads {
+		if pongs[i] != p {
+			t.Errorf("pong %d: got %q expected %q", i, pongs[i], p)
+		}
+	}
+	if got := resp.Data.Connection; got.Pings != uint64(len(payloads)) || got.PingBytes != uint64(total) {
+		t.Errorf("stats: got %d pings, %d bytes expected %d, %d", got.Pings, got.PingBytes, len(payloads), total)
+	}
+}
+
+func TestIdleTimeoutFakeClock(t *testing.T) {
+	const idle = 20 * time.Second // inside pongWait, so no pong is needed
+	clock := newFakeClock(time.Now())
+	url := startServer(t, NewHandler(Options{Clock: clock, IdleTimeout: idle}))
+
+	// Ping, pong-timeout and idle tickers
+	chatty := dial(t, url)
+	clock.waitTickers(t, 3)
+	clock.Advance(idle / 2)
+	roundTrip(t, chatty, `{"command":"add","a":1,"b":1}`)
+	clock.Advance(idle / 2)
+	if got := roundTrip(t, chatty, `{"command":"add","a":2,"b":2}`); got != `{"command":"add","result":4}` {
+		t.Fatalf("data half an idle period ago: got %s", got)
+	}
+
+	quiet := dial(t, url)
+	clock.waitTickers(t, 6)
+	clock.Advance(idle)
+	assertClosedWith(t, quiet, websocket.CloseNormalClosure, "idle: no data")
+}
+
+func TestCheckOriginOption(t *testing.T) {
+	url := startServer(t, NewHandler(Options{CheckOrigin: func(r *http.Request) bool {
+		return strings.HasSuffix(r.Header.Get("Origin"), ".example.com")
+	}}))
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"https://app.example.com"}})
+	if err != nil {
+		t.Fatalf("allowed origin: %v", err)
+	}
+	conn.Close()
+	if _, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {allowedOrigins[0]}}); err == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("origin the checker refuses: got %v", err)
+	}
+}
+
+// A bytes.Buffer safe to read while the server logs into it
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestLoggerOption(t *testing.T) {
+	var out lockedBuffer
+	conn := dial(t, startServer(t, NewHandler(Options{Logger: log.New(&out, "", 0)})))
+	roundTrip(t, conn, "hello")
+	rawRoundTrip(t, conn, `{"command":"nick","name":"ada"}`)
+	for _, line := range []string{"connection opened from", `is now known as "ada"`} {
+		if got := out.String(); !strings.Contains(got, line) {
+			t.Errorf("logged %q expected %q", got, line)
+		}
+	}
+}
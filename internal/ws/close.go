@@ -0,0 +1,192 @@
+package ws
+
+// Filename: internal/ws/close.go
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Send a close frame so the peer learns why we're hanging up, unless one
+// has gone already. closeGracefully then waits for the peer's answer
+// before the connection is torn down.
+func (c *client) closeWith(code int, reason string) {
+	if !c.closeSent.CompareAndSwap(false, true) {
+		return
+	}
+	c.log.Printf("closing with %d (%s)", code, reason)
+	c.recordClose(code, reason)
+	_ = c.conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, reason),
+		time.Now().Add(writeWait),
+	)
+}
+
+// Like kick, but the close frame waits its turn behind the frames already
+// queued, so a final notice is delivered before the connection closes. If
+// the data queue is full it goes on the system lane instead, behind the
+// notices there but ahead of the data, so it is never lost to a client
+// that has stopped reading. The read loop ignores anything the peer sends
+// from now on.
+func (c *client) closeAfterQueued(code int, reason string) {
+	if !c.closing.CompareAndSwap(false, true) {
+		return
+	}
+	c.log.Printf("closing %s with %d (%s) after queued frames", c.session.label(), code, reason)
+	c.recordClose(code, reason)
+	m := outbound{messageType: websocket.CloseMessage, data: websocket.FormatCloseMessage(code, reason)}
+	if !c.offer(m) {
+		c.queue(m, laneSystem)
+	}
+	_ = c.conn.SetReadDeadline(time.Now().Add(kickGrace + writeWait))
+}
+
+// Close c with 1001 once ctx is cancelled, the server going away or the
+// request being abandoned; the read loop then tears down as for a kick.
+// Stops with c.
+func (c *client) closeOnCancel(ctx context.Context) {
+	c.goWorker(func() {
+		select {
+		case <-ctx.Done():
+			c.kick(websocket.CloseGoingAway, "server shutting down")
+		case <-c.done:
+		}
+	})
+}
+
+// CloseHandler for the peer's close frame: record the code and reason and,
+// if the peer started the close, answer the way gorilla's default handler
+// does, mirroring its code, so the closing handshake completes. An answer
+// to our own close completes it already. gorilla has already rejected
+// reserved or malformed codes with 1002; a close with no status arrives as
+// 1005 and is answered empty.
+func (c *client) handlePeerClose(code int, text string) error {
+	c.log.Printf("close from %s: %d (%q)", c.session.conn.RemoteAddr, code, text)
+	c.closeReceived.Store(true)
+	c.session.stats.recordCloseCode(code)
+	c.recordClose(code, text)
+	if !c.closeSent.CompareAndSwap(false, true) {
+		c.closeAnswered.Store(true)
+		return nil
+	}
+
+	message := []byte{}
+	if code != websocket.CloseNoStatusReceived {
+		message = websocket.FormatCloseMessage(code, "")
+	}
+	_ = c.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(writeWait))
+	return nil
+}
+
+// Every way out of a read loop ends here, before the socket is closed. If
+// we sent a close frame the peer hasn't answered, keep reading for up to
+// writeWait, discarding data frames, until its close arrives, so it sees
+// our code rather than 1006. After any other read error this returns at
+// once, since gorilla's read errors stick.
+func (c *client) closeGracefully() {
+	if !c.closeSent.Load() || c.closeReceived.Load() {
+		return
+	}
+	_ = c.conn.SetReadDeadline(time.Now().Add(writeWait))
+	for {
+		if _, _, err := c.conn.NextReader(); err != nil {
+			return
+		}
+	}
+}
+
+// Remember the first close frame to go either way
+func (c *client) recordClose(code int, reason string) {
+	if c.closeCode.CompareAndSwap(0, int32(code)) {
+		c.closeReason.Store(reason)
+	}
+}
+
+// The code and reason the connection ended with; 1006 if no close frame
+// went either way
+func (c *client) finalClose() (int, string) {
+	code := c.closeCode.Load()
+	if code == 0 {
+		return websocket.CloseAbnormalClosure, ""
+	}
+	reason, _ := c.closeReason.Load().(string)
+	return int(code), reason
+}
+
+// Pick the close frame to send after ReadMessage fails. ok is false when
+// there is nothing to say: the peer closed first (gorilla already answered),
+// sent a malformed frame (gorilla already sent 1002), or the socket is gone.
+func closeForReadError(err error) (code int, reason string, ok bool) {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, websocket.ErrReadLimit):
+		return websocket.CloseMessageTooBig, "message too big", true
+	case errors.As(err, &netErr) && netErr.Timeout():
+		// No pong (or anything else) within Options.PongWait
+		return websocket.CloseNormalClosure, "idle timeout", true
+	}
+	return 0, "", false
+}
+
+// Why a read loop ended, as told by the error ReadMessage gave
+type readEnd int
+
+const (
+	endNormal     readEnd = iota // the peer closed with 1000 or 1001, or answered our close
+	endUnexpected                // the peer closed with any other code
+	endTimeout                   // nothing, not even a pong, within the read deadline
+	endTooBig                    // a message over the read limit
+	endTransport                 // the connection broke: reset, EOF without a close frame...
+	numReadEnds
+)
+
+// Names for the stats and the log
+var readEndNames = [numReadEnds]string{"normal", "unexpected", "timeout", "too_big", "transport"}
+
+func (e readEnd) String() string { return readEndNames[e] }
+
+// Classify the error that ended c's read loop. gorilla reports a
+// connection that simply stopped, with no close frame, as a 1006 close
+// error; that's a transport failure like a reset, not a close.
+func (c *client) readEnd(err error) readEnd {
+	var netErr net.Error
+	switch {
+	case websocket.IsCloseError(err, websocket.CloseAbnormalClosure):
+		return endTransport
+	case c.closeAnswered.Load(), websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway):
+		return endNormal
+	case websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway):
+		return endUnexpected
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return endTimeout
+	case errors.Is(err, websocket.ErrReadLimit):
+		return endTooBig
+	}
+	return endTransport
+}
+
+// Count and log the end of c's read loop. A close from the peer is
+// routine; any other code, and a broken connection, are warnings.
+func (c *client) logReadEnd(end readEnd, err error) {
+	c.session.stats.readEnds[end].Add(1)
+	label := c.session.label()
+	switch end {
+	case endNormal:
+		c.log.Printf("connection %s closed: %v", label, err)
+	case endUnexpected:
+		var closeErr *websocket.CloseError
+		errors.As(err, &closeErr)
+		c.log.Printf("warning: unexpected close on %s: code %d (%q)", label, closeErr.Code, closeErr.Text)
+	case endTimeout:
+		c.log.Printf("read timeout on %s: no frame or pong in time", label)
+	case endTooBig:
+		c.log.Printf("message over the read limit on %s", label)
+	default:
+		c.log.Printf("warning: transport error on %s: %v", label, err)
+	}
+}
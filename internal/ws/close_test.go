@@ -0,0 +1,341 @@
+// Filename: internal/ws/close_test.go
+
+package ws
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/goleak"
+)
+
+// Send one frame and expect the server to close with code
+func expectClose(t *testing.T, conn *websocket.Conn, messageType int, payload []byte, code int) {
+	t.Helper()
+	if err := conn.WriteMessage(messageType, payload); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err := readData(conn)
+
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != code {
+		t.Errorf("got %v expected close %d", err, code)
+	}
+}
+
+func TestCloseCodes(t *testing.T) {
+	// A command whose reply can't be encoded
+	reg := NewCommandRegistry()
+	if err := reg.Register("broken", func(_ *Session, req CommandRequest) CommandResponse {
+		return CommandResponse{Command: req.Command, Data: func() {}}
+	}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	url := startServer(t, NewHandler(Options{Registry: reg}))
+
+	tests := []struct {
+		name        string
+		messageType int
+		payload     []byte
+		code        int
+	}{
+		{"too big", websocket.TextMessage, []byte(strings.Repeat("x", maxMessageSize+1)), websocket.CloseMessageTooBig},
+		{"invalid UTF-8", websocket.TextMessage, []byte{'h', 'i', 0xff}, websocket.CloseInvalidFramePayloadData},
+		{"marshal failure", websocket.TextMessage, []byte(`{"command":"broken"}`), websocket.CloseInternalServerErr},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expectClose(t, dial(t, url), tt.messageType, tt.payload, tt.code)
+		})
+	}
+}
+
+func TestCloseForReadError(t *testing.T) {
+	timeout := &net.OpError{Op: "read", Err: errTimeout{}}
+
+	tests := []struct {
+		name string
+		err  error
+		code int
+		ok   bool
+	}{
+		{"read limit", websocket.ErrReadLimit, websocket.CloseMessageTooBig, true},
+		{"pong timeout", timeout, websocket.CloseNormalClosure, true},
+		{"peer closed", &websocket.CloseError{Code: websocket.CloseGoingAway}, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, ok := closeForReadError(tt.err)
+			if code != tt.code || ok != tt.ok {
+				t.Errorf("got %d, %v expected %d, %v", code, ok, tt.code, tt.ok)
+			}
+		})
+	}
+}
+
+// A net.Error that reports a timeout
+type errTimeout struct{}
+
+func (errTimeout) Error() string   { return "i/o timeout" }
+func (errTimeout) Timeout() bool   { return true }
+func (errTimeout) Temporary() bool { return true }
+
+func TestPeerCloseIsRecorded(t *testing.T) {
+	tests := []struct {
+		name    string
+		message []byte
+		code    int
+	}{
+		{"code and reason", websocket.FormatCloseMessage(4001, "client done"), 4001},
+		{"empty reason", websocket.FormatCloseMessage(4002, ""), 4002},
+		{"no status", []byte{}, websocket.CloseNoStatusReceived},
+	}
+
+	h := NewHandler(Options{})
+	url := startServer(t, h)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := h.stats.closeCodeCounts()[tt.code]
+			conn := dial(t, url)
+			if err := conn.WriteControl(websocket.CloseMessage, tt.message, time.Now().Add(time.Second)); err != nil {
+				t.Fatalf("write close: %v", err)
+			}
+
+			// The server echoes the close after recording it
+			_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			_, _, err := conn.ReadMessage()
+			var closeErr *websocket.CloseError
+			if !errors.As(err, &closeErr) || closeErr.Code != tt.code {
+				t.Fatalf("got %v expected close %d echoed", err, tt.code)
+			}
+
+			if got := h.stats.closeCodeCounts()[tt.code]; got != before+1 {
+				t.Errorf("close %d counted %d times expected %d", tt.code, got, before+1)
+			}
+		})
+	}
+}
+
+func TestContextCancelClosesConnection(t *testing.T) {
+	// Each request gets a context the test can cancel, and ServeHTTP
+	// returning (after the connection's goroutines have) is reported
+	h := NewHandler(Options{})
+	cancels := make(chan context.CancelFunc, 2)
+	returned := make(chan struct{}, 2)
+	url := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithCancel(r.Context())
+		cancels <- cancel
+		h.ServeHTTP(w, r.WithContext(ctx))
+		returned <- struct{}{}
+	}))
+
+	doomed := dial(t, url)
+	cancelDoomed := <-cancels
+	other := dial(t, url)
+	<-cancels
+
+	cancelDoomed()
+	assertClosedWith(t, doomed, websocket.CloseGoingAway, "server shutting down")
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatal("connection still being served after its context was cancelled")
+	}
+
+	if got := roundTrip(t, other, `{"command":"add","a":1,"b":1}`); got != `{"command":"add","result":2}` {
+		t.Errorf("other connection: got %s", got)
+	}
+	select {
+	case <-returned:
+		t.Error("other connection ended too")
+	default:
+	}
+}
+
+func TestCloseHandshakeCompletes(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  Options
+		close func(h *Handler, conn *websocket.Conn, id string)
+		code  int
+	}{
+		{"idle timeout", Options{IdleTimeout: 50 * time.Millisecond}, func(*Handler, *websocket.Conn, string) {}, websocket.CloseNormalClosure},
+		{"admin kick", Options{}, func(h *Handler, _ *websocket.Conn, id string) {
+			h.hub.kick(id, websocket.ClosePolicyViolation, "kicked by admin")
+		}, websocket.ClosePolicyViolation},
+		{"invalid UTF-8", Options{}, func(_ *Handler, conn *websocket.Conn, _ string) {
+			_ = conn.WriteMessage(websocket.TextMessage, []byte{0xff})
+		}, websocket.CloseInvalidFramePayloadData},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out lockedBuffer
+			tt.opts.Logger = log.New(&out, "", 0)
+			h := NewHandler(tt.opts)
+			conn, welcome := dialDecoded(t, startServer(t, h))
+
+			// A well-behaved client, but a data frame crosses our close
+			// on its way and has to be discarded
+			conn.SetCloseHandler(func(code int, text string) error {
+				_ = conn.WriteMessage(websocket.TextMessage, []byte("late"))
+				return conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, ""), time.Now().Add(time.Second))
+			})
+			tt.close(h, conn, welcome.ConnID)
+			_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			_, _, err := readData(conn)
+			var closeErr *websocket.CloseError
+			if !errors.As(err, &closeErr) || closeErr.Code != tt.code {
+				t.Fatalf("got %v expected close %d", err, tt.code)
+			}
+
+			// The server waited for the answer before hanging up
+			deadline := time.Now().Add(2 * time.Second)
+			for !strings.Contains(out.String(), "connection closed from") {
+				if time.Now().After(deadline) {
+					t.Fatal("connection never closed")
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+			if got := out.String(); !strings.Contains(got, "close from 127.0.0.1") {
+				t.Errorf("server log: %s", got)
+			}
+		})
+	}
+}
+
+// A listener whose connections fail every write once broken is set
+type breakingListener struct {
+	net.Listener
+	broken *atomic.Bool
+}
+
+func (l breakingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return breakingConn{conn, l.broken}, nil
+}
+
+type breakingConn struct {
+	net.Conn
+	broken *atomic.Bool
+}
+
+func (c breakingConn) Write(p []byte) (int, error) {
+	if c.broken.Load() {
+		return 0, errors.New("broken pipe")
+	}
+	return c.Conn.Write(p)
+}
+
+func TestPingFailureClosesConnection(t *testing.T) {
+	var out lockedBuffer
+	h := NewHandler(Options{PingPeriod: 20 * time.Millisecond, Logger: log.New(&out, "", 0)})
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	var broken atomic.Bool
+	srv := httptest.NewUnstartedServer(h)
+	srv.Listener = breakingListener{srv.Listener, &broken}
+	srv.Start()
+	defer srv.Close()
+	conn := dial(t, "ws"+strings.TrimPrefix(srv.URL, "http"))
+	defer conn.Close()
+
+	// The next ping fails, and the server hangs up then rather than at the
+	// read deadline (pongWait) like before
+	broken.Store(true)
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err := readData(conn)
+	var netErr net.Error
+	if err == nil || errors.As(err, &netErr) && netErr.Timeout() {
+		t.Fatalf("got %v expected the server to hang up", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for !strings.Contains(out.String(), "connection closed from") {
+		if time.Now().After(deadline) {
+			t.Fatal("read loop never ended")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := out.String(); !strings.Contains(got, "ping write error") {
+		t.Errorf("server log: %s", got)
+	}
+}
+
+func TestReadEndClassified(t *testing.T) {
+	closeWith := func(code int) func(*Handler, *websocket.Conn, string) {
+		return func(_ *Handler, conn *websocket.Conn, _ string) {
+			_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, "bye"), time.Now().Add(time.Second))
+			_, _, _ = readData(conn) // for the answer
+		}
+	}
+	tests := []struct {
+		name     string
+		end      func(h *Handler, conn *websocket.Conn, id string)
+		expected readEnd
+		log      string
+	}{
+		{"normal close", closeWith(websocket.CloseNormalClosure), endNormal, "connection c"},
+		{"going away", closeWith(websocket.CloseGoingAway), endNormal, "connection c"},
+		{"application close", closeWith(4000), endUnexpected, `warning: unexpected close on c`},
+		{"answered kick", func(h *Handler, conn *websocket.Conn, id string) {
+			h.hub.kick(id, websocket.ClosePolicyViolation, "kicked by admin")
+			_, _, _ = readData(conn) // gorilla answers the close
+		}, endNormal, "connection c"},
+		{"unanswered kick", func(h *Handler, _ *websocket.Conn, id string) {
+			h.hub.kick(id, websocket.ClosePolicyViolation, "kicked by admin") // never read, so never answered
+		}, endTimeout, "read timeout on c"},
+		{"tcp reset", func(_ *Handler, conn *websocket.Conn, _ string) {
+			tcp := conn.UnderlyingConn().(*net.TCPConn)
+			_ = tcp.SetLinger(0)
+			_ = tcp.Close()
+		}, endTransport, "warning: transport error on c"},
+		{"eof without close frame", func(_ *Handler, conn *websocket.Conn, _ string) {
+			_ = conn.UnderlyingConn().Close()
+		}, endTransport, "warning: transport error on c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out lockedBuffer
+			h := NewHandler(Options{Logger: log.New(&out, "", 0)})
+			conn, welcome := dialDecoded(t, startServer(t, h))
+			before := h.stats.readEndCounts()
+
+			tt.end(h, conn, welcome.ConnID)
+			deadline := time.Now().Add(3 * time.Second)
+			for !strings.Contains(out.String(), "connection closed from") {
+				if time.Now().After(deadline) {
+					t.Fatalf("connection never closed:\n%s", out.String())
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+
+			after := h.stats.readEndCounts()
+			for e := range numReadEnds {
+				expected := uint64(0)
+				if e == tt.expected {
+					expected = 1
+				}
+				if got := after[e.String()] - before[e.String()]; got != expected {
+					t.Errorf("%s: went up by %d expected %d", e, got, expected)
+				}
+			}
+			if !strings.Contains(out.String(), tt.log) {
+				t.Errorf("log lacks %q:\n%s", tt.log, out.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,338 @@
+package ws
+
+// Filename: internal/ws/command.go
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/alexdev404/ws-main/pkg/wsproto"
+)
+
+// Error codes carried in CommandResponse.Code
+const (
+	ErrCodeInvalidJSON    = "ERR_INVALID_JSON"
+	ErrCodeUnknownCommand = "ERR_UNKNOWN_COMMAND"
+	ErrCodeDivByZero      = "ERR_DIVISION_BY_ZERO"
+	ErrCodeBatchTooLarge  = "ERR_BATCH_TOO_LARGE"
+	ErrCodeTooManyLines   = "ERR_TOO_MANY_LINES"
+	ErrCodeNotFinite      = "ERR_NOT_FINITE"
+	ErrCodeInternal       = "ERR_INTERNAL"
+	ErrCodeNoResult       = "ERR_NO_RESULT"
+	ErrCodeNoMemory       = "ERR_NO_MEMORY"
+	ErrCodeInvalidOperand = "ERR_INVALID_OPERAND"
+	ErrCodeInvalidName    = "ERR_INVALID_NAME"
+	ErrCodeNoSuchVar      = "ERR_NO_SUCH_VAR"
+	ErrCodeTooManyVars    = "ERR_TOO_MANY_VARS"
+)
+
+// The command types live in pkg/wsproto so Go clients can share them
+type (
+	CommandRequest  = wsproto.CommandRequest
+	CommandResponse = wsproto.CommandResponse
+)
+
+// JSON has no encoding for NaN or ±Inf, so those become errors here
+func resultResponse(command string, v float64) CommandResponse {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return errorResponse(command, ErrCodeNotFinite, "Result is not a finite number")
+	}
+	return CommandResponse{Command: command, Result: &v}
+}
+
+func errorResponse(command, code, msg string) CommandResponse {
+	return CommandResponse{Command: command, Error: msg, Code: code}
+}
+
+// Run a single command through the registry and build its response.
+// A successful numeric result becomes the session's "ans". On a traced
+// connection the command gets a span of its own. A repeated
+// idempotency_key gets the earlier response instead; see runIdempotent.
+func processCommand(reg *CommandRegistry, s *Session, req CommandRequest) CommandResponse {
+	return s.runIdempotent(req, func() CommandResponse {
+		if s.trace == nil {
+			return runCommand(reg, s, req)
+		}
+		span := s.trace.startCommand(s, req)
+		resp := runCommand(reg, s, req)
+		endCommand(span, resp)
+		return resp
+	})
+}
+
+// Run req through the registry's middleware and dispatch; see Use
+func runCommand(reg *CommandRegistry, s *Session, req CommandRequest) CommandResponse {
+	start := time.Now()
+	resp := safeRun(reg.chain(), s, req)
+	if resp.ID == "" {
+		resp.ID = req.ID // so clients can match replies to requests
+	}
+	// One measurement, from the monotonic clock, for the usage metrics,
+	// the event stream and duration_us
+	elapsed := time.Since(start)
+	usage := usageUnknown
+	if cmd, ok := reg.lookup(req.Command); ok && cmd.handler != nil {
+		usage = cmd.info.Name
+	}
+	s.stats.usage.observe(usage, elapsed)
+	s.events.publish(serverEvent{Event: "command", ConnID: s.conn.ID, Command: req.Command,
+		DurationMS: durationMS(elapsed), Error: resp.Code})
+	resp = s.timed(req, resp, elapsed)
+	if resp.Error == "" && resp.Result != nil {
+		s.setLast(*resp.Result)
+	}
+	return s.stamp(resp)
+}
+
+// An arithmetic operation in each of the ways it can be computed
+type arithOp struct {
+	float   func(command string, a, b float64) CommandResponse
+	precise func(command string, a, b *big.Float) CommandResponse // with a_str, b_str or precision
+	integer func(command string, a, b int64) CommandResponse      // with "mode":"int"
+}
+
+// Wrap a two-operand calculation so a and b are resolved against the
+// session first, in whichever arithmetic the request asks for
+func binaryOp(op arithOp) CommandHandler {
+	return func(s *Session, req CommandRequest) CommandResponse {
+		switch req.Mode {
+		case "", "float":
+		case "int":
+			return runIntMode(s, req, op.integer)
+		default:
+			return errorResponse(req.Command, ErrCodeInvalidMode, fmt.Sprintf("Unknown mode %q (want float or int)", req.Mode))
+		}
+		if wantsPrecise(req) {
+			return runPrecise(s, req, op.precise)
+		}
+		a, errResp := s.resolve(req.Command, req.A, req.AVar)
+		if errResp != nil {
+			return *errResp
+		}
+		b, errResp := s.resolve(req.Command, req.B, req.BVar)
+		if errResp != nil {
+			return *errResp
+		}
+		return op.float(req.Command, a, b)
+	}
+}
+
+func runAdd(command string, a, b float64) CommandResponse {
+	return resultResponse(command, a+b)
+}
+
+func runSubtract(command string, a, b float64) CommandResponse {
+	return resultResponse(command, a-b)
+}
+
+func runMultiply(command string, a, b float64) CommandResponse {
+	return resultResponse(command, a*b)
+}
+
+func runDivide(command string, a, b float64) CommandResponse {
+	if b == 0 {
+		return errorResponse(command, ErrCodeDivByZero, "Division by zero")
+	}
+	return resultResponse(command, a/b)
+}
+
+// Save the previous result in this connection's memory
+func runStore(s *Session, req CommandRequest) CommandResponse {
+	v, ok := s.store()
+	if !ok {
+		return errorResponse(req.Command, ErrCodeNoResult, "No previous result to store")
+	}
+	return resultResponse(req.Command, v)
+}
+
+func runRecall(s *Session, req CommandRequest) CommandResponse {
+	v, ok := s.recall()
+	if !ok {
+		return errorResponse(req.Command, ErrCodeNoMemory, "Nothing stored")
+	}
+	return resultResponse(req.Command, v)
+}
+
+func runClear(s *Session, req CommandRequest) CommandResponse {
+	s.clearMemory()
+	return CommandResponse{Command: req.Command, Done: true}
+}
+
+// Bind a variable to a (which may itself be "ans" or a_var)
+func runSet(s *Session, req CommandRequest) CommandResponse {
+	if err := validVarName(req.Name); err != nil {
+		return errorResponse(req.Command, ErrCodeInvalidName, err.Error())
+	}
+	v, errResp := s.resolve(req.Command, req.A, req.AVar)
+	if errResp != nil {
+		return *errResp
+	}
+	if !s.setVar(req.Name, v) {
+		return errorResponse(req.Command, ErrCodeTooManyVars,
+			fmt.Sprintf("Too many variables (max %d)", maxVarsPerSession))
+	}
+	return resultResponse(req.Command, v)
+}
+
+func runGet(s *Session, req CommandRequest) CommandResponse {
+	if err := validVarName(req.Name); err != nil {
+		return errorResponse(req.Command, ErrCodeInvalidName, err.Error())
+	}
+	v, errResp := s.resolve(req.Command, Operand{}, req.Name)
+	if errResp != nil {
+		return *errResp
+	}
+	return resultResponse(req.Command, v)
+}
+
+func runVars(s *Session, req CommandRequest) CommandResponse {
+	return CommandResponse{Command: req.Command, Data: s.varsSnapshot()}
+}
+
+// Is this text payload meant for the command layer rather than the echo?
+// An object (a command) or an array (a batch) is, after any leading
+// whitespace or byte order mark. Everything else is text, JSON primitives
+// included: 42, true and null are echoed like any other words.
+func isCommandPayload(payload []byte) bool {
+	payload = trimJSONPrefix(payload)
+	return len(payload) > 0 && (payload[0] == '{' || payload[0] == '[')
+}
+
+// The UTF-8 byte order mark some editors and clients put before text
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// Strip what may come before a JSON document but that JSON itself rejects:
+// a byte order mark, and whitespace either side of it
+func trimJSONPrefix(payload []byte) []byte {
+	payload = bytes.TrimLeft(payload, " \t\r\n")
+	if bytes.HasPrefix(payload, utf8BOM) {
+		payload = bytes.TrimLeft(payload[len(utf8BOM):], " \t\r\n")
+	}
+	return payload
+}
+
+// Decode a JSON object or array of objects, run it, and encode the reply.
+// A single object gets a single object back; an array gets an array back
+// with one response per entry, in the same order; several objects on
+// separate lines (NDJSON) get one response line each. Returns nil when the
+// command replies by streaming frames of its own, and an error only when the
+// reply can't be encoded.
+func (h *Handler) handleCommandPayload(c *client, payload []byte) ([]byte, error) {
+	payload = trimJSONPrefix(payload)
+	if refused := h.opts.jsonLimits().check(payload); refused != nil {
+		return marshalResponse(h.jsonViolation(c, refused))
+	}
+	if payload[0] == '[' {
+		return marshalResponse(processBatch(h.opts.Registry, c.session, payload, h.opts.MaxBatchSize))
+	}
+
+	// Decoded once: a syntax error is either NDJSON or invalid JSON
+	var req CommandRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		// A multi-line frame that isn't one JSON document (e.g. pretty-printed) is NDJSON
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) && bytes.IndexByte(payload, '\n') >= 0 {
+			return processNDJSON(h.opts.Registry, c.session, payload, h.opts.MaxLinesPerFrame)
+		}
+		return marshalResponse(invalidJSON(c.session, "Invalid JSON: "+err.Error()))
+	}
+	return h.runCommand(c, req)
+}
+
+// Run one decoded command and encode its reply; nil when the reply is
+// streamed, as for handleCommandPayload
+func (h *Handler) runCommand(c *client, req CommandRequest) ([]byte, error) {
+	if h.opts.Registry.isStream(req.Command) {
+		resp, ok := h.handleStreamCommand(c, req)
+		if !ok {
+			return nil, nil
+		}
+		return marshalResponse(c.session.stamp(resp))
+	}
+	resp := processCommand(h.opts.Registry, c.session, req)
+	if stream, ok := streamResponse(resp); ok {
+		c.enqueueStream(websocket.TextMessage, stream)
+		return nil, nil
+	}
+	return marshalResponse(resp)
+}
+
+// Run every entry of a JSON array through processCommand. Entries are
+// decoded one by one so a bad entry only fails itself, not the batch, and
+// run in order so "ans" refers to the entry before.
+func processBatch(reg *CommandRegistry, s *Session, payload []byte, maxBatch int) interface{} {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		return invalidJSON(s, "Invalid JSON: "+err.Error())
+	}
+	return processEntries(reg, s, raw, maxBatch)
+}
+
+// Run the decoded entries of a batch
+func processEntries(reg *CommandRegistry, s *Session, raw []json.RawMessage, maxBatch int) interface{} {
+	if len(raw) > maxBatch {
+		return s.stamp(errorResponse("", ErrCodeBatchTooLarge,
+			fmt.Sprintf("Batch too large: %d commands (max %d)", len(raw), maxBatch)))
+	}
+
+	out := make([]CommandResponse, len(raw))
+	for i, entry := range raw {
+		out[i] = processEntry(reg, s, entry)
+	}
+	return out
+}
+
+// Run each non-blank line of an NDJSON frame and answer with one NDJSON
+// frame holding a response line per command line, in order
+func processNDJSON(reg *CommandRegistry, s *Session, payload []byte, maxLines int) ([]byte, error) {
+	var lines [][]byte
+	for _, line := range bytes.Split(payload, []byte("\n")) {
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	return processLines(reg, s, lines, maxLines)
+}
+
+// Run the command lines of an NDJSON frame
+func processLines(reg *CommandRegistry, s *Session, lines [][]byte, maxLines int) ([]byte, error) {
+	if len(lines) > maxLines {
+		return marshalResponse(s.stamp(errorResponse("", ErrCodeTooManyLines,
+			fmt.Sprintf("Too many lines: %d commands (max %d)", len(lines), maxLines))))
+	}
+
+	out := make([][]byte, len(lines))
+	for i, line := range lines {
+		b, err := marshalResponse(processEntry(reg, s, line))
+		if err != nil {
+			return nil, err
+		}
+		out[i] = b
+	}
+	return bytes.Join(out, []byte("\n")), nil
+}
+
+// Decode and run one entry of a batch or NDJSON frame
+func processEntry(reg *CommandRegistry, s *Session, entry []byte) CommandResponse {
+	var req CommandRequest
+	if err := json.Unmarshal(entry, &req); err != nil {
+		return invalidJSON(s, "Invalid JSON: "+err.Error())
+	}
+	if reg.isStream(req.Command) {
+		return s.stamp(errorResponse(req.Command, ErrCodeNotBatchable, "Streaming commands cannot be batched"))
+	}
+	return processCommand(reg, s, req)
+}
+
+// Encode a response. Failing here is our bug, not the client's, so callers
+// close the connection with 1011 rather than reply.
+func marshalResponse(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
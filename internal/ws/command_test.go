@@ -0,0 +1,256 @@
+// Filename: internal/ws/command_test.go
+
+package ws
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// A handler with a small batch cap for exercising the command layer directly
+func testHandler(maxBatch int) *Handler {
+	return NewHandler(Options{MaxBatchSize: maxBatch})
+}
+
+// A client with a fresh session but no connection, for calling command code directly
+func testClient() *client {
+	return &client{session: newSession(defaultHistorySize), encoding: encodingJSON}
+}
+
+// Run payload on a fresh client and return the encoded reply
+func commandReply(t *testing.T, h *Handler, payload string) []byte {
+	t.Helper()
+	reply, err := h.handleCommandPayload(testClient(), []byte(payload))
+	if err != nil {
+		t.Fatalf("encode reply: %v", err)
+	}
+	return reply
+}
+
+func TestProcessCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		req  CommandRequest
+		want float64
+		code string
+	}{
+		{"add", CommandRequest{Command: "add", A: Num(2), B: Num(3)}, 5, ""},
+		{"subtract", CommandRequest{Command: "subtract", A: Num(2), B: Num(3)}, -1, ""},
+		{"multiply", CommandRequest{Command: "multiply", A: Num(2), B: Num(3)}, 6, ""},
+		{"divide", CommandRequest{Command: "divide", A: Num(3), B: Num(2)}, 1.5, ""},
+		{"divide by zero", CommandRequest{Command: "divide", A: Num(3)}, 0, ErrCodeDivByZero},
+		{"overflow", CommandRequest{Command: "multiply", A: Num(1e308), B: Num(10)}, 0, ErrCodeNotFinite},
+		{"unknown", CommandRequest{Command: "sqrt", A: Num(4)}, 0, ErrCodeUnknownCommand},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := processCommand(DefaultRegistry, newSession(defaultHistorySize), tt.req)
+			if resp.Code != tt.code {
+				t.Fatalf("code: got %q expected %q (error %q)", resp.Code, tt.code, resp.Error)
+			}
+			if tt.code != "" {
+				if resp.Result != nil {
+					t.Errorf("error response carried a result: %v", *resp.Result)
+				}
+				return
+			}
+			if resp.Result == nil || *resp.Result != tt.want {
+				t.Errorf("result: got %v expected %v", resp.Result, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleCommandPayloadSingle(t *testing.T) {
+	got := string(commandReply(t, testHandler(10), `{"command":"add","a":1,"b":2}`))
+	expected := `{"command":"add","result":3}`
+	if got != expected {
+		t.Errorf("got %s expected %s", got, expected)
+	}
+
+	got = string(commandReply(t, testHandler(10), `{"command":`))
+	if !strings.Contains(got, ErrCodeInvalidJSON) {
+		t.Errorf("invalid JSON: got %s", got)
+	}
+}
+
+func TestCommandRepliesCarryID(t *testing.T) {
+	for payload, expected := range map[string]string{
+		`{"command":"add","id":"r1","a":1,"b":2}`: `{"command":"add","id":"r1","result":3}`,
+		`{"command":"nope","id":"r2"}`:            `{"command":"nope","id":"r2","error":"Unknown command: \"nope\"","code":"ERR_UNKNOWN_COMMAND"}`,
+	} {
+		if got := string(commandReply(t, testHandler(10), payload)); got != expected {
+			t.Errorf("%s: got %s expected %s", payload, got, expected)
+		}
+	}
+}
+
+func TestHandleCommandPayloadBatch(t *testing.T) {
+	payload := `[
+		{"command":"add","a":1,"b":2},
+		{"command":"divide","a":1,"b":0},
+		"not an object",
+		{"command":"nope"},
+		{"command":"multiply","a":4,"b":5}
+	]`
+
+	var got []CommandResponse
+	if err := json.Unmarshal(commandReply(t, testHandler(10), payload), &got); err != nil {
+		t.Fatalf("response is not a JSON array: %v", err)
+	}
+	if len(got) != 5 {
+		t.Fatalf("got %d responses expected 5", len(got))
+	}
+
+	if got[0].Result == nil || *got[0].Result != 3 {
+		t.Errorf("entry 0: got %+v", got[0])
+	}
+	if got[1].Code != ErrCodeDivByZero {
+		t.Errorf("entry 1: got %+v", got[1])
+	}
+	if got[2].Code != ErrCodeInvalidJSON {
+		t.Errorf("entry 2: got %+v", got[2])
+	}
+	if got[3].Code != ErrCodeUnknownCommand {
+		t.Errorf("entry 3: got %+v", got[3])
+	}
+	if got[4].Result == nil || *got[4].Result != 20 {
+		t.Errorf("entry 4: got %+v", got[4])
+	}
+}
+
+func TestHandleCommandPayloadBatchEmpty(t *testing.T) {
+	if got := string(commandReply(t, testHandler(10), `[]`)); got != "[]" {
+		t.Errorf("got %s expected []", got)
+	}
+}
+
+func TestHandleCommandPayloadBatchTooLarge(t *testing.T) {
+	payload := "[" + strings.Repeat(`{"command":"add"},`, 3) + `{"command":"add"}]`
+
+	var got CommandResponse
+	if err := json.Unmarshal(commandReply(t, testHandler(3), payload), &got); err != nil {
+		t.Fatalf("response is not a JSON object: %v", err)
+	}
+	if got.Code != ErrCodeBatchTooLarge {
+		t.Errorf("got %+v expected code %s", got, ErrCodeBatchTooLarge)
+	}
+}
+
+func TestHelpListsEveryCommand(t *testing.T) {
+	var got struct {
+		Command string `json:"command"`
+		Data    struct {
+			Commands []struct {
+				Name        string   `json:"name"`
+				Params      []string `json:"params"`
+				Description string   `json:"description"`
+			} `json:"commands"`
+			Prefixes []struct {
+				Prefix string `json:"prefix"`
+			} `json:"prefixes"`
+		} `json:"data"`
+	}
+	payload := commandReply(t, testHandler(10), `{"command":"help"}`)
+	if err := json.Unmarshal(payload, &got); err != nil {
+		t.Fatalf("unmarshal %s: %v", payload, err)
+	}
+
+	commands := DefaultRegistry.Commands()
+	if len(got.Data.Commands) != len(commands) {
+		t.Fatalf("got %d commands expected %d", len(got.Data.Commands), len(commands))
+	}
+	for i, spec := range commands {
+		c := got.Data.Commands[i]
+		if c.Name != spec.Name || c.Description == "" || c.Params == nil {
+			t.Errorf("entry %d: got %+v expected name %q with params and description", i, c, spec.Name)
+		}
+	}
+	if len(got.Data.Prefixes) != len(textPrefixes) {
+		t.Errorf("got %d prefixes expected %d", len(got.Data.Prefixes), len(textPrefixes))
+	}
+}
+
+func TestHandleCommandPayloadNDJSON(t *testing.T) {
+	h := NewHandler(Options{MaxLinesPerFrame: 3})
+
+	tests := []struct {
+		name     string
+		send     string
+		expected string
+	}{
+		{
+			"three commands",
+			"{\"command\":\"add\",\"a\":1,\"b\":2}\n{\"command\":\"multiply\",\"a\":\"ans\",\"b\":2}\n{\"command\":\"divide\",\"a\":1,\"b\":0}",
+			"{\"command\":\"add\",\"result\":3}\n{\"command\":\"multiply\",\"result\":6}\n{\"command\":\"divide\",\"error\":\"Division by zero\",\"code\":\"ERR_DIVISION_BY_ZERO\"}",
+		},
+		{
+			"blank lines and a bad line",
+			"{\"command\":\"add\",\"a\":1,\"b\":1}\n\n  \n{oops\r\n{\"command\":\"subtract\",\"a\":1,\"b\":1}\n",
+			"{\"command\":\"add\",\"result\":2}\n{\"command\":\"\",\"error\":\"Invalid JSON: invalid character 'o' looking for beginning of object key string\",\"code\":\"ERR_INVALID_JSON\"}\n{\"command\":\"subtract\",\"result\":0}",
+		},
+		{
+			"pretty-printed single object",
+			"{\n  \"command\": \"add\",\n  \"a\": 2,\n  \"b\": 2\n}",
+			"{\"command\":\"add\",\"result\":4}",
+		},
+		{
+			"too many lines",
+			strings.Repeat("{\"command\":\"add\"}\n", 4),
+			"{\"command\":\"\",\"error\":\"Too many lines: 4 commands (max 3)\",\"code\":\"ERR_TOO_MANY_LINES\"}",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(commandReply(t, h, tt.send)); got != tt.expected {
+				t.Errorf("got %s expected %s", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCommandPayloadDetection(t *testing.T) {
+	conn := dial(t, startServer(t, NewHandler(Options{})))
+
+	tests := []struct {
+		name string
+		send string
+		want string
+	}{
+		{"leading spaces", `   {"command":"add","a":1,"b":2}`, `{"command":"add","result":3}`},
+		{"leading newline and tab", "\n\t{\"command\":\"add\",\"a\":1,\"b\":2}", `{"command":"add","result":3}`},
+		{"bom", "\ufeff{\"command\":\"add\",\"a\":1,\"b\":2}", `{"command":"add","result":3}`},
+		{"space then bom", " \ufeff [{\"command\":\"add\",\"a\":1,\"b\":2}]", `[{"command":"add","result":3}]`},
+		{"indented array", `  [{"command":"add","a":2,"b":2}]`, `[{"command":"add","result":4}]`},
+		{"indented ndjson", "  {\"command\":\"add\",\"a\":1,\"b\":1}\n{\"command\":\"add\",\"a\":2,\"b\":2}",
+			"{\"command\":\"add\",\"result\":2}\n{\"command\":\"add\",\"result\":4}"},
+		{"indented invalid", `  {"command":`, `{"command":"","error":"Invalid JSON: unexpected end of JSON input","code":"ERR_INVALID_JSON"}`},
+		{"wrong type", `{"command":5}`, `{"command":"","error":"Invalid JSON: json: cannot unmarshal number into Go struct field CommandRequest.command of type string","code":"ERR_INVALID_JSON"}`},
+		// JSON primitives, like any other text, are echoed
+		{"bare number", `42`, `42`},
+		{"indented number", `  42`, `  42`},
+		{"bare string", `"hello"`, `"hello"`},
+		{"null", `null`, `null`},
+		{"plain text", `hello {"command":"add"}`, `hello {"command":"add"}`},
+	}
+	for _, tt := range tests {
+		if got := roundTrip(t, conn, tt.send); got != tt.want {
+			t.Errorf("%s: got %q expected %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func BenchmarkJSONCommand(b *testing.B) {
+	h := testHandler(defaultMaxBatchSize)
+	c := testClient()
+	payload := []byte(`{"command":"add","a":1.5,"b":2.25}`)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := h.handleCommandPayload(c, payload); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package ws
+
+// Filename: internal/ws/compression.go
+
+import (
+	"compress/flate"
+	"net/http"
+	"strings"
+)
+
+// permessage-deflate (RFC 7692) settings used when Options.Compression is on
+const (
+	extensionDeflate            = "permessage-deflate"
+	defaultCompressionLevel     = flate.BestSpeed
+	defaultCompressionThreshold = 1024 // bytes; smaller frames are sent uncompressed
+)
+
+// Total outbound data frames written with compression
+var compressedCounter uint64
+
+// Report whether the client offered permessage-deflate. gorilla/websocket
+// accepts any such offer once EnableCompression is set, so this is also
+// what got negotiated.
+func offersDeflate(r *http.Request) bool {
+	for _, header := range r.Header.Values("Sec-Websocket-Extensions") {
+		for _, ext := range strings.Split(header, ",") {
+			name, _, _ := strings.Cut(ext, ";")
+			if strings.EqualFold(strings.TrimSpace(name), extensionDeflate) {
+				return true
+			}
+		}
+	}
+	return false
+}
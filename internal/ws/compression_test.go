@@ -0,0 +1,47 @@
+// Filename: internal/ws/compression_test.go
+
+package ws
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestCompressionRoundTrip(t *testing.T) {
+	conn := dialWith(t, &websocket.Dialer{EnableCompression: true},
+		startServer(t, NewHandler(Options{Compression: true, CompressionThreshold: 512})))
+
+	// Below the threshold: sent as is
+	before := atomic.LoadUint64(&compressedCounter)
+	if got := roundTrip(t, conn, "hello"); got != "hello" {
+		t.Errorf("small echo: got %q", got)
+	}
+	if n := atomic.LoadUint64(&compressedCounter) - before; n != 0 {
+		t.Errorf("small echo was compressed (%d frames)", n)
+	}
+
+	// Near the read limit: compressed
+	large := strings.Repeat("compress me ", 300)
+	before = atomic.LoadUint64(&compressedCounter)
+	if got := roundTrip(t, conn, large); got != large {
+		t.Errorf("large echo came back altered (%d bytes)", len(got))
+	}
+	if n := atomic.LoadUint64(&compressedCounter) - before; n != 1 {
+		t.Errorf("large echo: got %d compressed frames expected 1", n)
+	}
+}
+
+func TestCompressionNotOffered(t *testing.T) {
+	conn := dial(t, startServer(t, NewHandler(Options{Compression: true, CompressionThreshold: 1})))
+
+	before := atomic.LoadUint64(&compressedCounter)
+	if got := roundTrip(t, conn, "hello"); got != "hello" {
+		t.Errorf("got %q", got)
+	}
+	if n := atomic.LoadUint64(&compressedCounter) - before; n != 0 {
+		t.Errorf("compressed %d frames for a client that didn't offer deflate", n)
+	}
+}
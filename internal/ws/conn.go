@@ -0,0 +1,391 @@
+package ws
+
+// Filename: internal/ws/conn.go
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Outbound queue lengths per connection: data frames, and the system
+// frames written ahead of them
+const (
+	sendQueueSize   = 64
+	systemQueueSize = 16
+)
+
+// Which of a connection's outbound queues a frame waits on
+type lane int
+
+const (
+	// Replies, echoes, broadcasts and the rest, in order, subject to the
+	// slow-consumer policy
+	laneData lane = iota
+	// Close frames and notices the client must get however far behind it
+	// is: quota, bandwidth, ack reports, shutdown. writePump empties this
+	// queue before it takes the next data frame.
+	laneSystem
+)
+
+// A single data frame waiting to be written, or a close frame that has to
+// follow the data frames ahead of it
+type outbound struct {
+	messageType int
+	data        []byte
+	shared      *sharedFrame // set when the same frame is going to many clients
+	stream      streamFunc   // set instead of data for messages written as produced
+	kind        string       // envelope type for a text frame that isn't JSON; see envelop
+	droppable   bool         // a broadcast, tick or presence frame; see replaceOldest
+	bseq        uint64       // a broadcast's number, for the gap notice if it's discarded
+}
+
+// client owns the write side of one websocket connection. gorilla/websocket
+// allows only one concurrent writer, so every data frame (echoes, command
+// replies, streamed results) is queued on send, or on system for the few
+// that mustn't wait behind the rest, and written by writePump. Control
+// frames go through WriteControl, which is safe to call concurrently.
+type client struct {
+	conn          *websocket.Conn
+	session       *Session
+	encoding      string        // encodingJSON or encodingMsgpack
+	subprotocol   string        // negotiated subprotocol, "" for none
+	extension     string        // negotiated extension, "" for none
+	compressAbove int           // compress frames at least this long; 0 disables
+	send          chan outbound // laneData
+	system        chan outbound // laneSystem
+	done          chan struct{} // closed when the connection is going away
+	closing       atomic.Bool   // set once the server has decided to close
+	closeSent     atomic.Bool   // a close frame has gone to the peer
+	closeReceived atomic.Bool   // the peer's close frame has arrived
+	closeAnswered atomic.Bool   // ...and was the answer to ours
+	closeCode     atomic.Int32  // first close code sent or received; 0 for none yet
+	closeReason   atomic.Value  // string sent or received with closeCode
+	sent          uint64        // data frames written (atomic)
+	lastData      atomic.Int64  // unix nanos of the last data frame read, for IdleTimeout
+	lastSeen      atomic.Int64  // unix nanos of the last frame or pong read, for the pong timeout
+
+	policy    connPolicy  // limits for the connection's origin
+	rate      *rateWindow // enforces policy.MessagesPerSecond; nil for no limit
+	bandwidth *byteWindow // enforces policy.BytesPerMinute; nil for no limit
+
+	slowGrace  time.Duration // how long the queue may stay full; 0 waits forever
+	dropOldest bool          // SlowConsumerDropOldest: discard instead of waiting
+	debugDump  int           // bytes of each frame logged in debug mode
+	fullSince  atomic.Int64  // unix nanos a lossy frame first didn't fit; 0 when it did
+	sendMu     sync.Mutex    // under dropOldest, held by whoever puts frames on send
+	room       chan struct{} // signalled each time writePump takes a frame off send
+	gap        gapRange      // broadcasts missed since the last that fit
+
+	envelope    bool   // wrap every text frame in a v2 envelope
+	envelopeSeq uint64 // v2 frames written; only writePump touches it
+	clock       Clock  // stamps v2 envelopes
+
+	audit  *AuditLog     // every frame in and out is recorded here; nil unless auditing
+	logger MessageLogger // told about every frame; never nil
+	log    *log.Logger   // operational log lines; never nil
+
+	ctx       context.Context // ends with the connection; see Close
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+	workers   sync.WaitGroup // writePump plus any stream goroutines
+
+	streamsMu sync.Mutex
+	streams   map[string]chan struct{} // stream id -> cancel channel
+	nextID    uint64
+	delays    int // "delay" replies still waiting
+
+	jsonViolations int   // frames refused by the JSON limits; only the read loop touches it
+	streamAbove    int64 // Options.StreamThreshold; 0 reads every message whole
+
+	chaos atomic.Pointer[ChaosConfig] // faults to inject; nil, as it almost always is, for none
+}
+
+func newClient(conn *websocket.Conn, encoding string, opts Options) *client {
+	session := newSession(opts.HistorySize)
+	session.maxBroadcast = opts.MaxBroadcastBytes
+	session.whoAddrs = opts.WhoRemoteAddrs
+	session.maxReadLimit = opts.MaxReadLimit
+	session.broadcasts = newRateWindow(opts.BroadcastLimit, opts.BroadcastWindow)
+	session.uploadDir, session.maxUpload = opts.UploadDir, opts.MaxUploadSize
+	session.rand, session.clock = rand.New(opts.RandSource), opts.Clock
+	session.timing = opts.Timing
+	session.log = opts.Logger
+	session.replies = newIdempotencyCache(opts.IdempotencyKeys, opts.IdempotencyTTL)
+	ctx, cancel := context.WithCancel(context.Background())
+	return &client{
+		ctx:         ctx,
+		cancel:      cancel,
+		conn:        conn,
+		encoding:    encoding,
+		subprotocol: conn.Subprotocol(),
+		session:     session,
+		audit:       opts.Audit,
+		logger:      opts.MessageLogger,
+		log:         opts.Logger,
+		clock:       opts.Clock,
+		slowGrace:   opts.SlowConsumerGrace,
+		dropOldest:  opts.SlowConsumerPolicy == SlowConsumerDropOldest,
+		debugDump:   opts.DebugDumpBytes,
+		streamAbove: opts.StreamThreshold,
+		send:        make(chan outbound, sendQueueSize),
+		system:      make(chan outbound, systemQueueSize),
+		room:        make(chan struct{}, 1),
+		done:        make(chan struct{}),
+		streams:     make(map[string]chan struct{}),
+	}
+}
+
+// Queue a frame for the write pump on lane l. While the data queue is full
+// it waits up to slowGrace and then drops the client as too slow (under
+// SlowConsumerDropOldest it first discards the oldest droppable frames).
+// The system queue is never given up on: writePump empties it first, and a
+// stalled write ends the connection anyway. Returns false if the frame
+// wasn't queued because the connection is closing.
+func (c *client) enqueue(messageType int, data []byte, l lane) bool {
+	return c.queue(outbound{messageType: messageType, data: data}, l)
+}
+
+func (c *client) queue(m outbound, l lane) bool {
+	if l == laneSystem {
+		select {
+		case c.system <- m:
+			return true
+		case <-c.done:
+			return false
+		}
+	}
+	if c.dropOldest {
+		return c.replaceOldest(m) || c.waitForRoom(m)
+	}
+	select {
+	case c.send <- m:
+		return true
+	case <-c.done:
+		return false
+	default:
+	}
+	var expired <-chan time.Time
+	if c.slowGrace > 0 {
+		timer := time.NewTimer(c.slowGrace)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case c.send <- m:
+		return true
+	case <-c.done:
+		return false
+	case <-expired:
+		c.dropSlow("queue full for " + c.slowGrace.String())
+		return false
+	}
+}
+
+// Queue a frame on lane l only if there is room right now; false means it
+// was dropped. A data queue that stays full past slowGrace gets the client
+// dropped; under SlowConsumerDropOldest the oldest droppable frame is
+// discarded instead, or m itself if nothing queued is droppable.
+func (c *client) tryEnqueue(messageType int, data []byte, l lane) bool {
+	return c.tryQueue(outbound{messageType: messageType, data: data}, l)
+}
+
+func (c *client) tryQueue(m outbound, l lane) bool {
+	if l == laneSystem {
+		select {
+		case c.system <- m:
+			return true
+		default:
+			return false
+		}
+	}
+	if c.dropOldest {
+		if c.replaceOldest(m) {
+			return true
+		}
+		if m.droppable {
+			atomic.AddUint64(&droppedFrameCounter, 1)
+		}
+		return false
+	}
+	select {
+	case c.send <- m:
+		c.fullSince.Store(0)
+		return true
+	default:
+	}
+	if c.slowGrace > 0 && c.fullTooLong(time.Now()) {
+		c.dropSlow("queue full for over " + c.slowGrace.String())
+	}
+	return false
+}
+
+// Encode v in the connection's encoding and queue it as data. A value that
+// can't be encoded closes the connection with 1011.
+func (c *client) sendEncoded(v interface{}) bool {
+	messageType, b, ok := c.encode(v)
+	return ok && c.enqueue(messageType, b, laneData)
+}
+
+// Like sendEncoded, but on the system lane, ahead of any data queued
+func (c *client) sendSystem(v interface{}) bool {
+	messageType, b, ok := c.encode(v)
+	return ok && c.enqueue(messageType, b, laneSystem)
+}
+
+// Like sendEncoded, but on lane l, and dropping v instead of waiting for
+// queue space
+func (c *client) trySendEncoded(v interface{}, l lane) bool {
+	messageType, b, ok := c.encode(v)
+	return ok && c.tryEnqueue(messageType, b, l)
+}
+
+func (c *client) encode(v interface{}) (int, []byte, bool) {
+	messageType, marshal := websocket.TextMessage, marshalResponse
+	if c.encoding == encodingMsgpack {
+		messageType, marshal = websocket.BinaryMessage, marshalMsgpack
+	}
+	b, err := marshal(v)
+	if err != nil {
+		c.log.Printf("encode %T: %v", v, err)
+		c.closeWith(websocket.CloseInternalServerErr, "internal error")
+		return 0, nil, false
+	}
+	return messageType, b, true
+}
+
+// The only goroutine that writes data frames to the connection. Whatever
+// is on the system lane goes before the next data frame.
+func (c *client) writePump() {
+	for {
+		var m outbound
+		select {
+		case m = <-c.system:
+		default:
+			select {
+			case m = <-c.system:
+			case m = <-c.send:
+				select {
+				case c.room <- struct{}{}:
+				default:
+				}
+			case <-c.done:
+				return
+			}
+		}
+		if cfg := c.chaos.Load(); cfg != nil && !c.chaosWrite(cfg, m) {
+			continue
+		}
+		if !c.write(m) {
+			return
+		}
+	}
+}
+
+// Write one queued frame; false once the connection has failed
+func (c *client) write(m outbound) bool {
+	if m.messageType == websocket.CloseMessage {
+		// Queued by closeAfterQueued; a control frame, so not counted
+		if c.closeSent.CompareAndSwap(false, true) {
+			_ = c.conn.WriteControl(websocket.CloseMessage, m.data, time.Now().Add(writeWait))
+		}
+		return true
+	}
+	if c.envelope && m.messageType == websocket.TextMessage {
+		m = c.envelop(m)
+	}
+	data, size, err := m.data, len(m.data), error(nil)
+	if m.stream != nil {
+		data, size, err = c.writeStream(m)
+	} else {
+		err = c.writeData(m)
+	}
+	if err != nil {
+		c.log.Printf("write error: %v", err)
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			c.dropSlow("write missed its deadline")
+			return false
+		}
+		code, reason := websocket.CloseAbnormalClosure, ""
+		if m.stream != nil {
+			// Part of a message is on the wire; fail it rather
+			// than let the peer mistake it for the whole thing,
+			// saying why if it was a streamed echo's message at fault
+			code, reason = c.session.streamClose(err)
+		}
+		c.Close(code, reason)
+		return false
+	}
+	atomic.AddUint64(&c.sent, 1)
+	c.session.countOut(size)
+	if c.session.debugging() {
+		c.debugFrame(directionOut, m.messageType, size, data)
+	}
+	c.audit.record(c.session, directionOut, m.messageType, data)
+	c.logger.LogOutbound(c.session.conn.ID, m.messageType, data)
+	return true
+}
+
+// Write a message held in memory, compressing it if it's long enough
+func (c *client) writeData(m outbound) error {
+	_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	compress := c.compressAbove > 0 && len(m.data) >= c.compressAbove
+	c.conn.EnableWriteCompression(compress)
+	if compress {
+		atomic.AddUint64(&compressedCounter, 1)
+	}
+	if pm := m.compressedOnce(compress, c.log); pm != nil {
+		return c.conn.WritePreparedMessage(pm)
+	}
+	return c.conn.WriteMessage(m.messageType, m.data)
+}
+
+// A fanned-out frame that is being compressed is deflated once for all
+// its clients; nil means write m.data as usual
+func (m outbound) compressedOnce(compress bool, logger *log.Logger) *websocket.PreparedMessage {
+	if !compress || m.shared == nil {
+		return nil
+	}
+	return m.shared.message(logger)
+}
+
+// Start a goroutine tracked by the client so close can wait for it
+func (c *client) goWorker(fn func()) {
+	c.workers.Add(1)
+	go func() {
+		defer c.workers.Done()
+		fn()
+	}()
+}
+
+// Close ends the connection, once, whoever calls it first: send a close
+// frame with code and reason unless one has gone already (1006 sends none,
+// for a socket that is gone), cancel the connection's context, signal
+// every worker through done and close the socket, so a blocked read or
+// write returns at once. Every way a connection ends comes here: the read
+// loop once a read error, kick, shutdown or quota has run its closing
+// handshake (see closeGracefully), and the write pump, the heartbeat or a
+// slow-consumer drop when a write fails.
+func (c *client) Close(code int, reason string) {
+	c.closeOnce.Do(func() {
+		if code != websocket.CloseAbnormalClosure {
+			c.closeWith(code, reason)
+		}
+		c.cancel()
+		close(c.done)
+		_ = c.conn.Close()
+	})
+}
+
+// Wait for every worker to exit, after Close
+func (c *client) wait() {
+	c.workers.Wait()
+}
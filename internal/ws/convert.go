@@ -0,0 +1,117 @@
+package ws
+
+// Filename: internal/ws/convert.go
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Error codes for the convert command
+const (
+	ErrCodeMissingOperand    = "ERR_MISSING_OPERAND"
+	ErrCodeMissingUnit       = "ERR_MISSING_UNIT"
+	ErrCodeUnknownUnit       = "ERR_UNKNOWN_UNIT"
+	ErrCodeIncompatibleUnits = "ERR_INCOMPATIBLE_UNITS"
+)
+
+// A unit converts to its category's base unit (kelvin, meter, kilogram) as
+// (v + offset) * scale. Only temperatures have an offset.
+type unit struct {
+	name     string // what replies call it
+	category string
+	scale    float64
+	offset   float64
+}
+
+func (u unit) toBase(v float64) float64   { return (v + u.offset) * u.scale }
+func (u unit) fromBase(v float64) float64 { return v/u.scale - u.offset }
+
+var (
+	unitCelsius    = unit{"celsius", "temperature", 1, 273.15}
+	unitFahrenheit = unit{"fahrenheit", "temperature", 5.0 / 9, 459.67}
+	unitKelvin     = unit{"kelvin", "temperature", 1, 0}
+	unitMeter      = unit{"meter", "length", 1, 0}
+	unitKilometer  = unit{"kilometer", "length", 1000, 0}
+	unitMile       = unit{"mile", "length", 1609.344, 0}
+	unitFoot       = unit{"foot", "length", 0.3048, 0}
+	unitInch       = unit{"inch", "length", 0.0254, 0}
+	unitKilogram   = unit{"kilogram", "mass", 1, 0}
+	unitPound      = unit{"pound", "mass", 0.45359237, 0}
+	unitOunce      = unit{"ounce", "mass", 0.45359237 / 16, 0}
+)
+
+// Unit names and abbreviations, matched without case
+var units = map[string]unit{
+	"celsius": unitCelsius, "c": unitCelsius,
+	"fahrenheit": unitFahrenheit, "f": unitFahrenheit,
+	"kelvin": unitKelvin, "k": unitKelvin,
+	"meter": unitMeter, "meters": unitMeter, "metre": unitMeter, "metres": unitMeter, "m": unitMeter,
+	"kilometer": unitKilometer, "kilometers": unitKilometer, "kilometre": unitKilometer, "kilometres": unitKilometer, "km": unitKilometer,
+	"mile": unitMile, "miles": unitMile, "mi": unitMile,
+	"foot": unitFoot, "feet": unitFoot, "ft": unitFoot,
+	"inch": unitInch, "inches": unitInch, "in": unitInch,
+	"kilogram": unitKilogram, "kilograms": unitKilogram, "kg": unitKilogram,
+	"pound": unitPound, "pounds": unitPound, "lb": unitPound, "lbs": unitPound,
+	"ounce": unitOunce, "ounces": unitOunce, "oz": unitOunce,
+}
+
+// Results keep this many significant digits, so 0.1 km is 100 m rather
+// than 100.00000000000001
+const convertDigits = 12
+
+// Units of the convert reply, so clients can show "100 celsius = 212 fahrenheit"
+type conversion struct {
+	A    float64 `json:"a"`
+	From string  `json:"from"`
+	To   string  `json:"to"`
+}
+
+// Convert a from one unit to another of the same kind. The result becomes "ans".
+func runConvert(s *Session, req CommandRequest) CommandResponse {
+	if req.A == (Operand{}) && req.AVar == "" {
+		return errorResponse(req.Command, ErrCodeMissingOperand, "Missing a")
+	}
+	a, errResp := s.resolve(req.Command, req.A, req.AVar)
+	if errResp != nil {
+		return *errResp
+	}
+	from, errResp := lookupUnit(req.Command, "from", req.From)
+	if errResp != nil {
+		return *errResp
+	}
+	to, errResp := lookupUnit(req.Command, "to", req.To)
+	if errResp != nil {
+		return *errResp
+	}
+	if from.category != to.category {
+		return errorResponse(req.Command, ErrCodeIncompatibleUnits,
+			fmt.Sprintf("Cannot convert %s (%s) to %s (%s)", from.name, from.category, to.name, to.category))
+	}
+
+	v := to.fromBase(from.toBase(a))
+	if !math.IsNaN(v) && !math.IsInf(v, 0) {
+		v, _ = strconv.ParseFloat(strconv.FormatFloat(v, 'g', convertDigits, 64), 64)
+	}
+	resp := resultResponse(req.Command, v)
+	if resp.Error == "" {
+		resp.Data = conversion{A: a, From: from.name, To: to.name}
+	}
+	return resp
+}
+
+// Find the unit named by the from or to field
+func lookupUnit(command, field, name string) (unit, *CommandResponse) {
+	if name == "" {
+		resp := errorResponse(command, ErrCodeMissingUnit, fmt.Sprintf("Missing %s unit", field))
+		return unit{}, &resp
+	}
+	u, ok := units[strings.ToLower(name)]
+	if !ok {
+		resp := errorResponse(command, ErrCodeUnknownUnit, fmt.Sprintf("Unknown %s unit %q", field, name))
+		return unit{}, &resp
+	}
+	return u, nil
+}
@@ -0,0 +1,130 @@
+// Filename: internal/ws/convert_test.go
+
+package ws
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func convert(a float64, from, to string) CommandResponse {
+	req := CommandRequest{Command: "convert", A: Num(a), From: from, To: to}
+	return processCommand(DefaultRegistry, newSession(defaultHistorySize), req)
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		a        float64
+		from, to string
+		want     float64
+	}{
+		{100, "celsius", "fahrenheit", 212},
+		{212, "F", "C", 100},
+		{-40, "c", "f", -40},
+		{0, "kelvin", "celsius", -273.15},
+		{0, "fahrenheit", "kelvin", 255.372222222},
+		{98.6, "f", "c", 37},
+		{1, "mi", "km", 1.609344},
+		{1, "mile", "feet", 5280},
+		{1, "ft", "in", 12},
+		{1, "in", "m", 0.0254},
+		{3, "km", "mi", 1.86411357671},
+		// Rounded to 12 significant digits, so float noise disappears
+		{0.1, "km", "m", 100},
+		{0.3, "m", "m", 0.3},
+		{1, "lb", "oz", 16},
+		{1, "kg", "lb", 2.20462262185},
+		{16, "oz", "kg", 0.45359237},
+		{1, "Kilograms", "POUNDS", 2.20462262185},
+	}
+	for _, tt := range tests {
+		resp := convert(tt.a, tt.from, tt.to)
+		if resp.Code != "" || resp.Result == nil || *resp.Result != tt.want {
+			t.Errorf("%v %s to %s: got %+v expected %v", tt.a, tt.from, tt.to, resp, tt.want)
+		}
+	}
+}
+
+func TestConvertEchoesUnits(t *testing.T) {
+	b, _ := json.Marshal(convert(100, "c", "fahrenheit"))
+	if want := `{"command":"convert","result":212,"data":{"a":100,"from":"celsius","to":"fahrenheit"}}`; unstamped(string(b)) != want {
+		t.Errorf("got %s expected %s", b, want)
+	}
+}
+
+func TestConvertErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to string
+		code     string
+	}{
+		{"no from", "", "kelvin", ErrCodeMissingUnit},
+		{"no to", "kelvin", "", ErrCodeMissingUnit},
+		{"unknown from", "parsec", "m", ErrCodeUnknownUnit},
+		{"unknown to", "m", "furlong", ErrCodeUnknownUnit},
+		{"length to temperature", "meters", "kelvin", ErrCodeIncompatibleUnits},
+		{"mass to length", "kg", "ft", ErrCodeIncompatibleUnits},
+	}
+	for _, tt := range tests {
+		if resp := convert(1, tt.from, tt.to); resp.Code != tt.code || resp.Result != nil {
+			t.Errorf("%s: got %+v expected %s", tt.name, resp, tt.code)
+		}
+	}
+
+	// A number isn't a unit
+	req := CommandRequest{Command: "convert", A: Num(1), FromNum: Num(1), To: "m"}
+	if resp := processCommand(DefaultRegistry, newSession(defaultHistorySize), req); resp.Code != ErrCodeMissingUnit {
+		t.Errorf("numeric from: got %+v", resp)
+	}
+	if resp := convert(1e308, "km", "in"); resp.Code != ErrCodeNotFinite {
+		t.Errorf("overflow: got %+v", resp)
+	}
+
+	// A missing a is an error rather than 0; an explicit 0 is fine
+	req = CommandRequest{Command: "convert", From: "c", To: "k"}
+	if resp := processCommand(DefaultRegistry, newSession(defaultHistorySize), req); resp.Code != ErrCodeMissingOperand {
+		t.Errorf("no a: got %+v", resp)
+	}
+	if resp := convert(0, "c", "k"); resp.Result == nil || *resp.Result != 273.15 {
+		t.Errorf("a of 0: got %+v", resp)
+	}
+}
+
+// Units travel as strings in "from" and "to", the same keys count uses for numbers
+func TestFromToWireForms(t *testing.T) {
+	tests := []struct {
+		in       string
+		from, to string
+		fromNum  Operand
+		toNum    Operand
+	}{
+		{`{"command":"convert","a":1,"from":"km","to":"mi"}`, "km", "mi", Operand{}, Operand{}},
+		{`{"command":"count","from":1,"to":3}`, "", "", Num(1), Num(3)},
+		{`{"command":"dm","to":"c2","text":"hi"}`, "", "c2", Operand{}, Operand{}},
+		{`{"command":"count","from":null}`, "", "", Operand{}, Operand{}},
+	}
+	for _, tt := range tests {
+		var req CommandRequest
+		if err := json.Unmarshal([]byte(tt.in), &req); err != nil {
+			t.Fatalf("%s: %v", tt.in, err)
+		}
+		same := func(got CommandRequest) bool {
+			return got.From == tt.from && got.To == tt.to && got.FromNum == tt.fromNum && got.ToNum == tt.toNum
+		}
+		if !same(req) {
+			t.Errorf("%s: got %+v", tt.in, req)
+		}
+
+		// Both encodings put them back under the same keys
+		b, err := json.Marshal(req)
+		var again CommandRequest
+		if err != nil || json.Unmarshal(b, &again) != nil || !same(again) {
+			t.Errorf("%s: JSON round trip gave %s", tt.in, b)
+		}
+		b, err = marshalMsgpack(req)
+		again = CommandRequest{}
+		if err != nil || unmarshalMsgpack(b, &again) != nil || !same(again) {
+			t.Errorf("%s: msgpack round trip gave %+v", tt.in, again)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package ws
+
+// Filename: internal/ws/counter.go
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How often the message counter is written to Options.CounterFile
+const defaultCounterSaveInterval = 5 * time.Second
+
+// Read a counter saved by saveCounter
+func loadCounter(path string) (uint64, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return n, nil
+}
+
+// Write n to path; see writeFileAtomic
+func saveCounter(path string, n uint64) error {
+	return writeFileAtomic(path, []byte(strconv.FormatUint(n, 10)+"\n"))
+}
+
+// Write data to path through a temporary file and a rename, so a crash
+// part way through leaves the old contents rather than torn ones
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Carry the message counter on from the value saved in Options.CounterFile.
+// A missing or unreadable file is only a warning: numbering starts from zero
+// instead. The counter never moves backwards, in case frames were already
+// counted.
+func (h *Handler) restoreMessageCounter() {
+	path := h.opts.CounterFile
+	n, err := loadCounter(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			h.opts.Logger.Printf("warning: message counter %s: %v; starting from zero", path, err)
+		}
+		return
+	}
+	for {
+		cur := h.stats.messages.Load()
+		if cur >= n || h.stats.messages.CompareAndSwap(cur, n) {
+			return
+		}
+	}
+}
+
+// Save the message counter to Options.CounterFile every
+// CounterSaveInterval, and once more on Close. Only reads the counter, so
+// the per-frame increment stays lock-free.
+func (h *Handler) persistMessageCounter() {
+	path := h.opts.CounterFile
+	h.background.Add(1)
+	go func() {
+		defer h.background.Done()
+		ticker := time.NewTicker(h.opts.CounterSaveInterval)
+		defer ticker.Stop()
+		saved := h.stats.messages.Load()
+		save := func() {
+			n := h.stats.messages.Load()
+			if n == saved {
+				return
+			}
+			if err := saveCounter(path, n); err != nil {
+				h.opts.Logger.Printf("save message counter: %v", err)
+				return
+			}
+			saved = n
+		}
+		for {
+			select {
+			case <-ticker.C:
+				save()
+			case <-h.stop:
+				save()
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,88 @@
+// Filename: internal/ws/counter_test.go
+
+package ws
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Echo once and return the Msg # from the prefix
+func echoMsgNumber(t *testing.T, url string) uint64 {
+	t.Helper()
+	var c, n uint64
+	got := rawRoundTrip(t, dial(t, url), "hello")
+	if _, err := fmt.Sscanf(got, "[Conn #%d / Msg #%d]", &c, &n); err != nil {
+		t.Fatalf("echo %q: %v", got, err)
+	}
+	return n
+}
+
+func TestMessageCounterSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "counter")
+	opts := Options{CounterFile: path, CounterSaveInterval: time.Hour}
+
+	first := NewHandler(opts)
+	before := echoMsgNumber(t, startServer(t, first))
+	first.Close() // saves on the way out
+
+	// A new handler starts counting from zero
+	second := NewHandler(opts)
+	t.Cleanup(second.Close)
+	if after := echoMsgNumber(t, startServer(t, second)); after != before+1 {
+		t.Errorf("after restart: got Msg #%d expected #%d", after, before+1)
+	}
+}
+
+func TestMessageCounterSavedPeriodically(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "counter")
+	h := NewHandler(Options{CounterFile: path, CounterSaveInterval: 10 * time.Millisecond})
+	t.Cleanup(h.Close)
+	n := echoMsgNumber(t, startServer(t, h))
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if saved, err := loadCounter(path); err == nil && saved >= n {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("counter file never reached %d", n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestCorruptCounterFileStartsFromZero(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "counter")
+	if err := os.WriteFile(path, []byte("not a number"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(Options{CounterFile: path})
+	if got := h.stats.messages.Load(); got != 0 {
+		t.Errorf("counter: got %d expected 0", got)
+	}
+
+	// The bad file is replaced at the next save
+	echoMsgNumber(t, startServer(t, h))
+	h.Close()
+	if saved, err := loadCounter(path); err != nil || saved == 0 {
+		t.Errorf("after close: got %d, %v", saved, err)
+	}
+	if leftovers, _ := filepath.Glob(path + ".tmp*"); len(leftovers) != 0 {
+		t.Errorf("temporary files left behind: %v", leftovers)
+	}
+}
+
+func TestMessageCounterIsPerHandler(t *testing.T) {
+	first, second := NewHandler(Options{}), NewHandler(Options{})
+	echoMsgNumber(t, startServer(t, first))
+	if n := echoMsgNumber(t, startServer(t, second)); n != 1 {
+		t.Errorf("second handler: got Msg #%d expected #1", n)
+	}
+	if got := first.stats.messages.Load(); got != 1 {
+		t.Errorf("first handler: got %d messages expected 1", got)
+	}
+}
@@ -0,0 +1,97 @@
+package ws
+
+// Filename: internal/ws/debug.go
+
+import (
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Plain-text way to switch debug mode: "DEBUG:on" or "DEBUG:off"
+const debugTextPrefix = "DEBUG:"
+
+// Default for Options.DebugDumpBytes
+const defaultDebugDumpBytes = 256
+
+// The mode "DEBUG:on" or "DEBUG:off" asks for; nil for any other text,
+// which is echoed as usual even if it starts with the prefix
+func debugToggle(payload []byte) *bool {
+	var enabled bool
+	switch string(payload) {
+	case debugTextPrefix + "on":
+		enabled = true
+	case debugTextPrefix + "off":
+	default:
+		return nil
+	}
+	return &enabled
+}
+
+// Switch the connection's debug mode; see Session.setDebug
+func runDebug(s *Session, req CommandRequest) CommandResponse {
+	if req.Enabled == nil {
+		return errorResponse(req.Command, ErrCodeInvalidOperand, "enabled must be true or false")
+	}
+	s.setDebug(*req.Enabled)
+	return CommandResponse{Command: req.Command, Done: true}
+}
+
+// In debug mode every frame the connection reads or writes is logged,
+// with a dump of its start, and command replies carry received_at and
+// duration_us. It is off until the client or an admin turns it on.
+func (s *Session) setDebug(enabled bool) {
+	if s.debug.Swap(enabled) == enabled {
+		return
+	}
+	state := "off"
+	if enabled {
+		state = "on"
+	}
+	s.log.Printf("%s debug mode %s", s.label(), state)
+}
+
+func (s *Session) debugging() bool {
+	return s.debug.Load()
+}
+
+// Note when the frame about to be handled was read, if it was read in
+// debug mode. Its replies get the debug fields only then, so the reply to
+// turning debug mode on has none and the one to turning it off has both.
+func (s *Session) markReceived(debug bool) {
+	var at int64
+	if debug {
+		at = time.Now().UnixNano()
+	}
+	s.receivedAt.Store(at)
+}
+
+// Log a frame in or out, size bytes long, of which payload is at least the
+// start. Only for connections in debug mode.
+func (c *client) debugFrame(dir string, msgType, size int, payload []byte) {
+	kind := "text"
+	if msgType == websocket.BinaryMessage {
+		kind = "binary"
+	}
+	c.log.Printf("debug: %s %s %s frame, %d bytes: %s", c.session.conn.ID, dir, kind, size, debugDump(msgType, payload, size, c.debugDump))
+}
+
+// At most max bytes of a frame of size bytes: text quoted with Go
+// escapes, binary in hex, marked when cut short
+func debugDump(msgType int, payload []byte, size, max int) string {
+	if len(payload) > max {
+		payload = payload[:max]
+	}
+	var dump string
+	if msgType == websocket.BinaryMessage {
+		dump = hex.EncodeToString(payload)
+	} else {
+		dump = strconv.Quote(string(payload))
+	}
+	if len(payload) < size {
+		dump += " (" + strconv.Itoa(size-len(payload)) + " more bytes)"
+	}
+	return dump
+}
@@ -0,0 +1,132 @@
+// Filename: internal/ws/debug_test.go
+
+package ws
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestDebugModeIsPerConnection(t *testing.T) {
+	logs := &lockedBuffer{}
+	h := NewHandler(Options{Logger: log.New(logs, "", 0), DebugDumpBytes: 8})
+	mux := http.NewServeMux()
+	mux.Handle("/ws", h)
+	mux.Handle("/admin/", h.AdminHandler("secret"))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+
+	a, welcomeA := dialDecoded(t, url)
+	b, welcomeB := dialDecoded(t, url)
+
+	// Whether a reply to add has the debug fields
+	debugFields := func(conn *websocket.Conn) bool {
+		t.Helper()
+		var resp CommandResponse
+		if err := json.Unmarshal([]byte(rawRoundTrip(t, conn, `{"command":"add","a":1,"b":2}`)), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if (resp.ReceivedAt != "") != (resp.DurationUS != nil) {
+			t.Errorf("received_at %q and duration_us %v should come together", resp.ReceivedAt, resp.DurationUS)
+		}
+		return resp.ReceivedAt != ""
+	}
+	if debugFields(a) || debugFields(b) {
+		t.Fatal("debug fields before debug mode")
+	}
+
+	if got := roundTrip(t, a, "DEBUG:on"); got != `{"command":"debug","done":true}` {
+		t.Fatalf("DEBUG:on: got %s", got)
+	}
+	if !debugFields(a) {
+		t.Error("a in debug mode: no debug fields")
+	}
+	if debugFields(b) {
+		t.Error("b: debug fields while only a is in debug mode")
+	}
+	for conn, expected := range map[*websocket.Conn]bool{a: true, b: false} {
+		var stats struct {
+			Data statsInfo `json:"data"`
+		}
+		if err := json.Unmarshal([]byte(rawRoundTrip(t, conn, `{"command":"stats"}`)), &stats); err != nil || stats.Data.Connection.Debug != expected {
+			t.Errorf("%s stats: got debug=%t (%v) expected %t", stats.Data.Connection.ID, stats.Data.Connection.Debug, err, expected)
+		}
+	}
+
+	out := logs.String()
+	for _, line := range []string{
+		"debug: " + welcomeA.ConnID + ` in text frame, 29 bytes: "{\"comman" (21 more bytes)`,
+		"debug: " + welcomeA.ConnID + ` out text frame`,
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("log lacks %q:\n%s", line, out)
+		}
+	}
+	if strings.Contains(out, "debug: "+welcomeB.ConnID+" ") {
+		t.Errorf("frames logged for %s, not in debug mode:\n%s", welcomeB.ConnID, out)
+	}
+
+	// Read in debug mode, so answered in it
+	if got := roundTrip(t, a, `{"command":"debug","enabled":false}`); !strings.HasPrefix(got, `{"command":"debug","done":true,"duration_us":`) {
+		t.Fatalf("debug off: got %s", got)
+	}
+	if debugFields(a) {
+		t.Error("a: debug fields after turning it off")
+	}
+	if got := roundTrip(t, a, "DEBUG:maybe"); got != "DEBUG:maybe" {
+		t.Errorf("DEBUG:maybe: got %s expected the echo", got)
+	}
+
+	// An admin can switch it for any connection
+	put := func(id, body string) int {
+		req, _ := http.NewRequest("PUT", srv.URL+"/admin/connections/"+id+"/debug", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := put(welcomeB.ConnID, `{}`); code != http.StatusBadRequest {
+		t.Errorf("no enabled: got %d expected 400", code)
+	}
+	if code := put("nope", `{"enabled":true}`); code != http.StatusNotFound {
+		t.Errorf("unknown conn_id: got %d expected 404", code)
+	}
+	if code := put(welcomeB.ConnID, `{"enabled":true}`); code != http.StatusNoContent {
+		t.Fatalf("admin: got %d expected 204", code)
+	}
+	if !debugFields(b) {
+		t.Error("b after the admin turned debug mode on: no debug fields")
+	}
+	if debugFields(a) {
+		t.Error("a: debug fields after the admin switched b")
+	}
+}
+
+func TestDebugDump(t *testing.T) {
+	tests := []struct {
+		msgType  int
+		payload  string
+		size     int
+		expected string
+	}{
+		{websocket.TextMessage, "hi\n", 3, `"hi\n"`},
+		{websocket.TextMessage, "hello world", 11, `"hello" (6 more bytes)`},
+		{websocket.BinaryMessage, "\x00\x01\xff", 3, "0001ff"},
+		{websocket.BinaryMessage, "\x00\x01\xff", 1000, "0001ff (997 more bytes)"}, // a stream's head
+	}
+	for _, tt := range tests {
+		if got := debugDump(tt.msgType, []byte(tt.payload), tt.size, 5); got != tt.expected {
+			t.Errorf("%q: got %s expected %s", tt.payload, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package ws
+
+// Filename: internal/ws/delay.go
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Error codes for the delay command
+const (
+	ErrCodeDelayTooLong  = "ERR_DELAY_TOO_LONG"
+	ErrCodeTooManyDelays = "ERR_TOO_MANY_DELAYS"
+)
+
+const (
+	defaultMaxDelay         = 30 * time.Second // longest delay a client may ask for
+	defaultMaxPendingDelays = 8                // delays waiting at once per connection
+)
+
+// Reply to "delay" after a milliseconds. The wait runs on its own goroutine
+// so the read loop keeps answering other frames meanwhile, and the reply
+// goes out through the write pump like any other. A connection that closes
+// first gets no reply. The reply carries the delay frame's seq and id.
+func (h *Handler) startDelay(c *client, req CommandRequest) (CommandResponse, bool) {
+	ms, errResp := c.session.resolve(req.Command, req.A, req.AVar)
+	if errResp != nil {
+		return *errResp, true
+	}
+	if ms < 0 || ms != math.Trunc(ms) {
+		return errorResponse(req.Command, ErrCodeInvalidRange, "a must be a whole number of milliseconds, at least 0"), true
+	}
+	if maxMS := h.opts.MaxDelay.Milliseconds(); ms > float64(maxMS) {
+		return errorResponse(req.Command, ErrCodeDelayTooLong, fmt.Sprintf("Delay too long (max %d ms)", maxMS)), true
+	}
+
+	c.streamsMu.Lock()
+	if c.delays >= h.opts.MaxPendingDelays {
+		c.streamsMu.Unlock()
+		return errorResponse(req.Command, ErrCodeTooManyDelays,
+			fmt.Sprintf("Too many pending delays (max %d)", h.opts.MaxPendingDelays)), true
+	}
+	c.delays++
+	c.streamsMu.Unlock()
+
+	start := time.Now()
+	resp := c.session.stamp(resultResponse(req.Command, ms))
+	resp.ID = req.ID
+	c.goWorker(func() {
+		defer func() {
+			c.streamsMu.Lock()
+			c.delays--
+			c.streamsMu.Unlock()
+		}()
+		timer := time.NewTimer(time.Duration(ms) * time.Millisecond)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			c.sendEncoded(c.session.timed(req, resp, time.Since(start)))
+		case <-c.done:
+		}
+	})
+	return CommandResponse{}, false
+}
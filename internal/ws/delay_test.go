@@ -0,0 +1,113 @@
+// Filename: internal/ws/delay_test.go
+
+package ws
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDelayReplyTiming(t *testing.T) {
+	conn := dial(t, startServer(t, NewHandler(Options{})))
+
+	start := time.Now()
+	send(t, conn, `{"command":"delay","id":"d1","a":150}`)
+	// Answered while the delay waits, so in order of completion
+	send(t, conn, `{"command":"add","a":1,"b":2}`)
+	resp := readResponse(t, conn)
+	if resp.Command != "add" || *resp.Result != 3 {
+		t.Fatalf("got %+v expected the add reply first", resp)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("add waited %v behind the delay", elapsed)
+	}
+
+	resp = readResponse(t, conn)
+	elapsed := time.Since(start)
+	if resp.Command != "delay" || resp.ID != "d1" || resp.Result == nil || *resp.Result != 150 {
+		t.Fatalf("got %+v expected the delay reply", resp)
+	}
+	// The reply answers the delay frame, the connection's first
+	if resp.Seq != 1 {
+		t.Errorf("got seq %d expected 1", resp.Seq)
+	}
+	if elapsed < 150*time.Millisecond || elapsed > time.Second {
+		t.Errorf("delay of 150ms took %v", elapsed)
+	}
+}
+
+func TestDelaysOverlap(t *testing.T) {
+	conn := dial(t, startServer(t, NewHandler(Options{})))
+
+	// Shorter delays sent later finish first, and all of them overlap
+	start := time.Now()
+	send(t, conn, `{"command":"delay","id":"long","a":200}`)
+	send(t, conn, `{"command":"delay","id":"short","a":50}`)
+	send(t, conn, `{"command":"delay","id":"zero","a":0}`)
+	for _, want := range []string{"zero", "short", "long"} {
+		if resp := readResponse(t, conn); resp.ID != want {
+			t.Fatalf("got %+v expected %s", resp, want)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 600*time.Millisecond {
+		t.Errorf("delays ran one after another: %v", elapsed)
+	}
+}
+
+func TestDelayLimits(t *testing.T) {
+	conn := dial(t, startServer(t, NewHandler(Options{MaxDelay: time.Second, MaxPendingDelays: 2})))
+
+	tests := []struct {
+		send string
+		code string
+	}{
+		{`{"command":"delay","a":1001}`, ErrCodeDelayTooLong},
+		{`{"command":"delay","a":-1}`, ErrCodeInvalidRange},
+		{`{"command":"delay","a":1.5}`, ErrCodeInvalidRange},
+		{`{"command":"delay","a":"ans"}`, ErrCodeNoResult},
+		{`[{"command":"delay","a":1}]`, ErrCodeNotBatchable},
+	}
+	for _, tt := range tests {
+		if got := roundTrip(t, conn, tt.send); !strings.Contains(got, `"code":"`+tt.code+`"`) {
+			t.Errorf("%s: got %s expected %s", tt.send, got, tt.code)
+		}
+	}
+
+	send(t, conn, `{"command":"delay","id":"a","a":300}`)
+	send(t, conn, `{"command":"delay","id":"b","a":300}`)
+	send(t, conn, `{"command":"delay","id":"c","a":300}`)
+	if resp := readResponse(t, conn); resp.Code != ErrCodeTooManyDelays {
+		t.Fatalf("third pending delay: got %+v", resp)
+	}
+	for range 2 {
+		if resp := readResponse(t, conn); resp.Command != "delay" || resp.Code != "" {
+			t.Fatalf("got %+v", resp)
+		}
+	}
+	// With those done there's room again
+	send(t, conn, `{"command":"delay","id":"d","a":1}`)
+	if resp := readResponse(t, conn); resp.ID != "d" || resp.Code != "" {
+		t.Errorf("after the others finished: got %+v", resp)
+	}
+}
+
+func TestDelayStopsOnDisconnect(t *testing.T) {
+	url := startServer(t, NewHandler(Options{}))
+	before := runtime.NumGoroutine()
+
+	conn := dial(t, url)
+	send(t, conn, `{"command":"delay","a":30000}`)
+	roundTrip(t, conn, `{"command":"add","a":1,"b":1}`)
+	conn.Close()
+
+	// The delay's goroutine goes with the connection instead of waiting out 30s
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("goroutines: got %d expected at most %d", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,110 @@
+package ws
+
+// Filename: internal/ws/dm.go
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Error codes for direct messages
+const (
+	ErrCodeNoSuchRecipient = "ERR_NO_SUCH_RECIPIENT"
+	ErrCodeRecipientBusy   = "ERR_RECIPIENT_QUEUE_FULL"
+)
+
+var (
+	errNoSuchRecipient = errors.New("no such recipient")
+	errRecipientBusy   = errors.New("recipient queue full")
+)
+
+// Frame pushed to the recipient of a direct message
+type dmFrame struct {
+	Type  string `json:"type"`
+	From  string `json:"from"` // sender's nickname, or conn_id if it has none
+	Text  string `json:"text"`
+	TS    string `json:"ts"`
+	MsgID string `json:"msg_id,omitempty"` // with "ack"; see runAck
+}
+
+// What the sender of a dm gets back
+type dmResult struct {
+	rosterEntry
+	MsgID string `json:"msg_id,omitempty"`
+}
+
+// Find the live client called to, by conn_id or (case-insensitively) by
+// nickname. Caller holds h.mu.
+func (h *hub) lookup(to string) (*client, bool) {
+	c, ok := h.ids[to]
+	if !ok {
+		s, named := h.nicks[strings.ToLower(to)]
+		if !named {
+			return nil, false
+		}
+		c, ok = h.ids[s.conn.ID]
+	}
+	if !ok {
+		return nil, false
+	}
+	// Still in the map while its handler unwinds; it won't write again
+	select {
+	case <-c.done:
+		return nil, false
+	default:
+		return c, true
+	}
+}
+
+// Queue frame on lane l for the client called to, counting it as a
+// recipient of ack (which may be nil). The lock is held across the send, so the recipient
+// can't leave halfway, and the queue is never waited on.
+func (h *hub) sendTo(to string, frame interface{}, ack *pendingAck, l lane) (*client, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c, ok := h.lookup(to)
+	if !ok {
+		return nil, errNoSuchRecipient
+	}
+	ack.expect(c.session.conn.ID)
+	if !c.trySendEncoded(frame, l) {
+		ack.unexpect(c.session.conn.ID)
+		return nil, errRecipientBusy
+	}
+	return c, nil
+}
+
+// Send text to one other connection (or this one). The ack names who got it.
+func runDM(s *Session, req CommandRequest) CommandResponse {
+	to := req.To
+	if to == "" {
+		return errorResponse(req.Command, ErrCodeInvalidOperand, "to must name a nickname or conn_id")
+	}
+	if req.Text == "" {
+		return errorResponse(req.Command, ErrCodeInvalidOperand, "text must not be empty")
+	}
+	if s.hub == nil {
+		return errorResponse(req.Command, ErrCodeNoSuchRecipient, fmt.Sprintf("No such recipient %q", to))
+	}
+
+	ack, errResp := s.openAck(req)
+	if errResp != nil {
+		return *errResp
+	}
+	frame := dmFrame{Type: "dm", From: s.name(), Text: req.Text, TS: s.clock.Now().UTC().Format(serverTimeFormat), MsgID: ack.id()}
+	c, err := s.hub.sendTo(to, frame, ack, laneData)
+	if err != nil {
+		ack.cancel()
+	}
+	switch err {
+	case nil:
+		ack.seal()
+		return CommandResponse{Command: req.Command, Done: true,
+			Data: dmResult{rosterEntry{ConnID: c.session.conn.ID, Nick: c.session.Nick()}, ack.id()}}
+	case errRecipientBusy:
+		return errorResponse(req.Command, ErrCodeRecipientBusy, fmt.Sprintf("Recipient %q queue full", to))
+	default:
+		return errorResponse(req.Command, ErrCodeNoSuchRecipient, fmt.Sprintf("No such recipient %q", to))
+	}
+}
@@ -0,0 +1,102 @@
+// Filename: internal/ws/dm_test.go
+
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestDMReachesOnlyTheRecipient(t *testing.T) {
+	url := startServer(t, NewHandler(Options{}))
+	alice, bob, carol := dial(t, url), dial(t, url), dial(t, url)
+	roundTrip(t, alice, "NICK:alice")
+	roundTrip(t, bob, "NICK:bob")
+
+	if got := roundTrip(t, bob, `{"command":"dm","to":"Alice","text":"hello"}`); got != `{"command":"dm","done":true,"data":{"conn_id":"`+who(t, bob)[0].ConnID+`","nick":"alice"}}` {
+		t.Errorf("ack: got %s", got)
+	}
+
+	// Skip the presence events from the joins and renames
+	_ = alice.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, msg, err := readData(alice)
+	if err != nil {
+		t.Fatalf("read dm: %v", err)
+	}
+	var dm dmFrame
+	if err := json.Unmarshal(msg, &dm); err != nil || dm.Type != "dm" || dm.From != "bob" || dm.Text != "hello" {
+		t.Errorf("dm: got %s", msg)
+	}
+	if _, err := time.Parse(time.RFC3339, dm.TS); err != nil {
+		t.Errorf("dm ts %q: %v", dm.TS, err)
+	}
+
+	// carol only ever sees presence
+	_ = carol.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, msg, err = readData(carol)
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("bystander: got %s, %v expected a read timeout", msg, err)
+	}
+
+	if got := roundTrip(t, bob, `{"command":"dm","to":"dave","text":"hi"}`); got != `{"command":"dm","error":"No such recipient \"dave\"","code":"ERR_NO_SUCH_RECIPIENT"}` {
+		t.Errorf("unknown recipient: got %s", got)
+	}
+}
+
+func TestDMToSelf(t *testing.T) {
+	conn, welcome := dialWelcome(t, websocket.DefaultDialer, startServer(t, NewHandler(Options{})))
+	var w welcomeFrame
+	if err := json.Unmarshal(welcome, &w); err != nil {
+		t.Fatalf("welcome: %v", err)
+	}
+
+	// The DM is queued before the ack, so it arrives first
+	got := rawRoundTrip(t, conn, `{"command":"dm","to":"`+w.ConnID+`","text":"note"}`)
+	var dm dmFrame
+	if err := json.Unmarshal([]byte(got), &dm); err != nil || dm.Type != "dm" || dm.From != w.ConnID || dm.Text != "note" {
+		t.Errorf("self dm: got %s", got)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, ack, err := conn.ReadMessage(); err != nil || unstamped(string(ack)) != `{"command":"dm","done":true,"data":{"conn_id":"`+w.ConnID+`"}}` {
+		t.Errorf("self ack: got %s, %v", ack, err)
+	}
+}
+
+func TestDMQueueFull(t *testing.T) {
+	h := newHub(log.Default())
+	sender := &client{session: newSession(defaultHistorySize), encoding: encodingJSON, send: make(chan outbound, 1), done: make(chan struct{})}
+	target := &client{session: newSession(defaultHistorySize), encoding: encodingJSON, send: make(chan outbound, 1), done: make(chan struct{})}
+	sender.session.conn.ID, target.session.conn.ID = "c1", "c2"
+	sender.session.hub = h
+	h.join(target)
+	h.join(sender) // fills target's queue with the join event
+
+	resp := runDM(sender.session, CommandRequest{Command: "dm", To: "c2", Text: "hi"})
+	if resp.Code != ErrCodeRecipientBusy {
+		t.Errorf("got %+v expected %s", resp, ErrCodeRecipientBusy)
+	}
+}
+
+func TestDMTimestampFollowsClock(t *testing.T) {
+	h := newHub(log.Default())
+	sender := &client{session: newSession(defaultHistorySize), encoding: encodingJSON, send: make(chan outbound, 1), done: make(chan struct{})}
+	target := &client{session: newSession(defaultHistorySize), encoding: encodingJSON, send: make(chan outbound, 2), done: make(chan struct{})}
+	sender.session.conn.ID, target.session.conn.ID = "c1", "c2"
+	sender.session.hub, sender.session.clock = h, newFakeClock(time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC))
+	h.join(target)
+
+	if resp := runDM(sender.session, CommandRequest{Command: "dm", To: "c2", Text: "hi"}); resp.Error != "" {
+		t.Fatalf("dm: got %+v", resp)
+	}
+	var frame dmFrame
+	if err := json.Unmarshal((<-target.send).data, &frame); err != nil || frame.TS != "2001-02-03T04:05:06Z" {
+		t.Errorf("got %+v, %v expected the fake clock's time", frame, err)
+	}
+}
@@ -0,0 +1,219 @@
+package ws
+
+// Filename: internal/ws/drain.go
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Default for Options.DrainNotices
+var defaultDrainNotices = []time.Duration{30 * time.Second, 10 * time.Second}
+
+// What Retry-After says while draining without a countdown: the process
+// is on its way out, and a new instance should be up by then
+const drainRetryAfter = 5 * time.Second
+
+// Notice pushed to every client while a drain counts down, and once more
+// if it is cancelled
+type shutdownFrame struct {
+	Type      string `json:"type"` // always "shutdown"
+	InSeconds int    `json:"in_seconds"`
+	Cancelled bool   `json:"cancelled,omitempty"`
+}
+
+// The grace period of a drain started with DrainIn, if one is running
+type drainCountdown struct {
+	mu       sync.Mutex
+	stop     chan struct{} // closed to end the countdown early; nil when none runs
+	deadline time.Time
+}
+
+// DrainIn drains the handler, as Drain does, and gives the clients already
+// connected grace to leave: each is sent {"type":"shutdown","in_seconds":n}
+// now and again at each of Options.DrainNotices still ahead, and whoever is
+// left when grace runs out is closed with 1001. Calling it again while the
+// countdown runs starts it over with the new grace.
+func (h *Handler) DrainIn(grace time.Duration) {
+	h.Drain()
+	deadline := time.Now().Add(grace)
+
+	h.countdown.mu.Lock()
+	if h.countdown.stop != nil {
+		close(h.countdown.stop)
+	}
+	stop := make(chan struct{})
+	h.countdown.stop, h.countdown.deadline = stop, deadline
+	h.countdown.mu.Unlock()
+
+	h.opts.Logger.Printf("draining: closing remaining connections in %s", grace)
+	h.hub.pushAll(shutdownFrame{Type: "shutdown", InSeconds: ceilSeconds(grace)})
+	h.background.Add(1)
+	go func() {
+		defer h.background.Done()
+		h.runCountdown(deadline, stop)
+	}()
+}
+
+// CancelDrain stops a drain: any countdown ends, clients are told the
+// shutdown is off, and upgrades and /readyz are accepted again. Reports
+// whether the handler was draining.
+func (h *Handler) CancelDrain() bool {
+	h.countdown.mu.Lock()
+	counting := h.countdown.stop != nil
+	if counting {
+		close(h.countdown.stop)
+		h.countdown.stop = nil
+	}
+	h.countdown.mu.Unlock()
+
+	if !h.draining.CompareAndSwap(true, false) {
+		return false
+	}
+	h.opts.Logger.Printf("drain cancelled: accepting new connections")
+	if counting {
+		h.hub.pushAll(shutdownFrame{Type: "shutdown", Cancelled: true})
+	}
+	return true
+}
+
+// How long a refused client should wait before trying again: what is
+// left of the countdown, if there is one
+func (h *Handler) retryAfter() time.Duration {
+	h.countdown.mu.Lock()
+	defer h.countdown.mu.Unlock()
+	if h.countdown.stop == nil {
+		return drainRetryAfter
+	}
+	return max(time.Until(h.countdown.deadline), time.Second)
+}
+
+// Repeat the notice at each of Options.DrainNotices before deadline, then
+// close everyone left. Stops early if stop is closed or the handler is.
+func (h *Handler) runCountdown(deadline time.Time, stop <-chan struct{}) {
+	notices := slices.Clone(h.opts.DrainNotices)
+	slices.Sort(notices)
+	slices.Reverse(notices)
+	for _, left := range notices {
+		if left >= time.Until(deadline) {
+			continue // the first notice already said as much
+		}
+		if !h.sleepUntil(deadline.Add(-left), stop) {
+			return
+		}
+		h.hub.pushAll(shutdownFrame{Type: "shutdown", InSeconds: ceilSeconds(left)})
+	}
+	if !h.sleepUntil(deadline, stop) {
+		return
+	}
+
+	h.countdown.mu.Lock()
+	if h.countdown.stop == stop {
+		h.countdown.stop = nil
+	}
+	h.countdown.mu.Unlock()
+	clients := h.hub.snapshot()
+	h.opts.Logger.Printf("draining: grace period over, closing %d connections", len(clients))
+	for _, c := range clients {
+		c.kick(websocket.CloseGoingAway, "going away")
+	}
+}
+
+// Wait until t; false if stop or the handler closed first
+func (h *Handler) sleepUntil(t time.Time, stop <-chan struct{}) bool {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-stop:
+	case <-h.stop:
+	}
+	return false
+}
+
+// Whole seconds in d, rounded up, so a client never hears it has longer
+// than it does
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// Send frame to every connection on the system lane, ahead of any data
+// queued, skipping those whose system queue is full
+func (h *hub) pushAll(frame shutdownFrame) {
+	h.sse.publish(frame.Type, frame)
+	out := newFanout(frame)
+	out.lane = laneSystem
+	for _, c := range h.snapshot() {
+		if !out.trySend(c) {
+			h.log.Printf("shutdown notice dropped for %s: queue full", c.session.conn.RemoteAddr)
+		}
+	}
+}
+
+// Body of POST /admin/drain; without one the drain has no countdown
+type drainRequest struct {
+	Seconds float64 `json:"seconds"`
+}
+
+// Answer to POST /admin/drain with a grace period
+type drainStatus struct {
+	InSeconds int    `json:"in_seconds"`
+	Deadline  string `json:"deadline"`
+}
+
+func (h *Handler) drain(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxNotifyBody))
+	if err != nil {
+		http.Error(w, "bad body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	var req drainRequest
+	if len(bytes.TrimSpace(body)) > 0 {
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if !decodeBody(w, r, &req) {
+			return
+		}
+	}
+	if req.Seconds < 0 {
+		http.Error(w, "seconds must not be negative", http.StatusBadRequest)
+		return
+	}
+	if req.Seconds == 0 {
+		h.Drain()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	grace := time.Duration(req.Seconds * float64(time.Second))
+	h.DrainIn(grace)
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(drainStatus{
+		InSeconds: ceilSeconds(grace),
+		Deadline:  time.Now().Add(grace).UTC().Format(serverTimeFormat),
+	})
+	if err != nil {
+		h.opts.Logger.Printf("admin: encode drain: %v", err)
+	}
+}
+
+func (h *Handler) cancelDrain(w http.ResponseWriter, r *http.Request) {
+	if !h.CancelDrain() {
+		http.Error(w, "not draining", http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Retry-After for a refused upgrade, in whole seconds
+func (h *Handler) setRetryAfter(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(h.retryAfter())))
+}
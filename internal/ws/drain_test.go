@@ -0,0 +1,133 @@
+// Filename: internal/ws/drain_test.go
+
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// A handler with /ws, /readyz and the admin API, and the base URL of both
+func drainServer(t *testing.T, opts Options) (*Handler, string) {
+	t.Helper()
+	h := NewHandler(opts)
+	mux := http.NewServeMux()
+	mux.Handle("/ws", h)
+	mux.HandleFunc("GET /readyz", h.Readyz)
+	mux.Handle("/admin/", h.AdminHandler("secret"))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return h, srv.URL
+}
+
+func drainRequestTo(t *testing.T, method, url, body string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(method, url+"/admin/drain", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s /admin/drain: %v", method, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func readShutdown(t *testing.T, conn *websocket.Conn) shutdownFrame {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, msg, err := readData(conn)
+	if err != nil {
+		t.Fatalf("read shutdown notice: %v", err)
+	}
+	var frame shutdownFrame
+	if err := json.Unmarshal(msg, &frame); err != nil || frame.Type != "shutdown" {
+		t.Fatalf("expected a shutdown notice, got %s", msg)
+	}
+	return frame
+}
+
+func readyzStatus(t *testing.T, url string) int {
+	t.Helper()
+	resp, err := http.Get(url + "/readyz")
+	if err != nil {
+		t.Fatalf("GET /readyz: %v", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestDrainCountdown(t *testing.T) {
+	h, url := drainServer(t, Options{DrainNotices: []time.Duration{500 * time.Millisecond}})
+	wsURL := "ws" + strings.TrimPrefix(url, "http") + "/ws"
+	conn := dial(t, wsURL)
+
+	resp := drainRequestTo(t, "POST", url, `{"seconds":60}`)
+	var status drainStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil || resp.StatusCode != http.StatusOK || status.InSeconds != 60 {
+		t.Fatalf("POST seconds 60: got %d %+v, %v", resp.StatusCode, status, err)
+	}
+	if got := readShutdown(t, conn); got.InSeconds != 60 {
+		t.Errorf("first notice: got %+v", got)
+	}
+	if got := readyzStatus(t, url); got != http.StatusServiceUnavailable {
+		t.Errorf("/readyz while draining: got %d", got)
+	}
+	_, refused, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Origin": {allowedOrigins[0]}})
+	if err == nil || refused == nil || refused.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("dial while draining: got %v, %v expected 503", refused, err)
+	}
+	if got := refused.Header.Get("Retry-After"); got != "60" && got != "59" {
+		t.Errorf("Retry-After: got %q", got)
+	}
+
+	// A second POST starts the countdown over, shorter this time
+	drainRequestTo(t, "POST", url, `{"seconds":1.5}`)
+	for _, expected := range []int{2, 1} {
+		if got := readShutdown(t, conn); got.InSeconds != expected || got.Cancelled {
+			t.Errorf("notice: got %+v expected in_seconds %d", got, expected)
+		}
+	}
+	_, _, err = readData(conn)
+	var ce *websocket.CloseError
+	if !errors.As(err, &ce) || ce.Code != websocket.CloseGoingAway || ce.Text != "going away" {
+		t.Fatalf("at expiry: got %v expected 1001 going away", err)
+	}
+	if !h.Draining() {
+		t.Error("handler stopped draining after the countdown")
+	}
+}
+
+func TestCancelDrain(t *testing.T) {
+	h, url := drainServer(t, Options{})
+	wsURL := "ws" + strings.TrimPrefix(url, "http") + "/ws"
+	conn := dial(t, wsURL)
+
+	if resp := drainRequestTo(t, "DELETE", url, ""); resp.StatusCode != http.StatusConflict {
+		t.Errorf("DELETE while not draining: got %d expected 409", resp.StatusCode)
+	}
+
+	drainRequestTo(t, "POST", url, `{"seconds":30}`)
+	readShutdown(t, conn)
+	if resp := drainRequestTo(t, "DELETE", url, ""); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("DELETE: got %d", resp.StatusCode)
+	}
+	if got := readShutdown(t, conn); !got.Cancelled {
+		t.Errorf("after cancel: got %+v expected cancelled", got)
+	}
+	if h.Draining() || readyzStatus(t, url) != http.StatusOK {
+		t.Error("still draining after DELETE /admin/drain")
+	}
+	if got := roundTrip(t, dial(t, wsURL), `{"command":"add","a":1,"b":2}`); got != `{"command":"add","result":3}` {
+		t.Errorf("new connection after cancel: got %s", got)
+	}
+	if resp := drainRequestTo(t, "POST", url, `{"seconds":-1}`); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("negative seconds: got %d expected 400", resp.StatusCode)
+	}
+}
@@ -0,0 +1,117 @@
+package ws
+
+// Filename: internal/ws/envelope.go
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/websocket"
+)
+
+// Asking for this subprotocol, or connecting with ?v=2, wraps every text
+// frame the server sends in one JSON shape:
+//
+//	{"type":"command","seq":3,"ts":"2024-05-01T12:00:00.123456789Z","data":{...}}
+//
+// type is the frame's own "type" for pushes (welcome, broadcast, presence,
+// tick, dm...), "error" for a reply with an error, "command" for any other
+// command reply, "echo" for echoes, "plain" for the replies to plain-text
+// commands and "pong" for app-level pongs. seq counts the connection's
+// frames as they are written and ts is when. data holds the frame
+// unchanged, except that echoes, plain replies and pongs become
+// {"text":...} (without the "[Conn #c / Msg #n] " prefix) and an NDJSON
+// reply becomes an array of its lines. msgpack connections and binary frames
+// are never wrapped; without v2 every frame is exactly as before.
+const subprotocolEnvelope = "envelope.v2"
+
+// Envelope types for text frames that aren't JSON
+const (
+	kindEcho  = "echo"
+	kindPlain = "plain"
+	kindPong  = "pong"
+)
+
+// Does the request ask for v2 envelopes in the query? Only "1" and "2"
+// are versions.
+func requestedVersion(r *http.Request) (int, error) {
+	switch r.URL.Query().Get("v") {
+	case "", "1":
+		return 1, nil
+	case "2":
+		return 2, nil
+	}
+	return 0, errors.New(`unsupported version: v must be 1 or 2`)
+}
+
+// Wrap a text frame in its envelope. Called by writePump alone, so seq
+// numbers follow the order frames reach the wire. A shared frame loses its
+// sharing, since every envelope is different.
+func (c *client) envelop(m outbound) outbound {
+	c.envelopeSeq++
+	ts := c.clock.Now().UTC().Format(serverTimeFormat)
+	if m.stream != nil {
+		// Only command replies are streamed
+		head := envelopeHead("command", c.envelopeSeq, ts)
+		write := m.stream
+		m.stream = func(w io.Writer) error {
+			if _, err := w.Write(head); err != nil {
+				return err
+			}
+			if err := write(w); err != nil {
+				return err
+			}
+			_, err := w.Write([]byte{'}'})
+			return err
+		}
+		return m
+	}
+
+	kind, data := m.kind, m.data
+	switch {
+	case kind != "":
+		data, _ = json.Marshal(struct {
+			Text string `json:"text"`
+		}{string(data)})
+	case json.Valid(data):
+		kind = frameType(data)
+	default:
+		// The one reply that isn't a JSON document: NDJSON, a line per command
+		kind, data = "command", append(append([]byte{'['}, bytes.ReplaceAll(data, []byte("\n"), []byte(","))...), ']')
+	}
+	b := envelopeHead(kind, c.envelopeSeq, ts)
+	b = append(append(b, data...), '}')
+	return outbound{messageType: websocket.TextMessage, data: b}
+}
+
+// Everything of an envelope up to its data
+func envelopeHead(kind string, seq uint64, ts string) []byte {
+	b := append([]byte(`{"type":`), strconv.Quote(kind)...)
+	b = append(b, `,"seq":`...)
+	b = strconv.AppendUint(b, seq, 10)
+	b = append(b, `,"ts":"`...)
+	b = append(b, ts...)
+	return append(b, `","data":`...)
+}
+
+// The envelope type of a JSON frame: its own "type" if it has one, "error"
+// for a failed command, otherwise "command"
+func frameType(data []byte) string {
+	var probe struct {
+		Type  string `json:"type"`
+		Error string `json:"error"`
+	}
+	if len(data) > 0 && data[0] == '{' && json.Unmarshal(data, &probe) == nil {
+		switch {
+		case probe.Type != "":
+			return probe.Type
+		case probe.Error != "":
+			return "error"
+		}
+	}
+	return "command"
+}
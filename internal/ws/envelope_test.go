@@ -0,0 +1,146 @@
+// Filename: internal/ws/envelope_test.go
+
+package ws
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type envelopeFrame struct {
+	Type string          `json:"type"`
+	Seq  uint64          `json:"seq"`
+	TS   string          `json:"ts"`
+	Data json.RawMessage `json:"data"`
+}
+
+func readEnvelope(t *testing.T, conn *websocket.Conn) envelopeFrame {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, msg, err := readData(conn)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	var env envelopeFrame
+	dec := json.NewDecoder(strings.NewReader(string(msg)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&env); err != nil {
+		t.Fatalf("envelope %s: %v", msg, err)
+	}
+	return env
+}
+
+func TestEnvelopeVersionsSideBySide(t *testing.T) {
+	// Read deadlines come from the clock too, so it has to be near the real one
+	clock := newFakeClock(time.Now())
+	ts := clock.Now().UTC().Format(time.RFC3339Nano)
+	url := startServer(t, NewHandler(Options{Clock: clock}))
+	v1 := dial(t, url)
+	v2, welcome := dialWelcome(t, websocket.DefaultDialer, url+"?v=2")
+
+	var env envelopeFrame
+	if err := json.Unmarshal(welcome, &env); err != nil || env.Type != "welcome" || env.Seq != 1 || env.TS != ts {
+		t.Fatalf("v2 welcome: got %s", welcome)
+	}
+	if !strings.Contains(string(env.Data), `"type":"welcome"`) || !strings.Contains(string(env.Data), `"version":2`) {
+		t.Errorf("v2 welcome data: got %s", env.Data)
+	}
+
+	// v1 replies are pinned byte for byte, bar the message numbers and
+	// sequence stamps
+	echoPrefix := regexp.MustCompile(`^\[Conn #\d+ / Msg #\d+\] `)
+	tests := []struct {
+		send     string
+		v1       string
+		v2Type   string
+		v2Data   string
+		v1Prefix bool
+	}{
+		{"hello", "hello", "echo", `{"text":"hello"}`, true},
+		{"UPPER:abc", "ABC", "echo", `{"text":"ABC"}`, true},
+		{`{"command":"add","a":2,"b":3}`, `{"command":"add","result":5}`, "command", `{"command":"add","result":5}`, false},
+		{`{"command":"divide","a":1,"b":0}`,
+			`{"command":"divide","error":"Division by zero","code":"ERR_DIVISION_BY_ZERO"}`,
+			"error", `{"command":"divide","error":"Division by zero","code":"ERR_DIVISION_BY_ZERO"}`, false},
+		{`[{"command":"add","a":1,"b":1}]`, `[{"command":"add","result":2}]`, "command", `[{"command":"add","result":2}]`, false},
+		{"{\"command\":\"add\",\"a\":1,\"b\":1}\n{\"command\":\"add\",\"a\":2,\"b\":2}",
+			"{\"command\":\"add\",\"result\":2}\n{\"command\":\"add\",\"result\":4}",
+			"command", `[{"command":"add","result":2},{"command":"add","result":4}]`, false},
+		{"PING x", "", "pong", "", false},
+	}
+	seq := env.Seq
+	for _, tt := range tests {
+		got := rawRoundTrip(t, v1, tt.send)
+		switch {
+		case tt.send == "PING x":
+			if !strings.HasPrefix(got, "PONG ") || !strings.HasSuffix(got, " x") {
+				t.Errorf("v1 %q: got %q", tt.send, got)
+			}
+		case tt.v1Prefix:
+			if !echoPrefix.MatchString(got) || echoPrefix.ReplaceAllString(got, "") != tt.v1 {
+				t.Errorf("v1 %q: got %q", tt.send, got)
+			}
+		case unstamped(got) != tt.v1:
+			t.Errorf("v1 %q: got %s expected %s", tt.send, got, tt.v1)
+		}
+
+		if err := v2.WriteMessage(websocket.TextMessage, []byte(tt.send)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		env := readEnvelope(t, v2)
+		seq++
+		if env.Type != tt.v2Type || env.Seq != seq || env.TS != ts {
+			t.Errorf("v2 %q: got type %q seq %d ts %s", tt.send, env.Type, env.Seq, env.TS)
+		}
+		if tt.v2Data != "" && unstamped(string(env.Data)) != tt.v2Data {
+			t.Errorf("v2 %q: got data %s expected %s", tt.send, env.Data, tt.v2Data)
+		}
+	}
+}
+
+func TestEnvelopeBroadcastAndStream(t *testing.T) {
+	url := startServer(t, NewHandler(Options{}))
+	v1 := dial(t, url)
+	v2 := dialWith(t, &websocket.Dialer{Subprotocols: []string{subprotocolEnvelope}}, url)
+
+	// Both get the same broadcast, each in its own version
+	if got := roundTrip(t, v1, `{"command":"broadcast","text":"hi all"}`); !strings.Contains(got, `"command":"broadcast"`) {
+		t.Fatalf("broadcast reply: got %s", got)
+	}
+	var b broadcastFrame
+	env := readEnvelope(t, v2)
+	if err := json.Unmarshal(env.Data, &b); err != nil || env.Type != "broadcast" || b.Text != "hi all" {
+		t.Errorf("v2 broadcast: got %+v %s", env, env.Data)
+	}
+
+	// A streamed history reply is wrapped like any other
+	if err := v2.WriteMessage(websocket.TextMessage, []byte(`{"command":"history"}`)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	env = readEnvelope(t, v2)
+	var resp CommandResponse
+	if err := json.Unmarshal(env.Data, &resp); err != nil || env.Type != "command" || resp.Command != "history" {
+		t.Errorf("v2 history: got %+v, %v", env, err)
+	}
+}
+
+func TestEnvelopeVersionParam(t *testing.T) {
+	url := startServer(t, NewHandler(Options{}))
+	_, res, err := websocket.DefaultDialer.Dial(url+"?v=3", http.Header{"Origin": {allowedOrigins[0]}})
+	if err == nil || res == nil || res.StatusCode != http.StatusBadRequest {
+		t.Errorf("v=3: got %v expected 400", err)
+	}
+
+	// v=1 is today's format
+	_, welcome := dialWelcome(t, websocket.DefaultDialer, url+"?v=1")
+	var w map[string]json.RawMessage
+	if !strings.HasPrefix(string(welcome), `{"type":"welcome","conn_id":`) || json.Unmarshal(welcome, &w) != nil || w["version"] != nil {
+		t.Errorf("v1 welcome: got %s", welcome)
+	}
+}
@@ -0,0 +1,120 @@
+package ws
+
+// Filename: internal/ws/events.go
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// serverEvent is one entry on the admin event stream
+type serverEvent struct {
+	Type       string   `json:"type"`  // always "event"
+	Event      string   `json:"event"` // "open", "close", "command" or "rate_limit"
+	Time       string   `json:"time"`
+	ConnID     string   `json:"conn_id,omitempty"`
+	RemoteAddr string   `json:"remote_addr,omitempty"` // open only
+	Command    string   `json:"command,omitempty"`
+	DurationMS *float64 `json:"duration_ms,omitempty"` // command only
+	Error      string   `json:"error,omitempty"`       // command's error code, if it failed
+	CloseCode  int      `json:"close_code,omitempty"`  // close only
+}
+
+// eventBus fans server events out to the admin stream's subscribers
+type eventBus struct {
+	mu   sync.Mutex
+	subs map[*client]struct{}
+	n    atomic.Int32 // len(subs), so publish is free with nobody listening
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subs: make(map[*client]struct{})}
+}
+
+func (b *eventBus) subscribe(c *client) {
+	b.mu.Lock()
+	b.subs[c] = struct{}{}
+	b.n.Store(int32(len(b.subs)))
+	b.mu.Unlock()
+}
+
+func (b *eventBus) unsubscribe(c *client) {
+	b.mu.Lock()
+	delete(b.subs, c)
+	b.n.Store(int32(len(b.subs)))
+	b.mu.Unlock()
+}
+
+// Queue ev for every subscriber. A subscriber that can't keep up misses
+// events rather than slow down the connection that caused them. b may be
+// nil (sessions built outside a handler).
+func (b *eventBus) publish(ev serverEvent) {
+	if b == nil || b.n.Load() == 0 {
+		return
+	}
+	ev.Type, ev.Time = "event", time.Now().UTC().Format(serverTimeFormat)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := newFanout(ev)
+	for c := range b.subs {
+		out.trySend(c)
+	}
+}
+
+// Milliseconds, as the event stream reports durations
+func durationMS(d time.Duration) *float64 {
+	ms := float64(d) / float64(time.Millisecond)
+	return &ms
+}
+
+// AdminStream serves a websocket that streams server events as JSON text
+// frames. It needs "Authorization: Bearer <token>" on the upgrade request
+// (wscat -H). Admin connections get the usual heartbeat but aren't part of
+// the hub or the connection counts, and anything they send is ignored.
+func (h *Handler) AdminStream(token string) http.Handler {
+	return requireToken(token, http.HandlerFunc(h.serveAdminStream))
+}
+
+func (h *Handler) serveAdminStream(w http.ResponseWriter, r *http.Request) {
+	// Browsers can't set the Authorization header on a websocket, so the
+	// token already rules out cross-site pages and any Origin will do
+	remote := clientAddr(r, h.opts.TrustedProxies)
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		h.opts.Logger.Printf("admin stream upgrade error: %v", err)
+		return
+	}
+	defer conn.Close()
+	h.opts.Logger.Printf("admin stream opened from %s", remote)
+
+	conn.SetReadLimit(maxMessageSize)
+	c := newClient(conn, encodingJSON, h.opts)
+	c.session.conn = connInfo{ID: "admin", RemoteAddr: remote, ConnectedAt: time.Now()}
+	c.audit, c.logger = nil, NopLogger{} // the stream isn't client traffic
+	c.closeOnCancel(r.Context())
+	h.startHeartbeat(c, remote, heartbeatServer)
+	c.goWorker(c.writePump)
+
+	h.events.subscribe(c)
+	defer h.events.unsubscribe(c)
+
+	// Reading keeps pongs and the close handshake flowing
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if code, reason, ok := closeForReadError(err); ok {
+				c.closeWith(code, reason)
+			}
+			break
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(h.opts.PongWait))
+		c.seen(h.opts.Clock.Now())
+	}
+	c.closeGracefully()
+	c.Close(websocket.CloseAbnormalClosure, "")
+	c.wait()
+	h.opts.Logger.Printf("admin stream closed from %s", remote)
+}
@@ -0,0 +1,92 @@
+// Filename: internal/ws/events_test.go
+
+package ws
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestAdminStreamEvents(t *testing.T) {
+	h := NewHandler(Options{})
+	mux := http.NewServeMux()
+	mux.Handle("/ws", h)
+	mux.Handle("/ws/admin", h.AdminStream("secret"))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	base := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	if _, resp, err := websocket.DefaultDialer.Dial(base+"/ws/admin", nil); err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("no token: expected 401, got err=%v", err)
+	}
+	admin, _, err := websocket.DefaultDialer.Dial(base+"/ws/admin", http.Header{"Authorization": {"Bearer secret"}})
+	if err != nil {
+		t.Fatalf("dial admin: %v", err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	conn := dial(t, base+"/ws")
+	roundTrip(t, conn, `{"command":"add","a":1,"b":2}`)
+
+	// The admin isn't one of the clients
+	if got := who(t, conn); len(got) != 1 {
+		t.Errorf("who: got %+v", got)
+	}
+	rawRoundTrip(t, conn, `{"command":"nope"}`)
+
+	_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "bye"))
+	conn.Close()
+
+	for _, expected := range []serverEvent{
+		{Event: "open"},
+		{Event: "command", Command: "add"},
+		{Event: "command", Command: "who"},
+		{Event: "command", Command: "nope", Error: ErrCodeUnknownCommand},
+		{Event: "close", CloseCode: websocket.CloseNormalClosure},
+	} {
+		_ = admin.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, msg, err := admin.ReadMessage()
+		if err != nil {
+			t.Fatalf("read %s: %v", expected.Event, err)
+		}
+		var got serverEvent
+		if err := json.Unmarshal(msg, &got); err != nil {
+			t.Fatalf("unmarshal %s: %v", msg, err)
+		}
+		if got.Type != "event" || got.Event != expected.Event || got.Command != expected.Command ||
+			got.CloseCode != expected.CloseCode || got.ConnID == "" || got.Error != expected.Error {
+			t.Errorf("got %s expected %+v", msg, expected)
+		}
+		if got.Event == "command" && got.DurationMS == nil {
+			t.Errorf("%s: no duration", msg)
+		}
+	}
+}
+
+func TestEventBusDropsWhenFull(t *testing.T) {
+	b := newEventBus()
+	slow := &client{session: newSession(defaultHistorySize), encoding: encodingJSON, send: make(chan outbound, 1)}
+	b.subscribe(slow)
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 3; i++ {
+			b.publish(serverEvent{Event: "open"})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publish blocked on a full subscriber")
+	}
+	if len(slow.send) != 1 {
+		t.Errorf("queued %d events expected 1", len(slow.send))
+	}
+}
@@ -0,0 +1,154 @@
+package ws
+
+// Filename: internal/ws/grapheme.go
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// Length in bytes of the grapheme cluster s starts with: a user-perceived
+// character such as "é" written as e plus U+0301, "👍🏽", "👨‍👩‍👧", "🇯🇵",
+// "क्षि" or a Hangul syllable spelled in conjoining jamo. This follows the
+// UAX #29 rules that matter for text people type: marks and other
+// extenders stay with what they follow (GB9, GB9a), emoji ZWJ sequences
+// hold together (GB11), regional indicators pair up into flags (GB12,
+// GB13), jamo make syllables (GB6-GB8) and a virama joins the consonants
+// either side of it in the Indic scripts (GB9c). Prepended marks and the
+// CR LF pair aren't treated specially. s is valid UTF-8 and not empty.
+func graphemeLen(s []byte) int {
+	// Plain ASCII is one byte per cluster; only a mark or joiner after it
+	// (never ASCII itself) can make it longer
+	if len(s) == 1 || s[0] < utf8.RuneSelf && s[1] < utf8.RuneSelf {
+		return 1
+	}
+
+	prev, size := utf8.DecodeRune(s)
+	base := prev                  // the last rune that wasn't an extender
+	emoji := isPictographic(prev) // a ZWJ may join another pictograph
+	flags := 0                    // regional indicators so far
+	if isRegionalIndicator(prev) {
+		flags = 1
+	}
+	linked := false // a virama has followed a letter
+	for size < len(s) {
+		next, n := utf8.DecodeRune(s[size:])
+		switch {
+		case isGraphemeExtend(next):
+			if isVirama(next) && isIndicLetter(base) {
+				linked = true
+			}
+		case prev == zwj && emoji && isPictographic(next):
+		case flags == 1 && isRegionalIndicator(next):
+			flags = 2
+		case hangulJoins(prev, next):
+		case linked && isIndicLetter(next):
+			linked = false
+		default:
+			return size
+		}
+		if !isGraphemeExtend(next) {
+			base = next
+		}
+		prev = next
+		size += n
+	}
+	return size
+}
+
+// The zero width joiner that glues emoji into one sequence
+const zwj = '\u200d'
+
+// Characters that extend whatever comes before them: combining and spacing
+// marks (variation selectors among them), the ZWJ, skin-tone modifiers
+// and the tag characters of subdivision flags
+func isGraphemeExtend(r rune) bool {
+	switch {
+	case r < 0x0300:
+		return false
+	case r == zwj, 0x1F3FB <= r && r <= 0x1F3FF, 0xE0020 <= r && r <= 0xE007F:
+		return true
+	}
+	return unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc)
+}
+
+func isRegionalIndicator(r rune) bool {
+	return 0x1F1E6 <= r && r <= 0x1F1FF
+}
+
+// Close enough to Extended_Pictographic for the emoji people send: the
+// symbol and pictograph blocks, plus the older symbols that have emoji
+// forms
+func isPictographic(r rune) bool {
+	switch {
+	case r < 0x00A9:
+		return false
+	case isRegionalIndicator(r), 0x1F3FB <= r && r <= 0x1F3FF:
+		return false
+	case 0x1F000 <= r && r <= 0x1FAFF, 0x2600 <= r && r <= 0x27BF, 0x2B00 <= r && r <= 0x2BFF:
+		return true
+	case 0x2190 <= r && r <= 0x21FF, 0x2300 <= r && r <= 0x23FF, 0x25A0 <= r && r <= 0x25FF:
+		return true
+	}
+	switch r {
+	case 0x00A9, 0x00AE, 0x203C, 0x2049, 0x2122, 0x2139, 0x2934, 0x2935, 0x3030, 0x303D, 0x3297, 0x3299:
+		return true
+	}
+	return false
+}
+
+// The viramas of the Indic scripts whose conjuncts UAX #29 keeps whole:
+// Devanagari, Bengali, Gujarati, Oriya, Telugu and Malayalam
+func isVirama(r rune) bool {
+	switch r {
+	case 0x094D, 0x09CD, 0x0ACD, 0x0B4D, 0x0C4D, 0x0D4D:
+		return true
+	}
+	return false
+}
+
+// A letter of one of the scripts isVirama covers
+func isIndicLetter(r rune) bool {
+	return 0x0900 <= r && r <= 0x0DFF && unicode.Is(unicode.Lo, r)
+}
+
+// Hangul syllable types, from the conjoining jamo and precomposed blocks
+const (
+	hangulNone = iota
+	hangulL    // leading consonant
+	hangulV    // vowel
+	hangulT    // trailing consonant
+	hangulLV   // precomposed syllable without a trailing consonant
+	hangulLVT  // precomposed syllable with one
+)
+
+func hangulType(r rune) int {
+	switch {
+	case 0x1100 <= r && r <= 0x115F, 0xA960 <= r && r <= 0xA97C:
+		return hangulL
+	case 0x1160 <= r && r <= 0x11A7, 0xD7B0 <= r && r <= 0xD7C6:
+		return hangulV
+	case 0x11A8 <= r && r <= 0x11FF, 0xD7CB <= r && r <= 0xD7FB:
+		return hangulT
+	case 0xAC00 <= r && r <= 0xD7A3:
+		if (r-0xAC00)%28 == 0 {
+			return hangulLV
+		}
+		return hangulLVT
+	}
+	return hangulNone
+}
+
+// GB6-GB8: L × (L | V | LV | LVT), (LV | V) × (V | T), (LVT | T) × T
+func hangulJoins(prev, next rune) bool {
+	p, n := hangulType(prev), hangulType(next)
+	switch p {
+	case hangulL:
+		return n == hangulL || n == hangulV || n == hangulLV || n == hangulLVT
+	case hangulLV, hangulV:
+		return n == hangulV || n == hangulT
+	case hangulLVT, hangulT:
+		return n == hangulT
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+// Filename: internal/ws/grapheme_test.go
+
+package ws
+
+import (
+	"testing"
+)
+
+func TestReverseGraphemes(t *testing.T) {
+	tests := []struct {
+		name, in, expected string
+	}{
+		{"ascii", "hello, world!", "!dlrow ,olleh"},
+		{"ascii crlf", "a\r\nb", "b\n\ra"},
+		{"precomposed accent", "café", "éfac"},
+		{"combining accent", "cafe\u0301", "e\u0301fac"},
+		{"stacked marks", "a\u0323\u0302b", "ba\u0323\u0302"},
+		{"flags", "🇯🇵🇺🇸", "🇺🇸🇯🇵"},
+		{"odd regional indicator", "🇯🇵🇺", "🇺🇯🇵"},
+		{"subdivision flag", "x\U0001F3F4\U000E0067\U000E0062\U000E0073\U000E0063\U000E0074\U000E007Fy", "y\U0001F3F4\U000E0067\U000E0062\U000E0073\U000E0063\U000E0074\U000E007Fx"},
+		{"family", "a👨\u200d👩\u200d👧b", "b👨\u200d👩\u200d👧a"},
+		{"skin tone", "👍🏽!", "!👍🏽"},
+		{"skin tone in a zwj sequence", "👩🏽\u200d💻x", "x👩🏽\u200d💻"},
+		{"variation selector", "\u263a\ufe0f:", ":\u263a\ufe0f"},
+		{"zwj after text stays put", "a\u200d👍", "👍a\u200d"},
+		{"devanagari vowel signs", "हिंदी", "दीहिं"},
+		{"devanagari conjunct", "नमस्ते", "स्तेमन"},
+		{"hangul syllables", "한국어", "어국한"},
+		{"hangul jamo", "\u1112\u1161\u11ab\u1100\u116e\u11a8", "\u1100\u116e\u11a8\u1112\u1161\u11ab"},
+		{"invalid utf-8", "a\xffb\xc3", "\ufffdb\ufffda"},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(appendReversed(nil, []byte(tt.in))); got != tt.expected {
+				t.Errorf("reverse %q: got %q expected %q", tt.in, got, tt.expected)
+			}
+		})
+	}
+}
+
+// Reversing into a buffer with room allocates nothing, whatever the script
+func TestReverseDoesNotAllocate(t *testing.T) {
+	for _, s := range []string{"hello, this is a reversed echo", "héllo wörld 👨\u200d👩\u200d👧 🇯🇵 नमस्ते 한국어"} {
+		src, buf := []byte(s), make([]byte, 0, 256)
+		if n := testing.AllocsPerRun(100, func() { appendReversed(buf, src) }); n != 0 {
+			t.Errorf("%q: %v allocations per reverse, expected 0", s, n)
+		}
+	}
+}
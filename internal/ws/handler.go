@@ -1,15 +1,28 @@
-
 package ws
 
 // Filename: internal/ws/handler.go
 
 import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
 	"log"
+	"math/rand/v2"
+	"net"
 	"net/http"
+	"net/netip"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Heartbeat and timeout settings
@@ -19,16 +32,668 @@ const (
 	pingPeriod = (pongWait * 9) / 10 // send pings at ~90% of pongWait (e.g., 27s)
 )
 
+// Largest message a client may send, in bytes
+const maxMessageSize = 1024 * 4
+
+// Defaults used when an Options field is left at its zero value
+const (
+	defaultMaxBatchSize     = 100 // max commands in one JSON array frame
+	defaultMaxLinesPerFrame = 100 // max commands in one NDJSON frame
+)
+
+// Counters a Handler keeps across all of its connections
+type handlerStats struct {
+	messages atomic.Uint64 // data messages received
+	open     atomic.Int64  // connections between upgrade and close
+	blocked  atomic.Uint64 // upgrades refused by AllowIPs, DenyIPs or a ban
+	bytesIn  atomic.Uint64 // data frame payload bytes read...
+	bytesOut atomic.Uint64 // ...and written
+	readEnds [numReadEnds]atomic.Uint64
+	usage    usageStats // runs and timings per command
+
+	closeMu    sync.Mutex
+	closeCodes map[int]uint64 // close codes clients have sent
+}
+
+func newHandlerStats() *handlerStats {
+	return &handlerStats{
+		usage:      usageStats{commands: make(map[string]*commandUsage)},
+		closeCodes: make(map[int]uint64),
+	}
+}
+
+// Options configures a websocket handler built with NewHandler
+type Options struct {
+	// MaxBatchSize caps how many commands a single JSON array frame may carry
+	MaxBatchSize int
+
+	// MaxLinesPerFrame caps how many commands a single NDJSON frame may carry
+	MaxLinesPerFrame int
+
+	// Limits every JSON command frame is held to before it is decoded: how
+	// deeply objects and arrays may nest, how long a number or a string may
+	// be in bytes, and how many tokens the frame may hold. A frame over any
+	// of them gets an error of its own and counts as a violation.
+	MaxJSONDepth        int
+	MaxJSONNumberLength int
+	MaxJSONStringLength int
+	MaxJSONTokens       int
+
+	// MaxJSONViolations is how many violations of those limits a
+	// connection may commit before it is closed with 1008
+	MaxJSONViolations int
+
+	// JSONViolationBan, when set, bans the address of a connection closed
+	// for MaxJSONViolations for this long, as POST /admin/bans would
+	JSONViolationBan time.Duration
+
+	// MaxCountRange caps how many frames one "count" stream may produce
+	MaxCountRange int
+
+	// MinCountInterval is the shortest interval a "count" stream may ask for
+	MinCountInterval time.Duration
+
+	// MaxDelay is the longest a "delay" command may wait
+	MaxDelay time.Duration
+
+	// MaxPendingDelays caps how many "delay" commands one connection may
+	// have waiting at once
+	MaxPendingDelays int
+
+	// Timing adds duration_us, the time spent running the command, to
+	// every command reply; without it only requests with "timing":true get it
+	Timing bool
+
+	// Registry holds the commands this handler can run; nil means DefaultRegistry
+	Registry *CommandRegistry
+
+	// HistorySize is how many recent frames each connection keeps for "history"
+	HistorySize int
+
+	// WhoRemoteAddrs adds each connection's remote address to what the
+	// "who" command lists. Off by default: clients see each other's
+	// nicknames, not where they connect from. The admin API always lists
+	// addresses.
+	WhoRemoteAddrs bool
+
+	// Chaos, when set, injects faults into some connections for testing
+	// clients against; see ChaosConfig. Nil, the default, injects none.
+	Chaos *ChaosConfig
+
+	// DrainNotices are the times left at which DrainIn repeats its
+	// shutdown notice; nil means 30s and 10s
+	DrainNotices []time.Duration
+
+	// DebugDumpBytes is how much of each frame is logged for a connection
+	// in debug mode; see the "debug" command
+	DebugDumpBytes int
+
+	// RejectBinary closes connections that send binary frames with 1003
+	// (unsupported data) instead of echoing them
+	RejectBinary bool
+
+	// ProtobufBinary treats binary frames as protobuf-encoded commands
+	// (see internal/ws/pb) instead of echoing them
+	ProtobufBinary bool
+
+	// Compression negotiates permessage-deflate with clients that offer it
+	Compression bool
+
+	// CompressionLevel is the flate level for outbound frames (-2 to 9);
+	// 0 means flate.BestSpeed
+	CompressionLevel int
+
+	// CompressionThreshold is the smallest outbound frame, in bytes, worth compressing
+	CompressionThreshold int
+
+	// PongWait is how long a connection may go without a pong, or any
+	// other frame, before it is dropped; 30s by default. PingPeriod is how
+	// often the server pings each client, and must be shorter. A client
+	// that connects with ?heartbeat=client isn't pinged at all, and has to
+	// send its own pings or data within PongWait.
+	PongWait   time.Duration
+	PingPeriod time.Duration
+
+	// SlowRTT is the ping round trip above which a warning is logged
+	SlowRTT time.Duration
+
+	// IdleTimeout closes a connection with 1000 after this long without a
+	// data frame from the client, however well it answers pings. App-level
+	// PINGs don't count as data either. 0 means never.
+	IdleTimeout time.Duration
+
+	// Ticks broadcasts a {"type":"tick"} frame to every connection each
+	// TickInterval; connections can opt out with the "ticks" command
+	Ticks        bool
+	TickInterval time.Duration
+
+	// MaxBroadcastBytes caps the text of one "broadcast". Each connection
+	// may broadcast at most BroadcastLimit times per BroadcastWindow, on top
+	// of any other limits.
+	MaxBroadcastBytes int
+	BroadcastLimit    int
+	BroadcastWindow   time.Duration
+
+	// ConnectWebhook and DisconnectWebhook are URLs POSTed a JSON
+	// description of each connection as it opens and closes (they may be
+	// the same; the body's "event" tells them apart). Calls are made by
+	// WebhookWorkers goroutines, each attempt limited to WebhookTimeout.
+	// With WebhookSecret set, bodies are signed with HMAC-SHA256 in the
+	// X-Webhook-Signature header.
+	ConnectWebhook    string
+	DisconnectWebhook string
+	WebhookSecret     string
+	WebhookWorkers    int
+	WebhookTimeout    time.Duration
+
+	// RedisAddr, when set, relays broadcast and presence frames between
+	// every instance subscribed to RedisChannel at that Redis server
+	RedisAddr    string
+	RedisChannel string
+
+	// IdempotencyKeys is how many idempotency_keys each connection
+	// remembers the response to, each for IdempotencyTTL
+	IdempotencyKeys int
+	IdempotencyTTL  time.Duration
+
+	// AckWindow is how long the recipients of a broadcast or dm sent with
+	// "ack":true get to ack it before the sender's ack_report goes out
+	AckWindow time.Duration
+
+	// MaxSSEClients caps the /events streams open at once; see SSEHandler
+	MaxSSEClients int
+
+	// ResumeWindow is how long a closed session can be resumed with the
+	// token from its welcome frame; at most MaxResumable are held at once
+	ResumeWindow time.Duration
+	MaxResumable int
+
+	// ReplayBuffer broadcasts, none older than ReplayAge, are kept for
+	// clients resuming with "last_bseq"; see joinAfter
+	ReplayBuffer int
+	ReplayAge    time.Duration
+
+	// MetadataKeys lists the /ws query parameters, besides those it reads
+	// itself, kept as connection metadata; other keys are ignored, or
+	// refuse the upgrade with 400 under StrictQuery. See parseConnQuery.
+	MetadataKeys []string
+	StrictQuery  bool
+
+	// Audit, when set, records every data frame in and out; see OpenAuditLog.
+	// The caller owns it and closes it after the server stops.
+	Audit *AuditLog
+
+	// MessageLogger is told about every frame and every connection opening
+	// and closing; nil means NopLogger. FileLogger is the shipped sink.
+	MessageLogger MessageLogger
+
+	// CounterFile, when set, keeps the global message number across
+	// restarts: it is read at startup and written every CounterSaveInterval
+	// and on Close
+	CounterFile         string
+	CounterSaveInterval time.Duration
+
+	// UsageLogInterval, when set, logs a one-line summary of per-command
+	// counts and average durations this often; 0 logs nothing
+	UsageLogInterval time.Duration
+
+	// SlowConsumerGrace is how long a connection's outbound queue may stay
+	// full before SlowConsumerPolicy applies: SlowConsumerDisconnect (the
+	// default) closes it with 1008, SlowConsumerDropOldest discards its
+	// oldest queued frames to make room. A write that misses its deadline
+	// always disconnects.
+	SlowConsumerGrace  time.Duration
+	SlowConsumerPolicy string
+
+	// MessageQuota caps the data frames one connection may send; the one
+	// that reaches it is answered, then the connection is closed with
+	// 1008. Pings, app-level or not, don't count. 0 means no quota.
+	MessageQuota int
+
+	// BandwidthLimit caps the data frame bytes one connection may send
+	// in any minute, for origins whose policy sets no BytesPerMinute. The
+	// frame that goes over is refused with a "bandwidth" warning; another
+	// while still over closes the connection with 1008. 0 means no limit.
+	BandwidthLimit int64
+
+	// UploadDir, when set, enables the "upload" command: files sent in
+	// binary chunks are written here, none larger than MaxUploadSize bytes
+	UploadDir     string
+	MaxUploadSize int64
+
+	// TrustedProxies lists the proxies whose X-Forwarded-For and X-Real-IP
+	// headers are believed. A connection from anywhere else is identified
+	// by its own address, whatever headers it sends.
+	TrustedProxies []netip.Prefix
+
+	// AllowIPs and DenyIPs screen the client address before the upgrade.
+	// A denied address gets 403 even if it is also allowed; an empty
+	// AllowIPs allows everyone not denied.
+	AllowIPs []netip.Prefix
+	DenyIPs  []netip.Prefix
+
+	// BanFile, when set, keeps the bans made through the admin API across
+	// restarts; see AdminHandler
+	BanFile string
+
+	// AuthToken, when set, must come with every upgrade, as
+	// "Authorization: Bearer <token>" or, for browsers, which can't set
+	// headers on one, ?token=. Upgrades without it get 401.
+	AuthToken string
+
+	// Tenant names the tenant this handler serves in a Tenants; the admin
+	// listing and stats report it. "" for a handler on its own.
+	Tenant string
+
+	// MaxMessageSize is the read limit for origins without a policy that
+	// sets one. A connection may raise its own, up to MaxReadLimit, with
+	// "set_limit".
+	MaxMessageSize int64
+	MaxReadLimit   int64
+
+	// StreamThreshold is the length past which a text message is handled
+	// as it is read rather than read whole first, so a connection that has
+	// raised its read limit to megabytes doesn't cost megabytes of memory
+	// per message: echoes (UPPER: included) are written out window by
+	// window, and commands go through a streaming decoder. See
+	// handleTextStream for what still has to be held whole. Set it at or
+	// above MaxReadLimit to read every message whole.
+	StreamThreshold int64
+
+	// AllowedOrigins lists the page origins that may connect; nil means
+	// http://localhost:4000 only
+	AllowedOrigins []string
+
+	// OriginPolicies sets the read limit, message rate, connection cap and
+	// binary handling for the origins matching each pattern; see
+	// OriginPolicy. An origin with a policy may connect even if it isn't in
+	// AllowedOrigins. Other origins get the defaults.
+	OriginPolicies map[string]OriginPolicy
+
+	// ConfigFile names a JSON OriginConfig file. When set, NewHandler reads
+	// it in place of AllowedOrigins and OriginPolicies (keeping those if it
+	// can't), and Reload reads it again.
+	ConfigFile string
+
+	// HandshakeTimeout bounds the opening handshake. The upgrader uses it
+	// for the response; ConfigureServer applies it to reading the request.
+	HandshakeTimeout time.Duration
+
+	// ReadBufferSize and WriteBufferSize size each connection's I/O buffers, in bytes
+	ReadBufferSize  int
+	WriteBufferSize int
+
+	// CheckOrigin decides which upgrade requests may connect; nil checks
+	// the Origin header against AllowedOrigins and OriginPolicies
+	CheckOrigin func(r *http.Request) bool
+
+	// TracerProvider, when set, traces each upgrade, connection and command
+	// with OpenTelemetry; nil turns tracing off
+	TracerProvider trace.TracerProvider
+
+	// Propagator reads the trace context a client sent with the upgrade
+	// request; nil means W3C traceparent and baggage
+	Propagator propagation.TextMapPropagator
+
+	// Clock times the heartbeat, read deadlines and IdleTimeout, and is what
+	// the time command reports; nil means the wall clock. Write deadlines
+	// always use the wall clock.
+	Clock Clock
+
+	// Logger receives the server's operational log lines; nil means log.Default()
+	Logger *log.Logger
+
+	// RandSource feeds randint, randfloat and Chaos; nil means a randomly
+	// seeded source. A fixed one makes them repeatable. uuid ignores it.
+	RandSource rand.Source
+}
+
+// DefaultOptions returns the settings HandleWebSocket uses
+func DefaultOptions() Options {
+	return Options{
+		MaxBatchSize:     defaultMaxBatchSize,
+		MaxLinesPerFrame: defaultMaxLinesPerFrame,
+
+		MaxJSONDepth:        defaultMaxJSONDepth,
+		MaxJSONNumberLength: defaultMaxJSONNumberLength,
+		MaxJSONStringLength: defaultMaxJSONStringLength,
+		MaxJSONTokens:       defaultMaxJSONTokens,
+		MaxJSONViolations:   defaultMaxJSONViolations,
+
+		MaxCountRange:    defaultMaxCountRange,
+		MinCountInterval: defaultMinCountInterval,
+		MaxDelay:         defaultMaxDelay,
+		MaxPendingDelays: defaultMaxPendingDelays,
+		Registry:         DefaultRegistry,
+		HistorySize:      defaultHistorySize,
+		DebugDumpBytes:   defaultDebugDumpBytes,
+		DrainNotices:     defaultDrainNotices,
+
+		CompressionLevel:     defaultCompressionLevel,
+		CompressionThreshold: defaultCompressionThreshold,
+
+		AllowedOrigins: append([]string(nil), allowedOrigins...),
+
+		TickInterval: defaultTickInterval,
+
+		MaxBroadcastBytes: defaultMaxBroadcastBytes,
+		BroadcastLimit:    defaultBroadcastLimit,
+		BroadcastWindow:   defaultBroadcastWindow,
+
+		RedisChannel: defaultRedisChannel,
+
+		IdempotencyKeys: defaultIdempotencyKeys,
+		IdempotencyTTL:  defaultIdempotencyTTL,
+
+		AckWindow:     defaultAckWindow,
+		MaxSSEClients: defaultMaxSSEClients,
+
+		ResumeWindow: defaultResumeWindow,
+		MaxResumable: defaultMaxResumable,
+		ReplayBuffer: defaultReplayBuffer,
+		ReplayAge:    defaultReplayAge,
+
+		MessageLogger: NopLogger{},
+
+		MaxUploadSize: defaultMaxUploadSize,
+
+		MaxMessageSize: maxMessageSize,
+		MaxReadLimit:   defaultMaxReadLimit,
+
+		StreamThreshold: defaultStreamThreshold,
+
+		CounterSaveInterval: defaultCounterSaveInterval,
+
+		WebhookWorkers: defaultWebhookWorkers,
+		WebhookTimeout: defaultWebhookTimeout,
+
+		SlowConsumerGrace:  defaultSlowConsumerGrace,
+		SlowConsumerPolicy: SlowConsumerDisconnect,
+
+		PongWait:   pongWait,
+		PingPeriod: pingPeriod,
+		SlowRTT:    defaultSlowRTT,
+
+		HandshakeTimeout: defaultHandshakeTimeout,
+		ReadBufferSize:   defaultBufferSize,
+		WriteBufferSize:  defaultBufferSize,
+
+		Clock:      realClock{},
+		Logger:     log.Default(),
+		Propagator: defaultPropagator(),
+		RandSource: globalSource{},
+	}
+}
+
+// Fill in defaults for anything left unset
+func (o Options) withDefaults() Options {
+	d := DefaultOptions()
+	if o.MaxBatchSize <= 0 {
+		o.MaxBatchSize = d.MaxBatchSize
+	}
+	if o.MaxLinesPerFrame <= 0 {
+		o.MaxLinesPerFrame = d.MaxLinesPerFrame
+	}
+	if o.MaxJSONDepth <= 0 {
+		o.MaxJSONDepth = d.MaxJSONDepth
+	}
+	if o.MaxJSONNumberLength <= 0 {
+		o.MaxJSONNumberLength = d.MaxJSONNumberLength
+	}
+	if o.MaxJSONStringLength <= 0 {
+		o.MaxJSONStringLength = d.MaxJSONStringLength
+	}
+	if o.MaxJSONTokens <= 0 {
+		o.MaxJSONTokens = d.MaxJSONTokens
+	}
+	if o.MaxJSONViolations <= 0 {
+		o.MaxJSONViolations = d.MaxJSONViolations
+	}
+	if o.MaxCountRange <= 0 {
+		o.MaxCountRange = d.MaxCountRange
+	}
+	if o.MinCountInterval <= 0 {
+		o.MinCountInterval = d.MinCountInterval
+	}
+	if o.MaxDelay <= 0 {
+		o.MaxDelay = d.MaxDelay
+	}
+	if o.MaxPendingDelays <= 0 {
+		o.MaxPendingDelays = d.MaxPendingDelays
+	}
+	if o.Registry == nil {
+		o.Registry = d.Registry
+	}
+	if o.HistorySize <= 0 {
+		o.HistorySize = d.HistorySize
+	}
+	if o.DrainNotices == nil {
+		o.DrainNotices = d.DrainNotices
+	}
+	if o.DebugDumpBytes <= 0 {
+		o.DebugDumpBytes = d.DebugDumpBytes
+	}
+	if o.HistorySize > maxHistorySize {
+		o.HistorySize = maxHistorySize
+	}
+	if o.CompressionLevel == 0 || o.CompressionLevel < flate.HuffmanOnly || o.CompressionLevel > flate.BestCompression {
+		o.CompressionLevel = d.CompressionLevel
+	}
+	if o.CompressionThreshold <= 0 {
+		o.CompressionThreshold = d.CompressionThreshold
+	}
+	if o.TickInterval <= 0 {
+		o.TickInterval = d.TickInterval
+	}
+	if o.MaxBroadcastBytes <= 0 {
+		o.MaxBroadcastBytes = d.MaxBroadcastBytes
+	}
+	if o.BroadcastLimit <= 0 {
+		o.BroadcastLimit = d.BroadcastLimit
+	}
+	if o.BroadcastWindow <= 0 {
+		o.BroadcastWindow = d.BroadcastWindow
+	}
+	if o.IdempotencyKeys <= 0 {
+		o.IdempotencyKeys = d.IdempotencyKeys
+	}
+	if o.IdempotencyTTL <= 0 {
+		o.IdempotencyTTL = d.IdempotencyTTL
+	}
+	if o.AckWindow <= 0 {
+		o.AckWindow = d.AckWindow
+	}
+	if o.MaxSSEClients <= 0 {
+		o.MaxSSEClients = d.MaxSSEClients
+	}
+	if o.ResumeWindow <= 0 {
+		o.ResumeWindow = d.ResumeWindow
+	}
+	if o.MaxResumable <= 0 {
+		o.MaxResumable = d.MaxResumable
+	}
+	if o.ReplayBuffer <= 0 {
+		o.ReplayBuffer = d.ReplayBuffer
+	}
+	if o.ReplayAge <= 0 {
+		o.ReplayAge = d.ReplayAge
+	}
+	if o.MessageLogger == nil {
+		o.MessageLogger = d.MessageLogger
+	}
+	if o.MaxUploadSize <= 0 {
+		o.MaxUploadSize = d.MaxUploadSize
+	}
+	if o.MaxMessageSize <= 0 {
+		o.MaxMessageSize = d.MaxMessageSize
+	}
+	if o.MaxReadLimit <= 0 {
+		o.MaxReadLimit = d.MaxReadLimit
+	}
+	if o.StreamThreshold <= 0 {
+		o.StreamThreshold = d.StreamThreshold
+	}
+	if o.CounterSaveInterval <= 0 {
+		o.CounterSaveInterval = d.CounterSaveInterval
+	}
+	if o.RedisChannel == "" {
+		o.RedisChannel = d.RedisChannel
+	}
+	if o.WebhookWorkers <= 0 {
+		o.WebhookWorkers = d.WebhookWorkers
+	}
+	if o.WebhookTimeout <= 0 {
+		o.WebhookTimeout = d.WebhookTimeout
+	}
+	if o.PongWait <= 0 {
+		o.PongWait = d.PongWait
+	}
+	if o.PingPeriod <= 0 || o.PingPeriod >= o.PongWait {
+		o.PingPeriod = o.PongWait * 9 / 10
+	}
+	if o.SlowConsumerGrace <= 0 {
+		o.SlowConsumerGrace = d.SlowConsumerGrace
+	}
+	if o.SlowConsumerPolicy != SlowConsumerDropOldest {
+		o.SlowConsumerPolicy = d.SlowConsumerPolicy
+	}
+	if o.SlowRTT <= 0 {
+		o.SlowRTT = d.SlowRTT
+	}
+	if o.AllowedOrigins == nil {
+		o.AllowedOrigins = d.AllowedOrigins
+	}
+	if o.HandshakeTimeout <= 0 {
+		o.HandshakeTimeout = d.HandshakeTimeout
+	}
+	if o.ReadBufferSize <= 0 {
+		o.ReadBufferSize = d.ReadBufferSize
+	}
+	if o.WriteBufferSize <= 0 {
+		o.WriteBufferSize = d.WriteBufferSize
+	}
+	if o.Clock == nil {
+		o.Clock = d.Clock
+	}
+	if o.Logger == nil {
+		o.Logger = d.Logger
+	}
+	if o.Propagator == nil {
+		o.Propagator = d.Propagator
+	}
+	switch o.RandSource.(type) {
+	case nil:
+		o.RandSource = d.RandSource
+	case globalSource, *lockedSource:
+	default:
+		o.RandSource = &lockedSource{src: o.RandSource}
+	}
+	return o
+}
+
+// Handler serves websocket connections with a fixed set of Options
+type Handler struct {
+	opts         Options
+	upgrader     websocket.Upgrader
+	hub          *hub
+	events       *eventBus
+	stats        *handlerStats
+	tracer       trace.Tracer // no-op without Options.TracerProvider
+	webhooks     *webhooks    // nil without webhook URLs
+	resume       *resumeStore
+	bans         *banList
+	draining     atomic.Bool                  // set by Drain; new upgrades are refused
+	countdown    drainCountdown               // DrainIn's grace period
+	origins      atomic.Pointer[originConfig] // allowlist and policies; see Reload
+	originConns  originCounts                 // open connections per origin policy
+	blocked      blockedLog                   // rate-limits the "blocked connection" lines
+	metadataKeys map[string]bool              // Options.MetadataKeys as a set
+
+	stop       chan struct{} // closed by Close to stop background goroutines
+	stopOnce   sync.Once
+	background sync.WaitGroup
+}
+
+// NewHandler builds a websocket handler; zero-valued fields in opts fall back to defaults.
+// With Options.Ticks set it starts a broadcaster, with RedisAddr a Redis
+// bridge, with webhook URLs a pool of webhook workers, and with CounterFile
+// a goroutine saving the message counter, and with UsageLogInterval a usage
+// logger; all run until Close. With ConfigFile it loads the origin settings
+// from there. It panics if opts fails Validate.
+func NewHandler(opts Options) *Handler {
+	if err := opts.Validate(); err != nil {
+		panic(err)
+	}
+	h := &Handler{opts: opts.withDefaults(), events: newEventBus(), stats: newHandlerStats(), stop: make(chan struct{})}
+	h.hub = newHub(h.opts.Logger)
+	h.hub.acks.window = h.opts.AckWindow
+	h.hub.replay = broadcastRing{max: h.opts.ReplayBuffer, age: h.opts.ReplayAge}
+	h.tracer = newTracer(h.opts)
+	h.opts.Audit.setLogger(h.opts.Logger)
+	h.resume = newResumeStore(h.opts.ResumeWindow, h.opts.MaxResumable)
+	h.bans = newBanList(h.opts.BanFile)
+	if h.opts.BanFile != "" {
+		h.restoreBans()
+	}
+	h.upgrader = h.newUpgrader()
+	h.metadataKeys = make(map[string]bool, len(h.opts.MetadataKeys))
+	for _, key := range h.opts.MetadataKeys {
+		h.metadataKeys[key] = true
+	}
+	h.setOrigins(OriginConfig{AllowedOrigins: h.opts.AllowedOrigins, OriginPolicies: h.opts.OriginPolicies})
+	if h.opts.ConfigFile != "" {
+		_ = h.Reload() // logged; the Options settings stay
+	}
+	if h.opts.Ticks {
+		h.background.Add(1)
+		go func() {
+			defer h.background.Done()
+			h.hub.runTicks(h.opts.TickInterval, h.stop)
+		}()
+	}
+	if h.opts.RedisAddr != "" {
+		h.hub.relay = newRedisBridge(h.opts.RedisAddr, h.opts.RedisChannel, h.hub, h.opts.Logger)
+		h.hub.relay.start(h.stop, &h.background)
+	}
+	if h.webhooks = newWebhooks(h.opts); h.webhooks != nil {
+		h.webhooks.start(h.opts.WebhookWorkers, h.stop, &h.background)
+	}
+	if h.opts.CounterFile != "" {
+		h.restoreMessageCounter()
+		h.persistMessageCounter()
+	}
+	if h.opts.UsageLogInterval > 0 {
+		h.background.Add(1)
+		go func() {
+			defer h.background.Done()
+			h.logUsage()
+		}()
+	}
+	if c := h.opts.Chaos; c != nil {
+		h.opts.Logger.Printf("warning: chaos mode on: faults go to %.0f%% of connections", c.Probability*100)
+	}
+	return h
+}
+
+// Close stops the handler's background goroutines. Open connections are
+// left alone; they end when their clients or the server go away.
+func (h *Handler) Close() {
+	h.stopOnce.Do(func() { close(h.stop) })
+	h.background.Wait()
+}
+
+var defaultHandler = NewHandler(DefaultOptions())
+
 // Only allow pages served from this origin to connect
 var allowedOrigins = []string{
 	"http://localhost:4000",
 }
 
-func originAllowed(o string) bool {
+func originAllowed(allowed []string, o string) bool {
 	if o == "" {
 		return false
 	}
-	for _, a := range allowedOrigins {
+	for _, a := range allowed {
 		if strings.EqualFold(o, a) {
 			return true
 		}
@@ -36,111 +701,506 @@ func originAllowed(o string) bool {
 	return false
 }
 
-// The upgrader object is used when we need to upgrade from HTTP to RFC 6455
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		origin := r.Header.Get("Origin")
-		ok := originAllowed(origin)
-		if !ok {
-			log.Printf("blocked cross-origin websocket: Origin=%q Path=%s", origin, r.URL.Path)
-		}
-		return ok
-	},
-	Error: func(w http.ResponseWriter, r *http.Request, status int, reason error) {
-		http.Error(w, "origin not allowed", http.StatusForbidden)
-	},
+// The upgrader object is used when we need to upgrade from HTTP to RFC 6455.
+// Subprotocols is left unset so selectSubprotocol can honor the client's order.
+// ServeHTTP has checked the origin before it gets here.
+func (h *Handler) newUpgrader() websocket.Upgrader {
+	return websocket.Upgrader{
+		HandshakeTimeout:  h.opts.HandshakeTimeout,
+		ReadBufferSize:    h.opts.ReadBufferSize,
+		WriteBufferSize:   h.opts.WriteBufferSize,
+		CheckOrigin:       func(*http.Request) bool { return true },
+		EnableCompression: h.opts.Compression,
+		Error: func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+			http.Error(w, reason.Error(), status)
+		},
+	}
 }
 
-// Attempt to upgrade from HTTP to RFC 6455
+// Check the request with Options.CheckOrigin, or else the Origin header
+// against origins, the allowlist and policies in force
+func (h *Handler) checkOrigin(r *http.Request, origins *originConfig) bool {
+	origin := r.Header.Get("Origin")
+	var ok bool
+	if h.opts.CheckOrigin != nil {
+		ok = h.opts.CheckOrigin(r)
+	} else {
+		ok = origins.allows(origin)
+	}
+	if !ok {
+		h.opts.Logger.Printf("blocked cross-origin websocket: Origin=%q Path=%s", origin, r.URL.Path)
+	}
+	return ok
+}
+
+// HandleWebSocket serves /ws with DefaultOptions; it behaves exactly like
+// a Handler from NewHandler(DefaultOptions()), which is what callers
+// wanting any other settings should mount instead
 func HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	defaultHandler.ServeHTTP(w, r)
+}
+
+// Attempt to upgrade from HTTP to RFC 6455
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Has to be an HTTP GET request
 	if r.Method != http.MethodGet {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
+	traceCtx, upgrade := h.startUpgrade(r)
+	defer upgrade.End() // a no-op once startConnection has ended it
+
+	if h.Draining() {
+		h.setRetryAfter(w)
+		refuseUpgrade(w, upgrade, "server is draining", http.StatusServiceUnavailable)
+		return
+	}
+
+	if !h.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		refuseUpgrade(w, upgrade, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	remote := clientAddr(r, h.opts.TrustedProxies)
+	if !ipAllowed(remote, h.opts.AllowIPs, h.opts.DenyIPs) || h.bans.banned(remote, h.opts.Clock.Now()) {
+		h.stats.blocked.Add(1)
+		h.blocked.report(h.opts.Logger, remote, h.opts.Clock.Now())
+		refuseUpgrade(w, upgrade, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	encoding, err := requestedEncoding(r)
+	if err != nil {
+		refuseUpgrade(w, upgrade, err.Error(), http.StatusBadRequest)
+		return
+	}
+	version, err := requestedVersion(r)
+	if err != nil {
+		refuseUpgrade(w, upgrade, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query, err := h.parseConnQuery(r)
+	if err != nil {
+		refuseUpgrade(w, upgrade, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if query.name != "" && h.hub.nickTaken(query.name) {
+		refuseUpgrade(w, upgrade, "name already in use", http.StatusConflict)
+		return
+	}
+
+	// Checked here rather than by the upgrader so a refused origin is 403
+	// while other bad handshakes keep their own status
+	// One snapshot for the check and the policy, whatever Reload does meanwhile
+	origins := h.origins.Load()
+	if !h.checkOrigin(r, origins) {
+		refuseUpgrade(w, upgrade, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	policy := origins.policyFor(r.Header.Get("Origin"))
+	if !h.originConns.acquire(policy) {
+		refuseUpgrade(w, upgrade, "too many connections from this origin", http.StatusTooManyRequests)
+		return
+	}
+	defer h.originConns.release(policy)
+
+	var responseHeader http.Header
+	if proto := selectSubprotocol(r); proto != "" {
+		responseHeader = http.Header{"Sec-Websocket-Protocol": {proto}}
+	}
+
 	// Upgrade the connection from HTTP to RFC 6455
-	conn, err := upgrader.Upgrade(w, r, nil)
+	conn, err := h.upgrader.Upgrade(w, r, responseHeader)
 	if err != nil {
-		log.Printf("upgrade error: %v", err)
+		upgrade.SetStatus(codes.Error, err.Error())
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			atomic.AddUint64(&handshakeTimeoutCounter, 1)
+			h.opts.Logger.Printf("handshake timeout from %s: %v", remote, err)
+			return
+		}
+		h.opts.Logger.Printf("upgrade error: %v", err)
 		return
 	}
 	defer conn.Close()
 
-	log.Printf("connection opened from %s", r.RemoteAddr)
+	// The 101 went straight to the hijacked connection, so leave the
+	// subprotocol where middleware such as an access log can find it
+	if proto := conn.Subprotocol(); proto != "" {
+		w.Header().Set("Sec-WebSocket-Protocol", proto)
+	}
 
-	// Limit message size
-	conn.SetReadLimit(1024 * 4)
+	if conn.Subprotocol() == encodingMsgpack {
+		encoding = encodingMsgpack
+	}
 
-	// PING / PONG SETUP
+	extension := ""
+	if h.opts.Compression && offersDeflate(r) {
+		extension = extensionDeflate
+		if err := conn.SetCompressionLevel(h.opts.CompressionLevel); err != nil {
+			h.opts.Logger.Printf("compression level error: %v", err)
+		}
+	}
+	h.opts.Logger.Printf("connection opened from %s (encoding=%s, subprotocol=%q, extension=%q, heartbeat=%s)",
+		remote, encoding, conn.Subprotocol(), extension, query.heartbeat)
 
-	// Idle timeout window starts now: must receive a pong within pongWait
-	_ = conn.SetReadDeadline(time.Now().Add(pongWait))
+	// All data frames go through the client's write pump
+	c := newClient(conn, encoding, h.opts)
 
-	// On each pong, extend the read deadline again
-	conn.SetPongHandler(func(appData string) error {
-		_ = conn.SetReadDeadline(time.Now().Add(pongWait))
-		log.Printf("pong from %s (data=%q)", r.RemoteAddr, appData)
-		return nil
-	})
+	// Limit message size; see readMessage
+	c.session.readLimit.Store(policy.MaxMessageSize)
+	c.policy, c.rate, c.bandwidth = policy, policy.rateLimiter(), policy.bandwidthLimiter()
+	c.session.conn = connInfo{
+		ID:          nextConnID(),
+		Tenant:      h.opts.Tenant,
+		RemoteAddr:  remote,
+		Origin:      r.Header.Get("Origin"),
+		ConnectedAt: time.Now(),
+		Encoding:    encoding,
+		Subprotocol: conn.Subprotocol(),
+		Extension:   extension,
+		Room:        query.room,
+		Metadata:    query.meta,
+	}
+	if query.room != "" || query.meta != nil {
+		h.opts.Logger.Printf("%s room=%q metadata=%q", c.session.conn.ID, query.room, query.meta)
+	}
+	c.session.hub = h.hub
+	c.session.events = h.events
+	c.session.stats = h.stats
+	connSpan := h.startConnection(traceCtx, upgrade, c)
+	atomic.AddInt64(&openConnections, 1)
+	defer atomic.AddInt64(&openConnections, -1)
+	h.stats.open.Add(1)
+	defer h.stats.open.Add(-1)
 
-	// Start a goroutine that sends pings every pingPeriod
-	done := make(chan struct{})
-	ticker := time.NewTicker(pingPeriod)
-	go func() {
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ticker.C:
-				// Send a ping; if this fails, the read loop will notice soon
-				_ = conn.SetWriteDeadline(time.Now().Add(writeWait))
-				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
-					log.Printf("ping write error: %v", err)
-					return
-				}
-				log.Printf("ping → %s", r.RemoteAddr)
-			case <-done:
-				return
-			}
+	// The connection lives no longer than the request: a cancelled
+	// context (the server's BaseContext, say) closes it with 1001
+	c.closeOnCancel(r.Context())
+	if h.opts.Chaos != nil && c.session.rand.Float64() < h.opts.Chaos.Probability {
+		c.setChaos(h.opts.Chaos)
+	}
+	h.startHeartbeat(c, remote, query.heartbeat)
+	if h.opts.IdleTimeout > 0 {
+		c.watchIdle(h.opts.Clock, h.opts.IdleTimeout)
+	}
+	conn.SetCloseHandler(c.handlePeerClose)
+	c.extension = extension
+	c.envelope = c.encoding == encodingJSON && (version == 2 || c.subprotocol == subprotocolEnvelope)
+	if extension == extensionDeflate {
+		c.compressAbove = h.opts.CompressionThreshold
+	}
+	c.logger.LogLifecycle(c.session.conn.ID, "open", remote)
+	c.goWorker(c.writePump)
+	welcome := welcomeFrame{Type: "welcome", ConnID: c.session.conn.ID, Subprotocol: c.subprotocol, Encoding: c.encoding,
+		ResumeToken: newResumeToken(), Room: query.room, Metadata: query.meta, Build: CurrentBuild(), Heartbeat: query.heartbeat}
+	if c.envelope {
+		welcome.Version = 2
+	}
+	var resumed resumeState
+	if token := r.URL.Query().Get("resume"); token != "" {
+		var ok bool
+		if resumed, ok = h.resume.take(token, time.Now()); ok {
+			c.session.restore(resumed)
+			welcome.Resumed = true
+		} else {
+			welcome.ResumeFailed = true
+		}
+	}
+	c.sendEncoded(welcome)
+
+	// Every way out of this function passes the deferred leave exactly once.
+	// A resumed client that says which broadcast it saw last gets the rest.
+	if last, err := strconv.ParseUint(r.URL.Query().Get("last_bseq"), 10, 64); err == nil && welcome.Resumed {
+		h.hub.joinAfter(c, last, resumed.connID)
+	} else {
+		h.hub.join(c)
+	}
+	defer h.hub.leave(c)
+	if resumed.nick != "" {
+		if err := h.hub.rename(c.session, resumed.nick); err != nil {
+			h.opts.Logger.Printf("resume %s: nickname %q no longer available", c.session.conn.ID, resumed.nick)
+		}
+	} else if query.name != "" {
+		if err := h.hub.rename(c.session, query.name); err != nil {
+			h.opts.Logger.Printf("%s: name %q taken while connecting", c.session.conn.ID, query.name)
+		}
+	}
+	h.events.publish(serverEvent{Event: "open", ConnID: c.session.conn.ID, RemoteAddr: remote})
+	h.webhooks.connected(c)
+	defer func() {
+		code, reason := c.finalClose()
+		endConnection(connSpan, code)
+		c.session.abortUpload()
+		h.events.publish(serverEvent{Event: "close", ConnID: c.session.conn.ID, CloseCode: code})
+		h.webhooks.disconnected(c)
+		c.logger.LogLifecycle(c.session.conn.ID, "close", strings.TrimSpace(fmt.Sprintf("%d %s", code, reason)))
+		// Kicked clients don't get to come back as they were
+		if !c.closing.Load() {
+			h.resume.put(welcome.ResumeToken, c.session.snapshot(), time.Now())
 		}
 	}()
 
 	// Read/Echo loop
+	broken := false
 	for {
-		msgType, payload, err := conn.ReadMessage()
+		msgType, payload, text, err := c.readMessage()
+		if err == nil && text != nil && !h.streamable(c, payload) {
+			payload, err = text.whole()
+			text = nil
+		}
 		if err != nil {
-			// This error will be:
-			//  - a timeout (no pong in time), or
-			//  - a normal close, or
-			//  - some other read error
-			log.Printf("read error (timeout/close): %v", err)
-
-			// Try to send a graceful close so the client can see 1000 instead of 1006
-			_ = conn.SetWriteDeadline(time.Now().Add(writeWait))
-			_ = conn.WriteControl(
-				websocket.CloseMessage,
-				websocket.FormatCloseMessage(websocket.CloseNormalClosure, "idle timeout"),
-				time.Now().Add(writeWait),
-			)
+			// A close from the peer, expected or not, a timeout (no pong
+			// in time), an oversized message or a broken connection
+			end := c.readEnd(err)
+			c.logReadEnd(end, err)
+			broken = end == endTransport
+
+			// Tell the client why so it sees a real code instead of 1006,
+			// unless we already have
+			if code, reason, ok := closeForReadError(err); ok {
+				if code == websocket.CloseMessageTooBig {
+					reason = c.session.tooBig()
+				}
+				c.closeWith(code, reason)
+			}
 
 			break
 		}
 
+		// A streamed message's head stands in for it here; the rest is
+		// counted as it is read
+		c.session.countIn(len(payload))
+		debug := c.session.debugging()
+		c.session.markReceived(debug)
+		if debug {
+			c.debugFrame(directionIn, msgType, len(payload), payload)
+		}
+		c.audit.record(c.session, directionIn, msgType, payload)
+		c.logger.LogInbound(c.session.conn.ID, msgType, payload)
+
+		// Kicked: whatever the peer sends while the close is in flight is ignored
+		if c.closing.Load() {
+			continue
+		}
+
+		// Text frames must be UTF-8 (RFC 6455 section 8.1). A streamed one
+		// is checked as it is read, and one refused below is skipped
+		// unread by the next NextReader.
+		if msgType == websocket.TextMessage && text == nil && !utf8.Valid(payload) {
+			c.closeWith(websocket.CloseInvalidFramePayloadData, "invalid UTF-8")
+			break
+		}
+
 		// We successfully read a message; normal traffic also keeps the connection alive.
 		// Note: the pong handler also updates the read deadline on pongs.
+		_ = conn.SetReadDeadline(time.Now().Add(h.opts.PongWait))
+		c.seen(h.opts.Clock.Now())
+
+		// Frames over the byte rate are refused, then the connection closed
+		handle, keepReading := c.enforceBandwidth(h.opts.Clock.Now(), len(payload))
+		if !keepReading {
+			break
+		}
+		if !handle {
+			continue
+		}
+
+		// App-level pings are answered before anything else, on the system
+		// lane so the round trip doesn't include the data queue, and aren't
+		// counted, not even as activity for IdleTimeout
+		if msgType == websocket.TextMessage && c.encoding != encodingMsgpack && text == nil {
+			if token, ok := parseAppPing(payload); ok {
+				pong := outbound{messageType: websocket.TextMessage, data: appPong(h.opts.Clock.Now(), token), kind: kindPong}
+				if !c.queue(pong, laneSystem) {
+					break
+				}
+				continue
+			}
+		}
 
-		// Echo back text messages
-		if msgType == websocket.TextMessage {
-			_ = conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if err := conn.WriteMessage(websocket.TextMessage, payload); err != nil {
-				log.Printf("write error: %v", err)
+		// Frames over the origin's rate are refused without a number
+		if c.rate != nil && !c.rate.allow(h.opts.Clock.Now()) {
+			if !c.sendEncoded(c.session.stamp(errorResponse("", ErrCodeRateLimited,
+				fmt.Sprintf("Too many messages (at most %d per second)", c.policy.MessagesPerSecond)))) {
 				break
 			}
+			continue
+		}
+
+		c.touch(h.opts.Clock.Now())
+		n := h.stats.messages.Add(1)
+		seq := c.session.nextSeq(n)
+		if c.chaosKill(seq) {
+			broken = true
+			break
+		}
+		c.session.trace.frame(len(payload))
+
+		ok := true
+		switch {
+		case text != nil:
+			ok = h.handleTextStream(c, text)
+		case msgType == websocket.BinaryMessage && c.session.uploading():
+			ok = c.handleUploadChunk(payload)
+		case c.encoding == encodingMsgpack && msgType == websocket.BinaryMessage:
+			ok = h.handleMsgpackFrame(c, n, payload)
+		case c.encoding == encodingMsgpack:
+			ok = c.sendEncoded(c.session.stamp(errorResponse("", ErrCodeUnsupportedFrame, "Text frames are not accepted on a msgpack connection")))
+		case msgType == websocket.TextMessage:
+			ok = h.handleTextFrame(c, payload)
+		case msgType == websocket.BinaryMessage:
+			ok = h.handleBinaryFrame(c, remote, n, payload)
+		}
+		if !ok {
+			break
+		}
+		if h.opts.MessageQuota > 0 {
+			c.enforceQuota(seq, h.opts.MessageQuota)
 		}
+		if c.jsonViolations >= h.opts.MaxJSONViolations && !c.closing.Load() {
+			h.abusiveJSON(c)
+		}
+	}
+
+	// Then stop the write pump, the ping goroutine and any streams. Any
+	// close frame has gone by now, so there's none to send, and there's
+	// no answer to wait for on a broken connection.
+	if !broken {
+		c.closeGracefully()
 	}
+	c.Close(websocket.CloseAbnormalClosure, "")
+	c.wait()
+
+	h.opts.Logger.Printf("connection closed from %s (%s)", remote, c.session.label())
+}
 
-	// Stop the ping goroutine
-	close(done)
+// Ping c every PingPeriod, stamped with the send time, and on each pong
+// extend the read deadline and time the round trip. A ping from the client
+// extends it too, and is answered with a pong. In heartbeatClient mode
+// there are no pings from us, and the client's own pings and data frames
+// are all that keep it alive. Stops with c; a ping that can't be written
+// closes c, so the read loop ends at once instead of at the read deadline.
+func (h *Handler) startHeartbeat(c *client, remote, mode string) {
+	// Idle timeout window starts now: must receive a pong within PongWait.
+	// Deadlines are on the wall clock; watchPongs enforces the same wait
+	// on Options.Clock.
+	_ = c.conn.SetReadDeadline(time.Now().Add(h.opts.PongWait))
+	c.watchPongs(h.opts.Clock, h.opts.PongWait)
 
-	log.Printf("connection closed from %s", r.RemoteAddr)
+	// On each pong, extend the read deadline again and time the round trip
+	c.conn.SetPongHandler(func(appData string) error {
+		if c.closing.Load() || c.closeSent.Load() {
+			return nil // a closing client's deadline stays put
+		}
+		now := h.opts.Clock.Now()
+		_ = c.conn.SetReadDeadline(time.Now().Add(h.opts.PongWait))
+		c.seen(now)
+		rtt, ok := pongRTT(appData, now)
+		if !ok {
+			h.opts.Logger.Printf("pong from %s (data=%q)", remote, appData)
+			return nil
+		}
+		c.session.rtt.add(rtt)
+		if rtt > h.opts.SlowRTT {
+			h.opts.Logger.Printf("slow pong from %s: rtt %s (threshold %s)", remote, rtt, h.opts.SlowRTT)
+		} else {
+			h.opts.Logger.Printf("pong from %s (rtt %s)", remote, rtt)
+		}
+		return nil
+	})
+
+	// Clients that keep themselves alive with their own pings. gorilla has
+	// already refused a payload over 125 bytes with 1002; any other is
+	// echoed as it came.
+	c.conn.SetPingHandler(func(appData string) error {
+		c.session.pings.Add(1)
+		c.session.pingBytes.Add(uint64(len(appData)))
+		h.opts.Logger.Printf("ping from %s (%d bytes)", remote, len(appData))
+		if !c.closing.Load() && !c.closeSent.Load() {
+			_ = c.conn.SetReadDeadline(time.Now().Add(h.opts.PongWait))
+			c.seen(h.opts.Clock.Now())
+		}
+		err := c.conn.WriteControl(websocket.PongMessage, []byte(appData), time.Now().Add(writeWait))
+		var netErr net.Error
+		if err == websocket.ErrCloseSent || errors.As(err, &netErr) {
+			return nil // the read loop finds out for itself
+		}
+		return err
+	})
+
+	if mode == heartbeatClient {
+		return
+	}
+	ticker := h.opts.Clock.NewTicker(h.opts.PingPeriod)
+	c.goWorker(func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C():
+				if c.chaosSkipPing() {
+					continue
+				}
+				ping := pingPayload(h.opts.Clock.Now())
+				if err := c.conn.WriteControl(websocket.PingMessage, ping, time.Now().Add(writeWait)); err != nil {
+					h.opts.Logger.Printf("ping write error: %v", err)
+					c.Close(websocket.CloseAbnormalClosure, "")
+					return
+				}
+				h.opts.Logger.Printf("ping → %s", remote)
+			case <-c.ctx.Done():
+				return
+			}
+		}
+	})
+}
+
+// Echo back text messages; JSON objects/arrays are run as commands, and so
+// is plain text such as "add 2 3" (see parsePlainCommand). echo.v1
+// connections only ever echo (bar "NICK:") and commands.v1 connections only
+// ever run JSON commands. Returns false once the connection is closing.
+func (h *Handler) handleTextFrame(c *client, payload []byte) bool {
+	c.session.history.record(directionIn, payload)
+
+	var reply []byte
+	var err error
+	kind := ""
+	var req CommandRequest
+	plain := false
+	if c.subprotocol != subprotocolEcho && c.subprotocol != subprotocolCommands {
+		req, plain = parsePlainCommand(h.opts.Registry, payload)
+	}
+	switch {
+	case c.subprotocol != subprotocolEcho && isCommandPayload(payload):
+		reply, err = h.handleCommandPayload(c, payload)
+	case c.subprotocol != subprotocolCommands && bytes.HasPrefix(payload, []byte(nickTextPrefix)):
+		// Same as the nick command, so the reply is JSON like HELP's
+		name := string(payload[len(nickTextPrefix):])
+		reply, err = marshalResponse(processCommand(h.opts.Registry, c.session, CommandRequest{Command: "nick", Name: name}))
+	case c.subprotocol != subprotocolCommands && debugToggle(payload) != nil:
+		// Same as the debug command, as NICK: is the nick command
+		reply, err = marshalResponse(processCommand(h.opts.Registry, c.session, CommandRequest{Command: "debug", Enabled: debugToggle(payload)}))
+	case c.subprotocol == subprotocolCommands:
+		reply, err = marshalResponse(invalidJSON(c.session, "Invalid JSON: commands.v1 expects a JSON command"))
+	case plain:
+		reply, err = plainReply(payload, processCommand(h.opts.Registry, c.session, req))
+		kind = kindPlain
+	case c.envelope && !bytes.Equal(payload, []byte(helpText)):
+		// The envelope carries seq, so the echo is the text alone
+		reply, kind = appendText(nil, payload), kindEcho
+	default:
+		reply, err = handleText(h.opts.Registry, c.session, payload)
+	}
+	if err != nil {
+		c.log.Printf("encode reply: %v", err)
+		c.closeWith(websocket.CloseInternalServerErr, "internal error")
+		return false
+	}
+	if reply == nil {
+		return true
+	}
+	c.session.history.record(directionOut, reply)
+	return c.queue(outbound{messageType: websocket.TextMessage, data: reply, kind: kind}, laneData)
 }
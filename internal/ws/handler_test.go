@@ -0,0 +1,209 @@
+// Filename: internal/ws/handler_test.go
+
+package ws
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Start h under httptest and return a ws:// URL for it
+func startServer(t *testing.T, h http.Handler) string {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+// Dial url with an allowed Origin header
+func dial(t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+	return dialWith(t, websocket.DefaultDialer, url)
+}
+
+// Dial url with a specific dialer (for subprotocols, compression, TLS...)
+// and consume the welcome frame
+func dialWith(t *testing.T, d *websocket.Dialer, url string) *websocket.Conn {
+	t.Helper()
+	conn, _ := dialWelcome(t, d, url)
+	return conn
+}
+
+// Dial url and return the raw welcome frame the server sends first
+func dialWelcome(t *testing.T, d *websocket.Dialer, url string) (*websocket.Conn, []byte) {
+	t.Helper()
+	header := http.Header{"Origin": {allowedOrigins[0]}}
+	conn, _, err := d.Dial(url, header)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, welcome, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("read welcome: %v", err)
+	}
+	return conn, welcome
+}
+
+// Send a text frame and wait for the next text reply, with the sequence
+// stamps taken off so expectations don't depend on test order
+func roundTrip(t *testing.T, conn *websocket.Conn, msg string) string {
+	t.Helper()
+	return unstamped(rawRoundTrip(t, conn, msg))
+}
+
+// Matches the "[Conn #c / Msg #n] " echo prefix and the JSON seq fields
+var seqStamps = regexp.MustCompile(`^\[Conn #\d+ / Msg #\d+\] |,"seq":\d+,"global_seq":\d+`)
+
+func unstamped(reply string) string {
+	return seqStamps.ReplaceAllString(reply, "")
+}
+
+// Send a text frame and wait for the next text reply, exactly as sent
+func rawRoundTrip(t *testing.T, conn *websocket.Conn, msg string) string {
+	t.Helper()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, reply, err := readData(conn)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return string(reply)
+}
+
+// Read the next frame that isn't a presence event. Tests that open several
+// connections on one handler get those in between their replies.
+func readData(conn *websocket.Conn) (int, []byte, error) {
+	for {
+		msgType, msg, err := conn.ReadMessage()
+		if err != nil {
+			return msgType, msg, err
+		}
+		var frame presenceFrame
+		decode := json.Unmarshal
+		if msgType == websocket.BinaryMessage {
+			decode = unmarshalMsgpack
+		}
+		if decode(msg, &frame) == nil && frame.Type == "presence" {
+			continue
+		}
+		return msgType, msg, nil
+	}
+}
+
+func TestEchoAndCommands(t *testing.T) {
+	conn := dial(t, startServer(t, NewHandler(Options{MaxBatchSize: 2})))
+
+	tests := []struct {
+		send     string
+		expected string
+	}{
+		{"hello", "hello"},
+		{`{"command":"add","a":2,"b":3}`, `{"command":"add","result":5}`},
+		{`[]`, `[]`},
+		{`[{"command":"add","a":1,"b":1},{"command":"divide","a":1,"b":0}]`,
+			`[{"command":"add","result":2},{"command":"divide","error":"Division by zero","code":"ERR_DIVISION_BY_ZERO"}]`},
+		{`[{},{},{}]`, `{"command":"","error":"Batch too large: 3 commands (max 2)","code":"ERR_BATCH_TOO_LARGE"}`},
+	}
+
+	for _, tt := range tests {
+		if got := roundTrip(t, conn, tt.send); got != tt.expected {
+			t.Errorf("send %s: got %s expected %s", tt.send, got, tt.expected)
+		}
+	}
+}
+
+func TestNDJSONFrame(t *testing.T) {
+	conn := dial(t, startServer(t, NewHandler(Options{})))
+
+	got := roundTrip(t, conn, "{\"command\":\"add\",\"a\":1,\"b\":2}\n{\"command\":\"subtract\",\"a\":5,\"b\":1}\n{\"command\":\"multiply\",\"a\":2,\"b\":3}")
+	expected := "{\"command\":\"add\",\"result\":3}\n{\"command\":\"subtract\",\"result\":4}\n{\"command\":\"multiply\",\"result\":6}"
+	if got != expected {
+		t.Errorf("got %q expected %q", got, expected)
+	}
+
+	// A single command on one line is unchanged
+	if got := roundTrip(t, conn, `{"command":"add","a":1,"b":2}`); got != `{"command":"add","result":3}` {
+		t.Errorf("single command: got %s", got)
+	}
+}
+
+func TestWSSRoundTrip(t *testing.T) {
+	srv := httptest.NewTLSServer(NewHandler(Options{AllowedOrigins: []string{"https://localhost:4000"}}))
+	t.Cleanup(srv.Close)
+
+	// Trust the test server's self-signed certificate and nothing else
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	d := &websocket.Dialer{TLSClientConfig: &tls.Config{RootCAs: roots}}
+
+	url := "wss" + strings.TrimPrefix(srv.URL, "https")
+	conn, _, err := d.Dial(url, http.Header{"Origin": {"https://localhost:4000"}})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	_, _, _ = conn.ReadMessage() // welcome
+
+	if got := roundTrip(t, conn, `{"command":"add","a":2,"b":3}`); got != `{"command":"add","result":5}` {
+		t.Errorf("got %s", got)
+	}
+
+	// The plain-http origin isn't on this handler's list
+	if _, resp, err := d.Dial(url, http.Header{"Origin": {allowedOrigins[0]}}); err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("http origin over wss: expected 403, got err=%v", err)
+	}
+}
+
+func TestEntryPointsAgree(t *testing.T) {
+	for name, h := range map[string]http.Handler{
+		"NewHandler":      NewHandler(DefaultOptions()),
+		"HandleWebSocket": http.HandlerFunc(HandleWebSocket),
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewServer(h)
+			defer srv.Close()
+			url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+			// Checked before the origin
+			req, _ := http.NewRequest(http.MethodPost, srv.URL, nil)
+			req.Header.Set("Origin", "https://evil.example")
+			if res, err := http.DefaultClient.Do(req); err != nil || res.StatusCode != http.StatusMethodNotAllowed {
+				t.Errorf("POST: got %v, %v expected 405", res, err)
+			} else {
+				res.Body.Close()
+			}
+
+			if _, res, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"https://evil.example"}}); err == nil || res == nil || res.StatusCode != http.StatusForbidden {
+				t.Errorf("bad origin: got %v expected 403", err)
+			}
+			if _, res, err := websocket.DefaultDialer.Dial(url, nil); err == nil || res == nil || res.StatusCode != http.StatusForbidden {
+				t.Errorf("no origin: got %v expected 403", err)
+			}
+
+			// A plain GET from an allowed page isn't a websocket handshake
+			req, _ = http.NewRequest(http.MethodGet, srv.URL, nil)
+			req.Header.Set("Origin", allowedOrigins[0])
+			if res, err := http.DefaultClient.Do(req); err != nil || res.StatusCode != http.StatusBadRequest {
+				t.Errorf("plain GET: got %v, %v expected 400", res, err)
+			} else {
+				res.Body.Close()
+			}
+
+			dial(t, url)
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package ws
+
+// Filename: internal/ws/handshake.go
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Opening handshake defaults
+const (
+	defaultHandshakeTimeout = 10 * time.Second
+	defaultBufferSize       = 4096 // bytes, gorilla/websocket's own default
+)
+
+// Total handshakes that timed out, before or during the upgrade
+var handshakeTimeoutCounter uint64
+
+// ConfigureServer bounds how long srv waits for a client to send its
+// upgrade request. A client that opens a TCP connection and then stalls is
+// never seen by ServeHTTP, so without this its goroutine hangs around until
+// the socket dies. Stalled clients are logged and counted as handshake
+// timeouts. Call it before the server starts; an existing ConnState hook
+// keeps running.
+func (h *Handler) ConfigureServer(srv *http.Server) {
+	timeout := h.opts.HandshakeTimeout
+	if srv.ReadHeaderTimeout == 0 || srv.ReadHeaderTimeout > timeout {
+		srv.ReadHeaderTimeout = timeout
+	}
+
+	var opened sync.Map // net.Conn -> time.Time it was accepted
+	next := srv.ConnState
+	srv.ConnState = func(c net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			opened.Store(c, time.Now())
+		case http.StateIdle, http.StateHijacked:
+			// Served a request (or upgraded); StateActive alone only means
+			// some bytes arrived
+			opened.Delete(c)
+		case http.StateClosed:
+			// Closed without finishing its first request
+			if at, ok := opened.LoadAndDelete(c); ok && time.Since(at.(time.Time)) >= timeout {
+				atomic.AddUint64(&handshakeTimeoutCounter, 1)
+				h.opts.Logger.Printf("handshake timeout from %s after %s", c.RemoteAddr(), timeout)
+			}
+		}
+		if next != nil {
+			next(c, state)
+		}
+	}
+}
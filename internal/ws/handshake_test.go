@@ -0,0 +1,70 @@
+// Filename: internal/ws/handshake_test.go
+
+package ws
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStalledHandshakeIsReleased(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+	h := NewHandler(Options{HandshakeTimeout: timeout})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	srv := &http.Server{Handler: h}
+	h.ConfigureServer(srv)
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+
+	before := atomic.LoadUint64(&handshakeTimeoutCounter)
+
+	// Start an upgrade request and never finish it
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	if _, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\n"); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	// The server should hang up on us shortly after the timeout
+	start := time.Now()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("read: got %v expected EOF", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*timeout {
+		t.Errorf("connection held for %s with a %s timeout", elapsed, timeout)
+	}
+
+	// ConnState runs just after the close, so give it a moment
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadUint64(&handshakeTimeoutCounter) == before {
+		if time.Now().After(deadline) {
+			t.Fatal("handshake timeout was not counted")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestUpgraderUsesOptions(t *testing.T) {
+	h := NewHandler(Options{HandshakeTimeout: time.Second, ReadBufferSize: 512, WriteBufferSize: 2048})
+	if h.upgrader.HandshakeTimeout != time.Second || h.upgrader.ReadBufferSize != 512 || h.upgrader.WriteBufferSize != 2048 {
+		t.Errorf("upgrader: got timeout %s, buffers %d/%d", h.upgrader.HandshakeTimeout,
+			h.upgrader.ReadBufferSize, h.upgrader.WriteBufferSize)
+	}
+
+	d := NewHandler(Options{})
+	if d.upgrader.HandshakeTimeout != defaultHandshakeTimeout || d.upgrader.ReadBufferSize != defaultBufferSize {
+		t.Errorf("defaults: got timeout %s, read buffer %d", d.upgrader.HandshakeTimeout, d.upgrader.ReadBufferSize)
+	}
+}
@@ -0,0 +1,72 @@
+package ws
+
+// Filename: internal/ws/hash.go
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// Error codes for the hash and hmac commands
+const (
+	ErrCodeUnknownAlgo = "ERR_UNKNOWN_ALGO"
+	ErrCodeMissingKey  = "ERR_MISSING_KEY"
+)
+
+// Digests for hash and hmac. md5 and sha1 are for checksums, not security.
+var hashAlgos = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// The algos in the order error messages list them
+var hashAlgoNames = []string{"md5", "sha1", "sha256", "sha512"}
+
+// Return the lowercase hex digest of text
+func runHash(_ *Session, req CommandRequest) CommandResponse {
+	newHash, errResp := hashRequest(req)
+	if errResp != nil {
+		return *errResp
+	}
+	h := newHash()
+	h.Write([]byte(req.Text))
+	return CommandResponse{Command: req.Command, Text: hex.EncodeToString(h.Sum(nil))}
+}
+
+// Return the lowercase hex HMAC of text under key
+func runHMAC(_ *Session, req CommandRequest) CommandResponse {
+	newHash, errResp := hashRequest(req)
+	if errResp != nil {
+		return *errResp
+	}
+	if req.Key == "" {
+		return errorResponse(req.Command, ErrCodeMissingKey, "Missing key")
+	}
+	mac := hmac.New(newHash, []byte(req.Key))
+	mac.Write([]byte(req.Text))
+	return CommandResponse{Command: req.Command, Text: hex.EncodeToString(mac.Sum(nil))}
+}
+
+// Check the algo and text every digest command needs
+func hashRequest(req CommandRequest) (func() hash.Hash, *CommandResponse) {
+	newHash, ok := hashAlgos[req.Algo]
+	if !ok {
+		resp := errorResponse(req.Command, ErrCodeUnknownAlgo,
+			fmt.Sprintf("Unknown algo %q (want %s)", req.Algo, strings.Join(hashAlgoNames, ", ")))
+		return nil, &resp
+	}
+	if req.Text == "" {
+		resp := errorResponse(req.Command, ErrCodeMissingText, "Missing text")
+		return nil, &resp
+	}
+	return newHash, nil
+}
@@ -0,0 +1,50 @@
+// Filename: internal/ws/hash_test.go
+
+package ws
+
+import (
+	"testing"
+)
+
+const quickFox = "The quick brown fox jumps over the lazy dog"
+
+func TestHashCommands(t *testing.T) {
+	tests := []struct {
+		name string
+		req  CommandRequest
+		want string
+		code string
+	}{
+		{"md5", CommandRequest{Command: "hash", Algo: "md5", Text: "hello"}, "5d41402abc4b2a76b9719d911017c592", ""},
+		{"md5 fox", CommandRequest{Command: "hash", Algo: "md5", Text: quickFox}, "9e107d9d372bb6826bd81d3542a419d6", ""},
+		{"sha1", CommandRequest{Command: "hash", Algo: "sha1", Text: "hello"}, "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d", ""},
+		{"sha256", CommandRequest{Command: "hash", Algo: "sha256", Text: "hello"}, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", ""},
+		{"sha256 fox", CommandRequest{Command: "hash", Algo: "sha256", Text: quickFox}, "d7a8fbb307d7809469ca9abcb0082e4f8d5651e46d3cdb762d02d0bf37c9e592", ""},
+		{"sha512", CommandRequest{Command: "hash", Algo: "sha512", Text: "hello"},
+			"9b71d224bd62f3785d96d46ad3ea3d73319bfbc2890caadae2dff72519673ca72323c3d99ba5c11d7c7acc6e14b8c5da0c4663475c2e5c3adef46f73bcdec043", ""},
+		{"hmac md5", CommandRequest{Command: "hmac", Algo: "md5", Key: "key", Text: quickFox}, "80070713463e7749b90c2dc24911e275", ""},
+		{"hmac sha256", CommandRequest{Command: "hmac", Algo: "sha256", Key: "key", Text: quickFox}, "f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8", ""},
+
+		{"unknown algo", CommandRequest{Command: "hash", Algo: "crc32", Text: "hello"}, "", ErrCodeUnknownAlgo},
+		{"no algo", CommandRequest{Command: "hash", Text: "hello"}, "", ErrCodeUnknownAlgo},
+		{"no text", CommandRequest{Command: "hash", Algo: "sha256"}, "", ErrCodeMissingText},
+		{"hmac unknown algo", CommandRequest{Command: "hmac", Algo: "SHA256", Key: "key", Text: "x"}, "", ErrCodeUnknownAlgo},
+		{"hmac no key", CommandRequest{Command: "hmac", Algo: "sha256", Text: "x"}, "", ErrCodeMissingKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := processCommand(DefaultRegistry, newSession(defaultHistorySize), tt.req)
+			if resp.Text != tt.want || resp.Code != tt.code || resp.Result != nil {
+				t.Errorf("got %+v expected %q %s", resp, tt.want, tt.code)
+			}
+		})
+	}
+}
+
+func TestHashOverTheWire(t *testing.T) {
+	conn := dial(t, startServer(t, NewHandler(Options{})))
+	got := roundTrip(t, conn, `{"command":"hash","algo":"sha256","text":"hello"}`)
+	if want := `{"command":"hash","text":"2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"}`; got != want {
+		t.Errorf("got %s expected %s", got, want)
+	}
+}
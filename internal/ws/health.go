@@ -0,0 +1,60 @@
+package ws
+
+// Filename: internal/ws/health.go
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Body of /healthz and /readyz
+type healthInfo struct {
+	Status          string  `json:"status"` // "ok", "ready" or "draining"
+	OpenConnections int64   `json:"open_connections"`
+	UptimeSeconds   float64 `json:"uptime_s"`
+}
+
+// Drain stops the handler accepting new websockets: upgrades and /readyz
+// answer 503 from now on, so a load balancer routes new clients elsewhere.
+// Open connections are left alone. Safe to call more than once.
+func (h *Handler) Drain() {
+	if h.draining.CompareAndSwap(false, true) {
+		h.opts.Logger.Printf("draining: refusing new connections")
+	}
+}
+
+// Draining reports whether Drain has been called
+func (h *Handler) Draining() bool {
+	return h.draining.Load()
+}
+
+// Healthz is the liveness probe: 200 whenever the process can answer at all
+func (h *Handler) Healthz(w http.ResponseWriter, r *http.Request) {
+	h.writeHealth(w, http.StatusOK, "ok")
+}
+
+// Readyz is the readiness probe: 200 while upgrades are accepted, 503 once
+// the handler is draining
+func (h *Handler) Readyz(w http.ResponseWriter, r *http.Request) {
+	if h.Draining() {
+		h.writeHealth(w, http.StatusServiceUnavailable, "draining")
+		return
+	}
+	h.writeHealth(w, http.StatusOK, "ready")
+}
+
+func (h *Handler) writeHealth(w http.ResponseWriter, code int, status string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	err := json.NewEncoder(w).Encode(healthInfo{
+		Status:          status,
+		OpenConnections: atomic.LoadInt64(&openConnections),
+		UptimeSeconds:   time.Since(startTime).Seconds(),
+	})
+	if err != nil {
+		h.opts.Logger.Printf("health: encode: %v", err)
+	}
+}
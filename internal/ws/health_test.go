@@ -0,0 +1,68 @@
+// Filename: internal/ws/health_test.go
+
+package ws
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestDrainFailsReadinessOnly(t *testing.T) {
+	h := NewHandler(Options{})
+	mux := http.NewServeMux()
+	mux.Handle("/ws", h)
+	mux.HandleFunc("GET /healthz", h.Healthz)
+	mux.HandleFunc("GET /readyz", h.Readyz)
+	mux.Handle("/admin/", h.AdminHandler("secret"))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	probe := func(path string, expectedCode int, expectedStatus string) {
+		t.Helper()
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		defer resp.Body.Close()
+		var body healthInfo
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		if resp.StatusCode != expectedCode || body.Status != expectedStatus || body.OpenConnections < 1 || body.UptimeSeconds <= 0 {
+			t.Errorf("GET %s: got %d %+v expected %d %q", path, resp.StatusCode, body, expectedCode, expectedStatus)
+		}
+	}
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+	conn := dial(t, url)
+	probe("/healthz", http.StatusOK, "ok")
+	probe("/readyz", http.StatusOK, "ready")
+
+	req, _ := http.NewRequest("POST", srv.URL+"/admin/drain", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("drain: got %v, %v", resp, err)
+	}
+	resp.Body.Close()
+	if !h.Draining() {
+		t.Fatal("handler not draining after POST /admin/drain")
+	}
+
+	probe("/healthz", http.StatusOK, "ok")
+	probe("/readyz", http.StatusServiceUnavailable, "draining")
+
+	// The open connection carries on; new ones are turned away
+	if got := roundTrip(t, conn, `{"command":"add","a":1,"b":2}`); got != `{"command":"add","result":3}` {
+		t.Errorf("after drain: got %s", got)
+	}
+	_, resp, err = websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"http://localhost:4000"}})
+	if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("dial while draining: got %v, %v expected 503", resp, err)
+	}
+}
@@ -0,0 +1,101 @@
+package ws
+
+// Filename: internal/ws/history.go
+
+import (
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// History limits
+const (
+	defaultHistorySize = 20   // exchanges kept per connection
+	maxHistorySize     = 1000 // upper bound for Options.HistorySize
+	historyPayloadMax  = 512  // bytes of each payload kept
+)
+
+// Directions recorded in history entries
+const (
+	directionIn  = "in"
+	directionOut = "out"
+)
+
+// One recorded frame
+type historyEntry struct {
+	Direction string    `json:"direction"`
+	Time      time.Time `json:"time"`
+	Payload   string    `json:"payload"`
+	Truncated bool      `json:"truncated,omitempty"`
+}
+
+// Fixed-size ring of the most recent frames on a connection
+type history struct {
+	mu      sync.Mutex
+	entries []historyEntry
+	next    int  // slot the next entry goes in
+	full    bool // every slot has been written at least once
+	skipOut bool // drop the next outbound entry (the history reply itself)
+}
+
+func newHistory(size int) *history {
+	return &history{entries: make([]historyEntry, size)}
+}
+
+func (h *history) record(direction string, payload []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch {
+	case direction == directionIn:
+		// A new request. A history reply that went out without being
+		// recorded, as msgpack, protobuf or a stream, left its skip set.
+		h.skipOut = false
+	case h.skipOut:
+		h.skipOut = false
+		return
+	}
+
+	e := historyEntry{Direction: direction, Time: time.Now()}
+	if len(payload) > historyPayloadMax {
+		cut := historyPayloadMax
+		// Back up to a rune boundary so the stored text stays valid UTF-8
+		for cut > 0 && !utf8.RuneStart(payload[cut]) {
+			cut--
+		}
+		payload, e.Truncated = payload[:cut], true
+	}
+	e.Payload = string(payload)
+
+	h.entries[h.next] = e
+	h.next = (h.next + 1) % len(h.entries)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// The newest limit entries, oldest first. The reply that carries them is
+// not recorded, so asking for history doesn't fill history with history.
+func (h *history) last(limit int) []historyEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	n := h.next
+	if h.full {
+		n = len(h.entries)
+	}
+	if limit <= 0 || limit > n {
+		limit = n
+	}
+
+	out := make([]historyEntry, 0, limit)
+	for i := h.next - limit; i < h.next; i++ {
+		out = append(out, h.entries[(i+len(h.entries))%len(h.entries)])
+	}
+	h.skipOut = true
+	return out
+}
+
+func runHistory(s *Session, req CommandRequest) CommandResponse {
+	return CommandResponse{Command: req.Command, Data: historyDump(s.history.last(req.Limit))}
+}
@@ -0,0 +1,95 @@
+// Filename: internal/ws/history_test.go
+
+package ws
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/alexdev404/ws-main/internal/ws/pb"
+)
+
+type historyReply struct {
+	Data []historyEntry `json:"data"`
+}
+
+func TestHistoryRingOverflow(t *testing.T) {
+	h := newHistory(4)
+	for _, msg := range []string{"1", "2", "3", "4", "5", "6"} {
+		h.record(directionIn, []byte(msg))
+	}
+
+	got := h.last(0)
+	if len(got) != 4 {
+		t.Fatalf("got %d entries expected 4", len(got))
+	}
+	for i, want := range []string{"3", "4", "5", "6"} {
+		if got[i].Payload != want {
+			t.Errorf("entry %d: got %q expected %q", i, got[i].Payload, want)
+		}
+	}
+
+	if got := h.last(2); len(got) != 2 || got[0].Payload != "5" || got[1].Payload != "6" {
+		t.Errorf("last(2): got %+v", got)
+	}
+	if got := h.last(100); len(got) != 4 {
+		t.Errorf("last(100): got %d entries expected 4", len(got))
+	}
+}
+
+func TestHistoryTruncatesPayloads(t *testing.T) {
+	h := newHistory(2)
+	h.record(directionIn, []byte(strings.Repeat("é", historyPayloadMax)))
+
+	e := h.last(1)[0]
+	if !e.Truncated || len(e.Payload) > historyPayloadMax || !utf8.ValidString(e.Payload) {
+		t.Errorf("got %d bytes (truncated=%v, valid=%v)", len(e.Payload), e.Truncated, utf8.ValidString(e.Payload))
+	}
+}
+
+func TestHistoryCommand(t *testing.T) {
+	conn := dial(t, startServer(t, NewHandler(Options{HistorySize: 6})))
+
+	for _, msg := range []string{"a", "b", "c", "d"} {
+		roundTrip(t, conn, msg)
+	}
+	roundTrip(t, conn, `{"command":"history"}`)
+
+	var got historyReply
+	if err := json.Unmarshal([]byte(roundTrip(t, conn, `{"command":"history","limit":3}`)), &got); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	// The first history reply was not recorded, so only its request shows up
+	expected := []struct{ dir, payload string }{
+		{directionOut, "d"},
+		{directionIn, `{"command":"history"}`},
+		{directionIn, `{"command":"history","limit":3}`},
+	}
+	if len(got.Data) != len(expected) {
+		t.Fatalf("got %d entries expected %d: %+v", len(got.Data), len(expected), got.Data)
+	}
+	for i, e := range expected {
+		if got.Data[i].Direction != e.dir || unstamped(got.Data[i].Payload) != e.payload || got.Data[i].Time.IsZero() {
+			t.Errorf("entry %d: got %+v expected %s %s", i, got.Data[i], e.dir, e.payload)
+		}
+	}
+}
+
+func TestHistoryRecordsAfterProtobufHistory(t *testing.T) {
+	conn := dial(t, startServer(t, NewHandler(Options{ProtobufBinary: true})))
+
+	// Its reply is binary and never recorded, so must not skip the next one
+	protobufRoundTrip(t, conn, &pb.CommandRequest{Command: "history"})
+	roundTrip(t, conn, "a")
+
+	var got historyReply
+	if err := json.Unmarshal([]byte(roundTrip(t, conn, `{"command":"history"}`)), &got); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if len(got.Data) != 3 || got.Data[1].Direction != directionOut || unstamped(got.Data[1].Payload) != "a" {
+		t.Errorf("got %+v", got.Data)
+	}
+}
@@ -0,0 +1,257 @@
+package ws
+
+// Filename: internal/ws/hub.go
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Default time between tick broadcasts
+const defaultTickInterval = 30 * time.Second
+
+// hub tracks a handler's open connections so the server can push to all of them
+type hub struct {
+	mu      sync.Mutex
+	clients map[*client]struct{}
+	ids     map[string]*client  // conn_id -> client
+	nicks   map[string]*Session // lower-cased nickname -> its owner
+	relay   *redisBridge        // other instances' hubs; nil when running alone
+	sse     *sseMirror          // /events streams
+	acks    *ackTracker         // broadcasts and dms waiting for acks
+	bseq    atomic.Uint64       // number of the latest broadcast; only changes under mu
+	replay  broadcastRing       // recent broadcasts, for joinAfter
+	log     *log.Logger
+}
+
+func newHub(logger *log.Logger) *hub {
+	h := &hub{
+		log:     logger,
+		sse:     newSSEMirror(logger),
+		clients: make(map[*client]struct{}),
+		ids:     make(map[string]*client),
+		nicks:   make(map[string]*Session),
+		replay:  broadcastRing{max: defaultReplayBuffer, age: defaultReplayAge},
+	}
+	h.acks = newAckTracker(defaultAckWindow, func(sender string, r ackReport) {
+		_, _ = h.sendTo(sender, r, nil, laneSystem) // a sender that's gone misses it
+	})
+	return h
+}
+
+// Frame sent to the other clients when a connection joins, leaves or renames
+type presenceFrame struct {
+	Type    string `json:"type"`
+	Event   string `json:"event"` // "join", "leave" or "rename"
+	ConnID  string `json:"conn_id"`
+	Nick    string `json:"nick,omitempty"`
+	OldNick string `json:"old_nick,omitempty"` // rename only
+	Count   int    `json:"count"`              // connections after the change
+}
+
+// Add c and tell everyone else. Events go out under the lock so every
+// client sees joins and leaves in the same order.
+func (h *hub) join(c *client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.add(c)
+}
+
+// Caller holds h.mu
+func (h *hub) add(c *client) {
+	h.clients[c] = struct{}{}
+	h.ids[c.session.conn.ID] = c
+	h.announce(c.session, presenceFrame{Event: "join"})
+}
+
+// Remove c and tell everyone else
+func (h *hub) leave(c *client) {
+	// Deferred first so it runs after the unlock; it may send ack reports
+	defer h.acks.forget(c.session.conn.ID)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[c]; !ok {
+		return
+	}
+	delete(h.clients, c)
+	delete(h.ids, c.session.conn.ID)
+	nick := c.session.Nick()
+	if nick != "" {
+		delete(h.nicks, strings.ToLower(nick))
+	}
+	h.announce(c.session, presenceFrame{Event: "leave", Nick: nick})
+}
+
+// Give s the nickname nick, freeing its old one. Fails if another live
+// connection holds it in any letter case.
+func (h *hub) rename(s *Session, nick string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := strings.ToLower(nick)
+	if owner, taken := h.nicks[key]; taken && owner != s {
+		return errNickTaken
+	}
+	old := s.Nick()
+	if old != "" {
+		delete(h.nicks, strings.ToLower(old))
+	}
+	h.nicks[key] = s
+	s.setNick(nick)
+	h.log.Printf("%s is now known as %q", s.conn.ID, nick)
+	h.announce(s, presenceFrame{Event: "rename", Nick: nick, OldNick: old})
+	return nil
+}
+
+// Send frame about s to every other client. Caller holds h.mu; queues are
+// never waited on, so a stuck client can't stall the hub, it just misses
+// the event.
+func (h *hub) announce(s *Session, frame presenceFrame) {
+	frame.Type, frame.ConnID, frame.Count = "presence", s.conn.ID, len(h.clients)
+	if h.relay != nil {
+		h.relay.publish("presence", frame)
+	}
+	h.sse.publish(frame.Type, frame)
+	out := newFanout(frame)
+	for other := range h.clients {
+		if other.session != s && !out.trySend(other) {
+			h.log.Printf("presence %s dropped for %s: queue full", frame.Event, other.session.label())
+		}
+	}
+}
+
+// A connection as a dm names it
+type rosterEntry struct {
+	ConnID string `json:"conn_id"`
+	Nick   string `json:"nick,omitempty"`
+}
+
+// Most connections one "who" reply lists, and how many it lists unless
+// asked for fewer
+const maxWhoPage = 100
+
+// One connection in the "who" roster
+type whoEntry struct {
+	ConnID      string `json:"conn_id"`
+	Nick        string `json:"nick,omitempty"`
+	ConnectedAt string `json:"connected_at"`
+	Room        string `json:"room,omitempty"`
+	RemoteAddr  string `json:"remote_addr,omitempty"` // with Options.WhoRemoteAddrs only
+}
+
+// What "who" answers with: one page of the roster, and how long the
+// whole roster is, after any room filter
+type whoPage struct {
+	Connections []whoEntry `json:"connections"`
+	Total       int        `json:"total"`
+	Offset      int        `json:"offset"`
+	Limit       int        `json:"limit"`
+}
+
+// Every connected client in room, or in any room if room is "", oldest
+// first. Only the copying happens under the lock; sorting, and the
+// caller's paging and encoding, work on the copy.
+func (h *hub) roster(room string, addrs bool) []whoEntry {
+	h.mu.Lock()
+	out := make([]whoEntry, 0, len(h.clients))
+	for c := range h.clients {
+		conn := c.session.conn
+		if room != "" && conn.Room != room {
+			continue
+		}
+		e := whoEntry{ConnID: conn.ID, Nick: c.session.Nick(), Room: conn.Room}
+		e.ConnectedAt = conn.ConnectedAt.UTC().Format(serverTimeFormat)
+		if addrs {
+			e.RemoteAddr = conn.RemoteAddr
+		}
+		out = append(out, e)
+	}
+	h.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return connIDLess(out[i].ConnID, out[j].ConnID) })
+	return out
+}
+
+// Order conn_ids by connection: shorter first, so "c9" comes before "c10"
+func connIDLess(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+// Copy of the current clients, so sends happen without holding the lock
+func (h *hub) snapshot() []*client {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make([]*client, 0, len(h.clients))
+	for c := range h.clients {
+		out = append(out, c)
+	}
+	return out
+}
+
+// Frame pushed to every client in tick mode
+type tickFrame struct {
+	Type        string `json:"type"`
+	ServerTime  string `json:"server_time"`
+	Connections int    `json:"connections"`
+}
+
+// Broadcast a tick every interval until stop is closed. A client whose
+// queue is full misses that tick rather than holding up everyone else.
+func (h *hub) runTicks(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			clients := h.snapshot()
+			frame := tickFrame{Type: "tick", ServerTime: now.UTC().Format(serverTimeFormat), Connections: len(clients)}
+			h.sse.publish(frame.Type, frame)
+			out := newFanout(frame)
+			for _, c := range clients {
+				if !c.session.ticksEnabled() {
+					continue
+				}
+				if !out.trySend(c) {
+					h.log.Printf("tick dropped for %s: queue full", c.session.conn.RemoteAddr)
+				}
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// List the clients on this connection's handler, those in "room" if it is
+// given, a page at a time: "limit" of them, at most maxWhoPage, from
+// "offset" on
+func runWho(s *Session, req CommandRequest) CommandResponse {
+	if req.Offset < 0 || req.Limit < 0 {
+		return errorResponse(req.Command, ErrCodeInvalidRange, "offset and limit must not be negative")
+	}
+	limit := maxWhoPage
+	if req.Limit > 0 {
+		limit = min(req.Limit, maxWhoPage)
+	}
+	roster := []whoEntry{}
+	if s.hub != nil {
+		roster = s.hub.roster(req.Room, s.whoAddrs)
+	}
+	page := whoPage{Total: len(roster), Offset: req.Offset, Limit: limit}
+	from := min(req.Offset, len(roster))
+	page.Connections = roster[from:min(from+limit, len(roster))]
+	return CommandResponse{Command: req.Command, Data: page}
+}
+
+// Turn tick frames on or off for this connection
+func runTicks(s *Session, req CommandRequest) CommandResponse {
+	if req.Enabled == nil {
+		return errorResponse(req.Command, ErrCodeInvalidOperand, "enabled must be true or false")
+	}
+	s.setTicks(*req.Enabled)
+	return CommandResponse{Command: req.Command, Done: true}
+}
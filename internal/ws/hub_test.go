@@ -0,0 +1,234 @@
+// Filename: internal/ws/hub_test.go
+
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Read frames until one that isn't a tick, returning it and how many ticks came first
+func readSkippingTicks(t *testing.T, conn *websocket.Conn) (string, int) {
+	t.Helper()
+	ticks := 0
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, msg, err := readData(conn)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		var frame tickFrame
+		if json.Unmarshal(msg, &frame) == nil && frame.Type == "tick" {
+			ticks++
+			continue
+		}
+		return string(msg), ticks
+	}
+}
+
+func TestTicksOnlyReachOptedInClients(t *testing.T) {
+	const interval = 20 * time.Millisecond
+	h := NewHandler(Options{Ticks: true, TickInterval: interval})
+	t.Cleanup(h.Close)
+	url := startServer(t, h)
+	quiet, loud := dial(t, url), dial(t, url)
+
+	for conn, enabled := range map[*websocket.Conn]string{quiet: "false", loud: "true"} {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"command":"ticks","enabled":`+enabled+`}`)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if got, _ := readSkippingTicks(t, conn); unstamped(got) != `{"command":"ticks","done":true}` {
+			t.Fatalf("ticks %s: got %s", enabled, got)
+		}
+	}
+
+	// The opted-in client keeps getting ticks
+	_ = loud.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, msg, err := loud.ReadMessage()
+	if err != nil {
+		t.Fatalf("read tick: %v", err)
+	}
+	var frame tickFrame
+	if err := json.Unmarshal(msg, &frame); err != nil || frame.Type != "tick" || frame.Connections != 2 {
+		t.Errorf("tick: got %s", msg)
+	}
+	if _, err := time.Parse(time.RFC3339, frame.ServerTime); err != nil {
+		t.Errorf("tick server_time %q: %v", frame.ServerTime, err)
+	}
+
+	// The opted-out one hears nothing for several intervals
+	_ = quiet.SetReadDeadline(time.Now().Add(5 * interval))
+	_, msg, err = quiet.ReadMessage()
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("opted-out client: got %q, %v expected a read timeout", msg, err)
+	}
+}
+
+func TestTickDroppedWhenQueueFull(t *testing.T) {
+	c := &client{session: newSession(defaultHistorySize), encoding: encodingJSON, send: make(chan outbound, 1)}
+	if !c.trySendEncoded(tickFrame{Type: "tick"}, laneData) {
+		t.Fatal("first tick should fit")
+	}
+
+	done := make(chan bool)
+	go func() { done <- c.trySendEncoded(tickFrame{Type: "tick"}, laneData) }()
+	select {
+	case queued := <-done:
+		if queued {
+			t.Error("second tick was queued on a full queue")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("trySendEncoded blocked on a full queue")
+	}
+}
+
+func TestHandlerCloseStopsBroadcaster(t *testing.T) {
+	h := NewHandler(Options{Ticks: true, TickInterval: time.Millisecond})
+
+	done := make(chan struct{})
+	go func() {
+		h.Close()
+		h.Close() // safe to repeat
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Close did not return")
+	}
+}
+
+// Ask for the roster over conn
+func who(t *testing.T, conn *websocket.Conn) []whoEntry {
+	t.Helper()
+	return whoPageOf(t, conn, `{"command":"who"}`).Connections
+}
+
+// Send a who command over conn and return the page it answers with
+func whoPageOf(t *testing.T, conn *websocket.Conn, cmd string) whoPage {
+	t.Helper()
+	var resp struct {
+		Data whoPage `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(rawRoundTrip(t, conn, cmd)), &resp); err != nil {
+		t.Fatalf("who: %v", err)
+	}
+	return resp.Data
+}
+
+func TestPresenceJoinWhoLeave(t *testing.T) {
+	url := startServer(t, NewHandler(Options{}))
+	first, firstWelcome := dialWelcome(t, websocket.DefaultDialer, url)
+	second, secondWelcome := dialWelcome(t, websocket.DefaultDialer, url)
+
+	var a, b welcomeFrame
+	if json.Unmarshal(firstWelcome, &a) != nil || json.Unmarshal(secondWelcome, &b) != nil || a.ConnID == "" || a.ConnID == b.ConnID {
+		t.Fatalf("welcome conn ids: %s, %s", firstWelcome, secondWelcome)
+	}
+
+	// Read straight off the wire: the next frames must be presence, in order
+	expectPresence := func(event string, count int) {
+		t.Helper()
+		_ = first.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, msg, err := first.ReadMessage()
+		if err != nil {
+			t.Fatalf("read %s: %v", event, err)
+		}
+		expected := presenceFrame{Type: "presence", Event: event, ConnID: b.ConnID, Count: count}
+		var got presenceFrame
+		if err := json.Unmarshal(msg, &got); err != nil || got != expected {
+			t.Fatalf("%s: got %s expected %+v", event, msg, expected)
+		}
+	}
+	expectPresence("join", 2)
+
+	if got := who(t, first); len(got) != 2 || got[0].ConnID != a.ConnID || got[1].ConnID != b.ConnID {
+		t.Errorf("who: got %+v", got)
+	}
+
+	// Dropping without a close frame still produces exactly one leave
+	second.Close()
+	expectPresence("leave", 1)
+	_ = first.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, msg, err := first.ReadMessage(); err == nil {
+		t.Errorf("after leave: got %s", msg)
+	}
+}
+
+func TestWhoRoomsAndPaging(t *testing.T) {
+	url := startServer(t, NewHandler(Options{}))
+	conns := make(map[string]*websocket.Conn)
+	for _, q := range []string{"name=n1&room=red", "name=n2&room=red", "name=n3&room=red", "name=n4&room=blue", "name=n5"} {
+		conn := dial(t, url+"?"+q)
+		conns[q[5:7]] = conn
+	}
+	n1 := conns["n1"]
+
+	nicks := func(entries []whoEntry) string {
+		var out []string
+		for _, e := range entries {
+			out = append(out, e.Nick)
+		}
+		return strings.Join(out, ",")
+	}
+	red := whoPageOf(t, n1, `{"command":"who","room":"red"}`)
+	if red.Total != 3 || nicks(red.Connections) != "n1,n2,n3" {
+		t.Errorf("room red: got %+v", red)
+	}
+	for _, e := range red.Connections {
+		if e.Room != "red" || e.ConnectedAt == "" || e.RemoteAddr != "" {
+			t.Errorf("room red entry: got %+v", e)
+		}
+	}
+
+	tests := []struct {
+		cmd      string
+		expected string
+		limit    int
+	}{
+		{`{"command":"who","limit":2}`, "n1,n2", 2},
+		{`{"command":"who","offset":2,"limit":2}`, "n3,n4", 2},
+		{`{"command":"who","offset":4,"limit":2}`, "n5", 2},
+		{`{"command":"who","offset":5}`, "", maxWhoPage},
+		{`{"command":"who","offset":50}`, "", maxWhoPage},
+		{`{"command":"who","limit":500}`, "n1,n2,n3,n4,n5", maxWhoPage},
+		{`{"command":"who","room":"blue","limit":1}`, "n4", 1},
+		{`{"command":"who","room":"green"}`, "", maxWhoPage},
+	}
+	for _, tt := range tests {
+		page := whoPageOf(t, n1, tt.cmd)
+		if nicks(page.Connections) != tt.expected || page.Limit != tt.limit || page.Connections == nil {
+			t.Errorf("%s: got %+v expected %q", tt.cmd, page, tt.expected)
+		}
+	}
+	if got := roundTrip(t, n1, `{"command":"who","offset":-1}`); !strings.Contains(got, ErrCodeInvalidRange) {
+		t.Errorf("negative offset: got %s", got)
+	}
+
+	// A closed connection leaves the roster as soon as its read loop ends
+	conns["n5"].Close()
+	for deadline := time.Now().Add(2 * time.Second); ; {
+		page := whoPageOf(t, n1, `{"command":"who"}`)
+		if page.Total == 4 && nicks(page.Connections) == "n1,n2,n3,n4" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("after n5 closed: got %+v", page)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWhoRemoteAddrsOptIn(t *testing.T) {
+	conn := dial(t, startServer(t, NewHandler(Options{WhoRemoteAddrs: true})))
+	if got := who(t, conn); len(got) != 1 || !strings.HasPrefix(got[0].RemoteAddr, "127.0.0.1:") {
+		t.Errorf("who: got %+v", got)
+	}
+}
@@ -0,0 +1,112 @@
+package ws
+
+// Filename: internal/ws/idempotency.go
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Defaults for the idempotency_key cache
+const (
+	defaultIdempotencyKeys = 256             // keys remembered per connection
+	defaultIdempotencyTTL  = 2 * time.Minute // how long each is remembered
+	maxIdempotencyKeyLen   = 128             // longest key accepted, in bytes
+)
+
+// idempotencyCache remembers the response to each recent idempotency_key
+// on one connection, so a retried command is answered again rather than
+// run twice. It keeps the size most recently used keys, each for ttl.
+type idempotencyCache struct {
+	mu    sync.Mutex
+	size  int
+	ttl   time.Duration
+	order *list.List // *idempotencyEntry, most recently used first
+	keys  map[string]*list.Element
+}
+
+type idempotencyEntry struct {
+	key     string
+	resp    CommandResponse
+	expires time.Time
+}
+
+func newIdempotencyCache(size int, ttl time.Duration) *idempotencyCache {
+	return &idempotencyCache{size: size, ttl: ttl, order: list.New(), keys: make(map[string]*list.Element)}
+}
+
+// The response cached for key, if it hasn't expired by now
+func (c *idempotencyCache) get(key string, now time.Time) (CommandResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.keys[key]
+	if !ok {
+		return CommandResponse{}, false
+	}
+	e := el.Value.(*idempotencyEntry)
+	if now.After(e.expires) {
+		c.order.Remove(el)
+		delete(c.keys, key)
+		return CommandResponse{}, false
+	}
+	c.order.MoveToFront(el)
+	return e.resp, true
+}
+
+// Remember resp for key, evicting the least recently used key if that
+// makes one too many
+func (c *idempotencyCache) put(key string, resp CommandResponse, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.keys[key]; ok {
+		el.Value = &idempotencyEntry{key: key, resp: resp, expires: now.Add(c.ttl)}
+		c.order.MoveToFront(el)
+		return
+	}
+	c.keys[key] = c.order.PushFront(&idempotencyEntry{key: key, resp: resp, expires: now.Add(c.ttl)})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.keys, oldest.Value.(*idempotencyEntry).key)
+	}
+}
+
+// The session's cache; restore can swap it for a resumed session's
+func (s *Session) recentReplies() *idempotencyCache {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.replies
+}
+
+func (c *idempotencyCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// Run req unless its idempotency_key was seen recently, in which case the
+// response from then is returned again, marked replayed. Errors are
+// remembered too, so a retry can't turn a failure into a success. Streamed
+// replies can't be played twice and aren't remembered.
+func (s *Session) runIdempotent(req CommandRequest, run func() CommandResponse) CommandResponse {
+	key := req.IdempotencyKey
+	if key == "" {
+		return run()
+	}
+	if len(key) > maxIdempotencyKeyLen {
+		return s.stamp(errorResponse(req.Command, ErrCodeInvalidOperand,
+			fmt.Sprintf("idempotency_key longer than %d bytes", maxIdempotencyKeyLen)))
+	}
+	cache := s.recentReplies()
+	if resp, ok := cache.get(key, s.clock.Now()); ok {
+		resp.Replayed = true
+		return resp
+	}
+	resp := run()
+	if _, streamed := streamResponse(resp); !streamed {
+		cache.put(key, resp, s.clock.Now())
+	}
+	return resp
+}
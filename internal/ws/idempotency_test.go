@@ -0,0 +1,76 @@
+// Filename: internal/ws/idempotency_test.go
+
+package ws
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyCacheEvictsAndExpires(t *testing.T) {
+	c := newIdempotencyCache(2, time.Minute)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	resp := func(n int) CommandResponse { return CommandResponse{Command: fmt.Sprint(n)} }
+
+	c.put("a", resp(1), now)
+	c.put("b", resp(2), now)
+	if _, ok := c.get("a", now); !ok { // a is now the most recently used
+		t.Fatal("a missing")
+	}
+	c.put("c", resp(3), now)
+	if _, ok := c.get("b", now); ok {
+		t.Error("b survived eviction")
+	}
+	for key, expected := range map[string]string{"a": "1", "c": "3"} {
+		if got, ok := c.get(key, now); !ok || got.Command != expected {
+			t.Errorf("%s: got %+v, %v", key, got, ok)
+		}
+	}
+
+	// Expired entries are gone, and leave room
+	if _, ok := c.get("a", now.Add(time.Minute+time.Nanosecond)); ok {
+		t.Error("a outlived its ttl")
+	}
+	if c.len() != 1 {
+		t.Errorf("got %d entries expected 1", c.len())
+	}
+}
+
+func TestIdempotencyKeyReplays(t *testing.T) {
+	clock := newFakeClock(time.Now())
+	url := startServer(t, NewHandler(Options{Clock: clock, IdempotencyTTL: 10 * time.Second}))
+	conn, other := dial(t, url), dial(t, url)
+
+	tests := []struct {
+		conn     string
+		send     string
+		expected string
+	}{
+		{"conn", `{"command":"add","a":1,"b":2,"idempotency_key":"k1"}`, `{"command":"add","result":3}`},
+		{"conn", `{"command":"add","a":"ans","b":1,"idempotency_key":"k2"}`, `{"command":"add","result":4}`},
+		// Not run again, so ans stays 4
+		{"conn", `{"command":"add","a":"ans","b":1,"idempotency_key":"k2"}`, `{"command":"add","result":4,"replayed":true}`},
+		{"conn", `{"command":"add","a":"ans","b":0}`, `{"command":"add","result":4}`},
+		// Errors are replayed too
+		{"conn", `{"command":"divide","a":1,"b":0,"idempotency_key":"oops"}`, `{"command":"divide","error":"Division by zero","code":"ERR_DIVISION_BY_ZERO"}`},
+		{"conn", `{"command":"divide","a":1,"b":1,"idempotency_key":"oops"}`, `{"command":"divide","error":"Division by zero","code":"ERR_DIVISION_BY_ZERO","replayed":true}`},
+		// Keys belong to one connection
+		{"other", `{"command":"add","a":5,"b":5,"idempotency_key":"k1"}`, `{"command":"add","result":10}`},
+	}
+	for _, tt := range tests {
+		c := conn
+		if tt.conn == "other" {
+			c = other
+		}
+		if got := roundTrip(t, c, tt.send); got != tt.expected {
+			t.Errorf("%s %s: got %s expected %s", tt.conn, tt.send, got, tt.expected)
+		}
+	}
+
+	// After the ttl the key runs afresh
+	clock.Advance(11 * time.Second)
+	if got := roundTrip(t, conn, `{"command":"add","a":"ans","b":1,"idempotency_key":"k2"}`); got != `{"command":"add","result":5}` {
+		t.Errorf("after ttl: got %s", got)
+	}
+}
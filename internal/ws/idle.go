@@ -0,0 +1,71 @@
+package ws
+
+// Filename: internal/ws/idle.go
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Note that a data frame has just arrived, restarting the idle clock
+func (c *client) touch(now time.Time) {
+	c.lastData.Store(now.UnixNano())
+}
+
+// Note that the peer has just shown it's alive, with a frame or a pong
+func (c *client) seen(now time.Time) {
+	c.lastSeen.Store(now.UnixNano())
+}
+
+// Close c with 1000 once nothing, not even a pong, has arrived for wait.
+// Runs on the Clock, so a fake one drives it; the read deadline set with
+// the wall clock stays behind it as a backstop. Stops with c.
+func (c *client) watchPongs(clock Clock, wait time.Duration) {
+	c.seen(clock.Now())
+	ticker := clock.NewTicker(wait)
+	c.goWorker(func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C():
+				quiet := clock.Now().Sub(time.Unix(0, c.lastSeen.Load()))
+				if quiet < wait {
+					ticker.Reset(wait - quiet)
+					continue
+				}
+				c.log.Printf("%s sent nothing for %s", c.session.label(), quiet.Round(time.Second))
+				c.kick(websocket.CloseNormalClosure, "idle timeout")
+				return
+			case <-c.done:
+				return
+			}
+		}
+	})
+}
+
+// Close c with 1000 once it has gone idle without a data frame. Pongs keep
+// the read deadline alive but don't touch this clock, so a client that
+// only answers pings is still dropped. Stops with c.
+func (c *client) watchIdle(clock Clock, idle time.Duration) {
+	c.touch(clock.Now())
+	ticker := clock.NewTicker(idle)
+	c.goWorker(func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C():
+				quiet := clock.Now().Sub(time.Unix(0, c.lastData.Load()))
+				if quiet < idle {
+					ticker.Reset(idle - quiet)
+					continue
+				}
+				c.log.Printf("%s idle for %s", c.session.label(), quiet.Round(time.Second))
+				c.kick(websocket.CloseNormalClosure, "idle: no data")
+				return
+			case <-c.done:
+				return
+			}
+		}
+	})
+}
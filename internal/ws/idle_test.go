@@ -0,0 +1,44 @@
+// Filename: internal/ws/idle_test.go
+
+package ws
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestIdleTimeout(t *testing.T) {
+	const idle = 150 * time.Millisecond
+	url := startServer(t, NewHandler(Options{IdleTimeout: idle, PingPeriod: 20 * time.Millisecond}))
+
+	// Reading keeps answering the server's pings, but sends no data. The
+	// idle clock starts during the upgrade, so time it from before the dial.
+	start := time.Now()
+	quiet := dial(t, url)
+	pongs := 0
+	quiet.SetPingHandler(func(data string) error {
+		pongs++
+		return quiet.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	_ = quiet.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, msg, err := readData(quiet)
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseNormalClosure || closeErr.Text != "idle: no data" {
+		t.Fatalf("ping-only client: got %q, %v expected close 1000", msg, err)
+	}
+	if waited := time.Since(start); waited < idle || pongs == 0 {
+		t.Errorf("closed after %s with %d pongs answered", waited, pongs)
+	}
+
+	// A client sending data more often than that stays up well past it
+	chatty := dial(t, url)
+	for deadline := time.Now().Add(3 * idle); time.Now().Before(deadline); {
+		if got := roundTrip(t, chatty, `{"command":"add","a":1,"b":1}`); got != `{"command":"add","result":2}` {
+			t.Fatalf("chatty client: got %s", got)
+		}
+		time.Sleep(idle / 3)
+	}
+}
@@ -0,0 +1,106 @@
+package ws
+
+// Filename: internal/ws/intmode.go
+
+import (
+	"fmt"
+	"math"
+)
+
+// Error codes for "mode" and int mode arithmetic
+const (
+	ErrCodeInvalidMode = "ERR_INVALID_MODE"
+	ErrCodeOverflow    = "ERR_OVERFLOW"
+)
+
+// Run an arithmetic command in int64. Operands must be whole numbers, and
+// a result that doesn't fit is an error instead of wrapping around. The
+// result comes back in int_result, exact at any size, and doesn't become
+// "ans" (which is a float64 and couldn't hold it).
+func runIntMode(s *Session, req CommandRequest, fn func(command string, a, b int64) CommandResponse) CommandResponse {
+	if wantsPrecise(req) {
+		return errorResponse(req.Command, ErrCodeInvalidMode, "a_str, b_str and precision can't be used with mode int")
+	}
+	a, errResp := s.resolveInt(req.Command, "a", req.A, req.AVar)
+	if errResp != nil {
+		return *errResp
+	}
+	b, errResp := s.resolveInt(req.Command, "b", req.B, req.BVar)
+	if errResp != nil {
+		return *errResp
+	}
+	return fn(req.Command, a, b)
+}
+
+// Resolve an operand to an int64. A literal is taken exactly as sent;
+// variables and "ans" must hold a whole number in range.
+func (s *Session) resolveInt(command, name string, o Operand, varName string) (int64, *CommandResponse) {
+	if varName == "" && o.Ref == "" && o.IsInt {
+		return o.Int, nil
+	}
+	v, errResp := s.resolve(command, o, varName)
+	if errResp != nil {
+		return 0, errResp
+	}
+	if v != math.Trunc(v) {
+		resp := errorResponse(command, ErrCodeNotInteger, fmt.Sprintf("%s must be an integer in mode int (got %v)", name, v))
+		return 0, &resp
+	}
+	if v < math.MinInt64 || v >= math.MaxInt64 {
+		resp := errorResponse(command, ErrCodeOverflow, fmt.Sprintf("%s is outside the int64 range", name))
+		return 0, &resp
+	}
+	return int64(v), nil
+}
+
+func intResponse(command string, v int64) CommandResponse {
+	return CommandResponse{Command: command, IntResult: &v}
+}
+
+func overflowResponse(command string) CommandResponse {
+	return errorResponse(command, ErrCodeOverflow, "Result overflows int64")
+}
+
+// Signed overflow is when both operands (for subtraction: a and -b) have
+// the same sign and the result's differs from it
+
+func runIntAdd(command string, a, b int64) CommandResponse {
+	c := a + b
+	if (a^c)&(b^c) < 0 {
+		return overflowResponse(command)
+	}
+	return intResponse(command, c)
+}
+
+func runIntSubtract(command string, a, b int64) CommandResponse {
+	c := a - b
+	if (a^b)&(a^c) < 0 {
+		return overflowResponse(command)
+	}
+	return intResponse(command, c)
+}
+
+func runIntMultiply(command string, a, b int64) CommandResponse {
+	if a == 0 || b == 0 {
+		return intResponse(command, 0)
+	}
+	c := a * b
+	if c/b != a || (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+		return overflowResponse(command)
+	}
+	return intResponse(command, c)
+}
+
+// Division truncates toward zero, and the remainder takes a's sign, as in Go
+func runIntDivide(command string, a, b int64) CommandResponse {
+	if b == 0 {
+		return errorResponse(command, ErrCodeDivByZero, "Division by zero")
+	}
+	if a == math.MinInt64 && b == -1 {
+		return overflowResponse(command)
+	}
+	resp := intResponse(command, a/b)
+	r := a % b
+	resp.Remainder = &r
+	return resp
+}
@@ -0,0 +1,135 @@
+// Filename: internal/ws/intmode_test.go
+
+package ws
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func intOp(command string, a, b int64) CommandResponse {
+	req := CommandRequest{Command: command, A: Integer(a), B: Integer(b), Mode: "int"}
+	return processCommand(DefaultRegistry, newSession(defaultHistorySize), req)
+}
+
+func TestIntModeBoundaries(t *testing.T) {
+	const max, min = math.MaxInt64, math.MinInt64
+	tests := []struct {
+		command string
+		a, b    int64
+		want    int64
+		rem     int64
+		code    string
+	}{
+		{"add", max - 1, 1, max, 0, ""},
+		{"add", max, 1, 0, 0, ErrCodeOverflow},
+		{"add", min, -1, 0, 0, ErrCodeOverflow},
+		{"add", max, min, -1, 0, ""},
+		{"subtract", min + 1, 1, min, 0, ""},
+		{"subtract", min, 1, 0, 0, ErrCodeOverflow},
+		{"subtract", 0, min, 0, 0, ErrCodeOverflow},
+		{"subtract", -1, min, max, 0, ""},
+		{"subtract", max, -1, 0, 0, ErrCodeOverflow},
+		{"multiply", max, 1, max, 0, ""},
+		{"multiply", max, -1, -max, 0, ""},
+		{"multiply", min, 1, min, 0, ""},
+		{"multiply", min, -1, 0, 0, ErrCodeOverflow},
+		{"multiply", -1, min, 0, 0, ErrCodeOverflow},
+		{"multiply", max/2 + 1, 2, 0, 0, ErrCodeOverflow},
+		{"multiply", 1 << 32, 1 << 31, 0, 0, ErrCodeOverflow},
+		{"multiply", 1 << 31, 1 << 31, 1 << 62, 0, ""},
+		{"multiply", min, 0, 0, 0, ""},
+		{"divide", 7, 2, 3, 1, ""},
+		{"divide", -7, 2, -3, -1, ""},
+		{"divide", 7, -2, -3, 1, ""},
+		{"divide", max, max, 1, 0, ""},
+		{"divide", min, 2, min / 2, 0, ""},
+		{"divide", min, max, -1, -1, ""},
+		{"divide", min, -1, 0, 0, ErrCodeOverflow},
+		{"divide", max, 0, 0, 0, ErrCodeDivByZero},
+	}
+	for _, tt := range tests {
+		resp := intOp(tt.command, tt.a, tt.b)
+		if resp.Code != tt.code {
+			t.Errorf("%s %d %d: got %+v expected %s", tt.command, tt.a, tt.b, resp, tt.code)
+			continue
+		}
+		if tt.code != "" {
+			if resp.IntResult != nil || resp.Result != nil {
+				t.Errorf("%s %d %d: error with a result: %+v", tt.command, tt.a, tt.b, resp)
+			}
+			continue
+		}
+		if resp.IntResult == nil || *resp.IntResult != tt.want || resp.Result != nil {
+			t.Errorf("%s %d %d: got %+v expected %d", tt.command, tt.a, tt.b, resp, tt.want)
+		}
+		if gotRem := resp.Remainder != nil; gotRem != (tt.command == "divide") || gotRem && *resp.Remainder != tt.rem {
+			t.Errorf("%s %d %d: got remainder %v expected %d", tt.command, tt.a, tt.b, resp.Remainder, tt.rem)
+		}
+	}
+}
+
+func TestIntModeJSON(t *testing.T) {
+	// 2^53 + 1 has no float64, so it only survives as an integer both ways
+	var req CommandRequest
+	if err := json.Unmarshal([]byte(`{"command":"add","a":9007199254740993,"b":0,"mode":"int"}`), &req); err != nil {
+		t.Fatal(err)
+	}
+	s := newSession(defaultHistorySize)
+	b, _ := json.Marshal(processCommand(DefaultRegistry, s, req))
+	if want := `{"command":"add","int_result":9007199254740993}`; unstamped(string(b)) != want {
+		t.Errorf("got %s expected %s", b, want)
+	}
+	if _, ok := s.LastResult(); ok {
+		t.Error("int result became ans")
+	}
+
+	// Float mode is as it was
+	req.Mode = ""
+	if resp := processCommand(DefaultRegistry, s, req); resp.Result == nil || *resp.Result != 9007199254740992 {
+		t.Errorf("float mode: got %+v", resp)
+	}
+}
+
+func TestIntModeErrors(t *testing.T) {
+	s := newSession(defaultHistorySize)
+	processCommand(DefaultRegistry, s, CommandRequest{Command: "divide", A: Num(1), B: Num(4)})
+	tests := []struct {
+		name string
+		req  CommandRequest
+		code string
+	}{
+		{"fractional a", CommandRequest{Command: "add", A: Num(1.5), B: Num(1), Mode: "int"}, ErrCodeNotInteger},
+		{"fractional b", CommandRequest{Command: "multiply", A: Num(2), B: Num(0.1), Mode: "int"}, ErrCodeNotInteger},
+		{"fractional ans", CommandRequest{Command: "add", A: Operand{Ref: ansOperand}, B: Num(1), Mode: "int"}, ErrCodeNotInteger},
+		{"out of range", CommandRequest{Command: "add", A: Num(1e19), B: Num(1), Mode: "int"}, ErrCodeOverflow},
+		{"unknown mode", CommandRequest{Command: "add", A: Num(1), B: Num(1), Mode: "decimal"}, ErrCodeInvalidMode},
+		{"precise in int mode", CommandRequest{Command: "add", AStr: "1", BStr: "1", Mode: "int"}, ErrCodeInvalidMode},
+	}
+	for _, tt := range tests {
+		if resp := processCommand(DefaultRegistry, s, tt.req); resp.Code != tt.code {
+			t.Errorf("%s: got %+v expected %s", tt.name, resp, tt.code)
+		}
+	}
+
+	// A whole-valued variable is fine
+	processCommand(DefaultRegistry, s, CommandRequest{Command: "set", Name: "n", A: Num(40)})
+	resp := processCommand(DefaultRegistry, s, CommandRequest{Command: "add", AVar: "n", B: Num(2), Mode: "int"})
+	if resp.IntResult == nil || *resp.IntResult != 42 {
+		t.Errorf("variable: got %+v", resp)
+	}
+}
+
+func TestIntModeMsgpackOperands(t *testing.T) {
+	for _, v := range []int64{0, -1, 127, 1 << 40, 9007199254740993, math.MinInt64, math.MaxInt64} {
+		b, err := marshalMsgpack(CommandRequest{Command: "add", A: Integer(v)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var req CommandRequest
+		if err := unmarshalMsgpack(b, &req); err != nil || !req.A.IsInt || req.A.Int != v {
+			t.Errorf("%d: got %+v, %v", v, req.A, err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package ws
+
+// Filename: internal/ws/ipfilter.go
+
+import (
+	"log"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ParseIPList reads a comma-separated list of CIDRs or single addresses,
+// as taken by Options.TrustedProxies, AllowIPs and DenyIPs
+func ParseIPList(s string) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	for _, field := range strings.Split(s, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		if !strings.Contains(field, "/") {
+			addr, err := netip.ParseAddr(field)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(field)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, p.Masked())
+	}
+	return out, nil
+}
+
+func inList(addr netip.Addr, list []netip.Prefix) bool {
+	for _, p := range list {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// May a client at remote (as clientAddr resolved it) connect? A denied
+// address is refused even if also allowed; an empty allow list allows
+// everyone else. An address that can't be parsed is only let through when
+// there is no allow list.
+func ipAllowed(remote string, allow, deny []netip.Prefix) bool {
+	addr, ok := parseHop(remote)
+	if !ok {
+		return len(allow) == 0
+	}
+	if inList(addr, deny) {
+		return false
+	}
+	return len(allow) == 0 || inList(addr, allow)
+}
+
+// Minimum time between "blocked" log lines
+const blockedLogInterval = 10 * time.Second
+
+// Writes at most one line per blockedLogInterval, folding the attempts in
+// between into a count, so a flood of blocked clients can't flood the log
+type blockedLog struct {
+	mu         sync.Mutex
+	last       time.Time
+	suppressed int
+}
+
+func (b *blockedLog) report(logger *log.Logger, remote string, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if now.Sub(b.last) < blockedLogInterval {
+		b.suppressed++
+		return
+	}
+	if b.suppressed > 0 {
+		logger.Printf("blocked connection from %s (and %d more since the last report)", remote, b.suppressed)
+	} else {
+		logger.Printf("blocked connection from %s", remote)
+	}
+	b.last, b.suppressed = now, 0
+}
@@ -0,0 +1,99 @@
+// Filename: internal/ws/ipfilter_test.go
+
+package ws
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestIPAllowed(t *testing.T) {
+	tests := []struct {
+		name        string
+		allow, deny string
+		remote      string
+		expected    bool
+	}{
+		{"empty lists allow all", "", "", "203.0.113.7:5000", true},
+		{"empty allow list, denied ipv4", "", "203.0.113.0/24", "203.0.113.7:5000", false},
+		{"empty allow list, other ipv4", "", "203.0.113.0/24", "198.51.100.1:5000", true},
+		{"inside allow list", "10.0.0.0/8", "", "10.1.2.3:5000", true},
+		{"outside allow list", "10.0.0.0/8", "", "11.1.2.3:5000", false},
+		{"deny wins over overlapping allow", "10.0.0.0/8", "10.6.0.0/16", "10.6.1.1:5000", false},
+		{"allowed next to the denied range", "10.0.0.0/8", "10.6.0.0/16", "10.7.1.1:5000", true},
+		{"deny wins over identical allow", "10.6.6.6", "10.6.6.6", "10.6.6.6:5000", false},
+		{"ipv6 allowed", "fd00::/8", "", "[fd12::1]:5000", true},
+		{"ipv6 outside allow list", "fd00::/8", "", "[2001:db8::1]:5000", false},
+		{"ipv6 denied inside allowed", "2001:db8::/32", "2001:db8:bad::/48", "[2001:db8:bad::1]:5000", false},
+		{"mixed families", "10.0.0.0/8, fd00::/8", "", "[fd12::1]:5000", true},
+		{"mapped ipv4 matches ipv4 entry", "10.0.0.0/8", "", "[::ffff:10.1.2.3]:5000", true},
+		{"ipv4 doesn't match ipv6 entry", "fd00::/8", "", "10.1.2.3:5000", false},
+		{"resolved address without port", "198.51.100.0/24", "", "198.51.100.4", true},
+		{"unparsable address, no allow list", "", "10.0.0.0/8", "pipe", true},
+		{"unparsable address, allow list", "10.0.0.0/8", "", "pipe", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allow, err := ParseIPList(tt.allow)
+			if err != nil {
+				t.Fatal(err)
+			}
+			deny, err := ParseIPList(tt.deny)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ipAllowed(tt.remote, allow, deny); got != tt.expected {
+				t.Errorf("got %v expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseIPListRejectsGarbage(t *testing.T) {
+	for _, s := range []string{"10.0.0.0/33", "proxy.local", "10.0.0.1/8/8"} {
+		if _, err := ParseIPList(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestDeniedClientGets403(t *testing.T) {
+	deny, _ := ParseIPList("127.0.0.0/8, ::1")
+	h := NewHandler(Options{DenyIPs: deny})
+	url := startServer(t, h)
+
+	_, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {allowedOrigins[0]}})
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("dial: got %v, %v expected 403", resp, err)
+	}
+	if got := h.stats.blocked.Load(); got != 1 {
+		t.Errorf("blocked counter: went up by %d expected 1", got)
+	}
+}
+
+func TestBlockedLogIsRateLimited(t *testing.T) {
+	var b blockedLog
+	var out bytes.Buffer
+	logger := log.New(&out, "", 0)
+	now := time.Now()
+	for i := range 100 {
+		b.report(logger, "203.0.113.7", now.Add(time.Duration(i)*time.Millisecond))
+	}
+	if b.suppressed != 99 {
+		t.Errorf("suppressed: got %d expected 99", b.suppressed)
+	}
+	b.report(logger, "203.0.113.7", now.Add(blockedLogInterval))
+	if b.suppressed != 0 {
+		t.Errorf("after the interval: got %d suppressed expected 0", b.suppressed)
+	}
+	if got := strings.Count(out.String(), "\n"); got != 2 {
+		t.Errorf("log lines: got %d expected 2:\n%s", got, out.String())
+	}
+}
@@ -0,0 +1,231 @@
+package ws
+
+// Filename: internal/ws/jsonguard.go
+
+import (
+	"fmt"
+	"io"
+	"net/netip"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+)
+
+// Error codes for a command frame refused before it is decoded
+const (
+	ErrCodeJSONTooDeep       = "ERR_JSON_TOO_DEEP"
+	ErrCodeJSONNumberTooLong = "ERR_JSON_NUMBER_TOO_LONG"
+	ErrCodeJSONStringTooLong = "ERR_JSON_STRING_TOO_LONG"
+	ErrCodeJSONTooManyTokens = "ERR_JSON_TOO_MANY_TOKENS"
+)
+
+// Defaults for the Options.MaxJSON* limits. A full batch of ordinary
+// commands stays well inside all of them.
+const (
+	defaultMaxJSONDepth        = 32
+	defaultMaxJSONNumberLength = 100
+	defaultMaxJSONStringLength = 16 << 10
+	defaultMaxJSONTokens       = 10000
+	defaultMaxJSONViolations   = 10
+)
+
+// Command frames refused by the JSON limits, across all connections
+var jsonViolationCounter uint64
+
+// The limits a command frame is held to before it is decoded
+type jsonLimits struct {
+	depth, number, str, tokens int
+}
+
+func (o Options) jsonLimits() jsonLimits {
+	return jsonLimits{
+		depth:  o.MaxJSONDepth,
+		number: o.MaxJSONNumberLength,
+		str:    o.MaxJSONStringLength,
+		tokens: o.MaxJSONTokens,
+	}
+}
+
+// Scan payload for the first limit it breaks, without decoding it: nil if
+// it breaks none. Only the shape is measured; whether it is valid JSON is
+// left to the decoder. Strings are measured as sent, escapes and all.
+// Objects and arrays, keys and values each count as a token.
+func (l jsonLimits) check(payload []byte) *CommandResponse {
+	s := jsonScanner{l: l}
+	if refused := s.scan(payload); refused != nil {
+		return refused
+	}
+	return s.end()
+}
+
+// Applies jsonLimits to a document read in pieces, as check does to one
+// held whole. A string or number cut by the end of a piece carries on into
+// the next, so a long one is refused as soon as it is too long rather than
+// once it has all been read.
+type jsonScanner struct {
+	l             jsonLimits
+	depth, tokens int
+	token         byte // the token the last piece ended inside: '"', '0' for a number, 'a' for a word; 0 for none
+	n             int  // its bytes so far, a string's opening quote not included
+	escaped       bool // a string's last byte so far was a backslash
+}
+
+// Scan the next piece of the document
+func (s *jsonScanner) scan(p []byte) *CommandResponse {
+	for i := 0; i < len(p); {
+		if s.token == 0 {
+			switch b := p[i]; {
+			case b == '{' || b == '[':
+				if s.depth++; s.depth > s.l.depth {
+					return refuseJSON(ErrCodeJSONTooDeep, fmt.Sprintf("JSON nested too deeply (max %d levels)", s.l.depth))
+				}
+				i++
+				if refused := s.count(); refused != nil {
+					return refused
+				}
+				continue
+			case b == '}' || b == ']':
+				s.depth = max(s.depth-1, 0)
+			case b == '"':
+				s.token = '"'
+			case b == '-' || b >= '0' && b <= '9':
+				s.token = '0'
+				continue
+			case b >= 'a' && b <= 'z':
+				s.token = 'a'
+				continue
+			}
+			i++ // whitespace, separators, and whatever the decoder will reject
+			continue
+		}
+		start := i
+		switch s.token {
+		case '"':
+			for ; i < len(p); i++ {
+				if s.escaped {
+					s.escaped = false
+				} else if p[i] == '\\' {
+					s.escaped = true
+				} else if p[i] == '"' {
+					break
+				}
+			}
+			if s.n += i - start; s.n > s.l.str {
+				return refuseJSON(ErrCodeJSONStringTooLong, fmt.Sprintf("JSON string too long: %d bytes (max %d)", s.n, s.l.str))
+			}
+			if i == len(p) {
+				return nil // the string goes on in the next piece
+			}
+			i++ // the closing quote
+		case '0':
+			for ; i < len(p) && isNumberByte(p[i]); i++ {
+			}
+			if s.n += i - start; s.n > s.l.number {
+				return refuseJSON(ErrCodeJSONNumberTooLong, fmt.Sprintf("JSON number too long: %d bytes (max %d)", s.n, s.l.number))
+			}
+			if i == len(p) {
+				return nil // as may the number...
+			}
+		case 'a':
+			for ; i < len(p) && p[i] >= 'a' && p[i] <= 'z'; i++ {
+			}
+			if i == len(p) {
+				return nil // ...or the word
+			}
+		}
+		s.token, s.n = 0, 0
+		if refused := s.count(); refused != nil {
+			return refused
+		}
+	}
+	return nil
+}
+
+// Finish the document, counting a token it ends inside
+func (s *jsonScanner) end() *CommandResponse {
+	if s.token == 0 {
+		return nil
+	}
+	s.token, s.n = 0, 0
+	return s.count()
+}
+
+func (s *jsonScanner) count() *CommandResponse {
+	if s.tokens++; s.tokens > s.l.tokens {
+		return refuseJSON(ErrCodeJSONTooManyTokens, fmt.Sprintf("JSON has too many tokens (max %d)", s.l.tokens))
+	}
+	return nil
+}
+
+func refuseJSON(code, msg string) *CommandResponse {
+	resp := errorResponse("", code, msg)
+	return &resp
+}
+
+// A command frame refused by the JSON limits part way through reading it
+type jsonRefused struct {
+	resp *CommandResponse
+}
+
+func (e jsonRefused) Error() string { return e.resp.Error }
+
+// Reads a command document through a jsonScanner, failing with jsonRefused
+// at the first limit it breaks, so the decoder never buffers more of an
+// abusive document than that
+type jsonGuard struct {
+	r    io.Reader
+	scan jsonScanner
+}
+
+func (g *jsonGuard) Read(p []byte) (int, error) {
+	n, err := g.r.Read(p)
+	if refused := g.scan.scan(p[:n]); refused != nil {
+		return 0, jsonRefused{refused}
+	}
+	if err == io.EOF {
+		if refused := g.scan.end(); refused != nil {
+			return 0, jsonRefused{refused}
+		}
+	}
+	return n, err
+}
+
+func isNumberByte(b byte) bool {
+	return b >= '0' && b <= '9' || b == '.' || b == 'e' || b == 'E' || b == '+' || b == '-'
+}
+
+// Count a frame refused by the JSON limits and build its reply
+func (h *Handler) jsonViolation(c *client, resp *CommandResponse) CommandResponse {
+	atomic.AddUint64(&jsonViolationCounter, 1)
+	s := c.session
+	s.stats.usage.observe(usageInvalidJSON, 0)
+	c.jsonViolations++
+	h.opts.Logger.Printf("%s: %s (%d of %d)", s.label(), resp.Error, c.jsonViolations, h.opts.MaxJSONViolations)
+	return s.stamp(*resp)
+}
+
+// Deal with a client that has reached Options.MaxJSONViolations, once its
+// last reply is queued: close it with 1008 after that reply or, with
+// Options.JSONViolationBan, ban its address for that long and close every
+// connection from it
+func (h *Handler) abusiveJSON(c *client) {
+	const reason = "too many malformed commands"
+	addr, ok := parseHop(c.session.conn.RemoteAddr)
+	if h.opts.JSONViolationBan <= 0 || !ok {
+		c.closeAfterQueued(websocket.ClosePolicyViolation, reason)
+		return
+	}
+	now := h.opts.Clock.Now()
+	expires := now.Add(h.opts.JSONViolationBan).UTC()
+	b := ban{
+		Prefix:    netip.PrefixFrom(addr, addr.BitLen()),
+		CreatedAt: now.UTC(),
+		ExpiresAt: &expires,
+		Reason:    reason,
+	}
+	if err := h.bans.add(b, now); err != nil {
+		h.opts.Logger.Printf("save ban list: %v", err)
+	}
+	kicked := h.hub.kickBanned(b.Prefix)
+	h.opts.Logger.Printf("banned %s for %s: %s (kicked %d)", b.Prefix, h.opts.JSONViolationBan, reason, kicked)
+}
@@ -0,0 +1,187 @@
+// Filename: internal/ws/jsonguard_test.go
+
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestJSONLimitBoundaries(t *testing.T) {
+	limits := jsonLimits{depth: 3, number: 5, str: 4, tokens: 6}
+	nested := func(n int) string { return strings.Repeat("[", n) + strings.Repeat("]", n) }
+
+	tests := []struct {
+		payload  string
+		expected string // error code; "" for none
+	}{
+		{nested(3), ""},
+		{nested(4), ErrCodeJSONTooDeep},
+		{`{"a":{"b":[1]}}`, ""},
+		{`{"a":{"b":[[1]]}}`, ErrCodeJSONTooDeep},
+		{`[[[]]] [[[]]]`, ""}, // depth is per document, as in NDJSON
+		{`[12345]`, ""},
+		{`[123456]`, ErrCodeJSONNumberTooLong},
+		{`[-1e+9]`, ""},
+		{`[-1e+10]`, ErrCodeJSONNumberTooLong},
+		{`["abcd"]`, ""},
+		{`["abcde"]`, ErrCodeJSONStringTooLong},
+		{`["ab\""]`, ""},
+		{`["ab\"\""]`, ErrCodeJSONStringTooLong},
+		{`{"abcde":1}`, ErrCodeJSONStringTooLong}, // keys too
+		{`["abcde`, ErrCodeJSONStringTooLong},     // unterminated
+		{`{"a":1,"b":true}`, ""},                  // { a 1 b true
+		{`{"a":1,"b":true,"c":null}`, ErrCodeJSONTooManyTokens},
+		{`[1,2,3,4,5]`, ""},
+		{`[1,2,3,4,5,6]`, ErrCodeJSONTooManyTokens},
+		{`{"a" 1 ,,, }}}}`, ""}, // invalid, but that's the decoder's call
+	}
+	for _, tt := range tests {
+		got := ""
+		if resp := limits.check([]byte(tt.payload)); resp != nil {
+			got = resp.Code
+		}
+		if got != tt.expected {
+			t.Errorf("check(%s): got %q expected %q", tt.payload, got, tt.expected)
+		}
+	}
+}
+
+func TestJSONLimitsSpareOrdinaryBatches(t *testing.T) {
+	h := NewHandler(Options{})
+	entries := make([]string, defaultMaxBatchSize)
+	for i := range entries {
+		entries[i] = `{"command":"add","a":1.5,"b":-2.25e3,"id":"req-` + strings.Repeat("x", 20) + `"}`
+	}
+	var out []CommandResponse
+	if err := json.Unmarshal(commandReply(t, h, "["+strings.Join(entries, ",")+"]"), &out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != defaultMaxBatchSize || out[0].Error != "" || out[len(out)-1].Error != "" {
+		t.Errorf("full batch: got %d responses, first %+v", len(out), out[0])
+	}
+}
+
+func TestJSONViolationsReplyThenClose(t *testing.T) {
+	before := atomic.LoadUint64(&jsonViolationCounter)
+	conn := dial(t, startServer(t, NewHandler(Options{MaxJSONDepth: 4, MaxJSONViolations: 2})))
+
+	deep := strings.Repeat("[", 5) + strings.Repeat("]", 5)
+	for range 2 {
+		var resp CommandResponse
+		if err := json.Unmarshal([]byte(roundTrip(t, conn, deep)), &resp); err != nil || resp.Code != ErrCodeJSONTooDeep {
+			t.Fatalf("too deep: got %+v, %v", resp, err)
+		}
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err := readData(conn)
+	var ce *websocket.CloseError
+	if !errors.As(err, &ce) || ce.Code != websocket.ClosePolicyViolation || ce.Text != "too many malformed commands" {
+		t.Fatalf("after the second violation: got %v expected 1008", err)
+	}
+	if got := atomic.LoadUint64(&jsonViolationCounter) - before; got < 2 {
+		t.Errorf("json_violations went up by %d expected at least 2", got)
+	}
+}
+
+func TestJSONViolationsBan(t *testing.T) {
+	h, url := drainServer(t, Options{MaxJSONTokens: 3, MaxJSONViolations: 1, JSONViolationBan: time.Hour})
+	wsURL := "ws" + strings.TrimPrefix(url, "http") + "/ws"
+	conn := dial(t, wsURL)
+	bystander := dial(t, wsURL)
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`[1,2,3,4]`)); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []*websocket.Conn{conn, bystander} {
+		_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var err error
+		for err == nil {
+			_, _, err = readData(c)
+		}
+		var ce *websocket.CloseError
+		if !errors.As(err, &ce) || ce.Code != websocket.ClosePolicyViolation || ce.Text != "banned" {
+			t.Errorf("got %v expected 1008 banned", err)
+		}
+	}
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Origin": {allowedOrigins[0]}})
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("dial after the ban: got %v, %v expected 403", resp, err)
+	}
+	if bans := h.bans.list(time.Now()); len(bans) != 1 || bans[0].ExpiresAt == nil || bans[0].Reason != "too many malformed commands" {
+		t.Errorf("ban list: got %+v", bans)
+	}
+}
+
+func FuzzJSONLimits(f *testing.F) {
+	for _, seed := range []string{`{"command":"add","a":1,"b":2}`, `[[[["x"]]]]`, `"\`, `-1e+-.5`, `{"a":"\\\""}`} {
+		f.Add([]byte(seed))
+	}
+	limits := jsonLimits{depth: 4, number: 8, str: 8, tokens: 16}
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		if limits.check(payload) != nil {
+			return
+		}
+		// Whatever passes is shallow enough for the decoder to reject or take
+		var v any
+		if json.Unmarshal(payload, &v) == nil && depthOf(v) > limits.depth {
+			t.Errorf("%q passed at depth %d", payload, depthOf(v))
+		}
+	})
+}
+
+func depthOf(v any) int {
+	deepest := 0
+	switch v := v.(type) {
+	case []any:
+		for _, e := range v {
+			deepest = max(deepest, depthOf(e))
+		}
+	case map[string]any:
+		for _, e := range v {
+			deepest = max(deepest, depthOf(e))
+		}
+	default:
+		return 0
+	}
+	return deepest + 1
+}
+
+// The parser entry point, with only "add" registered so nothing a fuzzed
+// frame runs needs a live connection
+func FuzzHandleCommandPayload(f *testing.F) {
+	for _, seed := range []string{
+		`{"command":"add","a":1,"b":2}`,
+		`[{"command":"add","a":1,"b":2},{"command":"nope"}]`,
+		"{\"command\":\"add\"}\n{\"a\":",
+		strings.Repeat("[", 40),
+		`{"command":"add","a":` + strings.Repeat("9", 200) + `}`,
+	} {
+		f.Add([]byte(seed))
+	}
+	reg := &CommandRegistry{commands: make(map[string]registeredCommand)}
+	add := func(s *Session, req CommandRequest) CommandResponse { return CommandResponse{Command: req.Command} }
+	if err := reg.Register("add", add); err != nil {
+		f.Fatal(err)
+	}
+	h := NewHandler(Options{Registry: reg})
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		if !isCommandPayload(payload) {
+			return
+		}
+		reply, err := h.handleCommandPayload(testClient(), payload)
+		if err != nil {
+			t.Fatalf("%q: %v", payload, err)
+		}
+		if len(reply) == 0 {
+			t.Fatalf("%q: no reply", payload)
+		}
+	})
+}
@@ -0,0 +1,55 @@
+// Filename: internal/ws/limit_test.go
+
+package ws
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestOversizedFrameNamesLimit(t *testing.T) {
+	url := startServer(t, NewHandler(Options{MaxMessageSize: 1000}))
+	conn := dial(t, url)
+	before := atomic.LoadUint64(&oversizedCounter)
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(strings.Repeat("x", 1001))); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err := conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseMessageTooBig || closeErr.Text != "message too big (max 1000 bytes)" {
+		t.Fatalf("got %v expected 1009 naming the limit", err)
+	}
+	if got := atomic.LoadUint64(&oversizedCounter); got != before+1 {
+		t.Errorf("oversized counter: got %d expected %d", got, before+1)
+	}
+}
+
+func TestSetLimit(t *testing.T) {
+	url := startServer(t, NewHandler(Options{MaxReadLimit: 65536}))
+	conn := dial(t, url)
+	big := `{"command":"wordcount","text":"` + strings.Repeat("x", 8000) + `"}`
+
+	for _, tc := range []struct{ send, expected string }{
+		{`{"command":"set_limit","a":65537}`, `{"command":"set_limit","error":"Read limit too high (max 65536 bytes)","code":"ERR_LIMIT_TOO_HIGH"}`},
+		{`{"command":"set_limit","a":10}`, `{"command":"set_limit","error":"a must be at least 64 bytes","code":"ERR_INVALID_RANGE"}`},
+		{`{"command":"set_limit","a":65536}`, `{"command":"set_limit","data":{"read_limit":65536}}`},
+	} {
+		if got := roundTrip(t, conn, tc.send); got != tc.expected {
+			t.Errorf("send %s: got %s expected %s", tc.send, got, tc.expected)
+		}
+	}
+	// Over the default 4 KiB, so it would have closed the connection before
+	if got := roundTrip(t, conn, big); !strings.HasPrefix(got, `{"command":"wordcount","data":{"words":1}`) {
+		t.Errorf("big frame after raise: got %.80s", got)
+	}
+
+	// Without the raise the same frame is refused
+	expectClose(t, dial(t, url), websocket.TextMessage, []byte(big), websocket.CloseMessageTooBig)
+}
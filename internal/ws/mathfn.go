@@ -0,0 +1,54 @@
+package ws
+
+// Filename: internal/ws/mathfn.go
+
+import (
+	"fmt"
+	"math"
+)
+
+// Error code for a function given an input outside its domain
+const ErrCodeDomain = "ERR_DOMAIN"
+
+// Wrap a one-operand function so a is resolved against the session first
+func unaryOp(fn func(req CommandRequest, a float64) CommandResponse) CommandHandler {
+	return func(s *Session, req CommandRequest) CommandResponse {
+		a, errResp := s.resolve(req.Command, req.A, req.AVar)
+		if errResp != nil {
+			return *errResp
+		}
+		return fn(req, a)
+	}
+}
+
+// A trig function of a, in radians unless unit is "deg". There is no pole
+// to hit: π/2 isn't a float64, so tan near it is huge (about 1.6e16) but
+// finite, and comes back as a result like any other.
+func trig(fn func(float64) float64) func(CommandRequest, float64) CommandResponse {
+	return func(req CommandRequest, a float64) CommandResponse {
+		switch req.Unit {
+		case "", "rad":
+		case "deg":
+			a *= math.Pi / 180
+		default:
+			return errorResponse(req.Command, ErrCodeUnknownUnit, fmt.Sprintf("Unknown unit %q (want rad or deg)", req.Unit))
+		}
+		return resultResponse(req.Command, fn(a))
+	}
+}
+
+// A logarithm of a, defined for a > 0 only. Without the check 0 gives -Inf
+// and negatives NaN, neither of which JSON can carry.
+func logarithm(fn func(float64) float64) func(CommandRequest, float64) CommandResponse {
+	return func(req CommandRequest, a float64) CommandResponse {
+		if a <= 0 {
+			return errorResponse(req.Command, ErrCodeDomain, fmt.Sprintf("%s is only defined for a > 0", req.Command))
+		}
+		return resultResponse(req.Command, fn(a))
+	}
+}
+
+// e^a; too large an a overflows, which resultResponse reports as not finite
+func runExp(req CommandRequest, a float64) CommandResponse {
+	return resultResponse(req.Command, math.Exp(a))
+}
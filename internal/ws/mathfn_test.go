@@ -0,0 +1,91 @@
+// Filename: internal/ws/mathfn_test.go
+
+package ws
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMathFunctions(t *testing.T) {
+	const eps = 1e-9
+	tests := []struct {
+		command string
+		a       float64
+		unit    string
+		want    float64
+	}{
+		{"sin", 0, "", 0},
+		{"sin", math.Pi / 2, "", 1},
+		{"sin", math.Pi / 6, "rad", 0.5},
+		{"sin", 30, "deg", 0.5},
+		{"sin", 180, "deg", 0},
+		{"cos", 0, "", 1},
+		{"cos", math.Pi, "", -1},
+		{"cos", 60, "deg", 0.5},
+		{"tan", math.Pi / 4, "", 1},
+		{"tan", 45, "deg", 1},
+		{"tan", -45, "deg", -1},
+		{"log", 1, "", 0},
+		{"log", math.E, "", 1},
+		{"log", 0.5, "", -math.Ln2},
+		{"log10", 1000, "", 3},
+		{"log10", 0.01, "", -2},
+		{"exp", 0, "", 1},
+		{"exp", 1, "", math.E},
+		{"exp", -1, "", 1 / math.E},
+	}
+	for _, tt := range tests {
+		req := CommandRequest{Command: tt.command, A: Num(tt.a), Unit: tt.unit}
+		resp := processCommand(DefaultRegistry, newSession(defaultHistorySize), req)
+		if resp.Code != "" || resp.Result == nil || math.Abs(*resp.Result-tt.want) > eps {
+			t.Errorf("%s(%v %s): got %+v expected %v", tt.command, tt.a, tt.unit, resp, tt.want)
+		}
+	}
+}
+
+func TestTanNearPole(t *testing.T) {
+	// π/2 rounds to just below the pole, so tan is huge but finite, and
+	// 90° lands on the same float
+	for _, req := range []CommandRequest{
+		{Command: "tan", A: Num(math.Pi / 2)},
+		{Command: "tan", A: Num(90), Unit: "deg"},
+	} {
+		resp := processCommand(DefaultRegistry, newSession(defaultHistorySize), req)
+		if resp.Code != "" || resp.Result == nil || *resp.Result < 1e15 || math.IsInf(*resp.Result, 0) {
+			t.Errorf("%+v: got %+v", req, resp)
+		}
+	}
+	resp := processCommand(DefaultRegistry, newSession(defaultHistorySize), CommandRequest{Command: "tan", A: Num(-90), Unit: "deg"})
+	if resp.Result == nil || *resp.Result > -1e15 {
+		t.Errorf("tan(-90°): got %+v", resp)
+	}
+}
+
+func TestMathFunctionErrors(t *testing.T) {
+	tests := []struct {
+		req  CommandRequest
+		code string
+	}{
+		{CommandRequest{Command: "log", A: Num(0)}, ErrCodeDomain},
+		{CommandRequest{Command: "log", A: Num(-1)}, ErrCodeDomain},
+		{CommandRequest{Command: "log10", A: Num(0)}, ErrCodeDomain},
+		{CommandRequest{Command: "log10", A: Num(-100)}, ErrCodeDomain},
+		{CommandRequest{Command: "exp", A: Num(1000)}, ErrCodeNotFinite},
+		{CommandRequest{Command: "sin", A: Num(1), Unit: "grad"}, ErrCodeUnknownUnit},
+		{CommandRequest{Command: "cos", A: Operand{Ref: ansOperand}}, ErrCodeNoResult},
+	}
+	for _, tt := range tests {
+		resp := processCommand(DefaultRegistry, newSession(defaultHistorySize), tt.req)
+		if resp.Code != tt.code || resp.Result != nil {
+			t.Errorf("%+v: got %+v expected %s", tt.req, resp, tt.code)
+		}
+	}
+
+	// Going through ans works like it does for add
+	s := newSession(defaultHistorySize)
+	processCommand(DefaultRegistry, s, CommandRequest{Command: "exp", A: Num(2)})
+	if resp := processCommand(DefaultRegistry, s, CommandRequest{Command: "log", A: Operand{Ref: ansOperand}}); resp.Result == nil || math.Abs(*resp.Result-2) > 1e-12 {
+		t.Errorf("log(ans): got %+v", resp)
+	}
+}
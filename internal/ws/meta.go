@@ -0,0 +1,99 @@
+package ws
+
+// Filename: internal/ws/meta.go
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Limits on the metadata a connection brings in its /ws query
+const (
+	maxMetadataKeys  = 16  // keys kept per connection
+	maxMetadataValue = 128 // bytes kept of each value
+)
+
+// Query parameters /ws understands itself. Any other key is metadata if
+// Options.MetadataKeys lists it.
+var reservedQueryKeys = map[string]bool{
+	"encoding": true, "v": true, "resume": true, "last_bseq": true, "name": true, "room": true,
+	"heartbeat": true, "token": true,
+}
+
+// What a client said about itself in its /ws query
+type connQuery struct {
+	name string            // nickname to start with; "" for none
+	room string            // room to join; see connInfo.Room
+	meta map[string]string // listed keys, sanitized; nil for none
+
+	heartbeat string // heartbeatServer or heartbeatClient
+}
+
+// Read the query of an upgrade request: ?name= must pass the nick rules,
+// ?room= the same ones, and metadata values are trimmed to
+// maxMetadataValue bytes of printable text, since they end up in logs.
+// Unlisted keys are ignored, or refused under Options.StrictQuery.
+// ?heartbeat= picks the heartbeat mode; see heartbeatMode.
+func (h *Handler) parseConnQuery(r *http.Request) (connQuery, error) {
+	query := r.URL.Query()
+	q := connQuery{name: query.Get("name"), room: query.Get("room"), heartbeat: heartbeatMode(query.Get("heartbeat"))}
+	if q.name != "" {
+		if err := validNick(q.name); err != nil {
+			return q, fmt.Errorf("name: %v", err)
+		}
+	}
+	if q.room != "" && (len(q.room) > maxNickLen || !nickPattern.MatchString(q.room)) {
+		return q, fmt.Errorf("room: must be 1 to %d letters, digits and underscores", maxNickLen)
+	}
+
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		switch {
+		case reservedQueryKeys[key]:
+		case h.metadataKeys[key]:
+			if len(q.meta) == maxMetadataKeys {
+				return q, fmt.Errorf("too many metadata keys (max %d)", maxMetadataKeys)
+			}
+			if q.meta == nil {
+				q.meta = make(map[string]string)
+			}
+			q.meta[key] = sanitizeMetadata(query.Get(key))
+		case h.opts.StrictQuery:
+			return q, fmt.Errorf("unknown query parameter %q", key)
+		}
+	}
+	return q, nil
+}
+
+// Printable runes only, at most maxMetadataValue bytes of them
+func sanitizeMetadata(v string) string {
+	var b strings.Builder
+	for _, r := range v {
+		if r == utf8.RuneError || !unicode.IsPrint(r) {
+			continue
+		}
+		if b.Len()+utf8.RuneLen(r) > maxMetadataValue {
+			break
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Is nick held by a live connection? Checked before the upgrade so a
+// taken ?name= gets a 409; a race with another connection claiming it
+// meanwhile just leaves this one without a nickname.
+func (h *hub) nickTaken(nick string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, taken := h.nicks[strings.ToLower(nick)]
+	return taken
+}
@@ -0,0 +1,96 @@
+// Filename: internal/ws/meta_test.go
+
+package ws
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// Dial url and return the HTTP status the upgrade got
+func upgradeStatus(t *testing.T, url string) int {
+	t.Helper()
+	conn, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {allowedOrigins[0]}})
+	if err == nil {
+		conn.Close()
+		return http.StatusSwitchingProtocols
+	}
+	if resp == nil {
+		t.Fatalf("dial: %v", err)
+	}
+	return resp.StatusCode
+}
+
+func TestQueryName(t *testing.T) {
+	url := startServer(t, NewHandler(Options{}))
+	conn := dial(t, url+"?name=alice")
+	if got := who(t, conn); len(got) != 1 || got[0].Nick != "alice" {
+		t.Errorf("who: got %+v", got)
+	}
+
+	for _, tc := range []struct {
+		query  string
+		status int
+	}{
+		{"?name=no%20spaces", http.StatusBadRequest},
+		{"?name=" + strings.Repeat("a", maxNickLen+1), http.StatusBadRequest},
+		{"?room=lobby!", http.StatusBadRequest},
+		{"?name=ALICE", http.StatusConflict},
+		{"?name=bob", http.StatusSwitchingProtocols},
+	} {
+		if got := upgradeStatus(t, url+tc.query); got != tc.status {
+			t.Errorf("%s: got %d expected %d", tc.query, got, tc.status)
+		}
+	}
+}
+
+func TestQueryMetadata(t *testing.T) {
+	h := NewHandler(Options{MetadataKeys: []string{"tags", "app"}})
+	mux := http.NewServeMux()
+	mux.Handle("/ws", h)
+	mux.Handle("/admin/", h.AdminHandler("secret"))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+
+	// Control characters are dropped and long values cut short; junk isn't
+	// listed, so it's ignored
+	query := "?room=lobby&tags=a,b&app=x%0Ay%1B&junk=1&app=second"
+	_, welcome := dialDecoded(t, url+query)
+	expected := map[string]string{"tags": "a,b", "app": "xy"}
+	if welcome.Room != "lobby" || !reflect.DeepEqual(welcome.Metadata, expected) {
+		t.Errorf("welcome: got room %q metadata %v", welcome.Room, welcome.Metadata)
+	}
+	if got := sanitizeMetadata(strings.Repeat("é", 100)); len(got) != maxMetadataValue {
+		t.Errorf("long value kept %d bytes", len(got))
+	}
+
+	req, _ := http.NewRequest("GET", srv.URL+"/admin/connections", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("admin: %v", err)
+	}
+	defer resp.Body.Close()
+	var conns []connectionInfo
+	if err := json.NewDecoder(resp.Body).Decode(&conns); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(conns) != 1 || conns[0].Room != "lobby" || !reflect.DeepEqual(conns[0].Metadata, expected) {
+		t.Errorf("admin listing: got %+v", conns)
+	}
+
+	strict := startServer(t, NewHandler(Options{MetadataKeys: []string{"tags"}, StrictQuery: true}))
+	if got := upgradeStatus(t, strict+"?tags=a&junk=1"); got != http.StatusBadRequest {
+		t.Errorf("strict, unknown key: got %d expected 400", got)
+	}
+	if got := upgradeStatus(t, strict+"?tags=a&encoding=json"); got != http.StatusSwitchingProtocols {
+		t.Errorf("strict, known keys: got %d expected 101", got)
+	}
+}
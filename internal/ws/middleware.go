@@ -0,0 +1,147 @@
+package ws
+
+// Filename: internal/ws/middleware.go
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// ErrCodeMissingField means a command arrived without a field RequireFields
+// says it needs
+const ErrCodeMissingField = "ERR_MISSING_FIELD"
+
+// CommandMiddleware wraps command dispatch: it gets the handler for the
+// rest of the chain and returns one to run in its place, which may act
+// before or after calling next, or answer by itself without calling it.
+// The Session names the connection; see Session.ConnID and
+// Session.Metadata.
+type CommandMiddleware func(next CommandHandler) CommandHandler
+
+// Use adds middleware around every command this registry runs. The first
+// added is the outermost; looking the command up and running it is the
+// innermost. A panic in a middleware (or a command) becomes an
+// ERR_INTERNAL reply rather than taking the connection down.
+func (r *CommandRegistry) Use(mw ...CommandMiddleware) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.middleware = append(r.middleware, mw...)
+}
+
+// The whole chain around the registry's own dispatch
+func (r *CommandRegistry) chain() CommandHandler {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	h := r.dispatch
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		h = r.middleware[i](h)
+	}
+	return h
+}
+
+// Run req's command, or answer that there is none. The innermost handler,
+// so it sees the request as the middleware left it.
+func (r *CommandRegistry) dispatch(s *Session, req CommandRequest) CommandResponse {
+	cmd, ok := r.lookup(req.Command)
+	if !ok || cmd.handler == nil {
+		return errorResponse(req.Command, ErrCodeUnknownCommand, fmt.Sprintf("Unknown command: %q", req.Command))
+	}
+	return cmd.handler(s, req)
+}
+
+// Run h, turning a panic into an ERR_INTERNAL reply
+func safeRun(h CommandHandler, s *Session, req CommandRequest) (resp CommandResponse) {
+	defer func() {
+		if v := recover(); v != nil {
+			s.log.Printf("%s: command %q panicked: %v", s.label(), req.Command, v)
+			resp = errorResponse(req.Command, ErrCodeInternal, "Internal error")
+		}
+	}()
+	return h(s, req)
+}
+
+// LogCommands logs every command with its connection, duration and error
+// code, if any
+func LogCommands(logger *log.Logger) CommandMiddleware {
+	return func(next CommandHandler) CommandHandler {
+		return func(s *Session, req CommandRequest) CommandResponse {
+			start := time.Now()
+			resp := next(s, req)
+			code := resp.Code
+			if code == "" {
+				code = "ok"
+			}
+			logger.Printf("command %q from %s: %s in %s", req.Command, s.ConnID(), code, time.Since(start))
+			return resp
+		}
+	}
+}
+
+// RequireFields refuses a command that lacks a field listed for it, by JSON
+// name, with ERR_MISSING_FIELD before it runs. "a|a_var" is satisfied by
+// either. A field counts as present when it isn't its zero value.
+func RequireFields(fields map[string][]string) CommandMiddleware {
+	for command, names := range fields {
+		for _, alts := range names {
+			for _, name := range strings.Split(alts, "|") {
+				if _, ok := requestFieldIndex[name]; !ok {
+					panic(fmt.Sprintf("ws: RequireFields: %s has no field %q", command, name))
+				}
+			}
+		}
+	}
+	return func(next CommandHandler) CommandHandler {
+		return func(s *Session, req CommandRequest) CommandResponse {
+			for _, alts := range fields[req.Command] {
+				if !hasAnyField(req, alts) {
+					return errorResponse(req.Command, ErrCodeMissingField,
+						fmt.Sprintf("Missing required field %s", strings.ReplaceAll(alts, "|", " or ")))
+				}
+			}
+			return next(s, req)
+		}
+	}
+}
+
+// RequiredFields lists what the built-in commands can't run without, for
+// RequireFields
+var RequiredFields = map[string][]string{
+	"add": {"a|a_var", "b|b_var"}, "subtract": {"a|a_var", "b|b_var"},
+	"multiply": {"a|a_var", "b|b_var"}, "divide": {"a|a_var", "b|b_var"},
+	"set": {"name", "a|a_var"}, "get": {"name"},
+	"dm": {"to", "text"}, "broadcast": {"text"}, "ack": {"msg_id"},
+	"hash": {"algo"}, "hmac": {"algo", "key"}, "convert": {"a|a_var", "from", "to"},
+}
+
+// CommandRequest field numbers by JSON name. "from" and "to" also stand
+// for the numeric forms count takes.
+var requestFieldIndex = func() map[string][]int {
+	out := make(map[string][]int)
+	t := reflect.TypeOf(CommandRequest{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			out[name] = append(out[name], i)
+		}
+	}
+	for name, field := range map[string]string{"from": "FromNum", "to": "ToNum"} {
+		f, _ := t.FieldByName(field)
+		out[name] = append(out[name], f.Index[0])
+	}
+	return out
+}()
+
+func hasAnyField(req CommandRequest, alts string) bool {
+	v := reflect.ValueOf(req)
+	for _, name := range strings.Split(alts, "|") {
+		for _, i := range requestFieldIndex[name] {
+			if !v.Field(i).IsZero() {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+// Filename: internal/ws/middleware_test.go
+
+package ws
+
+import (
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// A middleware that notes when it runs, on either side of next
+func tracing(name string, calls *[]string) CommandMiddleware {
+	return func(next CommandHandler) CommandHandler {
+		return func(s *Session, req CommandRequest) CommandResponse {
+			*calls = append(*calls, name+" before")
+			resp := next(s, req)
+			*calls = append(*calls, name+" after")
+			return resp
+		}
+	}
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	var calls []string
+	reg := NewCommandRegistry()
+	reg.Use(tracing("outer", &calls), tracing("inner", &calls))
+	if err := reg.Register("probe", func(_ *Session, req CommandRequest) CommandResponse {
+		calls = append(calls, "handler")
+		return CommandResponse{Command: req.Command}
+	}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	processCommand(reg, newSession(defaultHistorySize), CommandRequest{Command: "probe"})
+	expected := []string{"outer before", "inner before", "handler", "inner after", "outer after"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("got %v expected %v", calls, expected)
+	}
+
+	// Unknown commands go through the chain too, to the dispatch's answer
+	calls = nil
+	resp := processCommand(reg, newSession(defaultHistorySize), CommandRequest{Command: "nope"})
+	if resp.Code != ErrCodeUnknownCommand || len(calls) != 4 {
+		t.Errorf("unknown command: got %+v after %v", resp, calls)
+	}
+}
+
+func TestMiddlewareShortCircuit(t *testing.T) {
+	reg := NewCommandRegistry()
+	reg.Use(func(next CommandHandler) CommandHandler {
+		return func(s *Session, req CommandRequest) CommandResponse {
+			if req.Command == "uuid" && s.ConnID() != "c-admin" {
+				return errorResponse(req.Command, "ERR_FORBIDDEN", "Not for you")
+			}
+			return next(s, req)
+		}
+	})
+	s := newSession(defaultHistorySize)
+	s.conn.ID = "c-guest"
+	if resp := processCommand(reg, s, CommandRequest{Command: "uuid"}); resp.Code != "ERR_FORBIDDEN" || resp.Text != "" {
+		t.Errorf("guest: got %+v", resp)
+	}
+	s.conn.ID = "c-admin"
+	if resp := processCommand(reg, s, CommandRequest{Command: "uuid"}); resp.Error != "" || resp.Text == "" {
+		t.Errorf("admin: got %+v", resp)
+	}
+}
+
+func TestMiddlewarePanicRecovered(t *testing.T) {
+	reg := NewCommandRegistry()
+	reg.Use(func(next CommandHandler) CommandHandler {
+		return func(s *Session, req CommandRequest) CommandResponse {
+			if req.Command == "multiply" {
+				panic("bad middleware")
+			}
+			return next(s, req)
+		}
+	})
+	conn := dial(t, startServer(t, NewHandler(Options{Registry: reg})))
+	for _, tc := range []struct{ send, expected string }{
+		{`{"command":"multiply","a":2,"b":3}`, `{"command":"multiply","error":"Internal error","code":"ERR_INTERNAL"}`},
+		{`{"command":"add","a":2,"b":3}`, `{"command":"add","result":5}`},
+	} {
+		if got := roundTrip(t, conn, tc.send); got != tc.expected {
+			t.Errorf("send %s: got %s expected %s", tc.send, got, tc.expected)
+		}
+	}
+}
+
+func TestShippedMiddleware(t *testing.T) {
+	var out lockedBuffer
+	reg := NewCommandRegistry()
+	reg.Use(LogCommands(log.New(&out, "", 0)), RequireFields(RequiredFields))
+	s := newSession(defaultHistorySize)
+	s.conn.ID = "c9"
+
+	for _, tc := range []struct {
+		req  CommandRequest
+		code string
+	}{
+		{CommandRequest{Command: "add", A: Num(1)}, ErrCodeMissingField},
+		{CommandRequest{Command: "add", A: Num(0), B: Num(0)}, ""},
+		{CommandRequest{Command: "set", Name: "x", AVar: "y"}, ErrCodeNoSuchVar},
+		{CommandRequest{Command: "dm", Text: "hi"}, ErrCodeMissingField},
+	} {
+		if resp := processCommand(reg, s, tc.req); resp.Code != tc.code {
+			t.Errorf("%+v: got %+v expected code %q", tc.req, resp, tc.code)
+		}
+	}
+	if resp := processCommand(reg, s, CommandRequest{Command: "add", A: Num(1)}); resp.Error != "Missing required field b or b_var" {
+		t.Errorf("message: got %q", resp.Error)
+	}
+	logged := out.String()
+	for _, line := range []string{`command "add" from c9: ERR_MISSING_FIELD in `, `command "add" from c9: ok in `} {
+		if !strings.Contains(logged, line) {
+			t.Errorf("log %q expected %q", logged, line)
+		}
+	}
+}
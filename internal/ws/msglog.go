@@ -0,0 +1,208 @@
+package ws
+
+// Filename: internal/ws/msglog.go
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// MessageLogger receives every data frame and connection lifecycle event.
+// Calls come from many connections at once, and from the goroutines that
+// read and write them, so implementations must be safe for concurrent use
+// and should return quickly. Payloads must not be modified or retained
+// past the call.
+type MessageLogger interface {
+	// LogInbound is called for each data frame a client sends
+	LogInbound(connID string, messageType int, payload []byte)
+	// LogOutbound is called for each data frame written to a client
+	LogOutbound(connID string, messageType int, payload []byte)
+	// LogLifecycle is called with "open" (detail: remote address) and
+	// "close" (detail: close code and reason)
+	LogLifecycle(connID, event, detail string)
+}
+
+// NopLogger discards everything; it is the default MessageLogger
+type NopLogger struct{}
+
+func (NopLogger) LogInbound(string, int, []byte)      {}
+func (NopLogger) LogOutbound(string, int, []byte)     {}
+func (NopLogger) LogLifecycle(string, string, string) {}
+
+// Defaults for FileLogger settings left at zero
+const (
+	defaultLogMaxBytes   = 10 << 20 // 10 MiB per file
+	defaultLogMaxFiles   = 5
+	defaultLogMaxPayload = 1024
+)
+
+// FileLoggerOptions configures NewFileLogger
+type FileLoggerOptions struct {
+	// MaxBytes is the size a file may reach before it is rotated
+	MaxBytes int64
+	// MaxFiles is how many rotated files (path.1 newest … path.N) are kept
+	MaxFiles int
+	// MaxPayload is how many bytes of each payload are written
+	MaxPayload int
+}
+
+// FileLogger writes one JSON object per line to a file, rotating it to
+// path.1, path.2, … once it would grow past MaxBytes. Lines are written
+// whole under a lock, so concurrent connections never interleave them.
+type FileLogger struct {
+	path string
+	opts FileLoggerOptions
+
+	mu   sync.Mutex
+	file *os.File
+	buf  *bufio.Writer
+	size int64 // bytes in the current file
+}
+
+// One line of a FileLogger file
+type logLine struct {
+	Time      string `json:"ts"`
+	ConnID    string `json:"conn_id"`
+	Kind      string `json:"kind"` // "in", "out" or "lifecycle"
+	MsgType   string `json:"msg_type,omitempty"`
+	Payload   string `json:"payload,omitempty"` // text frames
+	Binary    []byte `json:"binary,omitempty"`  // binary frames, base64 in JSON
+	Truncated bool   `json:"truncated,omitempty"`
+	Event     string `json:"event,omitempty"`
+	Detail    string `json:"detail,omitempty"`
+}
+
+// NewFileLogger opens (appending to) the log at path
+func NewFileLogger(path string, opts FileLoggerOptions) (*FileLogger, error) {
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = defaultLogMaxBytes
+	}
+	if opts.MaxFiles <= 0 {
+		opts.MaxFiles = defaultLogMaxFiles
+	}
+	if opts.MaxPayload <= 0 {
+		opts.MaxPayload = defaultLogMaxPayload
+	}
+	l := &FileLogger{path: path, opts: opts}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *FileLogger) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file, l.buf, l.size = f, bufio.NewWriter(f), info.Size()
+	return nil
+}
+
+func (l *FileLogger) LogInbound(connID string, messageType int, payload []byte) {
+	l.write(l.frameLine(connID, directionIn, messageType, payload))
+}
+
+func (l *FileLogger) LogOutbound(connID string, messageType int, payload []byte) {
+	l.write(l.frameLine(connID, directionOut, messageType, payload))
+}
+
+func (l *FileLogger) LogLifecycle(connID, event, detail string) {
+	l.write(logLine{ConnID: connID, Kind: "lifecycle", Event: event, Detail: detail})
+}
+
+func (l *FileLogger) frameLine(connID, kind string, messageType int, payload []byte) logLine {
+	line := logLine{ConnID: connID, Kind: kind}
+	if len(payload) > l.opts.MaxPayload {
+		payload, line.Truncated = payload[:l.opts.MaxPayload], true
+	}
+	if messageType == websocket.BinaryMessage {
+		line.MsgType, line.Binary = "binary", payload
+	} else {
+		line.MsgType, line.Payload = "text", string(payload)
+	}
+	return line
+}
+
+// Encode outside the lock, then append the whole line under it
+func (l *FileLogger) write(line logLine) {
+	line.Time = time.Now().UTC().Format(serverTimeFormat)
+	b, err := json.Marshal(line)
+	if err != nil {
+		return
+	}
+	b = append(b, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return // closed
+	}
+	if l.size > 0 && l.size+int64(len(b)) > l.opts.MaxBytes {
+		if err := l.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "message log: rotate %s: %v\n", l.path, err)
+			if l.file == nil {
+				return
+			}
+		}
+	}
+	n, _ := l.buf.Write(b)
+	l.size += int64(n)
+}
+
+// Shift path.N-1 → path.N … path → path.1 and start a new file. Caller holds l.mu.
+func (l *FileLogger) rotate() error {
+	if err := l.buf.Flush(); err != nil {
+		return err
+	}
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	l.file = nil
+	_ = os.Remove(fmt.Sprintf("%s.%d", l.path, l.opts.MaxFiles))
+	for i := l.opts.MaxFiles - 1; i >= 1; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+	}
+	err := os.Rename(l.path, l.path+".1")
+	// Keep logging even if the rename failed, to the old file
+	if oerr := l.open(); err == nil {
+		err = oerr
+	}
+	return err
+}
+
+// Flush writes buffered lines to the file
+func (l *FileLogger) Flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	return l.buf.Flush()
+}
+
+// Close flushes and closes the file; later calls are ignored
+func (l *FileLogger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.buf.Flush()
+	if cerr := l.file.Close(); err == nil {
+		err = cerr
+	}
+	l.file = nil
+	return err
+}
@@ -0,0 +1,162 @@
+// Filename: internal/ws/msglog_test.go
+
+package ws
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Every line of the named files, checking each is a whole JSON object
+func readLogLines(t *testing.T, files ...string) []logLine {
+	t.Helper()
+	var out []logLine
+	for _, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			var line logLine
+			if err := json.Unmarshal(sc.Bytes(), &line); err != nil {
+				t.Errorf("%s: partial line %q: %v", name, sc.Text(), err)
+			}
+			out = append(out, line)
+		}
+		f.Close()
+	}
+	return out
+}
+
+func TestFileLoggerRotatesAtMaxBytes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.jsonl")
+	l, err := NewFileLogger(path, FileLoggerOptions{MaxBytes: 300, MaxFiles: 2, MaxPayload: 8})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+
+	l.LogInbound("c1", websocket.TextMessage, []byte("payload cut to eight"))
+	if err := l.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+		t.Fatalf("rotated before the threshold: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		l.LogOutbound("c1", websocket.TextMessage, []byte(fmt.Sprintf("reply %d", i)))
+	}
+	if err := l.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatalf("stat %s: %v", name, err)
+		}
+		if info.Size() > 300 {
+			t.Errorf("%s: %d bytes, over the 300 limit", name, info.Size())
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("kept more than MaxFiles rotated files")
+	}
+
+	// The oldest lines were rotated away; the newest one is last in the live file
+	lines := readLogLines(t, path)
+	if last := lines[len(lines)-1]; last.Payload != "reply 19" || last.Kind != directionOut {
+		t.Errorf("last line: got %+v", last)
+	}
+}
+
+func TestFileLoggerConcurrentLinesStayWhole(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.jsonl")
+	l, err := NewFileLogger(path, FileLoggerOptions{MaxBytes: 4096, MaxFiles: 100})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+
+	const writers, perWriter = 20, 50
+	payload := []byte(strings.Repeat("x", 200))
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				l.LogInbound(fmt.Sprintf("c%d", w), websocket.TextMessage, payload)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := l.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	files, _ := filepath.Glob(path + "*")
+	lines := readLogLines(t, files...)
+	if len(lines) != writers*perWriter {
+		t.Errorf("got %d lines expected %d", len(lines), writers*perWriter)
+	}
+	for _, line := range lines {
+		if line.Payload != string(payload) {
+			t.Fatalf("mangled line: %+v", line)
+		}
+	}
+}
+
+// Records calls so the test can check where the handler makes them
+type recordingLogger struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (r *recordingLogger) add(s string) {
+	r.mu.Lock()
+	r.calls = append(r.calls, s)
+	r.mu.Unlock()
+}
+
+func (r *recordingLogger) LogInbound(_ string, _ int, p []byte) { r.add("in " + string(p)) }
+func (r *recordingLogger) LogOutbound(_ string, _ int, p []byte) {
+	r.add("out " + unstamped(string(p)))
+}
+func (r *recordingLogger) LogLifecycle(_, event, detail string) {
+	if event == "open" {
+		detail = "" // the remote address varies
+	}
+	r.add(strings.TrimSpace(event + " " + detail))
+}
+
+func TestCustomMessageLogger(t *testing.T) {
+	rec := &recordingLogger{}
+	h := NewHandler(Options{MessageLogger: rec})
+	conn := dial(t, startServer(t, h))
+	roundTrip(t, conn, "hi")
+	_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "done"))
+	conn.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		rec.mu.Lock()
+		got := strings.Join(rec.calls, " | ")
+		rec.mu.Unlock()
+		if strings.HasSuffix(got, "close 1000 done") || time.Now().After(deadline) {
+			if !strings.HasPrefix(got, "open | out {\"type\":\"welcome\"") || !strings.HasSuffix(got, "| in hi | out hi | close 1000 done") {
+				t.Errorf("calls: %s", got)
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
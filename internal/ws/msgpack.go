@@ -0,0 +1,89 @@
+package ws
+
+// Filename: internal/ws/msgpack.go
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/vmihailenco/msgpack/v5/msgpcode"
+)
+
+// Wire encodings a connection can use, fixed at upgrade time
+const (
+	encodingJSON    = "json"
+	encodingMsgpack = "msgpack"
+)
+
+// Error codes for msgpack connections
+const (
+	ErrCodeInvalidMsgpack   = "ERR_INVALID_MSGPACK"
+	ErrCodeUnsupportedFrame = "ERR_UNSUPPORTED_FRAME"
+)
+
+// Pick the connection's encoding from the ?encoding= query parameter or
+// the negotiated subprotocol. Anything else is JSON.
+func requestedEncoding(r *http.Request) (string, error) {
+	switch e := r.URL.Query().Get("encoding"); e {
+	case "", encodingJSON:
+		return encodingJSON, nil
+	case encodingMsgpack:
+		return encodingMsgpack, nil
+	default:
+		return "", fmt.Errorf("unknown encoding %q", e)
+	}
+}
+
+// Reply sent for a msgpack value that isn't a command map
+type echoEnvelope struct {
+	Type string      `json:"type"`
+	Msg  uint64      `json:"msg"`
+	Data interface{} `json:"data"`
+}
+
+// Encode v as MessagePack, using the json struct tags so field names
+// match the JSON protocol
+func marshalMsgpack(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func unmarshalMsgpack(b []byte, v interface{}) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(b))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+// Handle a binary frame on a msgpack connection: a map is run as a
+// command, any other value comes back in a numbered echo envelope.
+// Returns false once the connection is closing.
+func (h *Handler) handleMsgpackFrame(c *client, n uint64, payload []byte) bool {
+	if len(payload) == 0 {
+		return c.sendEncoded(c.session.stamp(errorResponse("", ErrCodeInvalidMsgpack, "Invalid msgpack: empty frame")))
+	}
+
+	if code := payload[0]; msgpcode.IsFixedMap(code) || code == msgpcode.Map16 || code == msgpcode.Map32 {
+		var req CommandRequest
+		if err := unmarshalMsgpack(payload, &req); err != nil {
+			return c.sendEncoded(c.session.stamp(errorResponse("", ErrCodeInvalidMsgpack, "Invalid msgpack: "+err.Error())))
+		}
+		if h.opts.Registry.isStream(req.Command) {
+			resp, ok := h.handleStreamCommand(c, req)
+			return !ok || c.sendEncoded(c.session.stamp(resp))
+		}
+		return c.sendEncoded(processCommand(h.opts.Registry, c.session, req))
+	}
+
+	var v interface{}
+	if err := unmarshalMsgpack(payload, &v); err != nil {
+		return c.sendEncoded(c.session.stamp(errorResponse("", ErrCodeInvalidMsgpack, "Invalid msgpack: "+err.Error())))
+	}
+	return c.sendEncoded(echoEnvelope{Type: "echo", Msg: n, Data: v})
+}
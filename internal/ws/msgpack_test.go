@@ -0,0 +1,142 @@
+// Filename: internal/ws/msgpack_test.go
+
+package ws
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Send a msgpack-encoded value and decode the msgpack reply into out
+func msgpackRoundTrip(t *testing.T, conn *websocket.Conn, v interface{}, out interface{}) {
+	t.Helper()
+	payload, err := marshalMsgpack(v)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if err := unmarshalMsgpack(binaryRoundTrip(t, conn, payload), out); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+}
+
+func TestMsgpackParity(t *testing.T) {
+	url := startServer(t, NewHandler(Options{}))
+	jsonConn := dial(t, url)
+	viaQuery := dial(t, url+"?encoding=msgpack")
+	viaSubprotocol := dialWith(t, &websocket.Dialer{Subprotocols: []string{"msgpack"}}, url)
+
+	if viaSubprotocol.Subprotocol() != "msgpack" {
+		t.Fatalf("subprotocol: got %q expected msgpack", viaSubprotocol.Subprotocol())
+	}
+
+	// history is left out: it records text frames only
+	script := []string{
+		`{"command":"add","a":2,"b":3}`,
+		`{"command":"subtract","a":"ans","b":1}`,
+		`{"command":"multiply","a":1.5,"b":4}`,
+		`{"command":"divide","a":1,"b":0}`,
+		`{"command":"store"}`,
+		`{"command":"recall"}`,
+		`{"command":"clear"}`,
+		`{"command":"recall"}`,
+		`{"command":"set","name":"x","a":7}`,
+		`{"command":"get","name":"x"}`,
+		`{"command":"add","a_var":"x","b_var":"x"}`,
+		`{"command":"vars"}`,
+		`{"command":"help"}`,
+		`{"command":"cancel","id":"none"}`,
+		`{"command":"nope"}`,
+	}
+
+	for _, msg := range script {
+		var req CommandRequest
+		if err := json.Unmarshal([]byte(msg), &req); err != nil {
+			t.Fatalf("bad script entry %s: %v", msg, err)
+		}
+
+		// Each connection has seen the same frames, so only global_seq differs
+		var viaJSON CommandResponse
+		if err := json.Unmarshal([]byte(rawRoundTrip(t, jsonConn, msg)), &viaJSON); err != nil {
+			t.Fatalf("%s: json reply: %v", msg, err)
+		}
+		viaJSON.GlobalSeq = 0
+		want := canonicalJSON(t, viaJSON)
+
+		for name, conn := range map[string]*websocket.Conn{"query": viaQuery, "subprotocol": viaSubprotocol} {
+			var viaMsgpack CommandResponse
+			msgpackRoundTrip(t, conn, req, &viaMsgpack)
+			viaMsgpack.GlobalSeq = 0
+			if got := canonicalJSON(t, viaMsgpack); got != want {
+				t.Errorf("%s (%s):\n msgpack %s\n json    %s", msg, name, got, want)
+			}
+		}
+	}
+}
+
+func TestMsgpackEchoAndErrors(t *testing.T) {
+	conn := dial(t, startServer(t, NewHandler(Options{}))+"?encoding=msgpack")
+
+	var echo echoEnvelope
+	msgpackRoundTrip(t, conn, "hello", &echo)
+	if echo.Type != "echo" || echo.Msg == 0 || echo.Data != "hello" {
+		t.Errorf("echo: got %+v", echo)
+	}
+
+	var resp CommandResponse
+	if err := unmarshalMsgpack(binaryRoundTrip(t, conn, []byte{0xc1}), &resp); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if resp.Code != ErrCodeInvalidMsgpack {
+		t.Errorf("invalid msgpack: got %+v", resp)
+	}
+
+	// A text frame on a msgpack connection gets a msgpack error frame back
+	send(t, conn, `{"command":"add","a":1,"b":2}`)
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	msgType, payload, err := readData(conn)
+	if err != nil || msgType != websocket.BinaryMessage {
+		t.Fatalf("text frame: got type %d err %v", msgType, err)
+	}
+	resp = CommandResponse{}
+	if err := unmarshalMsgpack(payload, &resp); err != nil || resp.Code != ErrCodeUnsupportedFrame {
+		t.Errorf("text frame: got %+v (%v)", resp, err)
+	}
+}
+
+func TestMsgpackCountStream(t *testing.T) {
+	conn := dial(t, startServer(t, NewHandler(Options{MinCountInterval: time.Millisecond}))+"?encoding=msgpack")
+
+	payload, _ := marshalMsgpack(CommandRequest{Command: "count", ID: "c", FromNum: Num(1), ToNum: Num(3), IntervalMS: 1})
+	if err := conn.WriteMessage(websocket.BinaryMessage, payload); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	for want := 1.0; want <= 4; want++ {
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, frame, err := readData(conn)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		var resp CommandResponse
+		if err := unmarshalMsgpack(frame, &resp); err != nil {
+			t.Fatalf("unmarshal: %v", err)
+		}
+		if want == 4 {
+			if !resp.Done {
+				t.Errorf("got %+v expected done frame", resp)
+			}
+		} else if resp.Result == nil || *resp.Result != want {
+			t.Errorf("got %+v expected result %v", resp, want)
+		}
+	}
+}
+
+func TestMsgpackUnknownEncoding(t *testing.T) {
+	url := startServer(t, NewHandler(Options{}))
+	_, resp, err := websocket.DefaultDialer.Dial(url+"?encoding=xml", nil)
+	if err == nil || resp == nil || resp.StatusCode != 400 {
+		t.Errorf("got %v expected a 400 response", err)
+	}
+}
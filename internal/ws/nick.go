@@ -0,0 +1,47 @@
+package ws
+
+// Filename: internal/ws/nick.go
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// Longest nickname a connection may take
+const maxNickLen = 32
+
+var nickPattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// Plain-text way to set a nickname: "NICK:alice"
+const nickTextPrefix = "NICK:"
+
+// ErrCodeNickTaken means another live connection already has the nickname
+const ErrCodeNickTaken = "ERR_NICK_TAKEN"
+
+var errNickTaken = errors.New("nickname taken")
+
+// Check a nickname against the naming rules
+func validNick(nick string) error {
+	if nick == "" || len(nick) > maxNickLen {
+		return fmt.Errorf("Nickname must be 1 to %d characters", maxNickLen)
+	}
+	if !nickPattern.MatchString(nick) {
+		return fmt.Errorf("Invalid nickname %q: use letters, digits and underscores", nick)
+	}
+	return nil
+}
+
+// Bind a nickname to this connection, or rename it. Outside a handler
+// there is nobody to clash with, so the name is just taken.
+func runNick(s *Session, req CommandRequest) CommandResponse {
+	if err := validNick(req.Name); err != nil {
+		return errorResponse(req.Command, ErrCodeInvalidName, err.Error())
+	}
+	if s.hub == nil {
+		s.setNick(req.Name)
+	} else if err := s.hub.rename(s, req.Name); err != nil {
+		return errorResponse(req.Command, ErrCodeNickTaken, fmt.Sprintf("Nickname %q is already in use", req.Name))
+	}
+	return CommandResponse{Command: req.Command, Data: req.Name}
+}
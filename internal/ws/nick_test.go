@@ -0,0 +1,71 @@
+// Filename: internal/ws/nick_test.go
+
+package ws
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Read the next frame on conn, which must be a presence event
+func readPresence(t *testing.T, conn *websocket.Conn) presenceFrame {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, msg, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("read presence: %v", err)
+	}
+	var frame presenceFrame
+	if err := json.Unmarshal(msg, &frame); err != nil || frame.Type != "presence" {
+		t.Fatalf("expected presence, got %s", msg)
+	}
+	return frame
+}
+
+func TestNickUniqueAndFreedOnClose(t *testing.T) {
+	url := startServer(t, NewHandler(Options{}))
+	alice, bob := dial(t, url), dial(t, url)
+	readPresence(t, alice) // bob joined
+
+	if got := roundTrip(t, alice, "NICK:alice"); got != `{"command":"nick","data":"alice"}` {
+		t.Fatalf("NICK: got %s", got)
+	}
+	if got := readPresence(t, bob); got.Event != "rename" || got.Nick != "alice" || got.OldNick != "" {
+		t.Errorf("rename: got %+v", got)
+	}
+
+	// Taken regardless of case; bad names are refused before that
+	tests := []struct {
+		send     string
+		expected string
+	}{
+		{`{"command":"nick","name":"ALICE"}`, `{"command":"nick","error":"Nickname \"ALICE\" is already in use","code":"ERR_NICK_TAKEN"}`},
+		{`{"command":"nick","name":"al ice"}`, `{"command":"nick","error":"Invalid nickname \"al ice\": use letters, digits and underscores","code":"ERR_INVALID_NAME"}`},
+		{`{"command":"nick","name":""}`, `{"command":"nick","error":"Nickname must be 1 to 32 characters","code":"ERR_INVALID_NAME"}`},
+	}
+	for _, tt := range tests {
+		if got := roundTrip(t, bob, tt.send); got != tt.expected {
+			t.Errorf("send %s: got %s expected %s", tt.send, got, tt.expected)
+		}
+	}
+
+	// Renaming frees the old name and says what it was
+	roundTrip(t, alice, `{"command":"nick","name":"alice2"}`)
+	if got := readPresence(t, bob); got.Event != "rename" || got.Nick != "alice2" || got.OldNick != "alice" {
+		t.Errorf("second rename: got %+v", got)
+	}
+	if got := who(t, bob); len(got) != 2 || got[0].Nick != "alice2" || got[1].Nick != "" {
+		t.Errorf("who: got %+v", got)
+	}
+
+	alice.Close()
+	if got := readPresence(t, bob); got.Event != "leave" || got.Nick != "alice2" || got.Count != 1 {
+		t.Errorf("leave: got %+v", got)
+	}
+	if got := roundTrip(t, bob, `{"command":"nick","name":"Alice2"}`); got != `{"command":"nick","data":"Alice2"}` {
+		t.Errorf("freed name: got %s", got)
+	}
+}
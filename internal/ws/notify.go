@@ -0,0 +1,161 @@
+package ws
+
+// Filename: internal/ws/notify.go
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Largest POST /notify or /send body accepted
+const maxNotifyBody = 16 << 10
+
+// Body of POST /notify
+type notifyRequest struct {
+	Text string `json:"text"`
+	Type string `json:"type"` // frame type the clients see; "notice" if empty
+}
+
+// Frame pushed to every client by POST /notify
+type noticeFrame struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+	TS   string `json:"ts"`
+}
+
+// What POST /notify answers with
+type notifyResult struct {
+	Delivered int `json:"delivered"`
+	Skipped   int `json:"skipped"` // clients whose queue was full
+}
+
+// Frame pushed to one client by POST /send
+type pushFrame struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+// What POST /send answers with
+type pushResult struct {
+	Delivered bool   `json:"delivered"`
+	ConnID    string `json:"conn_id"`
+	Nick      string `json:"nick,omitempty"`
+}
+
+// NotifyHandler serves POST /notify: the JSON body {"text": ..., "type": ...}
+// is pushed to every connection as {"type", "text", "ts"} and the reply
+// counts who got it. Clients with a full queue are skipped, never waited
+// on. Requests must carry "Authorization: Bearer <token>".
+func (h *Handler) NotifyHandler(token string) http.Handler {
+	return requireToken(token, http.HandlerFunc(h.notify))
+}
+
+// PushHandler serves the targeted push API:
+//
+//	POST /send/{conn_id}  push the JSON body to that connection
+//	POST /send?nick=      push it to the connection with that nickname
+//
+// The client gets {"type":"push","data":<body>}. The reply is 200 once it
+// is queued, 404 for no such connection and 503 if its queue is full; the
+// queue is never waited on. Requests must carry
+// "Authorization: Bearer <token>".
+func (h *Handler) PushHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /send/{conn_id}", func(w http.ResponseWriter, r *http.Request) {
+		h.push(w, r, r.PathValue("conn_id"))
+	})
+	mux.HandleFunc("POST /send", func(w http.ResponseWriter, r *http.Request) {
+		nick := r.URL.Query().Get("nick")
+		if nick == "" {
+			http.Error(w, "nick is required", http.StatusBadRequest)
+			return
+		}
+		h.push(w, r, nick)
+	})
+	return requireToken(token, mux)
+}
+
+func (h *Handler) push(w http.ResponseWriter, r *http.Request, to string) {
+	var data interface{}
+	if !decodeBody(w, r, &data) {
+		return
+	}
+	c, err := h.hub.sendTo(to, pushFrame{Type: "push", Data: data}, nil, laneData)
+	switch err {
+	case nil:
+	case errRecipientBusy:
+		http.Error(w, "connection queue full", http.StatusServiceUnavailable)
+		return
+	default:
+		http.Error(w, "no such connection", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	res := pushResult{Delivered: true, ConnID: c.session.conn.ID, Nick: c.session.Nick()}
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		h.opts.Logger.Printf("push: encode result: %v", err)
+	}
+}
+
+// Decode a JSON body of at most maxNotifyBody bytes into v, or answer 413
+// or 400 and return false
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxNotifyBody))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err == nil && dec.More() {
+		err = errors.New("trailing data after the JSON value")
+	}
+	if err != nil {
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			http.Error(w, "body too large", http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(w, "bad body: "+err.Error(), http.StatusBadRequest)
+		}
+		return false
+	}
+	return true
+}
+
+func (h *Handler) notify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req notifyRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if req.Text == "" {
+		http.Error(w, "text must not be empty", http.StatusBadRequest)
+		return
+	}
+	if req.Type == "" {
+		req.Type = "notice"
+	}
+
+	res := h.hub.notify(noticeFrame{Type: req.Type, Text: req.Text, TS: h.opts.Clock.Now().UTC().Format(serverTimeFormat)})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		h.opts.Logger.Printf("notify: encode result: %v", err)
+	}
+}
+
+// Queue frame for every client, never waiting on a queue
+func (h *hub) notify(frame noticeFrame) notifyResult {
+	h.sse.publish(frame.Type, frame)
+	var res notifyResult
+	out := newFanout(frame)
+	for _, c := range h.snapshot() {
+		if out.trySend(c) {
+			res.Delivered++
+		} else {
+			res.Skipped++
+		}
+	}
+	return res
+}
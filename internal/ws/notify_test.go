@@ -0,0 +1,138 @@
+// Filename: internal/ws/notify_test.go
+
+package ws
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestNotifyReachesEveryClient(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	h := NewHandler(Options{Clock: clock})
+	t.Cleanup(h.Close)
+	mux := http.NewServeMux()
+	mux.Handle("/ws", h)
+	mux.Handle("/notify", h.NotifyHandler("secret"))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+	a, b := dial(t, url), dial(t, url)
+
+	post := func(token, body string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest("POST", srv.URL+"/notify", strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("post: %v", err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	resp := post("secret", `{"text":"deploy at noon"}`)
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("got %d expected 202", resp.StatusCode)
+	}
+	var res notifyResult
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil || res != (notifyResult{Delivered: 2}) {
+		t.Errorf("result: got %+v, %v", res, err)
+	}
+	expected := noticeFrame{Type: "notice", Text: "deploy at noon", TS: "2024-05-01T12:00:00Z"}
+	for i, conn := range []*websocket.Conn{a, b} {
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, msg, err := readData(conn)
+		var got noticeFrame
+		if err != nil || json.Unmarshal(msg, &got) != nil || got != expected {
+			t.Errorf("client %d: got %s, %v", i, msg, err)
+		}
+	}
+
+	tests := []struct {
+		name, token, body string
+		status            int
+	}{
+		{"no token", "", `{"text":"hi"}`, http.StatusUnauthorized},
+		{"wrong token", "wrong", `{"text":"hi"}`, http.StatusUnauthorized},
+		{"malformed", "secret", `{"text":`, http.StatusBadRequest},
+		{"empty text", "secret", `{"type":"notice"}`, http.StatusBadRequest},
+		{"too large", "secret", `{"text":"` + strings.Repeat("x", maxNotifyBody) + `"}`, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		if resp := post(tt.token, tt.body); resp.StatusCode != tt.status {
+			t.Errorf("%s: got %d expected %d", tt.name, resp.StatusCode, tt.status)
+		}
+	}
+}
+
+func TestPushReachesOneClient(t *testing.T) {
+	h := NewHandler(Options{})
+	t.Cleanup(h.Close)
+	mux := http.NewServeMux()
+	mux.Handle("/ws", h)
+	push := h.PushHandler("secret")
+	mux.Handle("/send", push)
+	mux.Handle("/send/", push)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+	target, bystander := dial(t, url), dial(t, url)
+	roundTrip(t, target, "NICK:alice")
+
+	post := func(path, body string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest("POST", srv.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("post: %v", err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+	h.hub.mu.Lock()
+	id := h.hub.nicks["alice"].conn.ID
+	h.hub.mu.Unlock()
+
+	for _, path := range []string{"/send/" + id, "/send?nick=ALICE"} {
+		resp := post(path, `{"job":42}`)
+		var res pushResult
+		if err := json.NewDecoder(resp.Body).Decode(&res); err != nil || resp.StatusCode != http.StatusOK || res != (pushResult{Delivered: true, ConnID: id, Nick: "alice"}) {
+			t.Errorf("%s: got %d %+v, %v", path, resp.StatusCode, res, err)
+		}
+		_ = target.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, msg, err := readData(target); err != nil || string(msg) != `{"type":"push","data":{"job":42}}` {
+			t.Errorf("%s: target got %s, %v", path, msg, err)
+		}
+	}
+	// Nothing reached the other client; its next frame is its own reply
+	if got := roundTrip(t, bystander, "after"); got != "after" {
+		t.Errorf("bystander: got %s", got)
+	}
+
+	target.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(h.hub.snapshot()) > 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if resp := post("/send/"+id, `"hello"`); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("stale id: got %d expected 404", resp.StatusCode)
+	}
+	if resp := post("/send/"+id, `{"job":`); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("malformed: got %d expected 400", resp.StatusCode)
+	}
+	if resp := post("/send", `{}`); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("no nick: got %d expected 400", resp.StatusCode)
+	}
+}
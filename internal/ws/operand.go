@@ -0,0 +1,53 @@
+package ws
+
+// Filename: internal/ws/operand.go
+
+import (
+	"fmt"
+
+	"github.com/alexdev404/ws-main/pkg/wsproto"
+)
+
+// The operand name that refers to the previous result on the connection
+const ansOperand = wsproto.Ans
+
+// Operand is a numeric command argument; see wsproto.Operand
+type Operand = wsproto.Operand
+
+// Num returns a literal numeric Operand
+func Num(v float64) Operand {
+	return wsproto.Num(v)
+}
+
+// Integer returns a literal Operand holding v exactly
+func Integer(v int64) Operand {
+	return wsproto.Integer(v)
+}
+
+// Resolve an operand to a number using this session's state. A non-empty
+// varName (from the a_var/b_var fields) takes the place of the operand.
+func (s *Session) resolve(command string, o Operand, varName string) (float64, *CommandResponse) {
+	if varName != "" {
+		v, ok := s.getVar(varName)
+		if !ok {
+			resp := errorResponse(command, ErrCodeNoSuchVar, fmt.Sprintf("Unknown variable %q", varName))
+			return 0, &resp
+		}
+		return v, nil
+	}
+
+	switch o.Ref {
+	case "":
+		return o.Value, nil
+	case ansOperand:
+		v, ok := s.LastResult()
+		if !ok {
+			resp := errorResponse(command, ErrCodeNoResult, `No previous result for "ans"`)
+			return 0, &resp
+		}
+		return v, nil
+	default:
+		resp := errorResponse(command, ErrCodeInvalidOperand, fmt.Sprintf("Unknown operand %q", o.Ref))
+		return 0, &resp
+	}
+}
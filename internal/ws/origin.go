@@ -0,0 +1,204 @@
+package ws
+
+// Filename: internal/ws/origin.go
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Smallest read limit a policy may set; a command needs more than this
+const minMessageSize = 64
+
+// OriginPolicy holds the limits for connections from the origins matching
+// one pattern in Options.OriginPolicies. Zero values mean the defaults:
+// the usual read limit, no rate limit, any number of connections. A
+// matched policy's RejectBinary replaces Options.RejectBinary.
+type OriginPolicy struct {
+	MaxMessageSize    int64 `json:"max_message_size"`    // largest frame a client may send, in bytes
+	MessagesPerSecond int   `json:"messages_per_second"` // data frames per second per connection; more are refused
+	MaxConnections    int   `json:"max_connections"`     // open connections from the matching origins together
+	RejectBinary      bool  `json:"reject_binary"`       // close with 1003 on a binary frame
+	BytesPerMinute    int64 `json:"bytes_per_minute"`    // data frame bytes per minute per connection; see Options.BandwidthLimit
+}
+
+// ValidateOriginPolicies rejects policies whose patterns can't match an origin, that
+// name the same origin twice, or whose limits are out of range. A pattern
+// is an origin ("https://app.example.com") or one with a "*" in its host
+// ("https://*.example.com"), compared without case.
+func ValidateOriginPolicies(policies map[string]OriginPolicy) error {
+	seen := make(map[string]string, len(policies))
+	for _, pattern := range sortedPatterns(policies) {
+		p := policies[pattern]
+		scheme, host, ok := strings.Cut(pattern, "://")
+		if !ok || scheme == "" || host == "" || strings.Contains(scheme, "*") || strings.Count(host, "*") > 1 {
+			return fmt.Errorf("origin policy %q: pattern must be scheme://host, with at most one * in the host", pattern)
+		}
+		if other, dup := seen[strings.ToLower(pattern)]; dup {
+			return fmt.Errorf("origin policy %q: same origins as %q", pattern, other)
+		}
+		seen[strings.ToLower(pattern)] = pattern
+		switch {
+		case p.MaxMessageSize < 0 || p.MessagesPerSecond < 0 || p.MaxConnections < 0 || p.BytesPerMinute < 0:
+			return fmt.Errorf("origin policy %q: limits must not be negative", pattern)
+		case p.MaxMessageSize > 0 && p.MaxMessageSize < minMessageSize:
+			return fmt.Errorf("origin policy %q: max_message_size must be at least %d", pattern, minMessageSize)
+		}
+	}
+	return nil
+}
+
+// ParseOriginPolicies reads a JSON object of pattern to policy, such as
+// {"https://*.example.com": {"max_message_size": 16384}}, and validates it
+func ParseOriginPolicies(data []byte) (map[string]OriginPolicy, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var policies map[string]OriginPolicy
+	if err := dec.Decode(&policies); err != nil {
+		return nil, fmt.Errorf("origin policies: %w", err)
+	}
+	if err := ValidateOriginPolicies(policies); err != nil {
+		return nil, err
+	}
+	return policies, nil
+}
+
+// Validate checks the Options NewHandler can't fix up by itself
+func (o Options) Validate() error {
+	if err := ValidateOriginPolicies(o.OriginPolicies); err != nil {
+		return errors.Join(errors.New("ws: invalid options"), err)
+	}
+	if o.Chaos != nil {
+		if err := ValidateChaos(o.Chaos); err != nil {
+			return errors.Join(errors.New("ws: invalid options"), err)
+		}
+	}
+	return nil
+}
+
+// Patterns in a fixed order, so errors and matches don't depend on map order
+func sortedPatterns(policies map[string]OriginPolicy) []string {
+	patterns := make([]string, 0, len(policies))
+	for pattern := range policies {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	return patterns
+}
+
+// A policy resolved for one connection, with the defaults filled in
+type connPolicy struct {
+	pattern string // the OriginPolicies key that matched; "" for the default
+	OriginPolicy
+}
+
+// The origin settings in force. Reload swaps in a new one whole; one is
+// never changed once stored, so a reader sees either the old settings or
+// the new ones.
+type originConfig struct {
+	allowed  []string
+	policies map[string]OriginPolicy
+	fallback OriginPolicy // for origins no pattern matches
+}
+
+// May origin connect, by the allowlist or by having a policy?
+func (o *originConfig) allows(origin string) bool {
+	return originAllowed(o.allowed, origin) || o.policyFor(origin).pattern != ""
+}
+
+// The policy for origin: an exact pattern first, then the longest matching
+// wildcard, otherwise the fallback
+func (o *originConfig) policyFor(origin string) connPolicy {
+	def := connPolicy{OriginPolicy: o.fallback}
+	if origin == "" {
+		return def
+	}
+	best := ""
+	for _, pattern := range sortedPatterns(o.policies) {
+		if strings.EqualFold(pattern, origin) {
+			best = pattern
+			break
+		}
+		if originMatches(pattern, origin) && len(pattern) > len(best) {
+			best = pattern
+		}
+	}
+	if best == "" {
+		return def
+	}
+	p := connPolicy{pattern: best, OriginPolicy: o.policies[best]}
+	if p.MaxMessageSize == 0 {
+		p.MaxMessageSize = o.fallback.MaxMessageSize
+	}
+	if p.BytesPerMinute == 0 {
+		p.BytesPerMinute = o.fallback.BytesPerMinute
+	}
+	return p
+}
+
+// Does origin match a pattern with a "*" in it? The "*" stands for one or
+// more characters, so "https://*.example.com" doesn't match
+// "https://.example.com".
+func originMatches(pattern, origin string) bool {
+	prefix, suffix, ok := strings.Cut(strings.ToLower(pattern), "*")
+	origin = strings.ToLower(origin)
+	return ok && len(origin) > len(prefix)+len(suffix) &&
+		strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix)
+}
+
+// Counts open connections per policy pattern, for MaxConnections
+type originCounts struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// Count a connection under p unless that would pass its MaxConnections.
+// Every successful acquire needs a release.
+func (o *originCounts) acquire(p connPolicy) bool {
+	if p.MaxConnections == 0 {
+		return true
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.counts[p.pattern] >= p.MaxConnections {
+		return false
+	}
+	if o.counts == nil {
+		o.counts = make(map[string]int)
+	}
+	o.counts[p.pattern]++
+	return true
+}
+
+func (o *originCounts) release(p connPolicy) {
+	if p.MaxConnections == 0 {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.counts[p.pattern]--; o.counts[p.pattern] <= 0 {
+		delete(o.counts, p.pattern)
+	}
+}
+
+// A limiter for the policy's MessagesPerSecond; nil when there is none
+func (p connPolicy) rateLimiter() *rateWindow {
+	if p.MessagesPerSecond == 0 {
+		return nil
+	}
+	return newRateWindow(p.MessagesPerSecond, time.Second)
+}
+
+// A limiter for the policy's BytesPerMinute; nil when there is none
+func (p connPolicy) bandwidthLimiter() *byteWindow {
+	if p.BytesPerMinute <= 0 {
+		return nil
+	}
+	return newByteWindow(p.BytesPerMinute, bandwidthWindow)
+}
@@ -0,0 +1,187 @@
+// Filename: internal/ws/origin_test.go
+
+package ws
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Dial url with the given Origin header and consume the welcome frame
+func dialOrigin(t *testing.T, url, origin string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {origin}})
+	if err != nil {
+		t.Fatalf("dial from %s: %v", origin, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := conn.ReadMessage(); err != nil {
+		t.Fatalf("read welcome: %v", err)
+	}
+	return conn
+}
+
+func TestOriginPolicyReadLimit(t *testing.T) {
+	url := startServer(t, NewHandler(Options{OriginPolicies: map[string]OriginPolicy{
+		"https://*.example.com": {MaxMessageSize: 16 << 10},
+	}}))
+	big := strings.Repeat("x", 8000)
+
+	// Matched by the wildcard, so 8000 bytes fit
+	wide := dialOrigin(t, url, "https://app.example.com")
+	if got := roundTrip(t, wide, big); got != big {
+		t.Errorf("echo under the policy: got %d bytes expected %d", len(got), len(big))
+	}
+
+	// The default origin keeps the usual 4 KiB limit
+	narrow := dial(t, url)
+	if err := narrow.WriteMessage(websocket.TextMessage, []byte(big)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_ = narrow.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err := readData(narrow)
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseMessageTooBig {
+		t.Errorf("got %v expected close %d", err, websocket.CloseMessageTooBig)
+	}
+}
+
+func TestOriginPolicyRate(t *testing.T) {
+	url := startServer(t, NewHandler(Options{OriginPolicies: map[string]OriginPolicy{
+		"https://chatty.example.com": {MessagesPerSecond: 2},
+	}}))
+	conn := dialOrigin(t, url, "https://chatty.example.com")
+
+	for _, msg := range []string{"one", "two"} {
+		if got := roundTrip(t, conn, msg); got != msg {
+			t.Fatalf("got %q expected %q", got, msg)
+		}
+	}
+	got := roundTrip(t, conn, "three")
+	if !strings.Contains(got, ErrCodeRateLimited) {
+		t.Errorf("third message in a second: got %q expected %s", got, ErrCodeRateLimited)
+	}
+}
+
+func TestOriginPolicyMaxConnections(t *testing.T) {
+	url := startServer(t, NewHandler(Options{OriginPolicies: map[string]OriginPolicy{
+		"https://*.example.com": {MaxConnections: 1},
+	}}))
+	first := dialOrigin(t, url, "https://a.example.com")
+
+	// The cap counts every origin the pattern matches together
+	_, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"https://b.example.com"}})
+	if err == nil || resp == nil || resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("second connection: got %v expected status 429", err)
+	}
+
+	// Other origins aren't affected
+	dial(t, url)
+
+	// A slot opens once the first connection goes away
+	first.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"https://b.example.com"}})
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("still refused after the first connection closed: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestOriginPolicyRejectBinary(t *testing.T) {
+	url := startServer(t, NewHandler(Options{OriginPolicies: map[string]OriginPolicy{
+		"https://text.example.com": {RejectBinary: true},
+	}}))
+
+	// Binary is still echoed for the default origin
+	if err := dial(t, url).WriteMessage(websocket.BinaryMessage, []byte{1, 2, 3}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	conn := dialOrigin(t, url, "https://text.example.com")
+	if err := conn.WriteMessage(websocket.BinaryMessage, []byte{1, 2, 3}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err := readData(conn)
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseUnsupportedData {
+		t.Errorf("got %v expected close %d", err, websocket.CloseUnsupportedData)
+	}
+}
+
+func TestPolicyFor(t *testing.T) {
+	h := NewHandler(Options{OriginPolicies: map[string]OriginPolicy{
+		"https://*.example.com":     {MaxMessageSize: 1000},
+		"https://*.api.example.com": {MaxMessageSize: 2000},
+		"https://api.example.com":   {MaxMessageSize: 3000},
+	}})
+	tests := []struct {
+		origin  string
+		pattern string
+		size    int64
+	}{
+		{"https://www.example.com", "https://*.example.com", 1000},
+		{"https://v1.api.example.com", "https://*.api.example.com", 2000},
+		{"HTTPS://API.EXAMPLE.COM", "https://api.example.com", 3000},
+		{"https://example.com", "", maxMessageSize},
+		{"http://www.example.com", "", maxMessageSize},
+		{"", "", maxMessageSize},
+	}
+	for _, tt := range tests {
+		p := h.origins.Load().policyFor(tt.origin)
+		if p.pattern != tt.pattern || p.MaxMessageSize != tt.size {
+			t.Errorf("%q: got %q (%d) expected %q (%d)", tt.origin, p.pattern, p.MaxMessageSize, tt.pattern, tt.size)
+		}
+	}
+}
+
+func TestValidateOriginPolicies(t *testing.T) {
+	tests := []struct {
+		name     string
+		policies map[string]OriginPolicy
+		wantErr  bool
+	}{
+		{"none", nil, false},
+		{"exact and wildcard", map[string]OriginPolicy{"https://a.example.com": {}, "https://*.example.com": {MaxConnections: 3}}, false},
+		{"no scheme", map[string]OriginPolicy{"example.com": {}}, true},
+		{"wildcard scheme", map[string]OriginPolicy{"*://example.com": {}}, true},
+		{"two wildcards", map[string]OriginPolicy{"https://*.*.example.com": {}}, true},
+		{"same origin twice", map[string]OriginPolicy{"https://example.com": {}, "HTTPS://EXAMPLE.COM": {}}, true},
+		{"negative rate", map[string]OriginPolicy{"https://example.com": {MessagesPerSecond: -1}}, true},
+		{"tiny read limit", map[string]OriginPolicy{"https://example.com": {MaxMessageSize: 10}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Options{OriginPolicies: tt.policies}.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got err %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseOriginPolicies(t *testing.T) {
+	policies, err := ParseOriginPolicies([]byte(`{"https://*.example.com": {"max_message_size": 16384, "reject_binary": true}}`))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if p := policies["https://*.example.com"]; p.MaxMessageSize != 16384 || !p.RejectBinary {
+		t.Errorf("got %+v", p)
+	}
+	if _, err := ParseOriginPolicies([]byte(`{"https://example.com": {"max_mesage_size": 16384}}`)); err == nil {
+		t.Error("misspelled field accepted")
+	}
+}
@@ -0,0 +1,470 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        (unknown)
+// source: internal/ws/pb/command.proto
+
+package pb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Operand struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Value:
+	//
+	//	*Operand_Number
+	//	*Operand_Ref
+	Value         isOperand_Value `protobuf_oneof:"value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Operand) Reset() {
+	*x = Operand{}
+	mi := &file_internal_ws_pb_command_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Operand) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Operand) ProtoMessage() {}
+
+func (x *Operand) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_ws_pb_command_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Operand.ProtoReflect.Descriptor instead.
+func (*Operand) Descriptor() ([]byte, []int) {
+	return file_internal_ws_pb_command_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Operand) GetValue() isOperand_Value {
+	if x != nil {
+		return x.Value
+	}
+	return nil
+}
+
+func (x *Operand) GetNumber() float64 {
+	if x != nil {
+		if x, ok := x.Value.(*Operand_Number); ok {
+			return x.Number
+		}
+	}
+	return 0
+}
+
+func (x *Operand) GetRef() string {
+	if x != nil {
+		if x, ok := x.Value.(*Operand_Ref); ok {
+			return x.Ref
+		}
+	}
+	return ""
+}
+
+type isOperand_Value interface {
+	isOperand_Value()
+}
+
+type Operand_Number struct {
+	Number float64 `protobuf:"fixed64,1,opt,name=number,proto3,oneof"`
+}
+
+type Operand_Ref struct {
+	Ref string `protobuf:"bytes,2,opt,name=ref,proto3,oneof"`
+}
+
+func (*Operand_Number) isOperand_Value() {}
+
+func (*Operand_Ref) isOperand_Value() {}
+
+type CommandRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Command       string                 `protobuf:"bytes,1,opt,name=command,proto3" json:"command,omitempty"`
+	Id            string                 `protobuf:"bytes,2,opt,name=id,proto3" json:"id,omitempty"`
+	A             *Operand               `protobuf:"bytes,3,opt,name=a,proto3" json:"a,omitempty"`
+	B             *Operand               `protobuf:"bytes,4,opt,name=b,proto3" json:"b,omitempty"`
+	AVar          string                 `protobuf:"bytes,5,opt,name=a_var,json=aVar,proto3" json:"a_var,omitempty"`
+	BVar          string                 `protobuf:"bytes,6,opt,name=b_var,json=bVar,proto3" json:"b_var,omitempty"`
+	Name          string                 `protobuf:"bytes,7,opt,name=name,proto3" json:"name,omitempty"`
+	Limit         int32                  `protobuf:"varint,8,opt,name=limit,proto3" json:"limit,omitempty"`
+	From          float64                `protobuf:"fixed64,9,opt,name=from,proto3" json:"from,omitempty"`
+	To            float64                `protobuf:"fixed64,10,opt,name=to,proto3" json:"to,omitempty"`
+	IntervalMs    int32                  `protobuf:"varint,11,opt,name=interval_ms,json=intervalMs,proto3" json:"interval_ms,omitempty"`
+	Enabled       *bool                  `protobuf:"varint,12,opt,name=enabled,proto3,oneof" json:"enabled,omitempty"`
+	ToRef         string                 `protobuf:"bytes,13,opt,name=to_ref,json=toRef,proto3" json:"to_ref,omitempty"`
+	Text          string                 `protobuf:"bytes,14,opt,name=text,proto3" json:"text,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CommandRequest) Reset() {
+	*x = CommandRequest{}
+	mi := &file_internal_ws_pb_command_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CommandRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CommandRequest) ProtoMessage() {}
+
+func (x *CommandRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_ws_pb_command_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CommandRequest.ProtoReflect.Descriptor instead.
+func (*CommandRequest) Descriptor() ([]byte, []int) {
+	return file_internal_ws_pb_command_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *CommandRequest) GetCommand() string {
+	if x != nil {
+		return x.Command
+	}
+	return ""
+}
+
+func (x *CommandRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *CommandRequest) GetA() *Operand {
+	if x != nil {
+		return x.A
+	}
+	return nil
+}
+
+func (x *CommandRequest) GetB() *Operand {
+	if x != nil {
+		return x.B
+	}
+	return nil
+}
+
+func (x *CommandRequest) GetAVar() string {
+	if x != nil {
+		return x.AVar
+	}
+	return ""
+}
+
+func (x *CommandRequest) GetBVar() string {
+	if x != nil {
+		return x.BVar
+	}
+	return ""
+}
+
+func (x *CommandRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *CommandRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *CommandRequest) GetFrom() float64 {
+	if x != nil {
+		return x.From
+	}
+	return 0
+}
+
+func (x *CommandRequest) GetTo() float64 {
+	if x != nil {
+		return x.To
+	}
+	return 0
+}
+
+func (x *CommandRequest) GetIntervalMs() int32 {
+	if x != nil {
+		return x.IntervalMs
+	}
+	return 0
+}
+
+func (x *CommandRequest) GetEnabled() bool {
+	if x != nil && x.Enabled != nil {
+		return *x.Enabled
+	}
+	return false
+}
+
+func (x *CommandRequest) GetToRef() string {
+	if x != nil {
+		return x.ToRef
+	}
+	return ""
+}
+
+func (x *CommandRequest) GetText() string {
+	if x != nil {
+		return x.Text
+	}
+	return ""
+}
+
+type CommandResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Command       string                 `protobuf:"bytes,1,opt,name=command,proto3" json:"command,omitempty"`
+	Id            string                 `protobuf:"bytes,2,opt,name=id,proto3" json:"id,omitempty"`
+	Result        *float64               `protobuf:"fixed64,3,opt,name=result,proto3,oneof" json:"result,omitempty"`
+	Done          bool                   `protobuf:"varint,4,opt,name=done,proto3" json:"done,omitempty"`
+	DataJson      []byte                 `protobuf:"bytes,5,opt,name=data_json,json=dataJson,proto3" json:"data_json,omitempty"`
+	Error         string                 `protobuf:"bytes,6,opt,name=error,proto3" json:"error,omitempty"`
+	Code          string                 `protobuf:"bytes,7,opt,name=code,proto3" json:"code,omitempty"`
+	ServerTime    string                 `protobuf:"bytes,8,opt,name=server_time,json=serverTime,proto3" json:"server_time,omitempty"`
+	Seq           uint64                 `protobuf:"varint,9,opt,name=seq,proto3" json:"seq,omitempty"`
+	GlobalSeq     uint64                 `protobuf:"varint,10,opt,name=global_seq,json=globalSeq,proto3" json:"global_seq,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CommandResponse) Reset() {
+	*x = CommandResponse{}
+	mi := &file_internal_ws_pb_command_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CommandResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CommandResponse) ProtoMessage() {}
+
+func (x *CommandResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_ws_pb_command_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CommandResponse.ProtoReflect.Descriptor instead.
+func (*CommandResponse) Descriptor() ([]byte, []int) {
+	return file_internal_ws_pb_command_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *CommandResponse) GetCommand() string {
+	if x != nil {
+		return x.Command
+	}
+	return ""
+}
+
+func (x *CommandResponse) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *CommandResponse) GetResult() float64 {
+	if x != nil && x.Result != nil {
+		return *x.Result
+	}
+	return 0
+}
+
+func (x *CommandResponse) GetDone() bool {
+	if x != nil {
+		return x.Done
+	}
+	return false
+}
+
+func (x *CommandResponse) GetDataJson() []byte {
+	if x != nil {
+		return x.DataJson
+	}
+	return nil
+}
+
+func (x *CommandResponse) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *CommandResponse) GetCode() string {
+	if x != nil {
+		return x.Code
+	}
+	return ""
+}
+
+func (x *CommandResponse) GetServerTime() string {
+	if x != nil {
+		return x.ServerTime
+	}
+	return ""
+}
+
+func (x *CommandResponse) GetSeq() uint64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+func (x *CommandResponse) GetGlobalSeq() uint64 {
+	if x != nil {
+		return x.GlobalSeq
+	}
+	return 0
+}
+
+var File_internal_ws_pb_command_proto protoreflect.FileDescriptor
+
+const file_internal_ws_pb_command_proto_rawDesc = "" +
+	"\n" +
+	"\x1cinternal/ws/pb/command.proto\x12\x02ws\"@\n" +
+	"\aOperand\x12\x18\n" +
+	"\x06number\x18\x01 \x01(\x01H\x00R\x06number\x12\x12\n" +
+	"\x03ref\x18\x02 \x01(\tH\x00R\x03refB\a\n" +
+	"\x05value\"\xdf\x02\n" +
+	"\x0eCommandRequest\x12\x18\n" +
+	"\acommand\x18\x01 \x01(\tR\acommand\x12\x0e\n" +
+	"\x02id\x18\x02 \x01(\tR\x02id\x12\x19\n" +
+	"\x01a\x18\x03 \x01(\v2\v.ws.OperandR\x01a\x12\x19\n" +
+	"\x01b\x18\x04 \x01(\v2\v.ws.OperandR\x01b\x12\x13\n" +
+	"\x05a_var\x18\x05 \x01(\tR\x04aVar\x12\x13\n" +
+	"\x05b_var\x18\x06 \x01(\tR\x04bVar\x12\x12\n" +
+	"\x04name\x18\a \x01(\tR\x04name\x12\x14\n" +
+	"\x05limit\x18\b \x01(\x05R\x05limit\x12\x12\n" +
+	"\x04from\x18\t \x01(\x01R\x04from\x12\x0e\n" +
+	"\x02to\x18\n" +
+	" \x01(\x01R\x02to\x12\x1f\n" +
+	"\vinterval_ms\x18\v \x01(\x05R\n" +
+	"intervalMs\x12\x1d\n" +
+	"\aenabled\x18\f \x01(\bH\x00R\aenabled\x88\x01\x01\x12\x15\n" +
+	"\x06to_ref\x18\r \x01(\tR\x05toRef\x12\x12\n" +
+	"\x04text\x18\x0e \x01(\tR\x04textB\n" +
+	"\n" +
+	"\b_enabled\"\x90\x02\n" +
+	"\x0fCommandResponse\x12\x18\n" +
+	"\acommand\x18\x01 \x01(\tR\acommand\x12\x0e\n" +
+	"\x02id\x18\x02 \x01(\tR\x02id\x12\x1b\n" +
+	"\x06result\x18\x03 \x01(\x01H\x00R\x06result\x88\x01\x01\x12\x12\n" +
+	"\x04done\x18\x04 \x01(\bR\x04done\x12\x1b\n" +
+	"\tdata_json\x18\x05 \x01(\fR\bdataJson\x12\x14\n" +
+	"\x05error\x18\x06 \x01(\tR\x05error\x12\x12\n" +
+	"\x04code\x18\a \x01(\tR\x04code\x12\x1f\n" +
+	"\vserver_time\x18\b \x01(\tR\n" +
+	"serverTime\x12\x10\n" +
+	"\x03seq\x18\t \x01(\x04R\x03seq\x12\x1d\n" +
+	"\n" +
+	"global_seq\x18\n" +
+	" \x01(\x04R\tglobalSeqB\t\n" +
+	"\a_resultB.Z,github.com/alexdev404/ws-main/internal/ws/pbb\x06proto3"
+
+var (
+	file_internal_ws_pb_command_proto_rawDescOnce sync.Once
+	file_internal_ws_pb_command_proto_rawDescData []byte
+)
+
+func file_internal_ws_pb_command_proto_rawDescGZIP() []byte {
+	file_internal_ws_pb_command_proto_rawDescOnce.Do(func() {
+		file_internal_ws_pb_command_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_internal_ws_pb_command_proto_rawDesc), len(file_internal_ws_pb_command_proto_rawDesc)))
+	})
+	return file_internal_ws_pb_command_proto_rawDescData
+}
+
+var file_internal_ws_pb_command_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_internal_ws_pb_command_proto_goTypes = []any{
+	(*Operand)(nil),         // 0: ws.Operand
+	(*CommandRequest)(nil),  // 1: ws.CommandRequest
+	(*CommandResponse)(nil), // 2: ws.CommandResponse
+}
+var file_internal_ws_pb_command_proto_depIdxs = []int32{
+	0, // 0: ws.CommandRequest.a:type_name -> ws.Operand
+	0, // 1: ws.CommandRequest.b:type_name -> ws.Operand
+	2, // [2:2] is the sub-list for method output_type
+	2, // [2:2] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_internal_ws_pb_command_proto_init() }
+func file_internal_ws_pb_command_proto_init() {
+	if File_internal_ws_pb_command_proto != nil {
+		return
+	}
+	file_internal_ws_pb_command_proto_msgTypes[0].OneofWrappers = []any{
+		(*Operand_Number)(nil),
+		(*Operand_Ref)(nil),
+	}
+	file_internal_ws_pb_command_proto_msgTypes[1].OneofWrappers = []any{}
+	file_internal_ws_pb_command_proto_msgTypes[2].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_internal_ws_pb_command_proto_rawDesc), len(file_internal_ws_pb_command_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   3,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_internal_ws_pb_command_proto_goTypes,
+		DependencyIndexes: file_internal_ws_pb_command_proto_depIdxs,
+		MessageInfos:      file_internal_ws_pb_command_proto_msgTypes,
+	}.Build()
+	File_internal_ws_pb_command_proto = out.File
+	file_internal_ws_pb_command_proto_goTypes = nil
+	file_internal_ws_pb_command_proto_depIdxs = nil
+}
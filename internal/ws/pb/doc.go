@@ -0,0 +1,6 @@
+// Filename: internal/ws/pb/doc.go
+
+// Package pb holds the protobuf encoding of the websocket command protocol.
+package pb
+
+//go:generate protoc --go_out=../../.. --go_opt=paths=source_relative --proto_path=../../.. internal/ws/pb/command.proto
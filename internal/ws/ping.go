@@ -0,0 +1,60 @@
+package ws
+
+// Filename: internal/ws/ping.go
+
+import (
+	"bytes"
+	"time"
+)
+
+// Browsers can't send protocol pings, so "PING" (optionally "PING <token>")
+// as a text frame gets "PONG <server time> [token]" back straight away
+const appPingText = "PING"
+
+// Heartbeat modes, picked per connection with ?heartbeat= on /ws
+const (
+	heartbeatServer = "server" // we ping; the default, and what browsers need
+	heartbeatClient = "client" // the client keeps itself alive; see startHeartbeat
+)
+
+// The mode a ?heartbeat= value asks for. Anything but "client" gets the
+// server's pings, since a client that can't ping would otherwise be dropped.
+func heartbeatMode(s string) string {
+	if s == heartbeatClient {
+		return heartbeatClient
+	}
+	return heartbeatServer
+}
+
+// Server timestamps use RFC 3339 with sub-second precision so clients can
+// measure round trips
+const serverTimeFormat = time.RFC3339Nano
+
+// Report whether a text frame is an app-level ping and return its token
+func parseAppPing(payload []byte) (token []byte, ok bool) {
+	rest, found := bytes.CutPrefix(payload, []byte(appPingText))
+	if !found {
+		return nil, false
+	}
+	if len(rest) == 0 {
+		return nil, true
+	}
+	if rest[0] != ' ' {
+		return nil, false // e.g. "PINGPONG" is just text
+	}
+	return rest[1:], true
+}
+
+// Build the PONG reply to an app-level ping
+func appPong(now time.Time, token []byte) []byte {
+	reply := []byte("PONG " + now.UTC().Format(serverTimeFormat))
+	if len(token) > 0 {
+		reply = append(append(reply, ' '), token...)
+	}
+	return reply
+}
+
+// The JSON form of PING
+func runPing(_ *Session, req CommandRequest) CommandResponse {
+	return CommandResponse{Command: req.Command, ServerTime: time.Now().UTC().Format(serverTimeFormat)}
+}
@@ -0,0 +1,151 @@
+// Filename: internal/ws/ping_test.go
+
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestAppPing(t *testing.T) {
+	h := NewHandler(Options{})
+	conn := dial(t, startServer(t, h))
+
+	tests := []struct {
+		send  string
+		token string
+	}{
+		{"PING", ""},
+		{"PING abc-123", "abc-123"},
+		{"PING two words", "two words"},
+	}
+
+	for _, tt := range tests {
+		before := h.stats.messages.Load()
+		got := roundTrip(t, conn, tt.send)
+		if after := h.stats.messages.Load(); after != before {
+			t.Errorf("send %q: message counter moved from %d to %d", tt.send, before, after)
+		}
+
+		fields := strings.SplitN(got, " ", 3)
+		if len(fields) < 2 || fields[0] != "PONG" {
+			t.Fatalf("send %q: got %q", tt.send, got)
+		}
+		if _, err := time.Parse(time.RFC3339, fields[1]); err != nil {
+			t.Errorf("send %q: bad timestamp %q: %v", tt.send, fields[1], err)
+		}
+		if token := strings.Join(fields[2:], ""); token != tt.token {
+			t.Errorf("send %q: got token %q expected %q", tt.send, token, tt.token)
+		}
+	}
+
+	// Only an exact PING (or PING + space) is special; prefixes still work
+	if got := rawRoundTrip(t, conn, "PINGPONG"); !strings.HasSuffix(got, "] PINGPONG") {
+		t.Errorf("PINGPONG: got %q", got)
+	}
+	if got := roundTrip(t, conn, "UPPER:ping"); got != "PING" {
+		t.Errorf("UPPER:ping: got %q", got)
+	}
+}
+
+func TestPingCommand(t *testing.T) {
+	conn := dial(t, startServer(t, NewHandler(Options{})))
+
+	var resp CommandResponse
+	got := roundTrip(t, conn, `{"command":"ping"}`)
+	if err := json.Unmarshal([]byte(got), &resp); err != nil {
+		t.Fatalf("unmarshal %s: %v", got, err)
+	}
+	if resp.Command != "ping" || resp.Error != "" {
+		t.Fatalf("got %s", got)
+	}
+	if _, err := time.Parse(time.RFC3339, resp.ServerTime); err != nil {
+		t.Errorf("bad server_time %q: %v", resp.ServerTime, err)
+	}
+}
+
+func TestHeartbeatModes(t *testing.T) {
+	const wait = 300 * time.Millisecond
+	url := startServer(t, NewHandler(Options{PongWait: wait, PingPeriod: 50 * time.Millisecond}))
+
+	// Dial with ?heartbeat=mode, count the server's pings, and hand every
+	// data frame (or the error that ends the connection) to the test
+	open := func(mode string) (*websocket.Conn, *atomic.Int64, chan error, welcomeFrame) {
+		t.Helper()
+		conn, raw := dialWelcome(t, websocket.DefaultDialer, url+"?heartbeat="+mode)
+		var welcome welcomeFrame
+		if err := json.Unmarshal(raw, &welcome); err != nil {
+			t.Fatalf("welcome: %v", err)
+		}
+		var pings atomic.Int64
+		conn.SetPingHandler(func(data string) error {
+			pings.Add(1)
+			return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+		})
+		ended := make(chan error, 16)
+		go func() {
+			for {
+				if _, _, err := readData(conn); err != nil {
+					ended <- err
+					return
+				}
+				ended <- nil
+			}
+		}()
+		return conn, &pings, ended, welcome
+	}
+
+	server, serverPings, serverEnded, welcome := open("bogus")
+	if welcome.Heartbeat != heartbeatServer {
+		t.Errorf("?heartbeat=bogus: got mode %q expected server", welcome.Heartbeat)
+	}
+	client, clientPings, clientEnded, welcome := open("client")
+	if welcome.Heartbeat != heartbeatClient {
+		t.Errorf("?heartbeat=client: got mode %q expected client", welcome.Heartbeat)
+	}
+
+	// For four times the wait, the client-mode connection lives on its own
+	// pings and the server-mode one on answering ours
+	for range 12 {
+		if err := client.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second)); err != nil {
+			t.Fatalf("client ping: %v", err)
+		}
+		time.Sleep(wait / 3)
+	}
+	for name, conn := range map[string]*websocket.Conn{"server": server, "client": client} {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"command":"add","a":1,"b":1}`)); err != nil {
+			t.Fatalf("%s mode: write after %s: %v", name, 4*wait, err)
+		}
+	}
+	for name, ended := range map[string]chan error{"server": serverEnded, "client": clientEnded} {
+		if err := <-ended; err != nil {
+			t.Fatalf("%s mode: closed after %s: %v", name, 4*wait, err)
+		}
+	}
+	if serverPings.Load() == 0 || clientPings.Load() != 0 {
+		t.Errorf("pings from the server: %d in server mode, %d in client mode; expected some and none",
+			serverPings.Load(), clientPings.Load())
+	}
+
+	// Gone quiet, the client-mode connection is dropped
+	select {
+	case err := <-clientEnded:
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseNormalClosure {
+			t.Errorf("silent client: got %v expected 1000", err)
+		}
+	case <-time.After(4 * wait):
+		t.Error("silent client-mode connection still open")
+	}
+	select {
+	case err := <-serverEnded:
+		t.Errorf("server-mode connection ended: %v", err)
+	default:
+	}
+}
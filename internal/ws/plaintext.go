@@ -0,0 +1,94 @@
+package ws
+
+// Filename: internal/ws/plaintext.go
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// The operand fields plain-text numbers fill, in order
+var plainOperands = []string{"a", "b"}
+
+// Read a text frame such as "add 2 3" as a command, for clients that can't
+// easily build JSON. It only is one when the first word is exactly the name
+// of a registered, non-streaming command taking a (and maybe b), and every
+// other word, of which there is at least one, is a finite number, as many
+// as the command has operands. Anything else is chat text and is echoed as
+// usual, so "add something later" never turns into an error.
+func parsePlainCommand(reg *CommandRegistry, payload []byte) (CommandRequest, bool) {
+	words := strings.Fields(string(payload))
+	if len(words) < 2 {
+		return CommandRequest{}, false
+	}
+	cmd, ok := reg.lookup(words[0])
+	if !ok || cmd.stream || cmd.handler == nil {
+		return CommandRequest{}, false
+	}
+	var slots []string
+	for _, name := range plainOperands {
+		for _, p := range cmd.info.Params {
+			if p == name {
+				slots = append(slots, name)
+				break
+			}
+		}
+	}
+	args := words[1:]
+	if len(args) > len(slots) {
+		return CommandRequest{}, false
+	}
+	req := CommandRequest{Command: words[0]}
+	for i, word := range args {
+		o, ok := plainNumber(word)
+		if !ok {
+			return CommandRequest{}, false
+		}
+		switch slots[i] {
+		case "a":
+			req.A = o
+		case "b":
+			req.B = o
+		}
+	}
+	return req, true
+}
+
+// A word as a literal operand, read the way JSON numbers are, so "2" is
+// held exactly as an integer. NaN and the infinities don't count.
+func plainNumber(word string) (Operand, bool) {
+	if i, err := strconv.ParseInt(word, 10, 64); err == nil {
+		return Integer(i), true
+	}
+	v, err := strconv.ParseFloat(word, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return Operand{}, false
+	}
+	return Num(v), true
+}
+
+// Render the response to a plain-text command as one line: the command as
+// typed (spacing normalised), then "= " and the result or text, or data as
+// compact JSON for commands without either; "error: " and the message if
+// it failed
+func plainReply(payload []byte, resp CommandResponse) ([]byte, error) {
+	if resp.Error != "" {
+		return []byte("error: " + resp.Error), nil
+	}
+	line := strings.Join(strings.Fields(string(payload)), " ") + " = "
+	switch {
+	case resp.Result != nil:
+		return []byte(line + strconv.FormatFloat(*resp.Result, 'g', -1, 64)), nil
+	case resp.Text != "":
+		return []byte(line + resp.Text), nil
+	case resp.Data != nil:
+		data, err := json.Marshal(resp.Data)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(line), data...), nil
+	}
+	return []byte(line + "ok"), nil
+}
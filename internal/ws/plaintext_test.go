@@ -0,0 +1,81 @@
+// Filename: internal/ws/plaintext_test.go
+
+package ws
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestPlainCommands(t *testing.T) {
+	url := startServer(t, NewHandler(Options{}))
+	conn := dial(t, url)
+
+	tests := []struct {
+		send     string
+		expected string
+	}{
+		{"add 2 3", "add 2 3 = 5"},
+		{"  multiply   1.5  4 ", "multiply 1.5 4 = 6"},
+		{"divide 10 0", "error: Division by zero"},
+		{"subtract 1e3 1", "subtract 1e3 1 = 999"},
+		{"set_limit 2048", `set_limit 2048 = {"read_limit":2048}`},
+
+		// Chat text falls through to the echo
+		{"add something later", "add something later"},
+		{"add 2 three", "add 2 three"},
+		{"add 1 2 3", "add 1 2 3"},
+		{"add", "add"},
+		{"add NaN 1", "add NaN 1"},
+		{"Add 2 3", "Add 2 3"},
+		{"vars 1", "vars 1"},
+		{"count 1 3", "count 1 3"},
+	}
+	for _, tt := range tests {
+		if got := roundTrip(t, conn, tt.send); got != tt.expected {
+			t.Errorf("send %q: got %q expected %q", tt.send, got, tt.expected)
+		}
+	}
+}
+
+func TestPlainCommandsMatchJSON(t *testing.T) {
+	url := startServer(t, NewHandler(Options{}))
+	conn := dial(t, url)
+
+	for _, tt := range []struct{ plain, json string }{
+		{"add 2 3", `{"command":"add","a":2,"b":3}`},
+		{"divide 7 2", `{"command":"divide","a":7,"b":2}`},
+		{"exp 1", `{"command":"exp","a":1}`},
+		{"sin 0.5", `{"command":"sin","a":0.5}`},
+		{"multiply 9007199254740993 1", `{"command":"multiply","a":9007199254740993,"b":1}`},
+	} {
+		var resp CommandResponse
+		if err := json.Unmarshal([]byte(roundTrip(t, conn, tt.json)), &resp); err != nil || resp.Result == nil {
+			t.Fatalf("%s: %+v (%v)", tt.json, resp, err)
+		}
+		expected := tt.plain + " = " + strconv.FormatFloat(*resp.Result, 'g', -1, 64)
+		if got := roundTrip(t, conn, tt.plain); got != expected {
+			t.Errorf("send %q: got %q expected %q", tt.plain, got, expected)
+		}
+	}
+
+	// The plain result is "ans" for the next command, as a JSON one is
+	roundTrip(t, conn, "add 40 2")
+	if got := roundTrip(t, conn, `{"command":"add","a":"ans","b":0}`); got != `{"command":"add","result":42}` {
+		t.Errorf("ans after a plain command: got %s", got)
+	}
+}
+
+func TestPlainCommandsNotOnSubprotocols(t *testing.T) {
+	if _, ok := parsePlainCommand(DefaultRegistry, []byte("add 2 3")); !ok {
+		t.Fatal("add 2 3: expected a command")
+	}
+	url := startServer(t, NewHandler(Options{}))
+	conn := dialWith(t, &websocket.Dialer{Subprotocols: []string{subprotocolEcho}}, url)
+	if got := roundTrip(t, conn, "add 2 3"); got != "add 2 3" {
+		t.Errorf("echo.v1: got %q expected the echo", got)
+	}
+}
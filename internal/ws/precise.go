@@ -0,0 +1,126 @@
+package ws
+
+// Filename: internal/ws/precise.go
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+)
+
+// Error codes for precise arithmetic
+const (
+	ErrCodeInvalidNumber    = "ERR_INVALID_NUMBER"
+	ErrCodeInvalidPrecision = "ERR_INVALID_PRECISION"
+)
+
+// Limits on precise arithmetic. Formatting a big.Float takes time that
+// grows with its exponent (1e-100000 takes seconds), so decimal exponents
+// are capped along with the precision and the length of a string operand.
+const (
+	defaultPrecision = 256 // mantissa bits when only a_str or b_str asks for precise mode
+	maxPrecision     = 4096
+	maxNumberLen     = 1000
+	maxExponent      = 1000
+
+	// Extra mantissa bits carried through the arithmetic and rounded away
+	// when the result is printed, so 1 - 0.9 prints as 0.1 rather than
+	// showing the binary rounding in its last digits
+	guardBits = 32
+)
+
+// Plain decimal only: no hex, no underscores, no Inf or NaN
+var decimalPattern = regexp.MustCompile(`^[+-]?(?:[0-9]+(?:\.[0-9]*)?|\.[0-9]+)(?:[eE]([+-]?[0-9]+))?$`)
+
+// Does the request ask for big.Float arithmetic instead of float64?
+func wantsPrecise(req CommandRequest) bool {
+	return req.Precision != 0 || req.AStr != "" || req.BStr != ""
+}
+
+// Run an arithmetic command on big.Floats. a_str and b_str are parsed as
+// decimals; plain a and b go through their shortest decimal form, so 0.1
+// means 0.1 rather than the float64 nearest to it.
+func runPrecise(s *Session, req CommandRequest, fn func(command string, a, b *big.Float) CommandResponse) CommandResponse {
+	prec := req.Precision
+	switch {
+	case prec == 0:
+		prec = defaultPrecision
+	case prec < 0 || prec > maxPrecision:
+		return errorResponse(req.Command, ErrCodeInvalidPrecision,
+			fmt.Sprintf("precision must be from 1 to %d bits", maxPrecision))
+	}
+	a, errResp := s.resolvePrecise(req.Command, "a_str", req.AStr, req.A, req.AVar, uint(prec)+guardBits)
+	if errResp != nil {
+		return *errResp
+	}
+	b, errResp := s.resolvePrecise(req.Command, "b_str", req.BStr, req.B, req.BVar, uint(prec)+guardBits)
+	if errResp != nil {
+		return *errResp
+	}
+	return fn(req.Command, a, b)
+}
+
+// Resolve one operand at prec bits: the string if there is one, else the
+// usual operand
+func (s *Session) resolvePrecise(command, field, str string, o Operand, varName string, prec uint) (*big.Float, *CommandResponse) {
+	if str == "" {
+		v, errResp := s.resolve(command, o, varName)
+		if errResp != nil {
+			return nil, errResp
+		}
+		str = strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	m := decimalPattern.FindStringSubmatch(str)
+	if len(str) > maxNumberLen || m == nil {
+		resp := errorResponse(command, ErrCodeInvalidNumber, fmt.Sprintf("%s must be a decimal number of at most %d characters", field, maxNumberLen))
+		return nil, &resp
+	}
+	if exp, err := strconv.Atoi(m[1]); m[1] != "" && (err != nil || exp > maxExponent || exp < -maxExponent) {
+		resp := errorResponse(command, ErrCodeInvalidNumber, fmt.Sprintf("%s's exponent must be within ±%d", field, maxExponent))
+		return nil, &resp
+	}
+	f, _, err := big.ParseFloat(str, 10, prec, big.ToNearestEven)
+	if err != nil {
+		resp := errorResponse(command, ErrCodeInvalidNumber, fmt.Sprintf("%s: %v", field, err))
+		return nil, &resp
+	}
+	return f, nil
+}
+
+// Significant decimal digits that prec mantissa bits can be trusted for
+func precisionDigits(prec uint) int {
+	return max(1, int(float64(prec)*math.Log10(2)))
+}
+
+// The reply to a precise command: result_str always, to as many digits as
+// the requested precision holds, and result as well when float64 can hold
+// it. Too large or too small for float64, result is left out (and "ans"
+// stays as it was).
+func preciseResponse(command string, z *big.Float) CommandResponse {
+	resp := CommandResponse{Command: command, ResultStr: z.Text('g', precisionDigits(z.Prec()-guardBits))}
+	if v, _ := z.Float64(); !math.IsInf(v, 0) && (v != 0 || z.Sign() == 0) {
+		resp.Result = &v
+	}
+	return resp
+}
+
+func runPreciseAdd(command string, a, b *big.Float) CommandResponse {
+	return preciseResponse(command, new(big.Float).SetPrec(a.Prec()).Add(a, b))
+}
+
+func runPreciseSubtract(command string, a, b *big.Float) CommandResponse {
+	return preciseResponse(command, new(big.Float).SetPrec(a.Prec()).Sub(a, b))
+}
+
+func runPreciseMultiply(command string, a, b *big.Float) CommandResponse {
+	return preciseResponse(command, new(big.Float).SetPrec(a.Prec()).Mul(a, b))
+}
+
+func runPreciseDivide(command string, a, b *big.Float) CommandResponse {
+	if b.Sign() == 0 {
+		return errorResponse(command, ErrCodeDivByZero, "Division by zero")
+	}
+	return preciseResponse(command, new(big.Float).SetPrec(a.Prec()).Quo(a, b))
+}
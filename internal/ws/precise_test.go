@@ -0,0 +1,92 @@
+// Filename: internal/ws/precise_test.go
+
+package ws
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestPreciseArithmetic(t *testing.T) {
+	tests := []struct {
+		name   string
+		req    CommandRequest
+		want   string
+		result float64 // 0 means no float result
+	}{
+		{"classic", CommandRequest{Command: "add", AStr: "0.1", BStr: "0.2"}, "0.3", 0.3},
+		// Plain operands go through their decimal form
+		{"float operands", CommandRequest{Command: "add", A: Num(0.1), B: Num(0.2), Precision: 64}, "0.3", 0.3},
+		{"mixed", CommandRequest{Command: "subtract", AStr: "1", B: Num(0.9)}, "0.1", 0.1},
+		{"multiply", CommandRequest{Command: "multiply", AStr: "1.1", BStr: "1.1"}, "1.21", 1.21},
+		{"divide", CommandRequest{Command: "divide", AStr: "1", BStr: "3", Precision: 64}, "0.3333333333333333333", 1.0 / 3},
+		{"more bits, more digits", CommandRequest{Command: "divide", AStr: "2", BStr: "3", Precision: 128},
+			"0.66666666666666666666666666666666666667", 2.0 / 3},
+		// Beyond float64 both ways: only result_str
+		{"overflow", CommandRequest{Command: "multiply", AStr: "1e300", BStr: "1e300"}, "1e+600", 0},
+		{"underflow", CommandRequest{Command: "divide", AStr: "1e-300", BStr: "1e300"}, "1e-600", 0},
+		{"huge", CommandRequest{Command: "add", AStr: "123456789012345678901234567890", BStr: "1"},
+			"123456789012345678901234567891", 123456789012345678901234567891},
+	}
+	for _, tt := range tests {
+		resp := processCommand(DefaultRegistry, newSession(defaultHistorySize), tt.req)
+		if resp.Code != "" || resp.ResultStr != tt.want {
+			t.Errorf("%s: got %+v expected %s", tt.name, resp, tt.want)
+			continue
+		}
+		switch {
+		case tt.result == 0 && resp.Result != nil:
+			t.Errorf("%s: got result %v, expected none", tt.name, *resp.Result)
+		case tt.result != 0 && (resp.Result == nil || *resp.Result != tt.result):
+			t.Errorf("%s: got result %v expected %v", tt.name, resp.Result, tt.result)
+		}
+	}
+}
+
+func TestPreciseResultIsAns(t *testing.T) {
+	s := newSession(defaultHistorySize)
+	processCommand(DefaultRegistry, s, CommandRequest{Command: "add", AStr: "0.1", BStr: "0.2"})
+	resp := processCommand(DefaultRegistry, s, CommandRequest{Command: "multiply", A: Operand{Ref: ansOperand}, B: Num(10), Precision: 64})
+	if resp.ResultStr != "3" {
+		t.Errorf("got %+v", resp)
+	}
+
+	// Without the precise fields nothing changes
+	b, _ := json.Marshal(processCommand(DefaultRegistry, s, CommandRequest{Command: "add", A: Num(0.1), B: Num(0.2)}))
+	if !strings.Contains(string(b), `"result":0.30000000000000004`) || strings.Contains(string(b), "result_str") {
+		t.Errorf("float64 add: got %s", b)
+	}
+}
+
+func TestPreciseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		req  CommandRequest
+		code string
+	}{
+		{"divide by zero", CommandRequest{Command: "divide", AStr: "1", BStr: "0.000"}, ErrCodeDivByZero},
+		{"zero by zero", CommandRequest{Command: "divide", AStr: "0", BStr: "0"}, ErrCodeDivByZero},
+		{"not a number", CommandRequest{Command: "add", AStr: "abc", BStr: "1"}, ErrCodeInvalidNumber},
+		{"bad b", CommandRequest{Command: "add", AStr: "1", BStr: "1..2"}, ErrCodeInvalidNumber},
+		{"inf", CommandRequest{Command: "add", AStr: "Inf", BStr: "1"}, ErrCodeInvalidNumber},
+		{"nan", CommandRequest{Command: "add", AStr: "NaN", BStr: "1"}, ErrCodeInvalidNumber},
+		{"hex", CommandRequest{Command: "add", AStr: "0x10", BStr: "1"}, ErrCodeInvalidNumber},
+		{"exponent too large", CommandRequest{Command: "add", AStr: "1e100000000", BStr: "1"}, ErrCodeInvalidNumber},
+		{"exponent too small", CommandRequest{Command: "add", AStr: "1e-1001", BStr: "1"}, ErrCodeInvalidNumber},
+		{"too long", CommandRequest{Command: "add", AStr: strings.Repeat("9", maxNumberLen+1), BStr: "1"}, ErrCodeInvalidNumber},
+		{"precision too high", CommandRequest{Command: "add", AStr: "1", BStr: "1", Precision: maxPrecision + 1}, ErrCodeInvalidPrecision},
+		{"negative precision", CommandRequest{Command: "add", A: Num(1), B: Num(1), Precision: -1}, ErrCodeInvalidPrecision},
+		{"no ans", CommandRequest{Command: "add", A: Operand{Ref: ansOperand}, BStr: "1"}, ErrCodeNoResult},
+	}
+	for _, tt := range tests {
+		resp := processCommand(DefaultRegistry, newSession(defaultHistorySize), tt.req)
+		if resp.Code != tt.code || resp.ResultStr != "" {
+			t.Errorf("%s: got %+v expected %s", tt.name, resp, tt.code)
+		}
+	}
+	req := CommandRequest{Command: "add", AStr: "1e1000", BStr: "1", Precision: maxPrecision}
+	if resp := processCommand(DefaultRegistry, newSession(defaultHistorySize), req); resp.ResultStr == "" {
+		t.Errorf("largest exponent and precision: got %+v", resp)
+	}
+}
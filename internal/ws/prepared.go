@@ -0,0 +1,71 @@
+package ws
+
+// Filename: internal/ws/prepared.go
+
+import (
+	"log"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// fanout is one frame on its way to many clients. It is encoded once per
+// encoding, the first time a client with that encoding needs it, rather
+// than once per client; see sharedFrame for the framing.
+type fanout struct {
+	v     interface{}
+	bseq  uint64 // the frame's broadcast number; 0 unless it's a broadcast
+	lane  lane   // laneData unless the frame is a system notice
+	json  sharedFrame
+	mpack sharedFrame
+}
+
+// One encoding of a fanout frame. For clients that compress it, the frame
+// is also framed and deflated once, as a PreparedMessage. Uncompressed
+// frames are cheaper to write directly than through a PreparedMessage
+// (see BenchmarkFanout), so that is only built when a deflate client
+// first needs it.
+type sharedFrame struct {
+	encodeOnce  sync.Once
+	messageType int
+	data        []byte // nil if the frame couldn't be encoded
+
+	prepareOnce sync.Once
+	prepared    *websocket.PreparedMessage
+}
+
+func newFanout(v interface{}) *fanout {
+	return &fanout{v: v}
+}
+
+// Queue the frame for c if there is room on its lane; see tryEnqueue
+func (f *fanout) trySend(c *client) bool {
+	p, messageType, marshal := &f.json, websocket.TextMessage, marshalResponse
+	if c.encoding == encodingMsgpack {
+		p, messageType, marshal = &f.mpack, websocket.BinaryMessage, marshalMsgpack
+	}
+	p.encodeOnce.Do(func() {
+		// Logged once; every client misses the frame
+		p.messageType = messageType
+		var err error
+		if p.data, err = marshal(f.v); err != nil {
+			c.log.Printf("encode %T: %v", f.v, err)
+		}
+	})
+	m := outbound{messageType: p.messageType, data: p.data, shared: p, droppable: f.lane == laneData, bseq: f.bseq}
+	return p.data != nil && c.tryQueue(m, f.lane)
+}
+
+// The frame as a PreparedMessage, or nil if it can't be prepared; the
+// failure goes to logger, once
+func (p *sharedFrame) message(logger *log.Logger) *websocket.PreparedMessage {
+	p.prepareOnce.Do(func() {
+		pm, err := websocket.NewPreparedMessage(p.messageType, p.data)
+		if err != nil {
+			logger.Printf("prepare frame: %v", err)
+			return
+		}
+		p.prepared = pm
+	})
+	return p.prepared
+}
@@ -0,0 +1,138 @@
+// Filename: internal/ws/prepared_test.go
+
+package ws
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Read frames until one decodes (with the connection's encoding) as a broadcast
+func readRawBroadcast(t *testing.T, conn *websocket.Conn, msgpackEncoded bool) ([]byte, broadcastFrame) {
+	t.Helper()
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		var frame broadcastFrame
+		if msgpackEncoded {
+			dec := msgpack.NewDecoder(bytes.NewReader(msg))
+			dec.SetCustomStructTag("json")
+			err = dec.Decode(&frame)
+		} else {
+			err = json.Unmarshal(msg, &frame)
+		}
+		if err == nil && frame.Type == "broadcast" {
+			return msg, frame
+		}
+	}
+}
+
+func TestFanoutSamePayloadWithAndWithoutCompression(t *testing.T) {
+	url := startServer(t, NewHandler(Options{Compression: true, CompressionThreshold: 64, MaxBroadcastBytes: 2048}))
+	plain := dial(t, url)
+	deflate := dialWith(t, &websocket.Dialer{EnableCompression: true}, url)
+	packed := dial(t, url+"?encoding=msgpack")
+	sender := dial(t, url)
+
+	text := strings.Repeat("fan me out ", 180)
+	before := atomic.LoadUint64(&compressedCounter)
+	if got := roundTrip(t, sender, `{"command":"broadcast","text":"`+text+`"}`); !strings.Contains(got, `"delivered":3`) {
+		t.Fatalf("broadcast: got %s", got)
+	}
+
+	plainRaw, plainFrame := readRawBroadcast(t, plain, false)
+	deflateRaw, _ := readRawBroadcast(t, deflate, false)
+	if atomic.LoadUint64(&compressedCounter) == before {
+		t.Error("the deflate client's copy wasn't compressed")
+	}
+	if !bytes.Equal(plainRaw, deflateRaw) {
+		t.Errorf("payloads differ:\n plain   %.80s\n deflate %.80s", plainRaw, deflateRaw)
+	}
+	if plainFrame.Text != text {
+		t.Errorf("text came back altered (%d bytes)", len(plainFrame.Text))
+	}
+	if _, packedFrame := readRawBroadcast(t, packed, true); packedFrame != plainFrame {
+		t.Errorf("msgpack client: got %+v expected %+v", packedFrame, plainFrame)
+	}
+}
+
+// Server ends of n websocket connections whose clients discard everything
+func fanoutConns(b *testing.B, n int, compress bool) []*websocket.Conn {
+	upgrader := websocket.Upgrader{EnableCompression: compress}
+	conns := make(chan *websocket.Conn, n)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			b.Error(err)
+			return
+		}
+		conn.EnableWriteCompression(compress)
+		conns <- conn
+	}))
+	b.Cleanup(srv.Close)
+
+	d := &websocket.Dialer{EnableCompression: compress}
+	out := make([]*websocket.Conn, n)
+	for i := range out {
+		client, _, err := d.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Cleanup(func() { client.Close() })
+		go func() {
+			for {
+				if _, _, err := client.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+		out[i] = <-conns
+	}
+	return out
+}
+
+// One frame to 100 connections, framed per connection or once
+func BenchmarkFanout(b *testing.B) {
+	payload := []byte(`{"type":"broadcast","from":"bench","text":"` + strings.Repeat("lorem ipsum ", 80) + `"}`)
+	for _, compress := range []bool{false, true} {
+		conns := fanoutConns(b, 100, compress)
+		name := "compress=" + strconv.FormatBool(compress)
+		b.Run(name+"/per-connection", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				for _, c := range conns {
+					if err := c.WriteMessage(websocket.TextMessage, payload); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+		b.Run(name+"/prepared", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				pm, err := websocket.NewPreparedMessage(websocket.TextMessage, payload)
+				if err != nil {
+					b.Fatal(err)
+				}
+				for _, c := range conns {
+					if err := c.WritePreparedMessage(pm); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
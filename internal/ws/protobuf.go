@@ -0,0 +1,146 @@
+package ws
+
+// Filename: internal/ws/protobuf.go
+
+import (
+	"encoding/json"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/alexdev404/ws-main/internal/ws/pb"
+)
+
+// Error code for binary frames that are not a valid protobuf CommandRequest
+const ErrCodeInvalidProtobuf = "ERR_INVALID_PROTOBUF"
+
+// Decode a protobuf CommandRequest, run it like its JSON twin, and reply
+// with a protobuf CommandResponse. Returns false once the connection is closing.
+func (h *Handler) handleProtobufFrame(c *client, remote string, payload []byte) bool {
+	var resp CommandResponse
+
+	var in pb.CommandRequest
+	if err := proto.Unmarshal(payload, &in); err != nil {
+		h.opts.Logger.Printf("protobuf decode error from %s (%d bytes): %v", remote, len(payload), err)
+		resp = c.session.stamp(errorResponse("", ErrCodeInvalidProtobuf, "Invalid protobuf: "+err.Error()))
+	} else {
+		req := commandRequestFromPB(&in)
+		if h.opts.Registry.isStream(req.Command) {
+			resp = c.session.stamp(errorResponse(req.Command, ErrCodeNotBatchable, "Streaming commands are only available over JSON"))
+		} else {
+			resp = processCommand(h.opts.Registry, c.session, req)
+		}
+	}
+
+	msg, err := commandResponseToPB(resp)
+	if err == nil {
+		var out []byte
+		if out, err = proto.Marshal(msg); err == nil {
+			return c.enqueue(websocket.BinaryMessage, out, laneData)
+		}
+	}
+	h.opts.Logger.Printf("protobuf encode error: %v", err)
+	c.closeWith(websocket.CloseInternalServerErr, "internal error")
+	return false
+}
+
+func operandFromPB(o *pb.Operand) Operand {
+	switch v := o.GetValue().(type) {
+	case *pb.Operand_Number:
+		return Num(v.Number)
+	case *pb.Operand_Ref:
+		return Operand{Ref: v.Ref}
+	}
+	return Operand{}
+}
+
+func operandToPB(o Operand) *pb.Operand {
+	if o.Ref != "" {
+		return &pb.Operand{Value: &pb.Operand_Ref{Ref: o.Ref}}
+	}
+	return &pb.Operand{Value: &pb.Operand_Number{Number: o.Value}}
+}
+
+func commandRequestFromPB(in *pb.CommandRequest) CommandRequest {
+	return CommandRequest{
+		Command:    in.GetCommand(),
+		ID:         in.GetId(),
+		A:          operandFromPB(in.GetA()),
+		B:          operandFromPB(in.GetB()),
+		AVar:       in.GetAVar(),
+		BVar:       in.GetBVar(),
+		Name:       in.GetName(),
+		Limit:      int(in.GetLimit()),
+		FromNum:    Num(in.GetFrom()),
+		ToNum:      Num(in.GetTo()),
+		To:         in.GetToRef(),
+		IntervalMS: int(in.GetIntervalMs()),
+		Enabled:    in.Enabled,
+		Text:       in.GetText(),
+	}
+}
+
+// CommandRequestToPB converts a request to its protobuf form, for clients
+// that speak the binary protocol
+func CommandRequestToPB(req CommandRequest) *pb.CommandRequest {
+	return &pb.CommandRequest{
+		Command:    req.Command,
+		Id:         req.ID,
+		A:          operandToPB(req.A),
+		B:          operandToPB(req.B),
+		AVar:       req.AVar,
+		BVar:       req.BVar,
+		Name:       req.Name,
+		Limit:      int32(req.Limit),
+		From:       req.FromNum.Value,
+		To:         req.ToNum.Value,
+		ToRef:      req.To,
+		IntervalMs: int32(req.IntervalMS),
+		Enabled:    req.Enabled,
+		Text:       req.Text,
+	}
+}
+
+func commandResponseToPB(resp CommandResponse) (*pb.CommandResponse, error) {
+	out := &pb.CommandResponse{
+		Command: resp.Command,
+		Id:      resp.ID,
+		Result:  resp.Result,
+		Done:    resp.Done,
+		Error:   resp.Error,
+		Code:    resp.Code,
+
+		ServerTime: resp.ServerTime,
+		Seq:        resp.Seq,
+		GlobalSeq:  resp.GlobalSeq,
+	}
+	if resp.Data != nil {
+		data, err := marshalResponse(resp.Data)
+		if err != nil {
+			return nil, err
+		}
+		out.DataJson = data
+	}
+	return out, nil
+}
+
+// CommandResponseFromPB converts a protobuf response back to the JSON
+// shape. Structured data is left as raw JSON.
+func CommandResponseFromPB(in *pb.CommandResponse) CommandResponse {
+	resp := CommandResponse{
+		Command: in.GetCommand(),
+		ID:      in.GetId(),
+		Result:  in.Result,
+		Done:    in.GetDone(),
+		Error:   in.GetError(),
+		Code:    in.GetCode(),
+
+		ServerTime: in.GetServerTime(),
+		Seq:        in.GetSeq(),
+		GlobalSeq:  in.GetGlobalSeq(),
+	}
+	if len(in.GetDataJson()) > 0 {
+		resp.Data = json.RawMessage(in.GetDataJson())
+	}
+	return resp
+}
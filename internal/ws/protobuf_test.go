@@ -0,0 +1,104 @@
+// Filename: internal/ws/protobuf_test.go
+
+package ws
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/alexdev404/ws-main/internal/ws/pb"
+)
+
+// Re-encode v through a generic value so key order doesn't matter
+func canonicalJSON(t *testing.T, v interface{}) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var generic interface{}
+	if err := json.Unmarshal(b, &generic); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	b, _ = json.Marshal(generic)
+	return string(b)
+}
+
+// Send a protobuf command and decode the protobuf reply
+func protobufRoundTrip(t *testing.T, conn *websocket.Conn, req *pb.CommandRequest) *pb.CommandResponse {
+	t.Helper()
+	payload, err := proto.Marshal(req)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var resp pb.CommandResponse
+	if err := proto.Unmarshal(binaryRoundTrip(t, conn, payload), &resp); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	return &resp
+}
+
+func TestProtobufParity(t *testing.T) {
+	url := startServer(t, NewHandler(Options{ProtobufBinary: true}))
+	jsonConn, pbConn := dial(t, url), dial(t, url)
+
+	// The same sequence on two fresh connections, so stateful commands line up.
+	// history is left out: it records text frames only.
+	script := []string{
+		`{"command":"add","a":2,"b":3}`,
+		`{"command":"subtract","a":"ans","b":1}`,
+		`{"command":"multiply","a":1.5,"b":4}`,
+		`{"command":"divide","a":1,"b":0}`,
+		`{"command":"divide","a":9,"b":3}`,
+		`{"command":"store"}`,
+		`{"command":"recall"}`,
+		`{"command":"clear"}`,
+		`{"command":"recall"}`,
+		`{"command":"set","name":"x","a":7}`,
+		`{"command":"get","name":"x"}`,
+		`{"command":"add","a_var":"x","b_var":"x"}`,
+		`{"command":"set","name":"9","a":1}`,
+		`{"command":"vars"}`,
+		`{"command":"help"}`,
+		`{"command":"count","from":1,"to":2,"interval_ms":10}`,
+		`{"command":"nope"}`,
+	}
+
+	for _, msg := range script {
+		var req CommandRequest
+		if err := json.Unmarshal([]byte(msg), &req); err != nil {
+			t.Fatalf("bad script entry %s: %v", msg, err)
+		}
+
+		// count is never sent over JSON, so the sequence numbers drift apart
+		var viaJSON CommandResponse
+		if req.Command == "count" {
+			// Streams are JSON-only; both encodings still answer with a response
+			viaJSON = errorResponse("count", ErrCodeNotBatchable, "Streaming commands are only available over JSON")
+		} else if err := json.Unmarshal([]byte(rawRoundTrip(t, jsonConn, msg)), &viaJSON); err != nil {
+			t.Fatalf("%s: json reply: %v", msg, err)
+		}
+		viaPB := CommandResponseFromPB(protobufRoundTrip(t, pbConn, CommandRequestToPB(req)))
+		viaJSON.Seq, viaJSON.GlobalSeq = 0, 0
+		viaPB.Seq, viaPB.GlobalSeq = 0, 0
+
+		if got, want := canonicalJSON(t, viaPB), canonicalJSON(t, viaJSON); got != want {
+			t.Errorf("%s:\n protobuf %s\n json     %s", msg, got, want)
+		}
+	}
+}
+
+func TestProtobufDecodeError(t *testing.T) {
+	conn := dial(t, startServer(t, NewHandler(Options{ProtobufBinary: true})))
+
+	var resp pb.CommandResponse
+	if err := proto.Unmarshal(binaryRoundTrip(t, conn, []byte{0xff, 0xff, 0xff}), &resp); err != nil {
+		t.Fatalf("reply is not protobuf: %v", err)
+	}
+	if resp.GetCode() != ErrCodeInvalidProtobuf {
+		t.Errorf("got %v expected code %s", &resp, ErrCodeInvalidProtobuf)
+	}
+}
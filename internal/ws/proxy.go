@@ -0,0 +1,66 @@
+package ws
+
+// Filename: internal/ws/proxy.go
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Address of the client behind r. Unless the direct peer is one of the
+// trusted proxies the forwarding headers are ignored and r.RemoteAddr is
+// returned as is. Otherwise X-Forwarded-For is walked from the right,
+// skipping trusted hops, and the first untrusted one is the client; with no
+// X-Forwarded-For, X-Real-IP is used.
+func clientAddr(r *http.Request, trusted []netip.Prefix) string {
+	peer, ok := parseHop(r.RemoteAddr)
+	if !ok || !inList(peer, trusted) {
+		return r.RemoteAddr
+	}
+
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	if len(hops) > 0 {
+		client := peer
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop, ok := parseHop(strings.TrimSpace(hops[i]))
+			if !ok {
+				// Can't see past a hop we don't understand
+				break
+			}
+			client = hop
+			if !inList(hop, trusted) {
+				break
+			}
+		}
+		return client.String()
+	}
+	if hop, ok := parseHop(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ok {
+		return hop.String()
+	}
+	return r.RemoteAddr
+}
+
+// ClientAddr is the address of the client behind r, believing forwarding
+// headers only from the trusted proxies, as the handler does for
+// Options.TrustedProxies
+func ClientAddr(r *http.Request, trusted []netip.Prefix) string {
+	return clientAddr(r, trusted)
+}
+
+// Parse an address as proxies write it: "1.2.3.4", "1.2.3.4:5678",
+// "2001:db8::1" or "[2001:db8::1]:5678"
+func parseHop(s string) (netip.Addr, bool) {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	addr, err := netip.ParseAddr(strings.Trim(s, "[]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap().WithZone(""), true
+}
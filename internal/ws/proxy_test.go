@@ -0,0 +1,96 @@
+// Filename: internal/ws/proxy_test.go
+
+package ws
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestClientAddr(t *testing.T) {
+	trusted, err := ParseIPList("10.0.0.0/8, 2001:db8::/32, 192.168.1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        []string
+		realIP     string
+		expected   string
+	}{
+		{"no proxy", "203.0.113.7:5000", nil, "", "203.0.113.7:5000"},
+		{"untrusted peer forging xff", "203.0.113.7:5000", []string{"1.1.1.1"}, "", "203.0.113.7:5000"},
+		{"untrusted peer forging real ip", "203.0.113.7:5000", nil, "1.1.1.1", "203.0.113.7:5000"},
+		{"single hop", "10.1.2.3:443", []string{"198.51.100.4"}, "", "198.51.100.4"},
+		{"multi hop", "10.1.2.3:443", []string{"198.51.100.4, 10.9.9.9, 10.0.0.2"}, "", "198.51.100.4"},
+		{"spoofed left of the client", "10.1.2.3:443", []string{"6.6.6.6, 198.51.100.4, 10.0.0.2"}, "", "198.51.100.4"},
+		{"split across headers", "10.1.2.3:443", []string{"6.6.6.6", "198.51.100.4, 10.0.0.2"}, "", "198.51.100.4"},
+		{"every hop trusted", "10.1.2.3:443", []string{"10.0.0.5, 10.0.0.2"}, "", "10.0.0.5"},
+		{"hop with port", "10.1.2.3:443", []string{"198.51.100.4:61000"}, "", "198.51.100.4"},
+		{"ipv6 peer", "[2001:db8::1]:443", []string{"2001:db8:ffff::1, 2001:db8::2"}, "", "2001:db8:ffff::1"},
+		{"ipv6 client with port", "[2001:db8::1]:443", []string{"[2600:1f18::9]:51000"}, "", "2600:1f18::9"},
+		{"ipv6 untrusted peer", "[2600:1f18::9]:443", []string{"1.1.1.1"}, "", "[2600:1f18::9]:443"},
+		{"mapped ipv4 peer", "[::ffff:10.1.2.3]:443", []string{"198.51.100.4"}, "", "198.51.100.4"},
+		{"single trusted address", "192.168.1.1:80", []string{"198.51.100.4"}, "", "198.51.100.4"},
+		{"neighbour of trusted address", "192.168.1.2:80", []string{"198.51.100.4"}, "", "192.168.1.2:80"},
+		{"garbage hop stops the walk", "10.1.2.3:443", []string{"198.51.100.4, nonsense, 10.0.0.2"}, "", "10.0.0.2"},
+		{"real ip", "10.1.2.3:443", nil, "198.51.100.4", "198.51.100.4"},
+		{"xff wins over real ip", "10.1.2.3:443", []string{"198.51.100.4"}, "6.6.6.6", "198.51.100.4"},
+		{"trusted peer without headers", "10.1.2.3:443", nil, "", "10.1.2.3:443"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/ws", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if got := clientAddr(r, trusted); got != tt.expected {
+				t.Errorf("got %q expected %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestForwardedAddrReachesAdminListing(t *testing.T) {
+	// httptest serves from loopback, so trust that
+	trusted, _ := ParseIPList("127.0.0.0/8, ::1")
+	h := NewHandler(Options{TrustedProxies: trusted})
+	mux := http.NewServeMux()
+	mux.Handle("/ws", h)
+	mux.Handle("/admin/", h.AdminHandler("secret"))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	header := http.Header{"Origin": {allowedOrigins[0]}, "X-Forwarded-For": {"198.51.100.4"}}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", header)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	_, _, _ = conn.ReadMessage() // welcome
+	roundTrip(t, conn, `{"command":"add","a":1,"b":1}`)
+
+	req, _ := http.NewRequest("GET", srv.URL+"/admin/connections", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var conns []connectionInfo
+	if err := json.NewDecoder(resp.Body).Decode(&conns); err != nil || len(conns) != 1 || conns[0].RemoteAddr != "198.51.100.4" {
+		t.Errorf("connections: got %+v, %v", conns, err)
+	}
+}
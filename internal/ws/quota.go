@@ -0,0 +1,35 @@
+package ws
+
+// Filename: internal/ws/quota.go
+
+import (
+	"github.com/gorilla/websocket"
+)
+
+// Notice sent as a connection nears and reaches Options.MessageQuota
+type quotaFrame struct {
+	Type      string `json:"type"` // always "quota"
+	Used      uint64 `json:"used"`
+	Limit     uint64 `json:"limit"`
+	Remaining uint64 `json:"remaining"`
+	Message   string `json:"message"`
+}
+
+// Check the connection's data frame count, seq, against quota after the
+// frame has been answered. At 90% the client is warned; at the quota it
+// gets a final notice and, queued behind it, a 1008 close. Notices go on
+// the system lane, so a client behind on broadcasts still gets them.
+func (c *client) enforceQuota(seq uint64, quota int) {
+	limit := uint64(quota)
+	frame := quotaFrame{Type: "quota", Used: seq, Limit: limit}
+	switch {
+	case seq >= limit:
+		frame.Message = "Message quota exhausted"
+		c.sendSystem(frame)
+		c.closeAfterQueued(websocket.ClosePolicyViolation, "message quota exceeded")
+	case seq == limit*9/10:
+		frame.Remaining = limit - seq
+		frame.Message = "90% of the message quota used"
+		c.sendSystem(frame)
+	}
+}
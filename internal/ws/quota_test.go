@@ -0,0 +1,68 @@
+// Filename: internal/ws/quota_test.go
+
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Read the next data frame as a quota notice
+func readQuota(t *testing.T, conn *websocket.Conn) quotaFrame {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, msg, err := readData(conn)
+	if err != nil {
+		t.Fatalf("read quota notice: %v", err)
+	}
+	var frame quotaFrame
+	if err := json.Unmarshal(msg, &frame); err != nil || frame.Type != "quota" {
+		t.Fatalf("expected a quota notice, got %s", msg)
+	}
+	return frame
+}
+
+func TestMessageQuota(t *testing.T) {
+	conn := dial(t, startServer(t, NewHandler(Options{MessageQuota: 5})))
+
+	for i := 1; i <= 5; i++ {
+		// App-level pings are free
+		if got := rawRoundTrip(t, conn, "PING"); !strings.HasPrefix(got, "PONG ") {
+			t.Fatalf("ping: got %s", got)
+		}
+		if got := roundTrip(t, conn, `{"command":"add","a":1,"b":1}`); got != `{"command":"add","result":2}` {
+			t.Fatalf("add %d: got %s", i, got)
+		}
+		switch i {
+		case 4:
+			if got := readQuota(t, conn); got.Used != 4 || got.Limit != 5 || got.Remaining != 1 {
+				t.Errorf("warning: got %+v", got)
+			}
+		case 5:
+			if got := readQuota(t, conn); got.Used != 5 || got.Limit != 5 || got.Remaining != 0 {
+				t.Errorf("final notice: got %+v", got)
+			}
+		}
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, msg, err := readData(conn)
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.ClosePolicyViolation || closeErr.Text != "message quota exceeded" {
+		t.Errorf("after the quota: got %q, %v expected close 1008", msg, err)
+	}
+}
+
+func TestNoQuotaByDefault(t *testing.T) {
+	conn := dial(t, startServer(t, NewHandler(Options{})))
+	for i := 0; i < 20; i++ {
+		if got := roundTrip(t, conn, `{"command":"add","a":1,"b":1}`); got != `{"command":"add","result":2}` {
+			t.Fatalf("add %d: got %s", i, got)
+		}
+	}
+}
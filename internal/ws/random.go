@@ -0,0 +1,78 @@
+package ws
+
+// Filename: internal/ws/random.go
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math"
+	mathrand "math/rand/v2"
+	"sync"
+)
+
+// Error code for a randint bound that isn't a whole number
+const ErrCodeNotInteger = "ERR_NOT_INTEGER"
+
+// randint and count bounds must be integers a float64 holds exactly
+const maxExactInt = 1 << 53
+
+// The math/rand/v2 global source, used when Options.RandSource is nil. It
+// is safe for concurrent use and seeded randomly.
+type globalSource struct{}
+
+func (globalSource) Uint64() uint64 { return mathrand.Uint64() }
+
+// A source shared by every connection of a handler, so it needs a lock
+type lockedSource struct {
+	mu  sync.Mutex
+	src mathrand.Source
+}
+
+func (l *lockedSource) Uint64() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.src.Uint64()
+}
+
+// Return a uniform integer in [a, b]
+func runRandInt(s *Session, req CommandRequest) CommandResponse {
+	a, errResp := s.resolve(req.Command, req.A, req.AVar)
+	if errResp != nil {
+		return *errResp
+	}
+	b, errResp := s.resolve(req.Command, req.B, req.BVar)
+	if errResp != nil {
+		return *errResp
+	}
+	for _, v := range []float64{a, b} {
+		if v != math.Trunc(v) || math.Abs(v) > maxExactInt {
+			return errorResponse(req.Command, ErrCodeNotInteger,
+				fmt.Sprintf("a and b must be integers no larger than 2^53 (got %v)", v))
+		}
+	}
+	if a > b {
+		return errorResponse(req.Command, ErrCodeInvalidRange, "a must not be greater than b")
+	}
+	lo, hi := int64(a), int64(b)
+	return resultResponse(req.Command, float64(lo+s.rand.Int64N(hi-lo+1)))
+}
+
+// Return a uniform float in [0, 1)
+func runRandFloat(s *Session, req CommandRequest) CommandResponse {
+	return resultResponse(req.Command, s.rand.Float64())
+}
+
+// Return a random (version 4) UUID, always from crypto/rand
+func runUUID(_ *Session, req CommandRequest) CommandResponse {
+	return CommandResponse{Command: req.Command, Text: newUUID()}
+}
+
+// A version 4 UUID as RFC 4122 lays it out: 122 random bits, the version
+// in the high nibble of byte 6 and the variant (10xx) in byte 8
+func newUUID() string {
+	var u [16]byte
+	_, _ = rand.Read(u[:]) // never fails, per crypto/rand
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
@@ -0,0 +1,114 @@
+// Filename: internal/ws/random_test.go
+
+package ws
+
+import (
+	"math/rand/v2"
+	"regexp"
+	"testing"
+)
+
+// A session drawing from a fixed source
+func seededSession(seed uint64) *Session {
+	s := newSession(defaultHistorySize)
+	s.rand = rand.New(rand.NewPCG(seed, seed))
+	return s
+}
+
+func TestRandIntRange(t *testing.T) {
+	s := seededSession(1)
+	seen := make(map[float64]int)
+	for i := 0; i < 2000; i++ {
+		resp := processCommand(DefaultRegistry, s, CommandRequest{Command: "randint", A: Num(-3), B: Num(3)})
+		if resp.Code != "" || resp.Result == nil {
+			t.Fatalf("got %+v", resp)
+		}
+		seen[*resp.Result]++
+	}
+	// Both bounds are included and nothing falls outside them
+	for v := -3.0; v <= 3; v++ {
+		if seen[v] == 0 {
+			t.Errorf("%v never came up: %v", v, seen)
+		}
+		delete(seen, v)
+	}
+	if len(seen) != 0 {
+		t.Errorf("out of range: %v", seen)
+	}
+
+	resp := processCommand(DefaultRegistry, s, CommandRequest{Command: "randint", A: Num(7), B: Num(7)})
+	if resp.Result == nil || *resp.Result != 7 {
+		t.Errorf("a == b: got %+v", resp)
+	}
+	// The result is "ans" like any other
+	if v, ok := s.LastResult(); !ok || v != 7 {
+		t.Errorf("ans: got %v %v", v, ok)
+	}
+}
+
+func TestRandIntErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b float64
+		code string
+	}{
+		{"a above b", 5, 1, ErrCodeInvalidRange},
+		{"fractional a", 1.5, 3, ErrCodeNotInteger},
+		{"fractional b", 1, 2.25, ErrCodeNotInteger},
+		{"too large", 0, 1 << 60, ErrCodeNotInteger},
+	}
+	for _, tt := range tests {
+		resp := processCommand(DefaultRegistry, seededSession(1), CommandRequest{Command: "randint", A: Num(tt.a), B: Num(tt.b)})
+		if resp.Code != tt.code {
+			t.Errorf("%s: got %+v expected %s", tt.name, resp, tt.code)
+		}
+	}
+}
+
+func TestRandFloatRange(t *testing.T) {
+	s := seededSession(2)
+	lo, hi := 1.0, 0.0
+	for i := 0; i < 2000; i++ {
+		resp := processCommand(DefaultRegistry, s, CommandRequest{Command: "randfloat"})
+		if resp.Result == nil || *resp.Result < 0 || *resp.Result >= 1 {
+			t.Fatalf("got %+v", resp)
+		}
+		lo, hi = min(lo, *resp.Result), max(hi, *resp.Result)
+	}
+	if lo > 0.01 || hi < 0.99 {
+		t.Errorf("samples span [%v, %v]", lo, hi)
+	}
+}
+
+func TestRandSourceOption(t *testing.T) {
+	// Two handlers with the same seed hand out the same numbers
+	var got [2][]string
+	for i := range got {
+		url := startServer(t, NewHandler(Options{RandSource: rand.NewPCG(42, 42)}))
+		conn := dial(t, url)
+		for j := 0; j < 5; j++ {
+			got[i] = append(got[i], roundTrip(t, conn, `{"command":"randint","a":1,"b":1000000}`))
+		}
+	}
+	for j := range got[0] {
+		if got[0][j] != got[1][j] {
+			t.Fatalf("same seed, different draws: %v and %v", got[0], got[1])
+		}
+	}
+}
+
+func TestUUID(t *testing.T) {
+	// Lowercase 8-4-4-4-12 hex, version 4, variant 10xx
+	format := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		resp := processCommand(DefaultRegistry, seededSession(1), CommandRequest{Command: "uuid"})
+		if !format.MatchString(resp.Text) || resp.Result != nil {
+			t.Fatalf("got %+v", resp)
+		}
+		if seen[resp.Text] {
+			t.Fatalf("repeated %s", resp.Text)
+		}
+		seen[resp.Text] = true
+	}
+}
@@ -0,0 +1,177 @@
+package ws
+
+// Filename: internal/ws/redis.go
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis bridge settings
+const (
+	defaultRedisChannel = "ws:events"
+	redisQueueSize      = 256 // events waiting to be published before new ones are dropped
+	redisMinBackoff     = 100 * time.Millisecond
+	redisMaxBackoff     = 10 * time.Second
+)
+
+// What instances send each other over the channel
+type redisEnvelope struct {
+	Instance string          `json:"instance"`
+	Kind     string          `json:"kind"` // "broadcast" or "presence"
+	Frame    json.RawMessage `json:"frame"`
+}
+
+// redisBridge relays a hub's broadcast and presence frames to the other
+// server instances on the same Redis channel, and theirs to this hub's
+// clients. While Redis is unreachable the hub simply stays local.
+type redisBridge struct {
+	client   *redis.Client
+	channel  string
+	instance string // tags our own publications so we skip them on the way back
+	hub      *hub
+	out      chan redisEnvelope
+	log      *log.Logger
+}
+
+func newRedisBridge(addr, channel string, h *hub, logger *log.Logger) *redisBridge {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	return &redisBridge{
+		client:   redis.NewClient(&redis.Options{Addr: addr}),
+		channel:  channel,
+		instance: hex.EncodeToString(id),
+		hub:      h,
+		out:      make(chan redisEnvelope, redisQueueSize),
+		log:      logger,
+	}
+}
+
+// Publish and subscribe until stop is closed
+func (b *redisBridge) start(stop <-chan struct{}, wg *sync.WaitGroup) {
+	ctx, cancel := context.WithCancel(context.Background())
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		<-stop
+		cancel()
+		b.client.Close()
+	}()
+	go func() {
+		defer wg.Done()
+		b.publishLoop(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		b.subscribeLoop(ctx)
+	}()
+}
+
+// Queue frame for the other instances. Called under the hub lock, so it
+// never waits: with Redis slow or down the frame is dropped.
+func (b *redisBridge) publish(kind string, frame interface{}) {
+	raw, err := json.Marshal(frame)
+	if err != nil {
+		b.log.Printf("redis: encode %s: %v", kind, err)
+		return
+	}
+	select {
+	case b.out <- redisEnvelope{Instance: b.instance, Kind: kind, Frame: raw}:
+	default:
+		b.log.Printf("redis: %s dropped: queue full", kind)
+	}
+}
+
+func (b *redisBridge) publishLoop(ctx context.Context) {
+	for {
+		select {
+		case env := <-b.out:
+			msg, _ := json.Marshal(env)
+			if err := b.client.Publish(ctx, b.channel, msg).Err(); err != nil && ctx.Err() == nil {
+				b.log.Printf("redis: publish %s: %v", env.Kind, err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Subscribe, deliver, and on any error resubscribe after a growing wait
+func (b *redisBridge) subscribeLoop(ctx context.Context) {
+	backoff := redisMinBackoff
+	for ctx.Err() == nil {
+		err := b.receive(ctx, func() { backoff = redisMinBackoff })
+		if ctx.Err() != nil {
+			return
+		}
+		b.log.Printf("redis: subscription lost, retrying in %s: %v", backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff = min(backoff*2, redisMaxBackoff)
+	}
+}
+
+// One subscription's lifetime; subscribed is called once it is confirmed
+func (b *redisBridge) receive(ctx context.Context, subscribed func()) error {
+	ps := b.client.Subscribe(ctx, b.channel)
+	defer ps.Close()
+	if _, err := ps.Receive(ctx); err != nil {
+		return err
+	}
+	subscribed()
+	for {
+		msg, err := ps.ReceiveMessage(ctx)
+		if err != nil {
+			return err
+		}
+		b.deliver([]byte(msg.Payload))
+	}
+}
+
+// Hand another instance's frame to every local client
+func (b *redisBridge) deliver(payload []byte) {
+	var env redisEnvelope
+	if err := json.Unmarshal(payload, &env); err != nil {
+		b.log.Printf("redis: bad message: %v", err)
+		return
+	}
+	if env.Instance == b.instance {
+		return
+	}
+
+	var frame interface{}
+	switch env.Kind {
+	case "broadcast":
+		frame = &broadcastFrame{}
+	case "presence":
+		frame = &presenceFrame{}
+	default:
+		return
+	}
+	if err := json.Unmarshal(env.Frame, frame); err != nil {
+		b.log.Printf("redis: bad %s frame: %v", env.Kind, err)
+		return
+	}
+	if bf, ok := frame.(*broadcastFrame); ok {
+		out, clients := b.hub.numbered(*bf, "")
+		for _, c := range clients {
+			c.trySendBroadcast(out)
+		}
+		return
+	}
+	b.hub.sse.publish(env.Kind, frame)
+	out := newFanout(frame)
+	for _, c := range b.hub.snapshot() {
+		out.trySend(c)
+	}
+}
@@ -0,0 +1,96 @@
+// Filename: internal/ws/redis_test.go
+
+package ws
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gorilla/websocket"
+)
+
+// Wait until n instances are subscribed to channel
+func waitSubscribers(t *testing.T, mr *miniredis.Miniredis, channel string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for mr.PubSubNumSub(channel)[channel] != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d subscribers expected %d", mr.PubSubNumSub(channel)[channel], n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Read frames until a broadcast, skipping presence
+func readBroadcast(t *testing.T, conn *websocket.Conn) broadcastFrame {
+	t.Helper()
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		var frame broadcastFrame
+		if json.Unmarshal(msg, &frame) == nil && frame.Type == "broadcast" {
+			return frame
+		}
+	}
+}
+
+func TestRedisBridgeAcrossHubs(t *testing.T) {
+	mr := miniredis.RunT(t)
+	newInstance := func() *Handler {
+		h := NewHandler(Options{RedisAddr: mr.Addr()})
+		t.Cleanup(h.Close)
+		return h
+	}
+	one, two := newInstance(), newInstance()
+	waitSubscribers(t, mr, defaultRedisChannel, 2)
+
+	listener := dial(t, startServer(t, two))
+	sender := dial(t, startServer(t, one))
+
+	// The sender's join crosses over as presence
+	if got := readPresence(t, listener); got.Event != "join" {
+		t.Errorf("presence: got %+v", got)
+	}
+
+	// Delivery counts stay local: nobody else is on instance one
+	if got := roundTrip(t, sender, `{"command":"broadcast","text":"across"}`); got != `{"command":"broadcast","data":{"delivered":0,"dropped":0}}` {
+		t.Errorf("ack: got %s", got)
+	}
+	if got := readBroadcast(t, listener); got.Text != "across" || got.From == "" {
+		t.Errorf("broadcast: got %+v", got)
+	}
+
+	// Instances resubscribe after Redis comes back
+	mr.Close()
+	if err := mr.Restart(); err != nil {
+		t.Fatalf("restart: %v", err)
+	}
+	waitSubscribers(t, mr, defaultRedisChannel, 2)
+	roundTrip(t, sender, `{"command":"broadcast","text":"again"}`)
+	if got := readBroadcast(t, listener); got.Text != "again" {
+		t.Errorf("after restart: got %+v", got)
+	}
+}
+
+func TestRedisUnavailableStaysLocal(t *testing.T) {
+	mr := miniredis.RunT(t)
+	addr := mr.Addr()
+	mr.Close()
+
+	h := NewHandler(Options{RedisAddr: addr})
+	t.Cleanup(h.Close)
+	url := startServer(t, h)
+	sender, listener := dial(t, url), dial(t, url)
+
+	if got := roundTrip(t, sender, `{"command":"broadcast","text":"local"}`); got != `{"command":"broadcast","data":{"delivered":1,"dropped":0}}` {
+		t.Errorf("ack: got %s", got)
+	}
+	if got := readBroadcast(t, listener); got.Text != "local" {
+		t.Errorf("broadcast: got %+v", got)
+	}
+}
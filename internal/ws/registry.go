@@ -0,0 +1,190 @@
+package ws
+
+// Filename: internal/ws/registry.go
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+// CommandHandler runs one JSON command for a connection's session and builds its response
+type CommandHandler func(*Session, CommandRequest) CommandResponse
+
+// CommandInfo describes a command for the "help" listing
+type CommandInfo struct {
+	Name        string   `json:"name"`
+	Params      []string `json:"params"`
+	Description string   `json:"description"`
+}
+
+type registeredCommand struct {
+	info    CommandInfo
+	handler CommandHandler
+	stream  bool // handled by handleStreamCommand instead of handler
+}
+
+// CommandRegistry maps command names to handlers. Registering is safe from
+// an init func or from main before the server starts; lookups are safe
+// from any number of connections at once.
+type CommandRegistry struct {
+	mu       sync.RWMutex
+	commands map[string]registeredCommand
+	order    []string // registration order, used by help
+
+	middleware []CommandMiddleware // see Use
+}
+
+// DefaultRegistry is used by handlers whose Options carry no Registry
+var DefaultRegistry = NewCommandRegistry()
+
+// NewCommandRegistry returns a registry holding the built-in commands
+func NewCommandRegistry() *CommandRegistry {
+	r := &CommandRegistry{commands: make(map[string]registeredCommand)}
+	r.registerBuiltins()
+	return r
+}
+
+// Register adds a command with no help text beyond its name
+func (r *CommandRegistry) Register(name string, handler CommandHandler) error {
+	return r.RegisterCommand(CommandInfo{Name: name}, handler)
+}
+
+// RegisterCommand adds a command along with its help description.
+// Registering a name twice is an error.
+func (r *CommandRegistry) RegisterCommand(info CommandInfo, handler CommandHandler) error {
+	if handler == nil {
+		return fmt.Errorf("ws: command %q has a nil handler", info.Name)
+	}
+	return r.add(registeredCommand{info: info, handler: handler})
+}
+
+func (r *CommandRegistry) add(cmd registeredCommand) error {
+	if cmd.info.Name == "" {
+		return fmt.Errorf("ws: command name must not be empty")
+	}
+	if cmd.info.Params == nil {
+		cmd.info.Params = []string{}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.commands[cmd.info.Name]; exists {
+		return fmt.Errorf("ws: command %q already registered", cmd.info.Name)
+	}
+	r.commands[cmd.info.Name] = cmd
+	r.order = append(r.order, cmd.info.Name)
+	return nil
+}
+
+func (r *CommandRegistry) lookup(name string) (registeredCommand, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	cmd, ok := r.commands[name]
+	return cmd, ok
+}
+
+// Commands lists every registered command in registration order
+func (r *CommandRegistry) Commands() []CommandInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]CommandInfo, 0, len(r.order))
+	for _, name := range r.order {
+		out = append(out, r.commands[name].info)
+	}
+	return out
+}
+
+func (r *CommandRegistry) isStream(name string) bool {
+	cmd, ok := r.lookup(name)
+	return ok && cmd.stream
+}
+
+func (r *CommandRegistry) mustAdd(cmd registeredCommand) {
+	if err := r.add(cmd); err != nil {
+		panic(err)
+	}
+}
+
+func (r *CommandRegistry) registerBuiltins() {
+	arith := func(name, description string, op arithOp) {
+		params := []string{"a", "b", "a_var", "b_var", "a_str", "b_str", "precision", "mode"}
+		r.mustAdd(registeredCommand{info: CommandInfo{Name: name, Params: params, Description: description}, handler: binaryOp(op)})
+	}
+	arith("add", "Return a + b", arithOp{runAdd, runPreciseAdd, runIntAdd})
+	arith("subtract", "Return a - b", arithOp{runSubtract, runPreciseSubtract, runIntSubtract})
+	arith("multiply", "Return a * b", arithOp{runMultiply, runPreciseMultiply, runIntMultiply})
+	arith("divide", "Return a / b; b must not be zero; in mode int, also the remainder", arithOp{runDivide, runPreciseDivide, runIntDivide})
+
+	unary := func(name, description string, params []string, fn func(CommandRequest, float64) CommandResponse) {
+		r.mustAdd(registeredCommand{info: CommandInfo{Name: name, Params: params, Description: description}, handler: unaryOp(fn)})
+	}
+	angle := []string{"a", "a_var", "unit"}
+	unary("sin", "Return the sine of a, in radians or with unit deg in degrees", angle, trig(math.Sin))
+	unary("cos", "Return the cosine of a, in radians or with unit deg in degrees", angle, trig(math.Cos))
+	unary("tan", "Return the tangent of a, in radians or with unit deg in degrees", angle, trig(math.Tan))
+	unary("log", "Return the natural logarithm of a; a must be positive", []string{"a", "a_var"}, logarithm(math.Log))
+	unary("log10", "Return the base 10 logarithm of a; a must be positive", []string{"a", "a_var"}, logarithm(math.Log10))
+	unary("exp", "Return e to the power a", []string{"a", "a_var"}, runExp)
+
+	r.mustAdd(registeredCommand{info: CommandInfo{Name: "store", Description: "Save the previous result in this connection's memory"}, handler: runStore})
+	r.mustAdd(registeredCommand{info: CommandInfo{Name: "recall", Description: "Return the value saved by store"}, handler: runRecall})
+	r.mustAdd(registeredCommand{info: CommandInfo{Name: "clear", Description: "Forget the value saved by store"}, handler: runClear})
+	r.mustAdd(registeredCommand{info: CommandInfo{Name: "set", Params: []string{"name", "a", "a_var"}, Description: "Bind a variable on this connection"}, handler: runSet})
+	r.mustAdd(registeredCommand{info: CommandInfo{Name: "get", Params: []string{"name"}, Description: "Return a variable's value"}, handler: runGet})
+	r.mustAdd(registeredCommand{info: CommandInfo{Name: "vars", Description: "List every variable on this connection"}, handler: runVars})
+	r.mustAdd(registeredCommand{info: CommandInfo{Name: "history", Params: []string{"limit"}, Description: "Return the most recent frames on this connection"}, handler: runHistory})
+
+	r.mustAdd(registeredCommand{
+		info:   CommandInfo{Name: "count", Params: []string{"from", "to", "interval_ms", "id"}, Description: "Stream one frame per integer from..to, then a done frame"},
+		stream: true,
+	})
+	r.mustAdd(registeredCommand{
+		info:   CommandInfo{Name: "cancel", Params: []string{"id"}, Description: "Stop the count stream with this id"},
+		stream: true,
+	})
+	r.mustAdd(registeredCommand{
+		info:   CommandInfo{Name: "delay", Params: []string{"a", "id"}, Description: "Reply with a after a milliseconds, without holding up other commands"},
+		stream: true,
+	})
+	r.mustAdd(registeredCommand{info: CommandInfo{Name: "transform", Params: []string{"op", "text"}, Description: "Return text in upper or lower case, reversed, or in title case"}, handler: runTransform})
+	r.mustAdd(registeredCommand{info: CommandInfo{Name: "hash", Params: []string{"algo", "text"}, Description: "Return the hex digest of text: md5, sha1, sha256 or sha512"}, handler: runHash})
+	r.mustAdd(registeredCommand{info: CommandInfo{Name: "hmac", Params: []string{"algo", "key", "text"}, Description: "Return the hex HMAC of text under key"}, handler: runHMAC})
+	r.mustAdd(registeredCommand{info: CommandInfo{Name: "b64encode", Params: []string{"text", "urlsafe"}, Description: "Return the base64 of text"}, handler: runB64Encode})
+	r.mustAdd(registeredCommand{info: CommandInfo{Name: "b64decode", Params: []string{"text", "urlsafe", "raw"}, Description: "Decode base64 text; raw returns the bytes in hex"}, handler: runB64Decode})
+	r.mustAdd(registeredCommand{info: CommandInfo{Name: "wordcount", Params: []string{"text"}, Description: "Count the whitespace-separated words of text"}, handler: runWordCount})
+	r.mustAdd(registeredCommand{info: CommandInfo{Name: "charcount", Params: []string{"text"}, Description: "Return the length of text in bytes, runes and chars"}, handler: runCharCount})
+	r.mustAdd(registeredCommand{info: CommandInfo{Name: "palindrome", Params: []string{"text"}, Description: "Check whether text's letters read the same backwards"}, handler: runPalindrome})
+	r.mustAdd(registeredCommand{info: CommandInfo{Name: "convert", Params: []string{"a", "from", "to"}, Description: "Convert a between units of temperature, length or mass"}, handler: runConvert})
+	r.mustAdd(registeredCommand{info: CommandInfo{Name: "randint", Params: []string{"a", "b"}, Description: "Return a random integer from a to b inclusive"}, handler: runRandInt})
+	r.mustAdd(registeredCommand{info: CommandInfo{Name: "randfloat", Description: "Return a random number from 0 up to but not including 1"}, handler: runRandFloat})
+	r.mustAdd(registeredCommand{info: CommandInfo{Name: "uuid", Description: "Return a random version 4 UUID"}, handler: runUUID})
+	r.mustAdd(registeredCommand{info: CommandInfo{Name: "time", Params: []string{"tz", "format"}, Description: "Return the server's time, in UTC or the IANA zone tz, as rfc3339, unix, unix_ms or kitchen"}, handler: runTime})
+	r.mustAdd(registeredCommand{info: CommandInfo{Name: "stats", Description: "Return server and connection statistics"}, handler: runStats})
+	r.mustAdd(registeredCommand{info: CommandInfo{Name: "who", Params: []string{"room", "offset", "limit"}, Description: "List connected clients, a page of at most 100 at a time"}, handler: runWho})
+	r.mustAdd(registeredCommand{info: CommandInfo{Name: "broadcast", Params: []string{"text", "ack"}, Description: "Send text to every other connected client"}, handler: runBroadcast})
+	r.mustAdd(registeredCommand{info: CommandInfo{Name: "dm", Params: []string{"to", "text", "ack"}, Description: "Send text to the connection named by to (nickname or conn_id)"}, handler: runDM})
+	r.mustAdd(registeredCommand{info: CommandInfo{Name: "bseq", Description: "Report the number of the latest broadcast, to spot missed ones"}, handler: runBSeq})
+	r.mustAdd(registeredCommand{info: CommandInfo{Name: "ack", Params: []string{"msg_id"}, Description: "Acknowledge the broadcast or dm with this msg_id"}, handler: runAck})
+	r.mustAdd(registeredCommand{info: CommandInfo{Name: "nick", Params: []string{"name"}, Description: "Set this connection's nickname to name"}, handler: runNick})
+	r.mustAdd(registeredCommand{info: CommandInfo{Name: "set_limit", Params: []string{"a"}, Description: "Set the largest frame this connection accepts to a bytes"}, handler: runSetLimit})
+	r.mustAdd(registeredCommand{info: CommandInfo{Name: "debug", Params: []string{"enabled"}, Description: "Turn debug mode on or off for this connection: frames logged, replies timed"}, handler: runDebug})
+	r.mustAdd(registeredCommand{info: CommandInfo{Name: "ticks", Params: []string{"enabled"}, Description: "Turn server tick broadcasts on or off for this connection"}, handler: runTicks})
+	r.mustAdd(registeredCommand{info: CommandInfo{Name: "upload", Params: []string{"name", "size", "chunks", "sha256"}, Description: "Receive a file as chunks binary frames, each prefixed with its index"}, handler: runUpload})
+	r.mustAdd(registeredCommand{info: CommandInfo{Name: "ping", Description: "Return the server time; also keeps the connection alive"}, handler: runPing})
+	r.mustAdd(registeredCommand{info: CommandInfo{Name: "version", Description: "Return the server's version, commit and build details"}, handler: runVersion})
+	r.mustAdd(registeredCommand{
+		info:    CommandInfo{Name: "help", Description: "List supported commands and text prefixes"},
+		handler: func(*Session, CommandRequest) CommandResponse { return r.help() },
+	})
+}
+
+// Payload of the "help" response
+type helpInfo struct {
+	Commands []CommandInfo `json:"commands"`
+	Prefixes []textPrefix  `json:"prefixes"`
+}
+
+func (r *CommandRegistry) help() CommandResponse {
+	return CommandResponse{Command: "help", Data: helpInfo{Commands: r.Commands(), Prefixes: textPrefixes}}
+}
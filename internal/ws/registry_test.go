@@ -0,0 +1,75 @@
+// Filename: internal/ws/registry_test.go
+
+package ws
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegistryDuplicate(t *testing.T) {
+	reg := NewCommandRegistry()
+	echo := func(_ *Session, req CommandRequest) CommandResponse { return CommandResponse{Command: req.Command} }
+
+	if err := reg.Register("echo", echo); err != nil {
+		t.Fatalf("first register: %v", err)
+	}
+	if err := reg.Register("echo", echo); err == nil {
+		t.Errorf("registering echo twice succeeded")
+	}
+	if err := reg.Register("add", echo); err == nil {
+		t.Errorf("overriding the built-in add succeeded")
+	}
+	if err := reg.Register("", echo); err == nil {
+		t.Errorf("registering an empty name succeeded")
+	}
+	if err := reg.Register("nil", nil); err == nil {
+		t.Errorf("registering a nil handler succeeded")
+	}
+}
+
+func TestRegistryIsolation(t *testing.T) {
+	reg := NewCommandRegistry()
+	_ = reg.Register("only-here", func(_ *Session, req CommandRequest) CommandResponse { return resultResponse(req.Command, 1) })
+
+	if resp := processCommand(DefaultRegistry, newSession(defaultHistorySize), CommandRequest{Command: "only-here"}); resp.Code != ErrCodeUnknownCommand {
+		t.Errorf("default registry ran a command registered elsewhere: %+v", resp)
+	}
+}
+
+func TestInjectedRegistry(t *testing.T) {
+	reg := NewCommandRegistry()
+	err := reg.RegisterCommand(CommandInfo{Name: "double", Params: []string{"a"}, Description: "Return 2a"},
+		func(_ *Session, req CommandRequest) CommandResponse {
+			return resultResponse(req.Command, 2*req.A.Value)
+		})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	conn := dial(t, startServer(t, NewHandler(Options{Registry: reg})))
+
+	if got := roundTrip(t, conn, `{"command":"double","a":21}`); got != `{"command":"double","result":42}` {
+		t.Errorf("double: got %s", got)
+	}
+	if got := roundTrip(t, conn, `{"command":"help"}`); !strings.Contains(got, `"name":"double","params":["a"],"description":"Return 2a"`) {
+		t.Errorf("help does not list double: %s", got)
+	}
+	if got := roundTrip(t, conn, `{"command":"triple"}`); !strings.Contains(got, ErrCodeUnknownCommand) {
+		t.Errorf("triple: got %s", got)
+	}
+}
+
+func TestMessagingCommandsDeclareParams(t *testing.T) {
+	for name, expected := range map[string]string{
+		"broadcast": "text,ack",
+		"dm":        "to,text,ack",
+		"ack":       "msg_id",
+		"nick":      "name",
+	} {
+		cmd, ok := DefaultRegistry.lookup(name)
+		if got := strings.Join(cmd.info.Params, ","); !ok || got != expected {
+			t.Errorf("%s: got params %q expected %q", name, got, expected)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package ws
+
+// Filename: internal/ws/reload.go
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"sync/atomic"
+)
+
+// Reloads of Options.ConfigFile that failed, across all handlers
+var configReloadFailures uint64
+
+// Returned by Reload on a handler without Options.ConfigFile
+var errNoConfigFile = errors.New("ws: no config file to reload")
+
+// OriginConfig is what Options.ConfigFile holds: the settings a running
+// Handler can reload. AllowedOrigins replaces Options.AllowedOrigins, so
+// leaving it out allows only the origins with policies.
+//
+//	{
+//	  "allowed_origins": ["https://app.example.com"],
+//	  "origin_policies": {"https://*.example.com": {"max_message_size": 16384}}
+//	}
+type OriginConfig struct {
+	AllowedOrigins []string                `json:"allowed_origins"`
+	OriginPolicies map[string]OriginPolicy `json:"origin_policies"`
+}
+
+// LoadOriginConfig reads and validates the OriginConfig in the JSON file at path
+func LoadOriginConfig(path string) (OriginConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return OriginConfig{}, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var cfg OriginConfig
+	if err := dec.Decode(&cfg); err != nil {
+		return OriginConfig{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := ValidateOriginPolicies(cfg.OriginPolicies); err != nil {
+		return OriginConfig{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Put cfg in force for the upgrades that start from now on. The snapshot
+// holds copies, so the caller may go on using cfg.
+func (h *Handler) setOrigins(cfg OriginConfig) {
+	h.origins.Store(&originConfig{
+		allowed:  slices.Clone(cfg.AllowedOrigins),
+		policies: maps.Clone(cfg.OriginPolicies),
+		fallback: OriginPolicy{
+			MaxMessageSize: h.opts.MaxMessageSize,
+			RejectBinary:   h.opts.RejectBinary,
+			BytesPerMinute: h.opts.BandwidthLimit,
+		},
+	})
+}
+
+// Reload reads Options.ConfigFile again and puts its allowlist and origin
+// policies in force for new upgrades. A file that can't be read or fails
+// validation leaves the current settings alone; the error is logged and
+// counted in stats. Connections already open keep the policy they opened
+// with, even if their origin is no longer allowed.
+func (h *Handler) Reload() error {
+	if h.opts.ConfigFile == "" {
+		return errNoConfigFile
+	}
+	cfg, err := LoadOriginConfig(h.opts.ConfigFile)
+	if err != nil {
+		atomic.AddUint64(&configReloadFailures, 1)
+		h.opts.Logger.Printf("config reload failed, keeping the previous config: %v", err)
+		return err
+	}
+	h.setOrigins(cfg)
+	h.opts.Logger.Printf("config reloaded from %s: %d allowed origins, %d origin policies",
+		h.opts.ConfigFile, len(cfg.AllowedOrigins), len(cfg.OriginPolicies))
+	return nil
+}
+
+func (h *Handler) reloadConfig(w http.ResponseWriter, r *http.Request) {
+	switch err := h.Reload(); {
+	case errors.Is(err, errNoConfigFile):
+		http.Error(w, "no config file", http.StatusNotFound)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,132 @@
+// Filename: internal/ws/reload_test.go
+
+package ws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// Write an OriginConfig file for the test
+func writeConfig(t *testing.T, path, body string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+}
+
+// Status of an upgrade from origin
+func dialStatus(t *testing.T, url, origin string) int {
+	t.Helper()
+	conn, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {origin}})
+	if err == nil {
+		conn.Close()
+	}
+	if resp == nil {
+		t.Fatalf("dial from %s: %v", origin, err)
+	}
+	return resp.StatusCode
+}
+
+func TestReloadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "origins.json")
+	writeConfig(t, path, `{"allowed_origins": ["https://old.example.com"]}`)
+	h := NewHandler(Options{ConfigFile: path})
+	mux := http.NewServeMux()
+	mux.Handle("/ws", h)
+	mux.Handle("/admin/", h.AdminHandler("secret"))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+
+	reload := func() int {
+		t.Helper()
+		req, _ := http.NewRequest("POST", srv.URL+"/admin/reload", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("reload: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// The file takes the place of the default allowlist
+	if got := dialStatus(t, url, "https://old.example.com"); got != http.StatusSwitchingProtocols {
+		t.Fatalf("old origin before reload: got %d", got)
+	}
+	if got := dialStatus(t, url, allowedOrigins[0]); got != http.StatusForbidden {
+		t.Fatalf("default origin: got %d expected 403", got)
+	}
+
+	// An open connection outlives its origin's removal
+	kept := dialOrigin(t, url, "https://old.example.com")
+
+	writeConfig(t, path, `{"allowed_origins": ["https://new.example.com"]}`)
+	if got := reload(); got != http.StatusNoContent {
+		t.Fatalf("reload: got %d expected 204", got)
+	}
+	if got := dialStatus(t, url, "https://new.example.com"); got != http.StatusSwitchingProtocols {
+		t.Errorf("new origin after reload: got %d", got)
+	}
+	if got := dialStatus(t, url, "https://old.example.com"); got != http.StatusForbidden {
+		t.Errorf("old origin after reload: got %d expected 403", got)
+	}
+	if got := roundTrip(t, kept, "still here"); got != "still here" {
+		t.Errorf("open connection: got %q", got)
+	}
+
+	// A broken file leaves the last good config in force
+	failures := atomic.LoadUint64(&configReloadFailures)
+	writeConfig(t, path, `{"allowed_origins": ["https://broken.example.com"`)
+	if got := reload(); got != http.StatusUnprocessableEntity {
+		t.Errorf("reload of a broken file: got %d expected 422", got)
+	}
+	if got := atomic.LoadUint64(&configReloadFailures); got != failures+1 {
+		t.Errorf("failure count: got %d expected %d", got, failures+1)
+	}
+	if got := dialStatus(t, url, "https://new.example.com"); got != http.StatusSwitchingProtocols {
+		t.Errorf("new origin after a failed reload: got %d", got)
+	}
+
+	// So does one that fails validation
+	writeConfig(t, path, `{"origin_policies": {"example.com": {}}}`)
+	if err := h.Reload(); err == nil {
+		t.Error("reload of an invalid policy succeeded")
+	}
+	if got := dialStatus(t, url, "https://new.example.com"); got != http.StatusSwitchingProtocols {
+		t.Errorf("new origin after an invalid reload: got %d", got)
+	}
+}
+
+func TestReloadPolicies(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "origins.json")
+	writeConfig(t, path, `{}`)
+	h := NewHandler(Options{ConfigFile: path})
+	url := startServer(t, h)
+
+	if got := dialStatus(t, url, "https://app.example.com"); got != http.StatusForbidden {
+		t.Fatalf("before reload: got %d expected 403", got)
+	}
+	writeConfig(t, path, `{"origin_policies": {"https://*.example.com": {"max_connections": 5}}}`)
+	if err := h.Reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if got := dialStatus(t, url, "https://app.example.com"); got != http.StatusSwitchingProtocols {
+		t.Errorf("after reload: got %d", got)
+	}
+}
+
+func TestReloadWithoutConfigFile(t *testing.T) {
+	h := NewHandler(Options{})
+	if err := h.Reload(); err != errNoConfigFile {
+		t.Errorf("got %v expected %v", err, errNoConfigFile)
+	}
+}
@@ -0,0 +1,85 @@
+package ws
+
+// Filename: internal/ws/replay.go
+
+import "time"
+
+// Defaults for the broadcast replay buffer
+const (
+	defaultReplayBuffer = 500              // broadcasts kept for resuming clients
+	defaultReplayAge    = 60 * time.Second // and for no longer than this
+)
+
+// Sent to a resuming client, before the replay, when broadcasts it asked
+// for are no longer held; it should refresh in full
+type replayGapFrame struct {
+	Type string `json:"type"`
+	From uint64 `json:"from"` // first bseq that can't be replayed
+	To   uint64 `json:"to"`   // last bseq that can't be replayed
+}
+
+// One buffered broadcast
+type replayEntry struct {
+	out    *fanout
+	sender string // conn_id that sent it
+	at     time.Time
+}
+
+// broadcastRing holds the latest broadcasts, at most max of them and none
+// older than age, oldest first. The hub's lock guards it.
+type broadcastRing struct {
+	max     int
+	age     time.Duration
+	entries []replayEntry
+}
+
+func (r *broadcastRing) add(out *fanout, sender string, now time.Time) {
+	if len(r.entries) == r.max {
+		r.entries = append(r.entries[:0], r.entries[1:]...)
+	}
+	r.entries = append(r.entries, replayEntry{out: out, sender: sender, at: now})
+}
+
+// The broadcasts after last still held at now, leaving out those sent by
+// skip, and the first bseq held (latest+1 when none are)
+func (r *broadcastRing) since(last, latest uint64, skip string, now time.Time) ([]*fanout, uint64) {
+	cutoff := now.Add(-r.age)
+	i := 0
+	for i < len(r.entries) && r.entries[i].at.Before(cutoff) {
+		i++
+	}
+	r.entries = r.entries[i:]
+	first := latest + 1
+	if len(r.entries) > 0 {
+		first = r.entries[0].out.bseq
+	}
+	var out []*fanout
+	for _, e := range r.entries {
+		if e.out.bseq > last && e.sender != skip {
+			out = append(out, e.out)
+		}
+	}
+	return out, first
+}
+
+// Add c like join, then queue the broadcasts after last for it, starting
+// with a replay_gap notice if some are gone. was is the conn_id c resumed,
+// whose own broadcasts it never got the first time. Broadcasts are
+// numbered and buffered under the same lock, so each one is either
+// replayed here or sent to c live, never both and never neither.
+func (h *hub) joinAfter(c *client, last uint64, was string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.add(c)
+	latest := h.bseq.Load()
+	if last >= latest {
+		return
+	}
+	missed, first := h.replay.since(last, latest, was, time.Now())
+	if first > last+1 {
+		c.trySendEncoded(replayGapFrame{Type: "replay_gap", From: last + 1, To: first - 1}, laneData)
+	}
+	for _, out := range missed {
+		c.trySendBroadcast(out)
+	}
+}
@@ -0,0 +1,89 @@
+// Filename: internal/ws/replay_test.go
+
+package ws
+
+import (
+	"fmt"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Read the next data frame as JSON text
+func nextFrame(t *testing.T, conn *websocket.Conn) string {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, msg, err := readData(conn)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return string(msg)
+}
+
+func TestResumeReplaysMissedBroadcasts(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		buffer   int
+		expected []string
+	}{
+		{"all held", 10, []string{
+			`{"type":"broadcast","from":"talker","text":"m2","bseq":2}`,
+			`{"type":"broadcast","from":"talker","text":"m3","bseq":3}`,
+			`{"type":"broadcast","from":"talker","text":"m4","bseq":4}`,
+		}},
+		// Only 3 and 4 are still held
+		{"overrun", 2, []string{
+			`{"type":"replay_gap","from":2,"to":2}`,
+			`{"type":"broadcast","from":"talker","text":"m3","bseq":3}`,
+			`{"type":"broadcast","from":"talker","text":"m4","bseq":4}`,
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := NewHandler(Options{ReplayBuffer: tc.buffer})
+			url := startServer(t, h)
+			talker, _ := dialDecoded(t, url)
+			roundTrip(t, talker, "NICK:talker")
+			listener, first := dialDecoded(t, url)
+
+			roundTrip(t, talker, `{"command":"broadcast","text":"m1"}`)
+			if got := nextFrame(t, listener); got != `{"type":"broadcast","from":"talker","text":"m1","bseq":1}` {
+				t.Fatalf("live: got %s", got)
+			}
+			dropAndWait(t, h, listener, 1)
+			for i := 2; i <= 4; i++ {
+				roundTrip(t, talker, fmt.Sprintf(`{"command":"broadcast","text":"m%d"}`, i))
+			}
+
+			listener, second := dialDecoded(t, url+"?resume="+first.ResumeToken+"&last_bseq=1")
+			if !second.Resumed {
+				t.Fatalf("resumed welcome: got %+v", second)
+			}
+			for _, exp := range tc.expected {
+				if got := nextFrame(t, listener); got != exp {
+					t.Errorf("replay: got %s expected %s", got, exp)
+				}
+			}
+			// Then live delivery carries on without a repeat
+			roundTrip(t, talker, `{"command":"broadcast","text":"m5"}`)
+			if got := nextFrame(t, listener); got != `{"type":"broadcast","from":"talker","text":"m5","bseq":5}` {
+				t.Errorf("live after replay: got %s", got)
+			}
+		})
+	}
+}
+
+func TestReplaySkipsOwnBroadcasts(t *testing.T) {
+	h := newHub(log.Default())
+	h.numbered(broadcastFrame{Type: "broadcast", From: "a", Text: "mine"}, "old")
+	h.numbered(broadcastFrame{Type: "broadcast", From: "b", Text: "theirs"}, "other")
+
+	c := tinyQueueClient(h, "new", 4)
+	h.leave(c)
+	h.joinAfter(c, 0, "old")
+	got := drainQueue(c)
+	if len(got) != 1 || got[0] != `{"type":"broadcast","from":"b","text":"theirs","bseq":2}` {
+		t.Errorf("replay: got %v", got)
+	}
+}
@@ -0,0 +1,131 @@
+package ws
+
+// Filename: internal/ws/resume.go
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults for session resumption
+const (
+	defaultResumeWindow = 2 * time.Minute
+	defaultMaxResumable = 10000 // closed sessions held for resumption at once
+)
+
+// What a resumed connection gets back from the one it replaces
+type resumeState struct {
+	seq       uint64
+	last      float64
+	hasLast   bool
+	memory    float64
+	hasMemory bool
+	vars      map[string]float64
+	nick      string
+	connID    string            // of the session resumed, for joinAfter
+	replies   *idempotencyCache // idempotency keys stay good across a resume
+}
+
+func (s *Session) snapshot() resumeState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := resumeState{
+		seq:  atomic.LoadUint64(&s.seq),
+		last: s.last, hasLast: s.hasLast,
+		memory: s.memory, hasMemory: s.hasMemory,
+		vars:    make(map[string]float64, len(s.vars)),
+		nick:    s.nick,
+		connID:  s.conn.ID,
+		replies: s.replies,
+	}
+	for k, v := range s.vars {
+		st.vars[k] = v
+	}
+	return st
+}
+
+// Take over st's counters and memory. The nickname is left to the caller,
+// which has to claim it from the hub again.
+func (s *Session) restore(st resumeState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	atomic.StoreUint64(&s.seq, st.seq)
+	s.last, s.hasLast = st.last, st.hasLast
+	s.memory, s.hasMemory = st.memory, st.hasMemory
+	s.vars = st.vars
+	if st.replies != nil {
+		s.replies = st.replies
+	}
+}
+
+// A fresh random resume token
+func newResumeToken() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+type resumeEntry struct {
+	state   resumeState
+	expires time.Time
+}
+
+// resumeStore holds the state of recently closed sessions by resume token.
+// Entries expire after window, each token works once, and when max entries
+// are held the one closest to expiring makes room.
+type resumeStore struct {
+	mu      sync.Mutex
+	window  time.Duration
+	max     int
+	entries map[string]resumeEntry
+}
+
+func newResumeStore(window time.Duration, max int) *resumeStore {
+	return &resumeStore{window: window, max: max, entries: make(map[string]resumeEntry)}
+}
+
+func (r *resumeStore) put(token string, st resumeState, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prune(now)
+	if len(r.entries) >= r.max {
+		oldest := ""
+		for t, e := range r.entries {
+			if oldest == "" || e.expires.Before(r.entries[oldest].expires) {
+				oldest = t
+			}
+		}
+		delete(r.entries, oldest)
+	}
+	r.entries[token] = resumeEntry{state: st, expires: now.Add(r.window)}
+}
+
+// Remove and return the state for token, if it is there and still fresh
+func (r *resumeStore) take(token string, now time.Time) (resumeState, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.entries[token]
+	delete(r.entries, token)
+	if !ok || now.After(e.expires) {
+		return resumeState{}, false
+	}
+	return e.state, true
+}
+
+func (r *resumeStore) len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.entries)
+}
+
+// Drop expired entries. Caller holds r.mu.
+func (r *resumeStore) prune(now time.Time) {
+	for t, e := range r.entries {
+		if now.After(e.expires) {
+			delete(r.entries, t)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Filename: internal/ws/resume_test.go
+
+package ws
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Dial and decode the welcome frame
+func dialDecoded(t *testing.T, url string) (*websocket.Conn, welcomeFrame) {
+	t.Helper()
+	conn, raw := dialWelcome(t, websocket.DefaultDialer, url)
+	var w welcomeFrame
+	if err := json.Unmarshal(raw, &w); err != nil {
+		t.Fatalf("welcome %s: %v", raw, err)
+	}
+	return conn, w
+}
+
+// Close conn and wait until the server has stored n sessions
+func dropAndWait(t *testing.T, h *Handler, conn *websocket.Conn, n int) {
+	t.Helper()
+	conn.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for h.resume.len() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("stored %d sessions expected %d", h.resume.len(), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestResumeRestoresSession(t *testing.T) {
+	h := NewHandler(Options{})
+	url := startServer(t, h)
+
+	conn, first := dialDecoded(t, url)
+	if first.ResumeToken == "" || first.Resumed || first.ResumeFailed {
+		t.Fatalf("welcome: got %+v", first)
+	}
+	roundTrip(t, conn, "NICK:mobile")
+	roundTrip(t, conn, `{"command":"set","name":"x","a":7}`)
+	roundTrip(t, conn, `{"command":"add","a":1,"b":2}`)
+	roundTrip(t, conn, `{"command":"store"}`)
+	dropAndWait(t, h, conn, 1)
+
+	conn, second := dialDecoded(t, url+"?resume="+first.ResumeToken)
+	if !second.Resumed || second.ResumeFailed || second.ResumeToken == first.ResumeToken {
+		t.Fatalf("resumed welcome: got %+v", second)
+	}
+
+	// Four frames went before, so this is the fifth
+	var resp CommandResponse
+	if err := json.Unmarshal([]byte(rawRoundTrip(t, conn, `{"command":"get","name":"x"}`)), &resp); err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if resp.Seq != 5 || resp.Result == nil || *resp.Result != 7 {
+		t.Errorf("get: got %+v", resp)
+	}
+	// In order: recall's result is what ans refers to next
+	for _, tc := range []struct{ send, expected string }{
+		{`{"command":"recall"}`, `{"command":"recall","result":3}`},
+		{`{"command":"add","a":"ans","b":1}`, `{"command":"add","result":4}`},
+	} {
+		if got := roundTrip(t, conn, tc.send); got != tc.expected {
+			t.Errorf("send %s: got %s expected %s", tc.send, got, tc.expected)
+		}
+	}
+	if got := who(t, conn); len(got) != 1 || got[0].Nick != "mobile" {
+		t.Errorf("who: got %+v", got)
+	}
+
+	// The token was used up
+	_, third := dialDecoded(t, url+"?resume="+first.ResumeToken)
+	if third.Resumed || !third.ResumeFailed {
+		t.Errorf("reused token: got %+v", third)
+	}
+}
+
+func TestResumeExpires(t *testing.T) {
+	h := NewHandler(Options{ResumeWindow: 20 * time.Millisecond})
+	url := startServer(t, h)
+
+	conn, first := dialDecoded(t, url)
+	roundTrip(t, conn, `{"command":"set","name":"x","a":7}`)
+	dropAndWait(t, h, conn, 1)
+	time.Sleep(40 * time.Millisecond)
+
+	conn, second := dialDecoded(t, url+"?resume="+first.ResumeToken)
+	if second.Resumed || !second.ResumeFailed {
+		t.Errorf("expired token: got %+v", second)
+	}
+	if got := roundTrip(t, conn, `{"command":"get","name":"x"}`); got != `{"command":"get","error":"Unknown variable \"x\"","code":"ERR_NO_SUCH_VAR"}` {
+		t.Errorf("fresh session: got %s", got)
+	}
+}
+
+func TestResumeStoreBounded(t *testing.T) {
+	r := newResumeStore(time.Minute, 2)
+	now := time.Now()
+	r.put("a", resumeState{seq: 1}, now)
+	r.put("b", resumeState{seq: 2}, now.Add(time.Second))
+	r.put("c", resumeState{seq: 3}, now.Add(2*time.Second))
+
+	if _, ok := r.take("a", now); ok {
+		t.Error("oldest entry kept past the limit")
+	}
+	if st, ok := r.take("c", now); !ok || st.seq != 3 {
+		t.Errorf("newest entry: got %+v, %v", st, ok)
+	}
+	if r.len() != 1 {
+		t.Errorf("len: got %d expected 1", r.len())
+	}
+}
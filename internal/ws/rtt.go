@@ -0,0 +1,84 @@
+package ws
+
+// Filename: internal/ws/rtt.go
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Round-trip samples kept per connection
+const rttWindowSize = 10
+
+// Default RTT above which a pong is logged as slow
+const defaultSlowRTT = 500 * time.Millisecond
+
+// Rolling window of the most recent ping round trips on one connection
+type rttWindow struct {
+	mu      sync.Mutex
+	samples [rttWindowSize]time.Duration
+	next    int // slot the next sample goes in
+	count   int // samples held, up to rttWindowSize
+}
+
+// RTTSummary is a snapshot of a connection's recent round-trip times
+type RTTSummary struct {
+	Samples int     `json:"samples"`
+	MinMS   float64 `json:"min_ms"`
+	AvgMS   float64 `json:"avg_ms"`
+	MaxMS   float64 `json:"max_ms"`
+	LastMS  float64 `json:"last_ms"`
+}
+
+func (w *rttWindow) add(d time.Duration) {
+	w.mu.Lock()
+	w.samples[w.next] = d
+	w.next = (w.next + 1) % rttWindowSize
+	if w.count < rttWindowSize {
+		w.count++
+	}
+	w.mu.Unlock()
+}
+
+func (w *rttWindow) summary() RTTSummary {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	out := RTTSummary{Samples: w.count}
+	if w.count == 0 {
+		return out
+	}
+	lo, hi, sum := w.samples[0], w.samples[0], time.Duration(0)
+	for _, d := range w.samples[:w.count] {
+		lo, hi, sum = min(lo, d), max(hi, d), sum+d
+	}
+	out.MinMS = ms(lo)
+	out.AvgMS = ms(sum / time.Duration(w.count))
+	out.MaxMS = ms(hi)
+	out.LastMS = ms(w.samples[(w.next+rttWindowSize-1)%rttWindowSize])
+	return out
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// Application data for an outgoing ping: the send time in unix nanos
+func pingPayload(now time.Time) []byte {
+	return strconv.AppendInt(nil, now.UnixNano(), 10)
+}
+
+// Recover the round trip from a pong's application data. ok is false when
+// the client echoed nothing or something we didn't send.
+func pongRTT(appData string, now time.Time) (rtt time.Duration, ok bool) {
+	sent, err := strconv.ParseInt(appData, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	rtt = now.Sub(time.Unix(0, sent))
+	if rtt < 0 {
+		return 0, false
+	}
+	return rtt, true
+}
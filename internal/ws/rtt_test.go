@@ -0,0 +1,86 @@
+// Filename: internal/ws/rtt_test.go
+
+package ws
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestRTTWindow(t *testing.T) {
+	var w rttWindow
+	if got := w.summary(); got.Samples != 0 {
+		t.Fatalf("empty window: got %+v", got)
+	}
+
+	// 12 samples of 1..12ms; only the last 10 (3..12ms) are kept
+	for i := 1; i <= 12; i++ {
+		w.add(time.Duration(i) * time.Millisecond)
+	}
+	expected := RTTSummary{Samples: 10, MinMS: 3, AvgMS: 7.5, MaxMS: 12, LastMS: 12}
+	if got := w.summary(); got != expected {
+		t.Errorf("got %+v expected %+v", got, expected)
+	}
+}
+
+func TestPongRTT(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		appData string
+		ok      bool
+	}{
+		{"our payload", string(pingPayload(now.Add(-20 * time.Millisecond))), true},
+		{"empty echo", "", false},
+		{"garbage", "hello", false},
+		{"from the future", string(pingPayload(now.Add(time.Hour))), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rtt, ok := pongRTT(tt.appData, now)
+			if ok != tt.ok {
+				t.Fatalf("ok: got %v expected %v", ok, tt.ok)
+			}
+			if ok && rtt != 20*time.Millisecond {
+				t.Errorf("rtt: got %s expected 20ms", rtt)
+			}
+		})
+	}
+}
+
+func TestRTTRecordedFromPongs(t *testing.T) {
+	// A command that reports the connection's RTT summary
+	reg := NewCommandRegistry()
+	if err := reg.Register("rtt", func(s *Session, req CommandRequest) CommandResponse {
+		return CommandResponse{Command: req.Command, Data: s.RTT()}
+	}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	const period = 20 * time.Millisecond
+	conn := dial(t, startServer(t, NewHandler(Options{Registry: reg, PingPeriod: period})))
+
+	// gorilla's client answers pings while it is reading, so keep asking
+	deadline := time.Now().Add(10 * period)
+	for {
+		var resp struct {
+			Data RTTSummary `json:"data"`
+		}
+		got := roundTrip(t, conn, `{"command":"rtt"}`)
+		if err := json.Unmarshal([]byte(got), &resp); err != nil {
+			t.Fatalf("unmarshal %s: %v", got, err)
+		}
+		if resp.Data.Samples > 0 {
+			if resp.Data.MinMS > resp.Data.AvgMS || resp.Data.AvgMS > resp.Data.MaxMS {
+				t.Errorf("inconsistent summary %+v", resp.Data)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no RTT sample after %s", 10*period)
+		}
+		time.Sleep(period / 2)
+	}
+}
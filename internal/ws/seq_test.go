@@ -0,0 +1,87 @@
+// Filename: internal/ws/seq_test.go
+
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestSequenceNumbers(t *testing.T) {
+	url := startServer(t, NewHandler(Options{}))
+	conns := []*websocket.Conn{dial(t, url), dial(t, url)}
+
+	const perConn = 20
+	globals := make([][]uint64, len(conns))
+	errs := make(chan error, len(conns)*perConn)
+
+	// Both connections talk at once so their global numbers interleave
+	var wg sync.WaitGroup
+	for c, conn := range conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 1; i <= perConn; i++ {
+				if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"command":"add","a":1,"b":1}`)); err != nil {
+					errs <- err
+					return
+				}
+				_, reply, err := readData(conn)
+				if err != nil {
+					errs <- err
+					return
+				}
+				var resp CommandResponse
+				if err := json.Unmarshal(reply, &resp); err != nil {
+					errs <- err
+					return
+				}
+				if resp.Seq != uint64(i) {
+					errs <- fmt.Errorf("conn %d frame %d: got seq %d", c, i, resp.Seq)
+				}
+				globals[c] = append(globals[c], resp.GlobalSeq)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	// global_seq increases on each connection and is never handed out twice
+	seen := make(map[uint64]bool)
+	for c, gs := range globals {
+		for i, g := range gs {
+			if i > 0 && g <= gs[i-1] {
+				t.Errorf("conn %d: global_seq went from %d to %d", c, gs[i-1], g)
+			}
+			if seen[g] {
+				t.Errorf("global_seq %d seen on both connections", g)
+			}
+			seen[g] = true
+		}
+	}
+}
+
+func TestEchoSequencePrefix(t *testing.T) {
+	conn := dial(t, startServer(t, NewHandler(Options{})))
+
+	for i := 1; i <= 3; i++ {
+		got := rawRoundTrip(t, conn, "hello")
+		var c, n uint64
+		var rest string
+		if _, err := fmt.Sscanf(got, "[Conn #%d / Msg #%d] %s", &c, &n, &rest); err != nil || c != uint64(i) || rest != "hello" {
+			t.Errorf("echo %d: got %q", i, got)
+		}
+	}
+
+	// HELP answers with the JSON listing, unprefixed
+	if got := rawRoundTrip(t, conn, helpText); got[0] != '{' {
+		t.Errorf("HELP: got %q", got)
+	}
+}
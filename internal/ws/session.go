@@ -0,0 +1,257 @@
+package ws
+
+// Filename: internal/ws/session.go
+
+import (
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Limits on named variables per connection
+const (
+	maxVarsPerSession = 100
+	maxVarNameLen     = 32
+)
+
+var varNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Session is the per-connection state handed to every command. It is
+// created when a connection opens and dropped with it, so nothing here
+// is shared between connections.
+type Session struct {
+	mu sync.Mutex
+
+	last    float64 // previous result, what "ans" refers to
+	hasLast bool
+
+	memory    float64 // value saved by "store"
+	hasMemory bool
+
+	vars map[string]float64 // bindings made with "set"
+
+	history *history // recent frames, returned by "history"
+
+	replies *idempotencyCache // responses by idempotency_key; see runIdempotent
+
+	rtt       rttWindow     // recent ping round trips
+	pings     atomic.Uint64 // pings the client sent us
+	pingBytes atomic.Uint64 // application data they carried
+	bytesIn   atomic.Uint64 // data frame payload read; see countIn
+	bytesOut  atomic.Uint64 // and written
+
+	conn connInfo // set when the connection opens, read-only after
+	nick string   // chosen with "nick"; "" until then
+
+	ticksOff atomic.Bool   // opted out of tick broadcasts
+	hub      *hub          // the handler's connections, for "who"; nil outside a handler
+	events   *eventBus     // where command runs are reported; nil outside a handler
+	stats    *handlerStats // counters shared with the handler's other connections
+	log      *log.Logger   // operational log lines; never nil
+
+	maxBroadcast int         // longest "broadcast" text, in bytes
+	whoAddrs     bool        // "who" lists remote addresses; see Options.WhoRemoteAddrs
+	broadcasts   *rateWindow // limits how often this connection may broadcast
+
+	rand  *rand.Rand // for randint and randfloat
+	clock Clock      // what "time" reports
+
+	timing bool // put duration_us on every reply, not just those that ask
+
+	debug      atomic.Bool  // debug mode; see setDebug
+	receivedAt atomic.Int64 // unix nanos the frame being handled was read, in debug mode
+
+	trace *connTrace // nil unless the handler traces
+
+	readLimit    atomic.Int64 // largest message the connection takes now, in bytes
+	maxReadLimit int64        // how far set_limit may raise it
+
+	uploadDir string  // where "upload" writes files; "" disables it
+	maxUpload int64   // largest upload accepted, in bytes
+	upload    *upload // the upload waiting for chunks, if any
+
+	// Sequence numbers of the frame being handled (atomic): seq counts data
+	// frames on this connection from 1, globalSeq is the handler's message counter
+	seq       uint64
+	globalSeq uint64
+}
+
+func newSession(historySize int) *Session {
+	return &Session{
+		vars:    make(map[string]float64),
+		history: newHistory(historySize),
+		replies: newIdempotencyCache(defaultIdempotencyKeys, defaultIdempotencyTTL),
+		rand:    rand.New(globalSource{}),
+		clock:   realClock{},
+		stats:   newHandlerStats(),
+		log:     log.Default(),
+
+		maxBroadcast: defaultMaxBroadcastBytes,
+		maxReadLimit: defaultMaxReadLimit,
+		broadcasts:   newRateWindow(defaultBroadcastLimit, defaultBroadcastWindow),
+	}
+}
+
+// Count a data frame on this connection and remember both its numbers for
+// stamping replies. Returns the per-connection number.
+func (s *Session) nextSeq(global uint64) uint64 {
+	atomic.StoreUint64(&s.globalSeq, global)
+	return atomic.AddUint64(&s.seq, 1)
+}
+
+// Add the time spent on a command to its reply, if the request or the
+// server asked for it or the frame was read in debug mode, which also gets
+// when it was read
+func (s *Session) timed(req CommandRequest, resp CommandResponse, elapsed time.Duration) CommandResponse {
+	at := s.receivedAt.Load()
+	if req.Timing || s.timing || at != 0 {
+		us := elapsed.Microseconds()
+		resp.DurationUS = &us
+	}
+	if at != 0 {
+		resp.ReceivedAt = time.Unix(0, at).UTC().Format(serverTimeFormat)
+	}
+	return resp
+}
+
+// Stamp a reply with the numbers of the frame it answers
+func (s *Session) stamp(resp CommandResponse) CommandResponse {
+	resp.Seq = atomic.LoadUint64(&s.seq)
+	resp.GlobalSeq = atomic.LoadUint64(&s.globalSeq)
+	return resp
+}
+
+func (s *Session) setTicks(enabled bool) {
+	s.ticksOff.Store(!enabled)
+}
+
+func (s *Session) ticksEnabled() bool {
+	return !s.ticksOff.Load()
+}
+
+// ConnID returns the connection's id, "c1", "c2" and so on; "" outside a
+// handler
+func (s *Session) ConnID() string {
+	return s.conn.ID
+}
+
+// Metadata returns what the connection said about itself in its /ws
+// query; see Options.MetadataKeys. The map must not be changed.
+func (s *Session) Metadata() map[string]string {
+	return s.conn.Metadata
+}
+
+// Nick returns the connection's nickname, or "" if it hasn't set one
+func (s *Session) Nick() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nick
+}
+
+// Only the hub sets this, so the name and its uniqueness change together
+func (s *Session) setNick(nick string) {
+	s.mu.Lock()
+	s.nick = nick
+	s.mu.Unlock()
+}
+
+// How other clients see this connection: its nickname, or its conn_id
+func (s *Session) name() string {
+	if nick := s.Nick(); nick != "" {
+		return nick
+	}
+	return s.conn.ID
+}
+
+// How logs refer to this connection: "c3", or "c3 (alice)" once named
+func (s *Session) label() string {
+	if nick := s.Nick(); nick != "" {
+		return s.conn.ID + " (" + nick + ")"
+	}
+	return s.conn.ID
+}
+
+// RTT summarizes this connection's recent ping round trips
+func (s *Session) RTT() RTTSummary {
+	return s.rtt.summary()
+}
+
+// Remember the result of a successful command for "ans"
+func (s *Session) setLast(v float64) {
+	s.mu.Lock()
+	s.last, s.hasLast = v, true
+	s.mu.Unlock()
+}
+
+// LastResult returns the previous result on this connection, if any
+func (s *Session) LastResult() (float64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last, s.hasLast
+}
+
+func (s *Session) store() (float64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.hasLast {
+		return 0, false
+	}
+	s.memory, s.hasMemory = s.last, true
+	return s.memory, true
+}
+
+func (s *Session) recall() (float64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.memory, s.hasMemory
+}
+
+func (s *Session) clearMemory() {
+	s.mu.Lock()
+	s.memory, s.hasMemory = 0, false
+	s.mu.Unlock()
+}
+
+// Check a variable name against the naming rules
+func validVarName(name string) error {
+	if len(name) > maxVarNameLen {
+		return fmt.Errorf("Variable name longer than %d characters", maxVarNameLen)
+	}
+	if !varNamePattern.MatchString(name) {
+		return fmt.Errorf("Invalid variable name %q", name)
+	}
+	return nil
+}
+
+// Bind name to v; new names fail once the session holds maxVarsPerSession
+func (s *Session) setVar(name string, v float64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.vars[name]; !exists && len(s.vars) >= maxVarsPerSession {
+		return false
+	}
+	s.vars[name] = v
+	return true
+}
+
+func (s *Session) getVar(name string) (float64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.vars[name]
+	return v, ok
+}
+
+// Copy of every binding, safe to hand to the encoder
+func (s *Session) varsSnapshot() map[string]float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]float64, len(s.vars))
+	for k, v := range s.vars {
+		out[k] = v
+	}
+	return out
+}
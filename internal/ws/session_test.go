@@ -0,0 +1,131 @@
+// Filename: internal/ws/session_test.go
+
+package ws
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCalculatorMemory(t *testing.T) {
+	conn := dial(t, startServer(t, NewHandler(Options{})))
+
+	tests := []struct {
+		send     string
+		expected string
+	}{
+		{`{"command":"add","a":"ans","b":1}`, `{"command":"add","error":"No previous result for \"ans\"","code":"ERR_NO_RESULT"}`},
+		{`{"command":"store"}`, `{"command":"store","error":"No previous result to store","code":"ERR_NO_RESULT"}`},
+		{`{"command":"recall"}`, `{"command":"recall","error":"Nothing stored","code":"ERR_NO_MEMORY"}`},
+		{`{"command":"add","a":2,"b":3}`, `{"command":"add","result":5}`},
+		{`{"command":"multiply","a":"ans","b":"ans"}`, `{"command":"multiply","result":25}`},
+		{`{"command":"store"}`, `{"command":"store","result":25}`},
+		{`{"command":"subtract","a":"ans","b":5}`, `{"command":"subtract","result":20}`},
+		{`{"command":"divide","a":1,"b":0}`, `{"command":"divide","error":"Division by zero","code":"ERR_DIVISION_BY_ZERO"}`},
+		{`{"command":"add","a":"ans","b":0}`, `{"command":"add","result":20}`},
+		{`{"command":"recall"}`, `{"command":"recall","result":25}`},
+		{`{"command":"clear"}`, `{"command":"clear","done":true}`},
+		{`{"command":"recall"}`, `{"command":"recall","error":"Nothing stored","code":"ERR_NO_MEMORY"}`},
+		{`{"command":"add","a":"x","b":0}`, `{"command":"add","error":"Unknown operand \"x\"","code":"ERR_INVALID_OPERAND"}`},
+		{`[{"command":"add","a":1,"b":1},{"command":"add","a":"ans","b":1}]`, `[{"command":"add","result":2},{"command":"add","result":3}]`},
+	}
+
+	for _, tt := range tests {
+		if got := roundTrip(t, conn, tt.send); got != tt.expected {
+			t.Errorf("send %s: got %s expected %s", tt.send, got, tt.expected)
+		}
+	}
+
+	if got := roundTrip(t, conn, `{"command":"add","a":true}`); !strings.Contains(got, ErrCodeInvalidJSON) {
+		t.Errorf("boolean operand: got %s", got)
+	}
+}
+
+func TestCalculatorMemoryIsolation(t *testing.T) {
+	url := startServer(t, NewHandler(Options{}))
+	first, second := dial(t, url), dial(t, url)
+
+	roundTrip(t, first, `{"command":"add","a":40,"b":2}`)
+	roundTrip(t, first, `{"command":"store"}`)
+
+	if got := roundTrip(t, second, `{"command":"recall"}`); !strings.Contains(got, ErrCodeNoMemory) {
+		t.Errorf("second connection saw first's memory: %s", got)
+	}
+	if got := roundTrip(t, second, `{"command":"add","a":"ans","b":0}`); !strings.Contains(got, ErrCodeNoResult) {
+		t.Errorf("second connection saw first's ans: %s", got)
+	}
+	if got := roundTrip(t, first, `{"command":"recall"}`); got != `{"command":"recall","result":42}` {
+		t.Errorf("first recall: got %s", got)
+	}
+}
+
+func TestVariables(t *testing.T) {
+	conn := dial(t, startServer(t, NewHandler(Options{})))
+
+	tests := []struct {
+		send     string
+		expected string
+	}{
+		{`{"command":"vars"}`, `{"command":"vars","data":{}}`},
+		{`{"command":"set","name":"x","a":5}`, `{"command":"set","result":5}`},
+		{`{"command":"set","name":"_y2","a":"ans"}`, `{"command":"set","result":5}`},
+		{`{"command":"get","name":"x"}`, `{"command":"get","result":5}`},
+		{`{"command":"add","a_var":"x","b":2}`, `{"command":"add","result":7}`},
+		{`{"command":"multiply","a_var":"x","b_var":"_y2"}`, `{"command":"multiply","result":25}`},
+		{`{"command":"set","name":"x","a_var":"_y2"}`, `{"command":"set","result":5}`},
+		{`{"command":"vars"}`, `{"command":"vars","data":{"_y2":5,"x":5}}`},
+		{`{"command":"get","name":"z"}`, `{"command":"get","error":"Unknown variable \"z\"","code":"ERR_NO_SUCH_VAR"}`},
+		{`{"command":"add","a_var":"z","b":1}`, `{"command":"add","error":"Unknown variable \"z\"","code":"ERR_NO_SUCH_VAR"}`},
+		{`{"command":"set","name":"9x","a":1}`, `{"command":"set","error":"Invalid variable name \"9x\"","code":"ERR_INVALID_NAME"}`},
+		{`{"command":"set","name":"","a":1}`, `{"command":"set","error":"Invalid variable name \"\"","code":"ERR_INVALID_NAME"}`},
+		{`{"command":"set","name":"` + strings.Repeat("v", maxVarNameLen+1) + `","a":1}`,
+			`{"command":"set","error":"Variable name longer than 32 characters","code":"ERR_INVALID_NAME"}`},
+	}
+
+	for _, tt := range tests {
+		if got := roundTrip(t, conn, tt.send); got != tt.expected {
+			t.Errorf("send %s: got %s expected %s", tt.send, got, tt.expected)
+		}
+	}
+}
+
+func TestVariablesCap(t *testing.T) {
+	s := newSession(defaultHistorySize)
+	reg := NewCommandRegistry()
+	for i := 0; i < maxVarsPerSession; i++ {
+		req := CommandRequest{Command: "set", Name: fmt.Sprintf("v%d", i), A: Num(1)}
+		if resp := processCommand(reg, s, req); resp.Error != "" {
+			t.Fatalf("set %s: %s", req.Name, resp.Error)
+		}
+	}
+
+	if resp := processCommand(reg, s, CommandRequest{Command: "set", Name: "one_more", A: Num(1)}); resp.Code != ErrCodeTooManyVars {
+		t.Errorf("set past the cap: got %+v", resp)
+	}
+	// Rebinding an existing name is still fine at the cap
+	if resp := processCommand(reg, s, CommandRequest{Command: "set", Name: "v0", A: Num(2)}); resp.Error != "" {
+		t.Errorf("rebind at the cap: got %+v", resp)
+	}
+}
+
+func TestVariablesIsolation(t *testing.T) {
+	url := startServer(t, NewHandler(Options{}))
+	first, second := dial(t, url), dial(t, url)
+
+	roundTrip(t, first, `{"command":"set","name":"x","a":1}`)
+	roundTrip(t, second, `{"command":"set","name":"x","a":2}`)
+
+	if got := roundTrip(t, first, `{"command":"get","name":"x"}`); got != `{"command":"get","result":1}` {
+		t.Errorf("first: got %s", got)
+	}
+	if got := roundTrip(t, second, `{"command":"get","name":"x"}`); got != `{"command":"get","result":2}` {
+		t.Errorf("second: got %s", got)
+	}
+
+	// A new connection starts with no variables at all
+	third := dial(t, url)
+	if got := roundTrip(t, third, `{"command":"vars"}`); got != `{"command":"vars","data":{}}` {
+		t.Errorf("third: got %s", got)
+	}
+}
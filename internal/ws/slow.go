@@ -0,0 +1,132 @@
+package ws
+
+// Filename: internal/ws/slow.go
+
+import (
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// What to do with a client whose outbound queue stays full
+const (
+	SlowConsumerDisconnect = "disconnect"  // close it with 1008 after SlowConsumerGrace
+	SlowConsumerDropOldest = "drop-oldest" // make room by discarding its oldest queued frame
+)
+
+const defaultSlowConsumerGrace = 5 * time.Second
+
+// A slow consumer's socket is likely full, so its close frame gets only
+// this long before the connection is cut
+const slowCloseWait = 250 * time.Millisecond
+
+// Clients dropped for not keeping up, and frames discarded under
+// SlowConsumerDropOldest, across all connections
+var (
+	slowConsumerCounter uint64
+	droppedFrameCounter uint64
+)
+
+// Queue m under SlowConsumerDropOldest, discarding the oldest queued
+// droppable frames until it fits. Close frames, replies and the rest are
+// never discarded, so false means nothing queued could make way for m.
+func (c *client) replaceOldest(m outbound) bool {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	for {
+		select {
+		case c.send <- m:
+			return true
+		case <-c.done:
+			return false
+		default:
+		}
+		if !c.discardOldest() {
+			return false
+		}
+		atomic.AddUint64(&droppedFrameCounter, 1)
+	}
+}
+
+// Take the oldest droppable frame off send, keeping the others in order.
+// The caller holds sendMu, so only writePump touches send meanwhile and
+// the frames taken off fit back on.
+func (c *client) discardOldest() bool {
+	queued := make([]outbound, 0, len(c.send))
+	for taking := true; taking; {
+		select {
+		case m := <-c.send:
+			queued = append(queued, m)
+		default:
+			taking = false
+		}
+	}
+	i := slices.IndexFunc(queued, func(m outbound) bool { return m.droppable })
+	if i >= 0 {
+		if seq := queued[i].bseq; seq != 0 {
+			c.gap.add(seq)
+		}
+		queued = slices.Delete(queued, i, i+1)
+	}
+	for _, m := range queued {
+		c.send <- m
+	}
+	return i >= 0
+}
+
+// Under SlowConsumerDropOldest, wait for writePump to make room for m when
+// nothing queued was droppable: up to slowGrace, then the client is
+// dropped as too slow
+func (c *client) waitForRoom(m outbound) bool {
+	var expired <-chan time.Time
+	if c.slowGrace > 0 {
+		timer := time.NewTimer(c.slowGrace)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	for {
+		select {
+		case <-c.room:
+			if c.replaceOldest(m) {
+				return true
+			}
+		case <-c.done:
+			return false
+		case <-expired:
+			c.dropSlow("queue full for " + c.slowGrace.String())
+			return false
+		}
+	}
+}
+
+// Has the queue been full for longer than the grace period? Called each
+// time a frame doesn't fit; a frame that does fit resets the clock.
+func (c *client) fullTooLong(now time.Time) bool {
+	since := c.fullSince.Load()
+	if since == 0 {
+		c.fullSince.CompareAndSwap(0, now.UnixNano())
+		return false
+	}
+	return now.Sub(time.Unix(0, since)) > c.slowGrace
+}
+
+// Give up on a client that isn't reading: try to tell it with 1008, then
+// hang up, which also unblocks a write pump stuck on the full socket.
+// Callers may hold the hub lock, so that happens on its own goroutine.
+func (c *client) dropSlow(why string) {
+	if !c.closing.CompareAndSwap(false, true) {
+		return
+	}
+	atomic.AddUint64(&slowConsumerCounter, 1)
+	c.log.Printf("dropping slow consumer %s: %s", c.session.label(), why)
+	const code, reason = websocket.ClosePolicyViolation, "client too slow"
+	c.recordClose(code, reason)
+	go func() {
+		if c.closeSent.CompareAndSwap(false, true) {
+			_ = c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(slowCloseWait))
+		}
+		c.Close(websocket.CloseAbnormalClosure, "")
+	}()
+}
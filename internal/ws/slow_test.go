@@ -0,0 +1,233 @@
+// Filename: internal/ws/slow_test.go
+
+package ws
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Push big broadcasts until until returns true or the deadline passes,
+// returning when frames first started being dropped
+func pushUntil(t *testing.T, h *Handler, deadline time.Duration, until func() bool) time.Time {
+	t.Helper()
+	frame := broadcastFrame{Type: "broadcast", From: "test", Text: strings.Repeat("x", 3500)}
+	var firstDrop time.Time
+	for end := time.Now().Add(deadline); time.Now().Before(end); {
+		if res := h.hub.broadcastFrom(nil, frame, nil); res.Dropped > 0 && firstDrop.IsZero() {
+			firstDrop = time.Now()
+		}
+		if until() {
+			return firstDrop
+		}
+		time.Sleep(100 * time.Microsecond)
+	}
+	t.Fatal("condition not met in time")
+	return firstDrop
+}
+
+// Read frames on conn in the background until it closes
+func drain(conn *websocket.Conn) {
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+func TestSlowConsumerDisconnected(t *testing.T) {
+	const grace = 200 * time.Millisecond
+	h := NewHandler(Options{SlowConsumerGrace: grace})
+	url := startServer(t, h)
+	healthy := dial(t, url)
+	dial(t, url) // never read from again
+
+	// The other client keeps reading throughout, passing on anything but broadcasts
+	healthyReply := make(chan string, 1)
+	go func() {
+		for {
+			_, msg, err := readData(healthy)
+			if err != nil {
+				healthyReply <- err.Error()
+				return
+			}
+			if !strings.Contains(string(msg), `"broadcast"`) {
+				healthyReply <- unstamped(string(msg))
+				return
+			}
+		}
+	}()
+
+	before := atomic.LoadUint64(&slowConsumerCounter)
+	firstDrop := pushUntil(t, h, 10*time.Second, func() bool { return len(h.hub.snapshot()) == 1 })
+	if took := time.Since(firstDrop); took > grace+time.Second {
+		t.Errorf("slow consumer dropped %s after its queue filled", took)
+	}
+	if got := atomic.LoadUint64(&slowConsumerCounter) - before; got != 1 {
+		t.Errorf("slow consumers: went up by %d expected 1", got)
+	}
+
+	// The client that kept reading is still connected and served
+	if err := healthy.WriteMessage(websocket.TextMessage, []byte(`{"command":"add","a":1,"b":2}`)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	select {
+	case got := <-healthyReply:
+		if got != `{"command":"add","result":3}` {
+			t.Errorf("healthy client: got %s", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("healthy client got no reply")
+	}
+}
+
+func TestSlowConsumerDropOldest(t *testing.T) {
+	h := NewHandler(Options{SlowConsumerGrace: 50 * time.Millisecond, SlowConsumerPolicy: SlowConsumerDropOldest})
+	url := startServer(t, h)
+	drain(dial(t, url))
+	dial(t, url) // never read from again
+
+	before := atomic.LoadUint64(&droppedFrameCounter)
+	pushUntil(t, h, 3*time.Second, func() bool { return atomic.LoadUint64(&droppedFrameCounter)-before > 100 })
+	if got := len(h.hub.snapshot()); got != 2 {
+		t.Errorf("connections: got %d expected both kept", got)
+	}
+}
+
+func TestDropOldestKeepsRepliesAndCloseFrames(t *testing.T) {
+	c := &client{session: newSession(defaultHistorySize), dropOldest: true, send: make(chan outbound, 3), room: make(chan struct{}, 1), done: make(chan struct{})}
+	reply := outbound{messageType: websocket.TextMessage, data: []byte("reply")}
+	closeFrame := outbound{messageType: websocket.CloseMessage, data: websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")}
+	tick := outbound{messageType: websocket.TextMessage, data: []byte("tick"), droppable: true}
+
+	// The tick between the reply and the close frame makes way for the next reply
+	for _, m := range []outbound{reply, tick, closeFrame} {
+		if !c.queue(m, laneData) {
+			t.Fatalf("queue %s: not queued", m.data)
+		}
+	}
+	before := atomic.LoadUint64(&droppedFrameCounter)
+	if !c.queue(outbound{messageType: websocket.TextMessage, data: []byte("late reply")}, laneData) {
+		t.Fatal("late reply: not queued")
+	}
+
+	// Nothing droppable is left, so a new tick is the one dropped
+	if c.tryQueue(tick, laneData) {
+		t.Error("tick queued on a queue with nothing droppable")
+	}
+	if got := atomic.LoadUint64(&droppedFrameCounter) - before; got != 2 {
+		t.Errorf("dropped frames: went up by %d expected 2", got)
+	}
+
+	var got []string
+	for range 3 {
+		got = append(got, string((<-c.send).data))
+	}
+	expected := []string{"reply", string(closeFrame.data), "late reply"}
+	if strings.Join(got, "|") != strings.Join(expected, "|") {
+		t.Errorf("queue: got %q expected %q", got, expected)
+	}
+
+	// A reply with nothing droppable ahead of it waits for the write pump
+	c.slowGrace = time.Second
+	for range 3 {
+		c.queue(reply, laneData)
+	}
+	queued := make(chan bool)
+	go func() {
+		queued <- c.queue(outbound{messageType: websocket.TextMessage, data: []byte("waiting")}, laneData)
+	}()
+	select {
+	case <-queued:
+		t.Fatal("reply queued on a full queue of replies")
+	case <-time.After(50 * time.Millisecond):
+	}
+	<-c.send
+	c.room <- struct{}{}
+	if !<-queued {
+		t.Error("waiting reply: not queued once there was room")
+	}
+}
+
+func TestSystemLaneOvertakesFullDataQueue(t *testing.T) {
+	h := NewHandler(Options{SlowConsumerGrace: time.Minute})
+	conn := dial(t, startServer(t, h))
+	clients := h.hub.snapshot()
+	if len(clients) != 1 {
+		t.Fatalf("got %d clients expected 1", len(clients))
+	}
+	c := clients[0]
+
+	// The client isn't reading, so once the socket is full the data queue
+	// fills up behind it
+	filler := strings.Repeat("x", 32<<10)
+	queued := 0
+	for ; c.tryEnqueue(websocket.TextMessage, []byte(fmt.Sprintf("%06d%s", queued, filler)), laneData); queued++ {
+		if queued > 10000 {
+			t.Fatal("data queue never filled")
+		}
+	}
+	h.hub.pushAll(shutdownFrame{Type: "shutdown", InSeconds: 30})
+	c.closeAfterQueued(websocket.CloseGoingAway, "going away")
+
+	// Reading again, the notice and the close turn up before the last of
+	// the data queued ahead of them
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	last, notified := -1, false
+	for {
+		_, msg, err := readData(conn)
+		if err != nil {
+			var ce *websocket.CloseError
+			if !errors.As(err, &ce) || ce.Code != websocket.CloseGoingAway {
+				t.Fatalf("got %v expected 1001 going away", err)
+			}
+			break
+		}
+		if strings.HasPrefix(string(msg), `{"type":"shutdown"`) {
+			notified = true
+			continue
+		}
+		if !notified {
+			last, _ = strconv.Atoi(string(msg[:6]))
+		}
+	}
+	if !notified {
+		t.Fatal("shutdown notice never arrived")
+	}
+	if last >= queued-1 {
+		t.Errorf("notice came after all %d queued data frames", queued)
+	}
+}
+
+func TestStreamedReplyMakesWayUnderDropOldest(t *testing.T) {
+	c := &client{session: newSession(defaultHistorySize), dropOldest: true, send: make(chan outbound, 2), room: make(chan struct{}, 1), done: make(chan struct{})}
+	tick := outbound{messageType: websocket.TextMessage, data: []byte("tick"), droppable: true}
+	for range 2 {
+		c.queue(tick, laneData)
+	}
+	queued := make(chan bool)
+	go func() {
+		queued <- c.enqueueStream(websocket.TextMessage, func(io.Writer) error { return nil })
+	}()
+	select {
+	case ok := <-queued:
+		if !ok {
+			t.Fatal("streamed reply: not queued")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("streamed reply waited on a queue of droppable frames")
+	}
+	if first, second := <-c.send, <-c.send; first.stream != nil || second.stream == nil {
+		t.Error("streamed reply didn't replace the oldest tick")
+	}
+}
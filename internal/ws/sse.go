@@ -0,0 +1,159 @@
+package ws
+
+// Filename: internal/ws/sse.go
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Defaults for the Server-Sent Events fallback
+const (
+	defaultMaxSSEClients = 1000 // open /events streams at once
+	sseBacklog           = 256  // recent events kept for Last-Event-ID
+	sseQueueSize         = 64   // events waiting for one slow stream
+)
+
+// How often an idle stream gets a comment line, so proxies keep it open
+var sseKeepalive = 15 * time.Second
+
+// One hub frame as an SSE event
+type sseEvent struct {
+	id    uint64
+	event string // the frame's type: "broadcast", "presence", "tick" or "notice"
+	data  []byte // the frame as JSON
+}
+
+// sseMirror copies the hub's fanned-out frames to the open /events streams
+// and keeps the last sseBacklog of them for clients that reconnect
+type sseMirror struct {
+	mu     sync.Mutex
+	nextID uint64
+	ring   []sseEvent // oldest first
+	subs   map[chan sseEvent]struct{}
+	log    *log.Logger
+}
+
+func newSSEMirror(logger *log.Logger) *sseMirror {
+	return &sseMirror{subs: make(map[chan sseEvent]struct{}), log: logger}
+}
+
+// Number frame and hand it to every stream. A stream whose queue is full
+// misses it, as a websocket client would.
+func (m *sseMirror) publish(event string, frame interface{}) {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		m.log.Printf("sse: encode %T: %v", frame, err)
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextID++
+	e := sseEvent{id: m.nextID, event: event, data: data}
+	if len(m.ring) == sseBacklog {
+		m.ring = append(m.ring[:0], m.ring[1:]...)
+	}
+	m.ring = append(m.ring, e)
+	for ch := range m.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// Open a stream unless max are already open. With after set, the events
+// still held that came after that id are returned to send first.
+func (m *sseMirror) subscribe(after uint64, resuming bool, max int) (chan sseEvent, []sseEvent, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.subs) >= max {
+		return nil, nil, false
+	}
+	var missed []sseEvent
+	if resuming {
+		for _, e := range m.ring {
+			if e.id > after {
+				missed = append(missed, e)
+			}
+		}
+	}
+	ch := make(chan sseEvent, sseQueueSize)
+	m.subs[ch] = struct{}{}
+	return ch, missed, true
+}
+
+func (m *sseMirror) unsubscribe(ch chan sseEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.subs, ch)
+}
+
+// SSEHandler serves GET /events, a read-only Server-Sent Events fallback for
+// clients that can't get a websocket through: every broadcast, presence,
+// tick and notice frame arrives as an event named after its type, with
+// the frame as JSON data. A reconnecting client's Last-Event-ID replays
+// what it missed, as far as the last few hundred events go back. At most
+// Options.MaxSSEClients streams are open at once.
+func (h *Handler) SSEHandler() http.Handler {
+	return http.HandlerFunc(h.serveSSE)
+}
+
+func (h *Handler) serveSSE(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	if h.Draining() {
+		http.Error(w, "server is draining", http.StatusServiceUnavailable)
+		return
+	}
+	after, err := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64)
+	ch, missed, ok := h.hub.sse.subscribe(after, err == nil, h.opts.MaxSSEClients)
+	if !ok {
+		http.Error(w, "too many event streams", http.StatusServiceUnavailable)
+		return
+	}
+	defer h.hub.sse.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	for _, e := range missed {
+		if writeSSE(w, e) != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepalive := time.NewTicker(sseKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case e := <-ch:
+			err = writeSSE(w, e)
+		case <-keepalive.C:
+			_, err = fmt.Fprint(w, ": keepalive\n\n")
+		case <-r.Context().Done():
+			return
+		case <-h.stop:
+			return
+		}
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// JSON has no raw newlines, so the data is always one line
+func writeSSE(w http.ResponseWriter, e sseEvent) error {
+	_, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.id, e.event, e.data)
+	return err
+}
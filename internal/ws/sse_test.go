@@ -0,0 +1,124 @@
+// Filename: internal/ws/sse_test.go
+
+package ws
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// An open /events stream, read one event at a time
+type sseReader struct {
+	t    *testing.T
+	scan *bufio.Scanner
+}
+
+func openSSE(t *testing.T, url, lastID string) *sseReader {
+	t.Helper()
+	// The stream ends, and next fails, if an event doesn't come in time
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("get %s: %v", url, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("got %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	return &sseReader{t: t, scan: bufio.NewScanner(resp.Body)}
+}
+
+// The next event named name, skipping others; its id and data
+func (r *sseReader) next(name string) (id, data string) {
+	r.t.Helper()
+	var event string
+	for r.scan.Scan() {
+		line := r.scan.Text()
+		switch {
+		case line == "":
+			if event == name {
+				return id, data
+			}
+			id, event, data = "", "", ""
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+	r.t.Fatalf("stream ended before a %s event: %v", name, r.scan.Err())
+	return "", ""
+}
+
+func TestSSEMirrorsBroadcasts(t *testing.T) {
+	h := NewHandler(Options{})
+	t.Cleanup(h.Close)
+	mux := http.NewServeMux()
+	mux.Handle("/ws", h)
+	mux.Handle("/events", h.SSEHandler())
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	events := openSSE(t, srv.URL+"/events", "")
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+	sender, other := dial(t, url), dial(t, url)
+	roundTrip(t, sender, "NICK:loud")
+	roundTrip(t, sender, `{"command":"broadcast","text":"hi all"}`)
+
+	_ = other.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, msg, err := readData(other)
+	expected := broadcastFrame{Type: "broadcast", From: "loud", Text: "hi all", BSeq: 1}
+	var frame broadcastFrame
+	if err != nil || json.Unmarshal(msg, &frame) != nil || frame != expected {
+		t.Errorf("websocket: got %s, %v", msg, err)
+	}
+	id, data := events.next("broadcast")
+	frame = broadcastFrame{}
+	if json.Unmarshal([]byte(data), &frame) != nil || frame != expected {
+		t.Errorf("sse: got %s", data)
+	}
+
+	// Reconnecting from the first broadcast's id replays the second
+	roundTrip(t, sender, `{"command":"broadcast","text":"again"}`)
+	replay := openSSE(t, srv.URL+"/events", id)
+	if _, data := replay.next("broadcast"); !strings.Contains(data, `"text":"again"`) {
+		t.Errorf("replay: got %s", data)
+	}
+}
+
+func TestSSELimitsAndFlushing(t *testing.T) {
+	h := NewHandler(Options{MaxSSEClients: 1})
+	t.Cleanup(h.Close)
+	srv := httptest.NewServer(h.SSEHandler())
+	t.Cleanup(srv.Close)
+
+	openSSE(t, srv.URL, "")
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("second stream: got %d expected 503", resp.StatusCode)
+	}
+
+	// A ResponseWriter that can't flush can't stream
+	rec := httptest.NewRecorder()
+	h.SSEHandler().ServeHTTP(struct{ http.ResponseWriter }{rec}, httptest.NewRequest("GET", "/events", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("no flusher: got %d expected 500", rec.Code)
+	}
+}
@@ -0,0 +1,154 @@
+package ws
+
+// Filename: internal/ws/stats.go
+
+import (
+	"maps"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// When the package was loaded, for uptime
+var startTime = time.Now()
+
+// Connections currently between upgrade and close, across all handlers,
+// for the health check. The stats command reports its handler's own.
+var openConnections int64
+
+func (st *handlerStats) recordCloseCode(code int) {
+	st.closeMu.Lock()
+	st.closeCodes[code]++
+	st.closeMu.Unlock()
+}
+
+// Copy of the per-code close counters
+func (st *handlerStats) closeCodeCounts() map[int]uint64 {
+	st.closeMu.Lock()
+	defer st.closeMu.Unlock()
+	return maps.Clone(st.closeCodes)
+}
+
+// Copy of the per-class read end counters
+func (st *handlerStats) readEndCounts() map[string]uint64 {
+	out := make(map[string]uint64, numReadEnds)
+	for e := range numReadEnds {
+		out[e.String()] = st.readEnds[e].Load()
+	}
+	return out
+}
+
+// Source of connection IDs
+var connCounter uint64
+
+// IDs are "c1", "c2", ... in connection order, unique per process
+func nextConnID() string {
+	return "c" + strconv.FormatUint(atomic.AddUint64(&connCounter, 1), 10)
+}
+
+// Fixed facts about a connection, set once when it opens
+type connInfo struct {
+	ID          string
+	RemoteAddr  string
+	Origin      string
+	ConnectedAt time.Time
+	Encoding    string
+	Subprotocol string
+	Extension   string
+	Tenant      string            // Options.Tenant of the handler it came in on
+	Room        string            // from ?room=; there are no rooms to join yet, so it is only reported, and filtered on by who
+	Metadata    map[string]string // from the /ws query; see parseConnQuery
+}
+
+// Payload of the "stats" response. Every number is read with an atomic
+// load (or a short per-connection lock), so asking is cheap.
+type statsInfo struct {
+	Server     serverStats `json:"server"`
+	Connection connStats   `json:"connection"`
+}
+
+type serverStats struct {
+	UptimeSeconds     float64           `json:"uptime_s"`
+	OpenConnections   int64             `json:"open_connections"`
+	Tenant            string            `json:"tenant,omitempty"`
+	Messages          uint64            `json:"messages"`
+	CompressedFrames  uint64            `json:"compressed_frames"`
+	HandshakeTimeouts uint64            `json:"handshake_timeouts"`
+	Blocked           uint64            `json:"blocked_connections"`
+	SlowConsumers     uint64            `json:"slow_consumers"`
+	DroppedFrames     uint64            `json:"dropped_frames"`
+	ReloadFailures    uint64            `json:"config_reload_failures"`
+	Oversized         uint64            `json:"oversized_messages"`
+	JSONViolations    uint64            `json:"json_violations"`
+	BytesReceived     uint64            `json:"bytes_received"`
+	BytesSent         uint64            `json:"bytes_sent"`
+	CloseCodes        map[int]uint64    `json:"close_codes"`
+	ChaosFaults       map[string]uint64 `json:"chaos_faults,omitempty"` // see ChaosConfig
+
+	// Read loops ended, by cause: normal, unexpected (close code),
+	// timeout, too_big or transport
+	ReadEnds map[string]uint64 `json:"read_ends"`
+
+	Commands map[string]commandUsageInfo `json:"commands"`
+}
+
+type connStats struct {
+	ID          string      `json:"conn_id"`
+	ConnectedAt string      `json:"connected_at"`
+	Messages    uint64      `json:"messages"`
+	Encoding    string      `json:"encoding"`
+	Subprotocol string      `json:"subprotocol"`
+	Extension   string      `json:"extension"`
+	RTT         *RTTSummary `json:"rtt,omitempty"`
+	Pings       uint64      `json:"pings_received"`
+	PingBytes   uint64      `json:"ping_bytes"`
+	BytesIn     uint64      `json:"bytes_received"`
+	BytesOut    uint64      `json:"bytes_sent"`
+	Debug       bool        `json:"debug"`
+}
+
+// Report server-wide and per-connection counters. The stats frame itself
+// has already been counted by the time this runs, in both totals.
+func runStats(s *Session, req CommandRequest) CommandResponse {
+	info := statsInfo{
+		Server: serverStats{
+			UptimeSeconds:     time.Since(startTime).Seconds(),
+			OpenConnections:   s.stats.open.Load(),
+			Messages:          s.stats.messages.Load(),
+			CompressedFrames:  atomic.LoadUint64(&compressedCounter),
+			HandshakeTimeouts: atomic.LoadUint64(&handshakeTimeoutCounter),
+			Blocked:           s.stats.blocked.Load(),
+			SlowConsumers:     atomic.LoadUint64(&slowConsumerCounter),
+			DroppedFrames:     atomic.LoadUint64(&droppedFrameCounter),
+			ReloadFailures:    atomic.LoadUint64(&configReloadFailures),
+			Oversized:         atomic.LoadUint64(&oversizedCounter),
+			JSONViolations:    atomic.LoadUint64(&jsonViolationCounter),
+			BytesReceived:     s.stats.bytesIn.Load(),
+			BytesSent:         s.stats.bytesOut.Load(),
+			CloseCodes:        s.stats.closeCodeCounts(),
+			ChaosFaults:       chaosFaultCounts(),
+			ReadEnds:          s.stats.readEndCounts(),
+			Commands:          s.stats.usage.snapshot(),
+		},
+		Connection: connStats{
+			ID:          s.conn.ID,
+			Messages:    atomic.LoadUint64(&s.seq),
+			Encoding:    s.conn.Encoding,
+			Subprotocol: s.conn.Subprotocol,
+			Extension:   s.conn.Extension,
+			Pings:       s.pings.Load(),
+			PingBytes:   s.pingBytes.Load(),
+			BytesIn:     s.bytesIn.Load(),
+			BytesOut:    s.bytesOut.Load(),
+			Debug:       s.debugging(),
+		},
+	}
+	info.Server.Tenant = s.conn.Tenant
+	if !s.conn.ConnectedAt.IsZero() {
+		info.Connection.ConnectedAt = s.conn.ConnectedAt.UTC().Format(serverTimeFormat)
+	}
+	if rtt := s.RTT(); rtt.Samples > 0 {
+		info.Connection.RTT = &rtt
+	}
+	return CommandResponse{Command: req.Command, Data: info}
+}
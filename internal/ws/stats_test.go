@@ -0,0 +1,59 @@
+// Filename: internal/ws/stats_test.go
+
+package ws
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// Decode a stats reply
+func decodeStats(t *testing.T, got string) statsInfo {
+	t.Helper()
+	var resp struct {
+		Command string    `json:"command"`
+		Data    statsInfo `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(got), &resp); err != nil || resp.Command != "stats" {
+		t.Fatalf("stats reply %s: %v", got, err)
+	}
+	return resp.Data
+}
+
+func TestStatsCommand(t *testing.T) {
+	conn := dial(t, startServer(t, NewHandler(Options{})))
+
+	roundTrip(t, conn, "hello")
+	roundTrip(t, conn, `{"command":"add","a":1,"b":2}`)
+	roundTrip(t, conn, "PING") // app-level pings aren't counted
+
+	// The stats request counts itself
+	first := decodeStats(t, roundTrip(t, conn, `{"command":"stats"}`))
+	if first.Connection.Messages != 3 {
+		t.Errorf("connection messages: got %d expected 3", first.Connection.Messages)
+	}
+	if first.Server.Messages < first.Connection.Messages {
+		t.Errorf("server messages %d below connection's %d", first.Server.Messages, first.Connection.Messages)
+	}
+	if first.Server.OpenConnections < 1 {
+		t.Errorf("open connections: got %d", first.Server.OpenConnections)
+	}
+	if first.Server.UptimeSeconds <= 0 {
+		t.Errorf("uptime: got %v", first.Server.UptimeSeconds)
+	}
+	if at, err := time.Parse(time.RFC3339, first.Connection.ConnectedAt); err != nil || time.Since(at) > time.Minute {
+		t.Errorf("connected_at: got %q (%v)", first.Connection.ConnectedAt, err)
+	}
+	if first.Connection.Encoding != encodingJSON {
+		t.Errorf("encoding: got %q", first.Connection.Encoding)
+	}
+	if first.Connection.RTT != nil {
+		t.Errorf("rtt before any pong: got %+v", first.Connection.RTT)
+	}
+
+	second := decodeStats(t, roundTrip(t, conn, `{"command":"stats"}`))
+	if second.Connection.Messages != first.Connection.Messages+1 {
+		t.Errorf("second stats: got %d messages expected %d", second.Connection.Messages, first.Connection.Messages+1)
+	}
+}
@@ -0,0 +1,143 @@
+package ws
+
+// Filename: internal/ws/stream.go
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Streaming commands push several frames back instead of a single reply
+const (
+	defaultMaxCountRange    = 1000                  // max frames a single count may produce
+	defaultMinCountInterval = 10 * time.Millisecond // fastest allowed count interval
+	maxStreamsPerConn       = 4                     // concurrent streams per connection
+)
+
+// Error codes for streaming commands
+const (
+	ErrCodeInvalidRange   = "ERR_INVALID_RANGE"
+	ErrCodeTooManyStreams = "ERR_TOO_MANY_STREAMS"
+	ErrCodeDuplicateID    = "ERR_DUPLICATE_ID"
+	ErrCodeNoSuchStream   = "ERR_NO_SUCH_STREAM"
+	ErrCodeNotBatchable   = "ERR_NOT_BATCHABLE"
+)
+
+// Start or cancel a stream, or start a delay. The bool reports whether resp
+// should be sent; a successfully started count answers with its stream
+// frames instead, and a delay with its reply once it's up.
+func (h *Handler) handleStreamCommand(c *client, req CommandRequest) (CommandResponse, bool) {
+	switch req.Command {
+	case "cancel":
+		return c.cancelStream(req.ID), true
+	case "delay":
+		return h.startDelay(c, req)
+	}
+
+	from, to := req.FromNum.Value, req.ToNum.Value
+	if req.From != "" || req.To != "" || req.FromNum.Ref != "" || req.ToNum.Ref != "" || from != math.Trunc(from) || to != math.Trunc(to) {
+		return errorResponse(req.Command, ErrCodeInvalidRange, "from and to must be integers"), true
+	}
+	if math.Abs(from) > maxExactInt || math.Abs(to) > maxExactInt {
+		return errorResponse(req.Command, ErrCodeInvalidRange, "from and to must be no larger than 2^53"), true
+	}
+	if from > to {
+		return errorResponse(req.Command, ErrCodeInvalidRange, "from must not be greater than to"), true
+	}
+	if to-from+1 > float64(h.opts.MaxCountRange) {
+		return errorResponse(req.Command, ErrCodeInvalidRange,
+			fmt.Sprintf("Range too large (max %d values)", h.opts.MaxCountRange)), true
+	}
+
+	interval := time.Duration(req.IntervalMS) * time.Millisecond
+	if interval < h.opts.MinCountInterval {
+		return errorResponse(req.Command, ErrCodeInvalidRange,
+			fmt.Sprintf("interval_ms must be at least %d", h.opts.MinCountInterval.Milliseconds())), true
+	}
+
+	if resp, ok := c.startCount(req.ID, int64(from), int64(to), interval); !ok {
+		return resp, true
+	}
+	return CommandResponse{}, false
+}
+
+// Register a count stream and start its goroutine
+func (c *client) startCount(id string, from, to int64, interval time.Duration) (CommandResponse, bool) {
+	c.streamsMu.Lock()
+	defer c.streamsMu.Unlock()
+
+	if len(c.streams) >= maxStreamsPerConn {
+		return errorResponse("count", ErrCodeTooManyStreams,
+			fmt.Sprintf("Too many active streams (max %d)", maxStreamsPerConn)), false
+	}
+	if id == "" {
+		c.nextID++
+		id = "count-" + strconv.FormatUint(c.nextID, 10)
+	}
+	if _, exists := c.streams[id]; exists {
+		return errorResponse("count", ErrCodeDuplicateID, fmt.Sprintf("Stream %q is already running", id)), false
+	}
+
+	cancel := make(chan struct{})
+	c.streams[id] = cancel
+	c.goWorker(func() {
+		defer c.removeStream(id, cancel)
+		c.runCount(id, from, to, interval, cancel)
+	})
+	return CommandResponse{}, true
+}
+
+// Push one frame per value, then a done frame. Stops early on cancel or disconnect.
+func (c *client) runCount(id string, from, to int64, interval time.Duration, cancel <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for v := from; v <= to; v++ {
+		if v > from {
+			select {
+			case <-ticker.C:
+			case <-cancel:
+				return
+			case <-c.done:
+				return
+			}
+		}
+		frame := resultResponse("count", float64(v))
+		frame.ID = id
+		if !c.sendEncoded(frame) {
+			return
+		}
+	}
+
+	select {
+	case <-cancel:
+		return
+	default:
+	}
+	c.sendEncoded(CommandResponse{Command: "count", ID: id, Done: true})
+}
+
+// Forget a finished stream, unless its id has since been reused
+func (c *client) removeStream(id string, cancel chan struct{}) {
+	c.streamsMu.Lock()
+	if c.streams[id] == cancel {
+		delete(c.streams, id)
+	}
+	c.streamsMu.Unlock()
+}
+
+// Stop the stream with this id; its goroutine exits at its next step
+func (c *client) cancelStream(id string) CommandResponse {
+	c.streamsMu.Lock()
+	defer c.streamsMu.Unlock()
+
+	cancel, ok := c.streams[id]
+	if !ok {
+		return errorResponse("cancel", ErrCodeNoSuchStream, fmt.Sprintf("No active stream %q", id))
+	}
+	close(cancel)
+	delete(c.streams, id)
+	return CommandResponse{Command: "cancel", ID: id, Done: true}
+}
@@ -0,0 +1,380 @@
+package ws
+
+// Filename: internal/ws/stream_read.go
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+)
+
+// Error code for a REVERSE: message too long to turn round
+const ErrCodeTooLongToReverse = "ERR_TOO_LONG_TO_REVERSE"
+
+const (
+	// Default for Options.StreamThreshold
+	defaultStreamThreshold = 64 << 10
+
+	// How much of a streamed echo is read and transformed at a time
+	streamWindow = 32 << 10
+
+	// REVERSE: has to hold a message whole to turn it round, so a streamed
+	// one is only reversed up to this many bytes, whatever the read limit
+	maxReverseLength = 1 << 20
+)
+
+// A streamed text message broke RFC 6455's rule that text is UTF-8
+var errInvalidUTF8 = errors.New("invalid UTF-8")
+
+// A text message longer than the connection's stream threshold: the head
+// readMessage has read, then the rest of the frame. Reading it reads the
+// message from the start, checking as it goes that it is UTF-8 and within
+// the read limit, which readMessage would have checked of a message read
+// whole, and failing with errInvalidUTF8 or websocket.ErrReadLimit.
+type textStream struct {
+	head []byte    // the stream threshold and one byte more
+	off  int       // head bytes read back so far
+	r    io.Reader // the rest of the frame, to one byte past the read limit
+	left int64     // bytes the rest may run to within the read limit
+	read int64     // bytes read from r
+	err  error     // the first read that failed other than at the end
+	s    *Session
+
+	carry [utf8.UTFMax]byte // a character the last read ended part way through
+	nc    int               // ...and how much of it there is
+}
+
+func (c *client) newTextStream(head []byte, r io.Reader, limit int64) *textStream {
+	left := limit - int64(len(head))
+	return &textStream{head: head, r: io.LimitReader(r, left+1), left: left, s: c.session}
+}
+
+func (t *textStream) Read(p []byte) (int, error) {
+	if t.err != nil {
+		return 0, t.err
+	}
+	var n int
+	var err error
+	if t.off < len(t.head) {
+		n = copy(p, t.head[t.off:])
+		t.off += n
+	} else {
+		n, err = t.rest(p)
+		t.s.countIn(n)
+	}
+	if !t.valid(p[:n]) || err == io.EOF && t.nc > 0 {
+		err = errInvalidUTF8
+	}
+	if err != nil && err != io.EOF {
+		t.err = err
+		return 0, err
+	}
+	return n, err
+}
+
+// Skip n bytes of head, which the caller knows to be whole characters
+func (t *textStream) skip(n int) {
+	t.off += n
+}
+
+// Read on from the frame, keeping to the read limit
+func (t *textStream) rest(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if t.read += int64(n); t.read > t.left {
+		return n, websocket.ErrReadLimit
+	}
+	return n, err
+}
+
+// The whole message, for one that won't be streamed after all. It isn't
+// checked as UTF-8 or counted, since the read loop does both for a message
+// read whole.
+func (t *textStream) whole() ([]byte, error) {
+	buf := bytes.NewBuffer(t.head)
+	n, err := buf.ReadFrom(t.r)
+	if err == nil && n > t.left {
+		err = websocket.ErrReadLimit
+	}
+	return buf.Bytes(), err
+}
+
+// Read what is left of the message without keeping it, so the read limit
+// and UTF-8 check cover all of it
+func (t *textStream) discard() error {
+	_, err := io.Copy(io.Discard, t)
+	return err
+}
+
+// Check the next piece of the message is UTF-8, holding back a character
+// cut off at its end until the next piece finishes it
+func (t *textStream) valid(p []byte) bool {
+	if t.nc > 0 {
+		k := copy(t.carry[t.nc:], p)
+		if !utf8.FullRune(t.carry[:t.nc+k]) {
+			t.nc += k
+			return true
+		}
+		r, size := utf8.DecodeRune(t.carry[:t.nc+k])
+		if r == utf8.RuneError && size == 1 {
+			return false
+		}
+		p, t.nc = p[size-t.nc:], 0
+	}
+	end := wholeRunes(p)
+	t.nc = copy(t.carry[:], p[end:])
+	return utf8.Valid(p[:end])
+}
+
+// The length of p less any character cut off at its end
+func wholeRunes(p []byte) int {
+	for i := len(p) - 1; i >= 0 && i >= len(p)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(p[i]) {
+			if !utf8.FullRune(p[i:]) {
+				return i
+			}
+			break
+		}
+	}
+	return len(p)
+}
+
+// The close frame for a streamed message that failed part way: 1009 or
+// 1007 if it broke the read limit or wasn't UTF-8, as for a message read
+// whole, otherwise 1011
+func (s *Session) streamClose(err error) (int, string) {
+	switch {
+	case errors.Is(err, websocket.ErrReadLimit):
+		return websocket.CloseMessageTooBig, s.tooBig()
+	case errors.Is(err, errInvalidUTF8):
+		return websocket.CloseInvalidFramePayloadData, "invalid UTF-8"
+	}
+	return websocket.CloseInternalServerErr, "internal error"
+}
+
+// Can this long text message, of which head has been read, be handled as
+// it is read? Commands and echoes can. The rest, which are never long
+// unless they are mistakes, are read whole, as is everything on a
+// connection that wraps or re-encodes its frames.
+func (h *Handler) streamable(c *client, head []byte) bool {
+	switch {
+	case c.encoding == encodingMsgpack || c.envelope:
+		return false
+	case c.subprotocol != subprotocolEcho && isCommandPayload(head):
+		return true
+	case c.subprotocol == subprotocolCommands || bytes.HasPrefix(head, []byte(nickTextPrefix)):
+		return false
+	case c.subprotocol != subprotocolEcho && startsWithCommand(h.opts.Registry, head):
+		return false // may be a plain-text command; see parsePlainCommand
+	}
+	return true
+}
+
+// Is the first word of text the name of a command?
+func startsWithCommand(reg *CommandRegistry, text []byte) bool {
+	word := bytes.TrimLeftFunc(text, unicode.IsSpace)
+	if i := bytes.IndexFunc(word, unicode.IsSpace); i >= 0 {
+		word = word[:i]
+	}
+	_, ok := reg.lookup(string(word))
+	return ok
+}
+
+// handleTextFrame for a message past Options.StreamThreshold, handled as
+// it is read. Commands are decoded as they arrive, and echoes, UPPER:
+// included, are written out as they are read, a window at a time. Only
+// REVERSE: still holds the message whole, up to maxReverseLength. Returns
+// false once the connection is closing.
+func (h *Handler) handleTextStream(c *client, text *textStream) bool {
+	c.session.history.record(directionIn, text.head)
+
+	var ok bool
+	var err error
+	switch p, prefixed := findTextPrefix(text.head); {
+	case c.subprotocol != subprotocolEcho && isCommandPayload(text.head):
+		ok, err = h.commandStream(c, text)
+	case prefixed && !p.windowed:
+		ok, err = c.reverseStream(text, p)
+	case prefixed:
+		text.skip(len(p.Prefix))
+		ok = c.echoStream(text, p.apply)
+	default:
+		ok = c.echoStream(text, nil)
+	}
+	if ok && err == nil {
+		err = text.discard()
+	}
+	if err != nil {
+		c.closeWith(c.session.streamClose(err))
+		return false
+	}
+	if ok && c.bandwidth != nil {
+		c.bandwidth.add(h.opts.Clock.Now(), int(text.read))
+	}
+	return ok
+}
+
+// The text prefix payload starts with, if any
+func findTextPrefix(payload []byte) (textPrefix, bool) {
+	for _, p := range textPrefixes {
+		if bytes.HasPrefix(payload, []byte(p.Prefix)) {
+			return p, true
+		}
+	}
+	return textPrefix{}, false
+}
+
+// Queue the echo of text, transformed by apply (nil for none), to be
+// written as it is read, and wait until it has been: the next message
+// can't be read before this one has. A failure part way is the write
+// pump's to close the connection over. Returns false if it did, or if the
+// connection closed first.
+func (c *client) echoStream(text *textStream, apply func(dst, src []byte) []byte) bool {
+	label := c.session.appendEchoLabel(nil)
+	finished := make(chan error, 1)
+	queued := c.enqueueStream(websocket.TextMessage, func(w io.Writer) error {
+		err := c.session.streamEcho(w, label, text, apply)
+		finished <- err
+		return err
+	})
+	if !queued {
+		return false
+	}
+	select {
+	case err := <-finished:
+		return err == nil
+	case <-c.done:
+		return false
+	}
+}
+
+// Write label, then text a window at a time, transformed by apply. Each
+// window ends on a character boundary, a character the read cut short
+// being carried into the next one, so apply never sees half of one. The
+// first window goes in the history, which keeps no more than its start.
+func (s *Session) streamEcho(w io.Writer, label []byte, text io.Reader, apply func(dst, src []byte) []byte) error {
+	if _, err := w.Write(label); err != nil {
+		return err
+	}
+	buf := make([]byte, streamWindow)
+	var out []byte
+	for n, first := 0, true; ; first = false {
+		m, err := text.Read(buf[n:])
+		if err != nil && err != io.EOF {
+			return err
+		}
+		n += m
+		end := n
+		if err == nil {
+			end = wholeRunes(buf[:n])
+		}
+		window := buf[:end]
+		if apply != nil {
+			out = apply(out[:0], window)
+			window = out
+		}
+		if first {
+			s.history.record(directionOut, append(label, window[:min(len(window), historyPayloadMax)]...))
+		}
+		if _, err := w.Write(window); err != nil {
+			return err
+		}
+		n = copy(buf, buf[end:n])
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// Reverse text, which has to be read whole to be turned round, unless it
+// is over maxReverseLength
+func (c *client) reverseStream(text *textStream, p textPrefix) (bool, error) {
+	text.skip(len(p.Prefix))
+	body, err := io.ReadAll(io.LimitReader(text, maxReverseLength+1))
+	if err != nil {
+		return false, err
+	}
+	var reply []byte
+	if len(body) > maxReverseLength {
+		reply, err = marshalResponse(c.session.stamp(errorResponse("", ErrCodeTooLongToReverse,
+			fmt.Sprintf("Too long to reverse (max %d bytes)", maxReverseLength))))
+		if err != nil {
+			return false, err
+		}
+	} else {
+		reply = p.apply(c.session.appendEchoLabel(nil), body)
+	}
+	return c.queueReply(reply), nil
+}
+
+// Decode and run a long command message as it is read: an array as a
+// batch, and anything else as a command or, if it holds several, as
+// NDJSON. The JSON limits are applied as it is read, so a message that
+// breaks one is refused there and the rest isn't decoded.
+func (h *Handler) commandStream(c *client, text *textStream) (bool, error) {
+	start := trimJSONPrefix(text.head)
+	text.skip(len(text.head) - len(start))
+	dec := json.NewDecoder(&jsonGuard{r: text, scan: jsonScanner{l: h.opts.jsonLimits()}})
+
+	batch := start[0] == '['
+	var raw []json.RawMessage
+	var docs [][]byte
+	var err error
+	if batch {
+		if err = dec.Decode(&raw); err == nil && dec.More() {
+			err = errors.New("more after the batch")
+		}
+	} else {
+		for len(docs) <= h.opts.MaxLinesPerFrame {
+			var doc json.RawMessage
+			if err = dec.Decode(&doc); err != nil {
+				break
+			}
+			docs = append(docs, doc)
+		}
+		if err == io.EOF {
+			err = nil
+		}
+	}
+
+	reg, s := h.opts.Registry, c.session
+	var refused jsonRefused
+	var reply []byte
+	switch {
+	case text.err != nil:
+		return false, text.err
+	case errors.As(err, &refused):
+		reply, err = marshalResponse(h.jsonViolation(c, refused.resp))
+	case err != nil:
+		reply, err = marshalResponse(invalidJSON(s, "Invalid JSON: "+err.Error()))
+	case batch:
+		reply, err = marshalResponse(processEntries(reg, s, raw, h.opts.MaxBatchSize))
+	case len(docs) == 1:
+		var req CommandRequest
+		if uerr := json.Unmarshal(docs[0], &req); uerr != nil {
+			reply, err = marshalResponse(invalidJSON(s, "Invalid JSON: "+uerr.Error()))
+			break
+		}
+		reply, err = h.runCommand(c, req)
+	default:
+		reply, err = processLines(reg, s, docs, h.opts.MaxLinesPerFrame)
+	}
+	if err != nil {
+		return false, err
+	}
+	return c.queueReply(reply), nil
+}
+
+// Queue a text reply, if there is one, and keep it in the history
+func (c *client) queueReply(reply []byte) bool {
+	if reply == nil {
+		return true
+	}
+	c.session.history.record(directionOut, reply)
+	return c.queue(outbound{messageType: websocket.TextMessage, data: reply}, laneData)
+}
@@ -0,0 +1,191 @@
+// Filename: internal/ws/stream_read_test.go
+
+package ws
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const testStreamThreshold = 1024
+
+func streamServer(t *testing.T, opts Options) *websocket.Conn {
+	t.Helper()
+	opts.MaxMessageSize = 1 << 18
+	opts.StreamThreshold = testStreamThreshold
+	return dial(t, startServer(t, NewHandler(opts)))
+}
+
+// Every boundary a read or window falls on cuts one of these in two
+const mixedRunes = "aé€😀"
+
+func TestStreamEchoRuneAcrossWindow(t *testing.T) {
+	// "€" straddles the first window, then every window after splits something
+	body := strings.Repeat("a", streamWindow-1) + "€" + strings.Repeat(mixedRunes, streamWindow/5)
+	expected := "[L] " + strings.ToUpper(body)
+
+	for name, r := range map[string]func() io.Reader{
+		"whole windows":  func() io.Reader { return strings.NewReader(body) },
+		"byte at a time": func() io.Reader { return iotest.OneByteReader(strings.NewReader(body)) },
+		"half windows":   func() io.Reader { return iotest.HalfReader(strings.NewReader(body)) },
+	} {
+		var out bytes.Buffer
+		if err := newSession(defaultHistorySize).streamEcho(&out, []byte("[L] "), r(), appendUpper); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if out.String() != expected {
+			t.Errorf("%s: echo differs from strings.ToUpper", name)
+		}
+	}
+}
+
+func TestTextStreamChecksUTF8AcrossReads(t *testing.T) {
+	read := func(head, rest string) error {
+		text := testClient().newTextStream([]byte(head), iotest.OneByteReader(strings.NewReader(rest)), 1<<20)
+		return text.discard()
+	}
+	if err := read("ab\xe2\x82", "\xac"+mixedRunes); err != nil {
+		t.Errorf("€ split between head and frame: %v", err)
+	}
+	if err := read("ab\xe2", "\x82a"); !errors.Is(err, errInvalidUTF8) {
+		t.Errorf("€ broken off: got %v expected errInvalidUTF8", err)
+	}
+	if err := read("ab", "c\xe2\x82"); !errors.Is(err, errInvalidUTF8) {
+		t.Errorf("ends part way through €: got %v expected errInvalidUTF8", err)
+	}
+	if err := read("ab", "c\xff"); !errors.Is(err, errInvalidUTF8) {
+		t.Errorf("0xff: got %v expected errInvalidUTF8", err)
+	}
+}
+
+func TestStreamedUpperEcho(t *testing.T) {
+	conn := streamServer(t, Options{})
+	// The head readMessage reads ends between the two bytes of "é"
+	body := strings.Repeat("a", testStreamThreshold-len("UPPER:")) + "é" + strings.Repeat(mixedRunes, 8000)
+	if got := roundTrip(t, conn, "UPPER:"+body); got != strings.ToUpper(body) {
+		t.Errorf("got %d bytes expected %d, the body in upper case", len(got), len(strings.ToUpper(body)))
+	}
+	plain := strings.Repeat(mixedRunes, 500)
+	if got := roundTrip(t, conn, plain); got != plain {
+		t.Errorf("plain echo: got %d bytes expected %d", len(got), len(plain))
+	}
+	// Messages under the threshold still work as before
+	if got := roundTrip(t, conn, "UPPER:short"); got != "SHORT" {
+		t.Errorf("short: got %q", got)
+	}
+}
+
+func TestStreamedReverseIsBuffered(t *testing.T) {
+	conn := streamServer(t, Options{})
+	body := strings.Repeat("ab€", 1000)
+	if got := roundTrip(t, conn, "REVERSE:"+body); got != string(appendReversed(nil, []byte(body))) {
+		t.Errorf("got %q...", got[:20])
+	}
+}
+
+func TestStreamedCommands(t *testing.T) {
+	conn := streamServer(t, Options{})
+	pad := strings.Repeat(" ", 2*testStreamThreshold)
+
+	var resp CommandResponse
+	if err := json.Unmarshal([]byte(roundTrip(t, conn, `{"command":"add",`+pad+`"a":2,"b":3}`)), &resp); err != nil || resp.Result == nil || *resp.Result != 5 {
+		t.Fatalf("single command: got %+v, %v", resp, err)
+	}
+
+	lines := strings.Split(roundTrip(t, conn, `{"command":"add","a":1,"b":1}`+pad+"\n"+`{"command":"add","a":2,"b":2}`), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[1], `"result":4`) {
+		t.Errorf("NDJSON: got %q", lines)
+	}
+
+	batch := "[" + strings.Repeat(`{"command":"add","a":1,"b":1},`, 60) + `{"command":"nope"}]`
+	var out []CommandResponse
+	if err := json.Unmarshal([]byte(roundTrip(t, conn, batch)), &out); err != nil || len(out) != 61 || out[60].Code != ErrCodeUnknownCommand {
+		t.Errorf("batch: got %d responses, %v", len(out), err)
+	}
+
+	if err := json.Unmarshal([]byte(roundTrip(t, conn, `{"command":"add",`+pad)), &resp); err != nil || resp.Code != ErrCodeInvalidJSON {
+		t.Errorf("cut short: got %+v, %v", resp, err)
+	}
+}
+
+func TestStreamedCommandRefusedPartWay(t *testing.T) {
+	conn := streamServer(t, Options{MaxJSONStringLength: 100})
+	var resp CommandResponse
+	long := `{"command":"nick","name":"` + strings.Repeat("x", 1<<17) + `"}`
+	if err := json.Unmarshal([]byte(roundTrip(t, conn, long)), &resp); err != nil || resp.Code != ErrCodeJSONStringTooLong {
+		t.Fatalf("got %+v, %v", resp, err)
+	}
+	// The rest of the message was skipped, not taken for the next one
+	if got := roundTrip(t, conn, "next"); got != "next" {
+		t.Errorf("next message: got %q", got)
+	}
+}
+
+func TestStreamedMessageCloses(t *testing.T) {
+	tests := []struct {
+		name     string
+		msg      string
+		code     int
+		expected string
+	}{
+		{"bad UTF-8", "UPPER:" + strings.Repeat("a", 4*testStreamThreshold) + "\xff", websocket.CloseInvalidFramePayloadData, "invalid UTF-8"},
+		{"bad UTF-8 after a command", `{"command":"add","a":1,"b":1}` + strings.Repeat(" ", 4*testStreamThreshold) + "\xff", websocket.CloseInvalidFramePayloadData, "invalid UTF-8"},
+		{"over the read limit", strings.Repeat("a", 1<<18+1), websocket.CloseMessageTooBig, tooBigReason(1 << 18)},
+	}
+	for _, tt := range tests {
+		conn := streamServer(t, Options{})
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(tt.msg)); err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var err error
+		for err == nil {
+			_, _, err = readData(conn)
+		}
+		var ce *websocket.CloseError
+		if !errors.As(err, &ce) || ce.Code != tt.code || ce.Text != tt.expected {
+			t.Errorf("%s: got %v expected %d %q", tt.name, err, tt.code, tt.expected)
+		}
+	}
+}
+
+// A 4 MiB UPPER: message, echoed as the read loop would: read whole then
+// transformed, against streamed through a window
+func BenchmarkUpperEcho(b *testing.B) {
+	msg := []byte("UPPER:" + strings.Repeat(mixedRunes, 4<<20/len(mixedRunes)))
+	c := testClient()
+	b.Run("whole", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(msg)))
+		for range b.N {
+			payload, err := io.ReadAll(bytes.NewReader(msg))
+			if err != nil {
+				b.Fatal(err)
+			}
+			_ = c.session.echoReply(payload)
+		}
+	})
+	b.Run("streamed", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(msg)))
+		for range b.N {
+			head, err := io.ReadAll(io.LimitReader(bytes.NewReader(msg), defaultStreamThreshold+1))
+			if err != nil {
+				b.Fatal(err)
+			}
+			text := c.newTextStream(head, bytes.NewReader(msg[len(head):]), int64(len(msg)))
+			text.skip(len("UPPER:"))
+			if err := c.session.streamEcho(io.Discard, c.session.appendEchoLabel(nil), text, appendUpper); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
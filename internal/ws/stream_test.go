@@ -0,0 +1,126 @@
+// Filename: internal/ws/stream_test.go
+
+package ws
+
+import (
+	"encoding/json"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Read the next text frame as a CommandResponse
+func readResponse(t *testing.T, conn *websocket.Conn) CommandResponse {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, payload, err := readData(conn)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	var resp CommandResponse
+	if err := json.Unmarshal(payload, &resp); err != nil {
+		t.Fatalf("unmarshal %s: %v", payload, err)
+	}
+	return resp
+}
+
+func send(t *testing.T, conn *websocket.Conn, msg string) {
+	t.Helper()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+}
+
+func TestCountStream(t *testing.T) {
+	conn := dial(t, startServer(t, NewHandler(Options{MinCountInterval: time.Millisecond})))
+
+	send(t, conn, `{"command":"count","id":"c1","from":1,"to":5,"interval_ms":5}`)
+	for want := 1.0; want <= 5; want++ {
+		resp := readResponse(t, conn)
+		if resp.ID != "c1" || resp.Result == nil || *resp.Result != want {
+			t.Fatalf("got %+v expected result %v", resp, want)
+		}
+	}
+	if resp := readResponse(t, conn); !resp.Done || resp.ID != "c1" {
+		t.Fatalf("got %+v expected done frame", resp)
+	}
+}
+
+func TestCountStreamCancel(t *testing.T) {
+	conn := dial(t, startServer(t, NewHandler(Options{MinCountInterval: time.Millisecond})))
+
+	send(t, conn, `{"command":"count","id":"c1","from":1,"to":1000,"interval_ms":50}`)
+	if resp := readResponse(t, conn); resp.Result == nil || *resp.Result != 1 {
+		t.Fatalf("got %+v expected first count frame", resp)
+	}
+
+	send(t, conn, `{"command":"cancel","id":"c1"}`)
+	for {
+		resp := readResponse(t, conn)
+		if resp.Command == "cancel" {
+			if !resp.Done || resp.Error != "" {
+				t.Fatalf("cancel: got %+v", resp)
+			}
+			break
+		}
+	}
+
+	// Nothing else should arrive from the cancelled stream
+	_ = conn.SetReadDeadline(time.Now().Add(150 * time.Millisecond))
+	if _, payload, err := conn.ReadMessage(); err == nil {
+		t.Fatalf("got frame after cancel: %s", payload)
+	}
+}
+
+func TestCountStreamLimits(t *testing.T) {
+	conn := dial(t, startServer(t, NewHandler(Options{MaxCountRange: 10, MinCountInterval: 20 * time.Millisecond})))
+
+	tests := []struct {
+		send string
+		code string
+	}{
+		{`{"command":"count","from":1,"to":11,"interval_ms":50}`, ErrCodeInvalidRange},
+		{`{"command":"count","from":1,"to":5,"interval_ms":1}`, ErrCodeInvalidRange},
+		{`{"command":"count","from":5,"to":1,"interval_ms":50}`, ErrCodeInvalidRange},
+		{`{"command":"count","from":1.5,"to":3,"interval_ms":50}`, ErrCodeInvalidRange},
+		{`{"command":"count","from":1152921504606846976,"to":1152921504606846976,"interval_ms":50}`, ErrCodeInvalidRange},
+		{`{"command":"count","from":-1e300,"to":-1e300,"interval_ms":50}`, ErrCodeInvalidRange},
+		{`{"command":"cancel","id":"missing"}`, ErrCodeNoSuchStream},
+		{`[{"command":"count","from":1,"to":2,"interval_ms":50}]`, ""},
+	}
+
+	for _, tt := range tests {
+		if tt.code == "" {
+			var got []CommandResponse
+			if err := json.Unmarshal([]byte(roundTrip(t, conn, tt.send)), &got); err != nil || len(got) != 1 || got[0].Code != ErrCodeNotBatchable {
+				t.Errorf("send %s: got %+v (%v)", tt.send, got, err)
+			}
+			continue
+		}
+		send(t, conn, tt.send)
+		if resp := readResponse(t, conn); resp.Code != tt.code {
+			t.Errorf("send %s: got %+v expected code %s", tt.send, resp, tt.code)
+		}
+	}
+}
+
+func TestCountStreamStopsOnDisconnect(t *testing.T) {
+	url := startServer(t, NewHandler(Options{MinCountInterval: time.Millisecond}))
+	before := runtime.NumGoroutine()
+
+	conn := dial(t, url)
+	send(t, conn, `{"command":"count","from":1,"to":1000,"interval_ms":50}`)
+	readResponse(t, conn)
+	conn.Close()
+
+	// The handler, write pump, ping loop and stream should all wind down
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("goroutines: got %d expected at most %d", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,103 @@
+package ws
+
+// Filename: internal/ws/stream_write.go
+
+import (
+	"encoding/json"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// Writes one whole message to w. A returned error means the message is
+// incomplete and must not be finished.
+type streamFunc func(w io.Writer) error
+
+// Queue a message that is written straight into the connection's frame
+// writer as write produces it, instead of being built in memory first.
+// Waits for queue space like enqueue, under the same slow-consumer policy.
+func (c *client) enqueueStream(messageType int, write streamFunc) bool {
+	return c.queue(outbound{messageType: messageType, stream: write}, laneData)
+}
+
+// Write a streamed message. The deadline is set before the writer is taken
+// so a stalled peer can't hold the pump past writeWait. The writer is only
+// closed, which finishes the message, once the producer has succeeded: on
+// an error the message is left unfinished and the caller tears the
+// connection down. Returns the start of the message for the audit log and
+// message logger, and its full length.
+func (c *client) writeStream(m outbound) ([]byte, int, error) {
+	_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	// The length isn't known up front, and streamed messages are the big ones
+	compress := c.compressAbove > 0
+	c.conn.EnableWriteCompression(compress)
+	if compress {
+		atomic.AddUint64(&compressedCounter, 1)
+	}
+	w, err := c.conn.NextWriter(m.messageType)
+	if err != nil {
+		return nil, 0, err
+	}
+	head := &headWriter{max: maxMessageSize}
+	if err := m.stream(io.MultiWriter(w, head)); err != nil {
+		return nil, 0, err
+	}
+	return head.buf, head.total, w.Close()
+}
+
+// Keeps the first max bytes written to it and discards the rest, counting
+// them all
+type headWriter struct {
+	buf   []byte
+	max   int
+	total int
+}
+
+func (h *headWriter) Write(p []byte) (int, error) {
+	if room := h.max - len(h.buf); room > 0 {
+		h.buf = append(h.buf, p[:min(room, len(p))]...)
+	}
+	h.total += len(p)
+	return len(p), nil
+}
+
+// The history a history command returns. On JSON connections it is
+// streamed entry by entry rather than marshalled in one piece, since a full
+// ring of payloads runs to hundreds of KB.
+type historyDump []historyEntry
+
+// A streamFunc writing resp as JSON, or false if resp has nothing worth
+// streaming. The output decodes to the same response as json.Marshal(resp);
+// only "data" moves to the end.
+func streamResponse(resp CommandResponse) (streamFunc, bool) {
+	dump, ok := resp.Data.(historyDump)
+	if !ok || len(dump) == 0 {
+		return nil, false
+	}
+	resp.Data = nil
+	head, err := marshalResponse(resp)
+	if err != nil {
+		return nil, false
+	}
+	// head always carries "command", so it's never just "{}"
+	open := append(head[:len(head)-1], `,"data":[`...)
+	return func(w io.Writer) error {
+		if _, err := w.Write(open); err != nil {
+			return err
+		}
+		for i, e := range dump {
+			b, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			if i > 0 {
+				b = append([]byte{','}, b...)
+			}
+			if _, err := w.Write(b); err != nil {
+				return err
+			}
+		}
+		_, err := io.WriteString(w, "]}")
+		return err
+	}, true
+}
@@ -0,0 +1,85 @@
+// Filename: internal/ws/stream_write_test.go
+
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestHistoryStreamsAsOneMessage(t *testing.T) {
+	conn := dial(t, startServer(t, NewHandler(Options{HistorySize: maxHistorySize})))
+
+	// Each echo records its request and its reply, so this fills the ring
+	payload := strings.Repeat("x", historyPayloadMax-32)
+	for i := 0; i < maxHistorySize/2; i++ {
+		roundTrip(t, conn, payload)
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"command":"history"}`)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	mt, msg, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if mt != websocket.TextMessage || len(msg) < 300<<10 {
+		t.Fatalf("got type %d with %d bytes, expected one text message over 300KB", mt, len(msg))
+	}
+	var got struct {
+		Command string         `json:"command"`
+		Seq     uint64         `json:"seq"`
+		Data    []historyEntry `json:"data"`
+	}
+	if err := json.Unmarshal(msg, &got); err != nil {
+		t.Fatalf("unmarshal %d bytes: %v", len(msg), err)
+	}
+	if got.Command != "history" || got.Seq == 0 || len(got.Data) != maxHistorySize {
+		t.Fatalf("got command %q seq %d with %d entries, expected history with %d", got.Command, got.Seq, len(got.Data), maxHistorySize)
+	}
+	if last := got.Data[len(got.Data)-1]; last.Direction != directionIn || last.Payload != `{"command":"history"}` {
+		t.Errorf("last entry: got %+v", last)
+	}
+
+	// Nothing of the dump is left over, and the streamed reply isn't recorded
+	if got := roundTrip(t, conn, `{"command":"history","limit":1}`); !strings.Contains(got, `"payload":"{\"command\":\"history\",\"limit\":1}"`) {
+		t.Errorf("next reply: got %s", got)
+	}
+}
+
+func TestStreamErrorClosesWith1011(t *testing.T) {
+	url := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		c := newClient(conn, encodingJSON, Options{}.withDefaults())
+		c.goWorker(c.writePump)
+		c.enqueueStream(websocket.TextMessage, func(w io.Writer) error {
+			if _, err := w.Write([]byte(`{"data":"` + strings.Repeat("x", 64<<10))); err != nil {
+				return err
+			}
+			return errors.New("producer failed")
+		})
+	}))
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, msg, err := conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseInternalServerErr) {
+		t.Fatalf("got %d bytes and %v, expected a 1011 close", len(msg), err)
+	}
+}
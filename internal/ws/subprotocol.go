@@ -0,0 +1,56 @@
+package ws
+
+// Filename: internal/ws/subprotocol.go
+
+import (
+	"net/http"
+
+	"github.com/gorilla/websocket"
+)
+
+// Subprotocols a client may ask for in Sec-WebSocket-Protocol
+const (
+	subprotocolEcho     = "echo.v1"     // prefix/echo only, text is never parsed as JSON
+	subprotocolCommands = "commands.v1" // every text frame is a JSON command
+)
+
+var supportedSubprotocols = []string{encodingMsgpack, subprotocolEcho, subprotocolCommands, subprotocolEnvelope}
+
+// Pick the first subprotocol in the client's own order of preference that
+// we support, or "" if it asked for none we know
+func selectSubprotocol(r *http.Request) string {
+	for _, requested := range websocket.Subprotocols(r) {
+		for _, supported := range supportedSubprotocols {
+			if requested == supported {
+				return requested
+			}
+		}
+	}
+	return ""
+}
+
+// First frame sent on every connection
+type welcomeFrame struct {
+	Type        string `json:"type"`
+	ConnID      string `json:"conn_id"`
+	Subprotocol string `json:"subprotocol"`
+	Encoding    string `json:"encoding"`
+
+	// Reconnect with ?resume=<ResumeToken> to carry this session over
+	ResumeToken  string `json:"resume_token"`
+	Resumed      bool   `json:"resumed,omitempty"`
+	ResumeFailed bool   `json:"resume_failed,omitempty"` // asked to resume, got a fresh session
+
+	// 2 when frames come in v2 envelopes; left out otherwise
+	Version int `json:"version,omitempty"`
+
+	// From the /ws query; see parseConnQuery
+	Room     string            `json:"room,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// Who keeps the connection alive: "server" (we ping) or "client"
+	Heartbeat string `json:"heartbeat"`
+
+	// Which build of the server this is; see CurrentBuild
+	Build BuildInfo `json:"build"`
+}
@@ -0,0 +1,74 @@
+// Filename: internal/ws/subprotocol_test.go
+
+package ws
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestSubprotocolNegotiation(t *testing.T) {
+	url := startServer(t, NewHandler(Options{}))
+
+	tests := []struct {
+		name     string
+		offered  []string
+		expected string
+	}{
+		{"none", nil, ""},
+		{"echo", []string{"echo.v1"}, "echo.v1"},
+		{"commands", []string{"commands.v1"}, "commands.v1"},
+		{"client order wins", []string{"commands.v1", "echo.v1"}, "commands.v1"},
+		{"unknown skipped", []string{"chat.v9", "echo.v1"}, "echo.v1"},
+		{"all unknown", []string{"chat.v9"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, raw := dialWelcome(t, &websocket.Dialer{Subprotocols: tt.offered}, url)
+			if got := conn.Subprotocol(); got != tt.expected {
+				t.Errorf("negotiated %q expected %q", got, tt.expected)
+			}
+
+			var welcome welcomeFrame
+			if err := json.Unmarshal(raw, &welcome); err != nil {
+				t.Fatalf("welcome %s: %v", raw, err)
+			}
+			if welcome.Type != "welcome" || welcome.Subprotocol != tt.expected || welcome.Encoding != encodingJSON {
+				t.Errorf("welcome: got %+v", welcome)
+			}
+		})
+	}
+}
+
+func TestSubprotocolBehavior(t *testing.T) {
+	url := startServer(t, NewHandler(Options{}))
+	echo := dialWith(t, &websocket.Dialer{Subprotocols: []string{"echo.v1"}}, url)
+	commands := dialWith(t, &websocket.Dialer{Subprotocols: []string{"commands.v1"}}, url)
+	mixed := dial(t, url)
+
+	tests := []struct {
+		name     string
+		conn     *websocket.Conn
+		send     string
+		expected string
+	}{
+		{"echo keeps JSON as text", echo, `{"command":"add","a":1,"b":2}`, `{"command":"add","a":1,"b":2}`},
+		{"echo keeps prefixes", echo, "UPPER:hi", "HI"},
+		{"commands runs JSON", commands, `{"command":"add","a":1,"b":2}`, `{"command":"add","result":3}`},
+		{"commands rejects text", commands, "hello",
+			`{"command":"","error":"Invalid JSON: commands.v1 expects a JSON command","code":"ERR_INVALID_JSON"}`},
+		{"mixed echoes text", mixed, "hello", "hello"},
+		{"mixed runs JSON", mixed, `{"command":"add","a":1,"b":2}`, `{"command":"add","result":3}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := roundTrip(t, tt.conn, tt.send); got != tt.expected {
+				t.Errorf("got %s expected %s", got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+package ws
+
+// Filename: internal/ws/tenants.go
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Tenant names are what follows /ws/ in the URL, so they stay short and plain
+var tenantPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// Paths under /ws/ that are already something else
+var reservedTenants = map[string]bool{"admin": true}
+
+// TenantConfig is one tenant's entry in a tenants file: the settings that
+// differ from one tenant to the next. Zero values keep the Options the
+// tenants were built from.
+type TenantConfig struct {
+	AllowedOrigins []string                `json:"allowed_origins"`
+	OriginPolicies map[string]OriginPolicy `json:"origin_policies"`
+	MaxMessageSize int64                   `json:"max_message_size"`
+	MessageQuota   int                     `json:"message_quota"`
+	BandwidthLimit int64                   `json:"bandwidth_limit"` // bytes per minute; see Options.BandwidthLimit
+	AuthToken      string                  `json:"auth_token"`      // see Options.AuthToken
+}
+
+// ValidateTenants rejects an empty set, names that can't appear in a path
+// or that /ws already uses, and settings out of range
+func ValidateTenants(tenants map[string]TenantConfig) error {
+	if len(tenants) == 0 {
+		return errors.New("tenants: none configured")
+	}
+	for _, name := range sortedTenants(tenants) {
+		t := tenants[name]
+		switch {
+		case !tenantPattern.MatchString(name):
+			return fmt.Errorf("tenant %q: name must be 1 to 32 lower-case letters, digits, - and _", name)
+		case reservedTenants[name]:
+			return fmt.Errorf("tenant %q: name is reserved", name)
+		case t.MaxMessageSize < 0 || t.MessageQuota < 0 || t.BandwidthLimit < 0:
+			return fmt.Errorf("tenant %q: limits must not be negative", name)
+		case t.MaxMessageSize > 0 && t.MaxMessageSize < minMessageSize:
+			return fmt.Errorf("tenant %q: max_message_size must be at least %d", name, minMessageSize)
+		}
+		if err := ValidateOriginPolicies(t.OriginPolicies); err != nil {
+			return fmt.Errorf("tenant %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// LoadTenants reads and validates the JSON object of tenant name to
+// TenantConfig in the file at path:
+//
+//	{
+//	  "app1": {"allowed_origins": ["https://app1.example.com"], "auth_token": "s3cret"},
+//	  "app2": {"allowed_origins": ["https://app2.example.com"], "bandwidth_limit": 262144}
+//	}
+func LoadTenants(path string) (map[string]TenantConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var tenants map[string]TenantConfig
+	if err := dec.Decode(&tenants); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := ValidateTenants(tenants); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return tenants, nil
+}
+
+func sortedTenants[V any](tenants map[string]V) []string {
+	names := make([]string, 0, len(tenants))
+	for name := range tenants {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Tenants serves several independent apps from one process, one at each
+// /ws/{tenant}. Every tenant has a Handler of its own, so its hub, message
+// counter, connection count and settings are its own too: broadcasts,
+// presence and dms never cross from one tenant to another.
+type Tenants struct {
+	handlers map[string]*Handler
+	logger   *log.Logger
+}
+
+// NewTenants builds a Handler for each tenant from base with the tenant's
+// TenantConfig on top. Each one's log lines start "tenant=<name> ", and
+// the files and Redis channel in base get the name added, so
+// "counter.json" becomes "counter.app1.json" for app1. base.ConfigFile is
+// ignored: tenants aren't reloaded. It panics if tenants fail
+// ValidateTenants or a tenant's Options fail Validate.
+func NewTenants(base Options, tenants map[string]TenantConfig) *Tenants {
+	if err := ValidateTenants(tenants); err != nil {
+		panic(err)
+	}
+	t := &Tenants{handlers: make(map[string]*Handler, len(tenants)), logger: base.Logger}
+	if t.logger == nil {
+		t.logger = log.Default()
+	}
+	for _, name := range sortedTenants(tenants) {
+		t.handlers[name] = NewHandler(tenantOptions(base, name, tenants[name]))
+	}
+	return t
+}
+
+// The Options for tenant name
+func tenantOptions(base Options, name string, cfg TenantConfig) Options {
+	opts := base
+	opts.Tenant = name
+	opts.ConfigFile = ""
+	if cfg.AllowedOrigins != nil {
+		opts.AllowedOrigins = cfg.AllowedOrigins
+	}
+	if cfg.OriginPolicies != nil {
+		opts.OriginPolicies = cfg.OriginPolicies
+	}
+	if cfg.MaxMessageSize > 0 {
+		opts.MaxMessageSize = cfg.MaxMessageSize
+	}
+	if cfg.MessageQuota > 0 {
+		opts.MessageQuota = cfg.MessageQuota
+	}
+	if cfg.BandwidthLimit > 0 {
+		opts.BandwidthLimit = cfg.BandwidthLimit
+	}
+	if cfg.AuthToken != "" {
+		opts.AuthToken = cfg.AuthToken
+	}
+
+	logger := opts.Logger
+	if logger == nil {
+		logger = log.Default()
+	}
+	opts.Logger = log.New(logger.Writer(), logger.Prefix()+"tenant="+name+" ", logger.Flags()|log.Lmsgprefix)
+	opts.CounterFile = tenantPath(opts.CounterFile, name)
+	opts.BanFile = tenantPath(opts.BanFile, name)
+	if opts.UploadDir != "" {
+		opts.UploadDir = filepath.Join(opts.UploadDir, name)
+	}
+	if opts.RedisChannel == "" {
+		opts.RedisChannel = defaultRedisChannel
+	}
+	opts.RedisChannel += ":" + name
+	return opts
+}
+
+// file with name before its extension; "" stays ""
+func tenantPath(file, name string) string {
+	if file == "" {
+		return ""
+	}
+	ext := filepath.Ext(file)
+	return strings.TrimSuffix(file, ext) + "." + name + ext
+}
+
+// Handler returns the handler for tenant name; nil if there's no such tenant
+func (t *Tenants) Handler(name string) *Handler {
+	return t.handlers[name]
+}
+
+// Names lists the tenants in order
+func (t *Tenants) Names() []string {
+	return sortedTenants(t.handlers)
+}
+
+// ServeHTTP hands an upgrade to its tenant's handler: the one the
+// {tenant} wildcard names when mounted at "/ws/{tenant}", otherwise the
+// last element of the path. An unknown tenant gets 404 before any
+// upgrade.
+func (t *Tenants) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("tenant")
+	if name == "" {
+		name = path.Base(r.URL.Path)
+	}
+	h, ok := t.handlers[name]
+	if !ok {
+		http.Error(w, "unknown tenant", http.StatusNotFound)
+		return
+	}
+	h.ServeHTTP(w, r)
+}
+
+// Drain drains every tenant's handler; see Handler.Drain
+func (t *Tenants) Drain() {
+	for _, h := range t.handlers {
+		h.Drain()
+	}
+}
+
+// Close closes every tenant's handler
+func (t *Tenants) Close() {
+	for _, h := range t.handlers {
+		h.Close()
+	}
+}
+
+// One tenant in GET /admin/tenants
+type tenantInfo struct {
+	Name        string `json:"name"`
+	Connections int64  `json:"open_connections"`
+	Messages    uint64 `json:"messages"`
+	Draining    bool   `json:"draining,omitempty"`
+}
+
+// AdminHandler serves the admin API for every tenant:
+//
+//	GET  /admin/tenants             each tenant's name, open connections and message count
+//	     /admin/tenants/{tenant}/... that tenant's own API; see Handler.AdminHandler
+//
+// so POST /admin/tenants/app1/drain drains app1 alone. Connections listed
+// there carry their tenant. Requests must carry "Authorization: Bearer <token>".
+func (t *Tenants) AdminHandler(token string) http.Handler {
+	admins := make(map[string]http.Handler, len(t.handlers))
+	for name, h := range t.handlers {
+		admins[name] = h.AdminHandler(token)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/tenants", t.listTenants)
+	mux.HandleFunc("/admin/tenants/{tenant}/", func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("tenant")
+		admin, ok := admins[name]
+		if !ok {
+			http.Error(w, "unknown tenant", http.StatusNotFound)
+			return
+		}
+		// The tenant's API is routed on the paths it has on its own
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = "/admin" + strings.TrimPrefix(r.URL.Path, "/admin/tenants/"+name)
+		r2.URL.RawPath = ""
+		admin.ServeHTTP(w, r2)
+	})
+	return requireToken(token, mux)
+}
+
+func (t *Tenants) listTenants(w http.ResponseWriter, r *http.Request) {
+	out := make([]tenantInfo, 0, len(t.handlers))
+	for _, name := range t.Names() {
+		h := t.handlers[name]
+		out = append(out, tenantInfo{
+			Name:        name,
+			Connections: h.stats.open.Load(),
+			Messages:    h.stats.messages.Load(),
+			Draining:    h.Draining(),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(out); err != nil {
+		t.logger.Printf("admin: encode tenants: %v", err)
+	}
+}
@@ -0,0 +1,145 @@
+// Filename: internal/ws/tenants_test.go
+
+package ws
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestTenantsAreIsolated(t *testing.T) {
+	tenants := NewTenants(Options{}, map[string]TenantConfig{
+		"app1": {},
+		"app2": {AuthToken: "s3cret"},
+	})
+	t.Cleanup(tenants.Close)
+	mux := http.NewServeMux()
+	mux.Handle("/ws/{tenant}", tenants)
+	admin := tenants.AdminHandler("secret")
+	mux.Handle("/admin/tenants", admin)
+	mux.Handle("/admin/tenants/", admin)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	base := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws/"
+
+	for url, expected := range map[string]int{
+		base + "nope":              http.StatusNotFound,
+		base + "app2":              http.StatusUnauthorized,
+		base + "app2?token=wrong":  http.StatusUnauthorized,
+		base + "app1?token=s3cret": http.StatusSwitchingProtocols,
+	} {
+		conn, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {allowedOrigins[0]}})
+		if err == nil {
+			conn.Close()
+		}
+		if resp == nil || resp.StatusCode != expected {
+			t.Errorf("%s: got %v, %v expected %d", url, resp, err, expected)
+		}
+	}
+
+	sender, peer := dial(t, base+"app1"), dial(t, base+"app1")
+	other := dial(t, base+"app2?token=s3cret")
+	roundTrip(t, sender, "NICK:loud")
+	roundTrip(t, other, "NICK:elsewhere")
+
+	// Each tenant counts its own messages: app2's ping is its second,
+	// not the third of the process
+	var reply struct {
+		GlobalSeq uint64 `json:"global_seq"`
+	}
+	if err := json.Unmarshal([]byte(rawRoundTrip(t, other, `{"command":"ping"}`)), &reply); err != nil || reply.GlobalSeq != 2 {
+		t.Errorf("app2 global_seq: got %d (%v) expected 2", reply.GlobalSeq, err)
+	}
+
+	if got := roundTrip(t, sender, `{"command":"broadcast","text":"hi app1"}`); got != `{"command":"broadcast","data":{"delivered":1,"dropped":0}}` {
+		t.Errorf("broadcast: got %s", got)
+	}
+	_ = peer.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, msg, err := readData(peer); err != nil || !strings.Contains(string(msg), "hi app1") {
+		t.Errorf("same tenant: got %s, %v", msg, err)
+	}
+	if got := roundTrip(t, sender, `{"command":"dm","to":"elsewhere","text":"psst"}`); !strings.Contains(got, ErrCodeNoSuchRecipient) {
+		t.Errorf("dm across tenants: got %s", got)
+	}
+	if got := roundTrip(t, sender, `{"command":"who"}`); strings.Contains(got, "elsewhere") {
+		t.Errorf("who lists another tenant: %s", got)
+	}
+
+	// Nor do its stats count app1's connections
+	var stats struct {
+		Data statsInfo `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(roundTrip(t, other, `{"command":"stats"}`)), &stats); err != nil ||
+		stats.Data.Server.OpenConnections != 1 || stats.Data.Server.Tenant != "app2" {
+		t.Errorf("app2 stats: got %+v, %v", stats.Data.Server, err)
+	}
+
+	// Nothing from app1, presence included, reaches app2
+	_ = other.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, msg, err := other.ReadMessage(); err == nil {
+		t.Errorf("app2 got a frame from app1: %s", msg)
+	}
+
+	get := func(path string, v any) {
+		t.Helper()
+		req, _ := http.NewRequest("GET", srv.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+	}
+	var list []connectionInfo
+	get("/admin/tenants/app1/connections", &list)
+	if len(list) != 2 || list[0].Tenant != "app1" || list[1].Tenant != "app1" {
+		t.Errorf("app1 connections: got %+v", list)
+	}
+	var summary []tenantInfo
+	get("/admin/tenants", &summary)
+	if len(summary) != 2 || summary[0] != (tenantInfo{Name: "app1", Connections: 2, Messages: 4}) ||
+		summary[1] != (tenantInfo{Name: "app2", Connections: 1, Messages: 3}) {
+		t.Errorf("tenants: got %+v", summary)
+	}
+}
+
+func TestTenantOptions(t *testing.T) {
+	opts := tenantOptions(Options{CounterFile: "/var/lib/ws/counter.json", BanFile: "bans", MaxMessageSize: 4096, AuthToken: "base"},
+		"app1", TenantConfig{MaxMessageSize: 16384})
+	if opts.Tenant != "app1" || opts.CounterFile != "/var/lib/ws/counter.app1.json" || opts.BanFile != "bans.app1" ||
+		opts.MaxMessageSize != 16384 || opts.AuthToken != "base" || opts.RedisChannel != defaultRedisChannel+":app1" {
+		t.Errorf("got %+v", opts)
+	}
+
+	path := filepath.Join(t.TempDir(), "tenants.json")
+	for _, tt := range []struct {
+		file string
+		ok   bool
+	}{
+		{`{"app1": {"auth_token": "x"}, "app-2": {"bandwidth_limit": 1024}}`, true},
+		{`{}`, false},
+		{`{"App1": {}}`, false},
+		{`{"admin": {}}`, false},
+		{`{"app1": {"message_quota": -1}}`, false},
+		{`{"app1": {"origin_policies": {"nope": {}}}}`, false},
+		{`{"app1": {"colour": "blue"}}`, false},
+	} {
+		if err := os.WriteFile(path, []byte(tt.file), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadTenants(path); (err == nil) != tt.ok {
+			t.Errorf("%s: got %v", tt.file, err)
+		}
+	}
+}
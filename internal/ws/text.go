@@ -0,0 +1,127 @@
+package ws
+
+// Filename: internal/ws/text.go
+
+import (
+	"bytes"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"unicode"
+	"unicode/utf8"
+)
+
+// textPrefix is a plain-text message prefix that transforms the rest of the message
+type textPrefix struct {
+	Prefix      string `json:"prefix"`
+	Description string `json:"description"`
+
+	apply    func(dst, src []byte) []byte // appends the transformed src to dst
+	windowed bool                         // apply works on any run of whole characters; see streamEcho
+}
+
+// Every supported text prefix; "help" lists these alongside the commands
+var textPrefixes = []textPrefix{
+	{Prefix: "UPPER:", Description: "Echo the rest of the message in upper case", apply: appendUpper, windowed: true},
+	{Prefix: "REVERSE:", Description: "Echo the rest of the message reversed", apply: appendReversed},
+}
+
+// The plain-text message that asks for help without JSON
+const helpText = "HELP"
+
+// Scratch space for building echo replies. Queued frames are kept by the
+// history, audit log and message logger, so each reply is copied out once
+// built and the buffer goes straight back. A message past
+// Options.StreamThreshold is echoed a streamWindow at a time instead (see
+// handleTextStream), so the buffers mostly stay within the threshold; one
+// read whole all the same, such as a text that starts with a command name,
+// can grow one to the read limit.
+var replyBuffers = sync.Pool{New: func() any {
+	b := make([]byte, 0, 512)
+	return &b
+}}
+
+// Build the reply to a non-command text message: the help listing for
+// HELP, otherwise the echo
+func handleText(reg *CommandRegistry, s *Session, payload []byte) ([]byte, error) {
+	if bytes.Equal(payload, []byte(helpText)) {
+		return marshalResponse(reg.help())
+	}
+	return s.echoReply(payload), nil
+}
+
+// Append the echo of payload to dst, transformed if it starts with a text prefix
+func appendText(dst, payload []byte) []byte {
+	if p, ok := findTextPrefix(payload); ok {
+		return p.apply(dst, payload[len(p.Prefix):])
+	}
+	return append(dst, payload...)
+}
+
+// The echo of a text message with "[Conn #c / Msg #n] " in front, where c
+// counts frames on this connection and n counts them across the server
+func (s *Session) echoReply(payload []byte) []byte {
+	bp := replyBuffers.Get().(*[]byte)
+	b := appendText(s.appendEchoLabel((*bp)[:0]), payload)
+	reply := bytes.Clone(b)
+	*bp = b
+	replyBuffers.Put(bp)
+	return reply
+}
+
+// Append the "[Conn #c / Msg #n] " an echo starts with
+func (s *Session) appendEchoLabel(b []byte) []byte {
+	b = append(b, "[Conn #"...)
+	b = strconv.AppendUint(b, atomic.LoadUint64(&s.seq), 10)
+	b = append(b, " / Msg #"...)
+	b = strconv.AppendUint(b, atomic.LoadUint64(&s.globalSeq), 10)
+	return append(b, "] "...)
+}
+
+// Append s in upper case, as strings.ToUpper would write it
+func appendUpper(dst, s []byte) []byte {
+	for len(s) > 0 {
+		if c := s[0]; c < utf8.RuneSelf {
+			if 'a' <= c && c <= 'z' {
+				c -= 'a' - 'A'
+			}
+			dst, s = append(dst, c), s[1:]
+			continue
+		}
+		r, size := utf8.DecodeRune(s)
+		dst, s = utf8.AppendRune(dst, unicode.ToUpper(r)), s[size:]
+	}
+	return dst
+}
+
+// Append s reversed grapheme cluster by cluster (see graphemeLen), so
+// accents stay on their letters and flags and emoji sequences stay whole.
+// Each byte that isn't UTF-8 becomes one U+FFFD. The clusters are copied
+// straight into place from the back, so nothing is allocated beyond dst.
+func appendReversed(dst, s []byte) []byte {
+	if !utf8.Valid(s) {
+		s = replaceInvalid(s)
+	}
+	n := len(dst)
+	dst = slices.Grow(dst, len(s))[:n+len(s)]
+	end := len(dst)
+	for len(s) > 0 {
+		size := graphemeLen(s)
+		end -= size
+		copy(dst[end:], s[:size])
+		s = s[size:]
+	}
+	return dst
+}
+
+// s with every byte that isn't part of a UTF-8 character replaced by U+FFFD
+func replaceInvalid(s []byte) []byte {
+	out := make([]byte, 0, len(s)+8)
+	for len(s) > 0 {
+		r, size := utf8.DecodeRune(s)
+		out = utf8.AppendRune(out, r)
+		s = s[size:]
+	}
+	return out
+}
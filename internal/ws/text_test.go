@@ -0,0 +1,84 @@
+// Filename: internal/ws/text_test.go
+
+package ws
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHandleText(t *testing.T) {
+	tests := []struct {
+		send     string
+		expected string
+	}{
+		{"hello", "hello"},
+		{"UPPER:hello", "HELLO"},
+		{"REVERSE:héllo", "olléh"},
+		{"upper:hello", "upper:hello"},
+		{"REVERSE:", ""},
+		{"UPPER:straße ǆ", "STRAßE Ǆ"},
+		{"REVERSE:a\xffb", "b\uFFFDa"},
+	}
+
+	s := newSession(defaultHistorySize)
+	s.nextSeq(41)
+	for _, tt := range tests {
+		got, err := handleText(DefaultRegistry, s, []byte(tt.send))
+		if err != nil || string(got) != "[Conn #1 / Msg #41] "+tt.expected {
+			t.Errorf("send %q: got %q (%v) expected %q", tt.send, got, err, tt.expected)
+		}
+	}
+}
+
+func TestHandleTextHelp(t *testing.T) {
+	got, err := handleText(DefaultRegistry, newSession(defaultHistorySize), []byte("HELP"))
+	if err != nil {
+		t.Fatalf("handleText: %v", err)
+	}
+	expected, _ := marshalResponse(DefaultRegistry.help())
+	if string(got) != string(expected) {
+		t.Errorf("got %s expected %s", got, expected)
+	}
+}
+
+// The byte-slice transforms must write exactly what the string functions
+// did. Reversing by rune is only the reference while every grapheme
+// cluster is one rune; see TestReverseGraphemes for the rest.
+func TestTransformsMatchStrings(t *testing.T) {
+	reverse := func(s string) string {
+		r := []rune(s)
+		for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+			r[i], r[j] = r[j], r[i]
+		}
+		return string(r)
+	}
+	for _, s := range []string{"", "hello", "Hello, World! 123", "héllo wörld", "straße ǆ ﬃ", "日本語テキスト", "a\xffb\xc3", "🙂👍"} {
+		if got := string(appendUpper(nil, []byte(s))); got != strings.ToUpper(s) {
+			t.Errorf("upper %q: got %q expected %q", s, got, strings.ToUpper(s))
+		}
+		if got := string(appendReversed(nil, []byte(s))); got != reverse(s) {
+			t.Errorf("reverse %q: got %q expected %q", s, got, reverse(s))
+		}
+	}
+}
+
+func BenchmarkTextEcho(b *testing.B) {
+	s := newSession(defaultHistorySize)
+	for name, payload := range map[string]string{
+		"plain":   "hello, this is a plain echo",
+		"upper":   "UPPER:hello, this is an upper-cased echo",
+		"reverse": "REVERSE:hello, this is a reversed echo",
+	} {
+		b.Run(name, func(b *testing.B) {
+			p := []byte(payload)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				s.nextSeq(uint64(i))
+				if _, err := handleText(DefaultRegistry, s, p); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
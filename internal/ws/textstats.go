@@ -0,0 +1,72 @@
+package ws
+
+// Filename: internal/ws/textstats.go
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Payload of the "wordcount" response
+type wordCount struct {
+	Words int `json:"words"`
+}
+
+// Payload of the "charcount" response. A rune is one code point; a char
+// here is a rune that isn't a combining mark, so "é" counts once whether it
+// arrives precomposed (1 rune, 2 bytes) or as "e" plus U+0301 (2 runes, 3
+// bytes). Emoji built from several code points still count each one.
+type charCount struct {
+	Bytes int `json:"bytes"`
+	Runes int `json:"runes"`
+	Chars int `json:"chars"`
+}
+
+// Payload of the "palindrome" response, with the letters that were compared
+type palindromeResult struct {
+	Palindrome bool   `json:"palindrome"`
+	Normalized string `json:"normalized"`
+}
+
+// Count the whitespace-separated words of text, Unicode spaces included
+func runWordCount(_ *Session, req CommandRequest) CommandResponse {
+	return CommandResponse{Command: req.Command, Data: wordCount{Words: len(strings.Fields(req.Text))}}
+}
+
+// Measure text in bytes, runes and chars
+func runCharCount(_ *Session, req CommandRequest) CommandResponse {
+	n := charCount{Bytes: len(req.Text), Runes: utf8.RuneCountInString(req.Text)}
+	for _, r := range req.Text {
+		if !unicode.Is(unicode.M, r) {
+			n.Chars++
+		}
+	}
+	return CommandResponse{Command: req.Command, Data: n}
+}
+
+// Does text read the same backwards, comparing letters only, without case
+// or accents?
+func runPalindrome(_ *Session, req CommandRequest) CommandResponse {
+	if req.Text == "" {
+		return errorResponse(req.Command, ErrCodeMissingText, "Missing text")
+	}
+	// Decomposed, an accented letter is its base letter plus marks, and
+	// the marks go with the other non-letters
+	var letters []rune
+	for _, r := range norm.NFD.String(req.Text) {
+		if unicode.IsLetter(r) {
+			letters = append(letters, unicode.ToLower(r))
+		}
+	}
+	res := palindromeResult{Palindrome: true, Normalized: string(letters)}
+	for i, j := 0, len(letters)-1; i < j; i, j = i+1, j-1 {
+		if letters[i] != letters[j] {
+			res.Palindrome = false
+			break
+		}
+	}
+	return CommandResponse{Command: req.Command, Data: res}
+}
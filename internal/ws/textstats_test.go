@@ -0,0 +1,106 @@
+// Filename: internal/ws/textstats_test.go
+
+package ws
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func textStat(t *testing.T, command, text string, into any) CommandResponse {
+	t.Helper()
+	resp := processCommand(DefaultRegistry, newSession(defaultHistorySize), CommandRequest{Command: command, Text: text})
+	if resp.Code == "" {
+		// Through JSON, as a client sees it
+		b, err := json.Marshal(resp.Data)
+		if err != nil || json.Unmarshal(b, into) != nil {
+			t.Fatalf("%s %q: data %s, %v", command, text, b, err)
+		}
+	}
+	return resp
+}
+
+func TestWordCount(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"   ", 0},
+		{"one", 1},
+		{"  two   words ", 2},
+		{"tabs\tand\nnewlines", 3},
+		// No-break space and ideographic space are whitespace too
+		{"a\u00A0b\u3000c", 3},
+		// Without spaces, CJK text is one word
+		{"日本語のテキスト", 1},
+		{"café crème", 2},
+	}
+	for _, tt := range tests {
+		var got wordCount
+		textStat(t, "wordcount", tt.text, &got)
+		if got.Words != tt.want {
+			t.Errorf("%q: got %d words expected %d", tt.text, got.Words, tt.want)
+		}
+	}
+}
+
+func TestCharCount(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want charCount
+	}{
+		{"empty", "", charCount{}},
+		{"ascii", "hello", charCount{Bytes: 5, Runes: 5, Chars: 5}},
+		{"precomposed é", "café", charCount{Bytes: 5, Runes: 4, Chars: 4}},
+		// e + COMBINING ACUTE ACCENT: one more rune, same char count
+		{"combining é", "cafe\u0301", charCount{Bytes: 6, Runes: 5, Chars: 4}},
+		{"stacked marks", "a\u0301\u0323", charCount{Bytes: 5, Runes: 3, Chars: 1}},
+		{"cjk", "日本", charCount{Bytes: 6, Runes: 2, Chars: 2}},
+		{"emoji", "😀", charCount{Bytes: 4, Runes: 1, Chars: 1}},
+		// Woman + ZWJ + rocket: one picture, three code points, all counted
+		{"zwj sequence", "👩‍🚀", charCount{Bytes: 11, Runes: 3, Chars: 3}},
+	}
+	for _, tt := range tests {
+		var got charCount
+		textStat(t, "charcount", tt.text, &got)
+		if got != tt.want {
+			t.Errorf("%s: got %+v expected %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPalindrome(t *testing.T) {
+	tests := []struct {
+		text       string
+		want       bool
+		normalized string
+	}{
+		{"racecar", true, "racecar"},
+		{"A man, a plan, a canal: Panama!", true, "amanaplanacanalpanama"},
+		{"Was it a car or a cat I saw?", true, "wasitacaroracatisaw"},
+		{"hello", false, "hello"},
+		{"x", true, "x"},
+		// Digits aren't letters
+		{"a1b2c", false, "abc"},
+		// Accents are dropped, precomposed or combining
+		{"Ésope reste ici et se repose", true, "esoperesteicietserepose"},
+		{"e\u0301te\u0301", true, "ete"},
+		{"été", true, "ete"},
+		{"Ελλά", false, "ελλα"},
+		{"上海自来水来自海上", true, "上海自来水来自海上"},
+		// No letters at all reads the same either way
+		{"12321", true, ""},
+	}
+	for _, tt := range tests {
+		var got palindromeResult
+		textStat(t, "palindrome", tt.text, &got)
+		if got.Palindrome != tt.want || got.Normalized != tt.normalized {
+			t.Errorf("%q: got %+v expected %v %q", tt.text, got, tt.want, tt.normalized)
+		}
+	}
+	if resp := textStat(t, "palindrome", "", nil); resp.Code != ErrCodeMissingText {
+		t.Errorf("no text: got %+v", resp)
+	}
+}
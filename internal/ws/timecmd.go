@@ -0,0 +1,72 @@
+package ws
+
+// Filename: internal/ws/timecmd.go
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Error codes for the time command
+const (
+	ErrCodeUnknownTimezone = "ERR_UNKNOWN_TIMEZONE"
+	ErrCodeUnknownFormat   = "ERR_UNKNOWN_FORMAT"
+)
+
+// The formats of the time command. The unix ones are numbers, in result;
+// the others are strings, in text.
+var timeFormats = map[string]func(time.Time) (string, float64){
+	"rfc3339": func(t time.Time) (string, float64) { return t.Format(time.RFC3339), 0 },
+	"kitchen": func(t time.Time) (string, float64) { return t.Format(time.Kitchen), 0 },
+	"unix":    func(t time.Time) (string, float64) { return "", float64(t.Unix()) },
+	"unix_ms": func(t time.Time) (string, float64) { return "", float64(t.UnixMilli()) },
+}
+
+// The formats in the order error messages list them
+var timeFormatNames = []string{"rfc3339", "unix", "unix_ms", "kitchen"}
+
+// Zones already loaded, by name. Only zones that exist are kept, so the
+// cache can't be grown with made-up names.
+var zoneCache sync.Map // string -> *time.Location
+
+// Find an IANA zone, reading it from the system's zone database once per process
+func loadZone(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	if loc, ok := zoneCache.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	zoneCache.Store(name, loc)
+	return loc, nil
+}
+
+// Return the server's time, in UTC unless tz names a zone, as RFC 3339
+// unless format picks another
+func runTime(s *Session, req CommandRequest) CommandResponse {
+	format := req.Format
+	if format == "" {
+		format = "rfc3339"
+	}
+	render, ok := timeFormats[format]
+	if !ok {
+		return errorResponse(req.Command, ErrCodeUnknownFormat,
+			fmt.Sprintf("Unknown format %q (want %s)", req.Format, strings.Join(timeFormatNames, ", ")))
+	}
+	loc, err := loadZone(req.TZ)
+	if err != nil {
+		return errorResponse(req.Command, ErrCodeUnknownTimezone, fmt.Sprintf("Unknown timezone %q: %v", req.TZ, err))
+	}
+
+	text, n := render(s.clock.Now().In(loc))
+	if text != "" {
+		return CommandResponse{Command: req.Command, Text: text}
+	}
+	return resultResponse(req.Command, n)
+}
@@ -0,0 +1,75 @@
+// Filename: internal/ws/timecmd_test.go
+
+package ws
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func timeAt(t time.Time, tz, format string) CommandResponse {
+	s := newSession(defaultHistorySize)
+	s.clock = newFakeClock(t)
+	return processCommand(DefaultRegistry, s, CommandRequest{Command: "time", TZ: tz, Format: format})
+}
+
+func TestTimeFormats(t *testing.T) {
+	summer := time.Date(2024, 7, 1, 16, 30, 5, 123456789, time.UTC)
+	winter := time.Date(2024, 1, 15, 16, 30, 5, 0, time.UTC)
+	tests := []struct {
+		name   string
+		at     time.Time
+		tz     string
+		format string
+		text   string
+		result float64
+	}{
+		{"default", summer, "", "", "2024-07-01T16:30:05Z", 0},
+		{"explicit UTC", summer, "UTC", "rfc3339", "2024-07-01T16:30:05Z", 0},
+		// New York is on daylight time in July and standard time in January
+		{"dst", summer, "America/New_York", "", "2024-07-01T12:30:05-04:00", 0},
+		{"standard time", winter, "America/New_York", "", "2024-01-15T11:30:05-05:00", 0},
+		{"no dst", summer, "America/Belize", "kitchen", "10:30AM", 0},
+		{"unix", summer, "", "unix", "", 1719851405},
+		// Milliseconds are truncated, and the zone doesn't change the instant
+		{"unix_ms", summer, "Asia/Tokyo", "unix_ms", "", 1719851405123},
+	}
+	for _, tt := range tests {
+		resp := timeAt(tt.at, tt.tz, tt.format)
+		if resp.Code != "" || resp.Text != tt.text {
+			t.Errorf("%s: got %+v expected %q", tt.name, resp, tt.text)
+		}
+		if tt.text == "" && (resp.Result == nil || *resp.Result != tt.result) {
+			t.Errorf("%s: got %+v expected %v", tt.name, resp, strconv.FormatFloat(tt.result, 'f', -1, 64))
+		}
+	}
+}
+
+func TestTimeErrors(t *testing.T) {
+	now := time.Now()
+	if resp := timeAt(now, "Mars/Olympus_Mons", ""); resp.Code != ErrCodeUnknownTimezone {
+		t.Errorf("unknown zone: got %+v", resp)
+	}
+	if _, ok := zoneCache.Load("Mars/Olympus_Mons"); ok {
+		t.Error("unknown zone was cached")
+	}
+	if resp := timeAt(now, "", "iso"); resp.Code != ErrCodeUnknownFormat {
+		t.Errorf("unknown format: got %+v", resp)
+	}
+
+	timeAt(now, "Europe/Paris", "")
+	if _, ok := zoneCache.Load("Europe/Paris"); !ok {
+		t.Error("Europe/Paris wasn't cached")
+	}
+}
+
+func TestTimeUsesHandlerClock(t *testing.T) {
+	clock := newFakeClock(time.Now())
+	url := startServer(t, NewHandler(Options{Clock: clock}))
+	conn := dial(t, url)
+	want := `{"command":"time","result":` + strconv.FormatInt(clock.Now().UnixMilli(), 10) + `}`
+	if got := roundTrip(t, conn, `{"command":"time","format":"unix_ms"}`); got != want {
+		t.Errorf("got %s expected %s", got, want)
+	}
+}
@@ -0,0 +1,43 @@
+// Filename: internal/ws/timing_test.go
+
+package ws
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTimingField(t *testing.T) {
+	conn := dial(t, startServer(t, NewHandler(Options{})))
+
+	if got := roundTrip(t, conn, `{"command":"add","a":1,"b":2}`); strings.Contains(got, "duration_us") {
+		t.Errorf("without timing: got %s", got)
+	}
+	for _, msg := range []string{
+		`{"command":"add","a":1,"b":2,"timing":true}`,
+		`{"command":"nope","timing":true}`,
+		`[{"command":"add","a":1,"b":2,"timing":true}]`,
+	} {
+		if got := roundTrip(t, conn, msg); !strings.Contains(got, `"duration_us":`) {
+			t.Errorf("%s: got %s", msg, got)
+		}
+	}
+
+	// The delay's duration is the wait
+	send(t, conn, `{"command":"delay","a":50,"timing":true}`)
+	resp := readResponse(t, conn)
+	if resp.DurationUS == nil || *resp.DurationUS < 50000 || *resp.DurationUS > 1000000 {
+		t.Errorf("delay: got %+v", resp)
+	}
+}
+
+func TestTimingOption(t *testing.T) {
+	conn := dial(t, startServer(t, NewHandler(Options{Timing: true})))
+	if got := roundTrip(t, conn, `{"command":"add","a":1,"b":2}`); !strings.Contains(got, `"duration_us":`) {
+		t.Errorf("got %s", got)
+	}
+	// A reply that isn't a command's doesn't get one
+	if got := roundTrip(t, conn, `{"command":`); !strings.Contains(got, ErrCodeInvalidJSON) || strings.Contains(got, "duration_us") {
+		t.Errorf("invalid JSON: got %s", got)
+	}
+}
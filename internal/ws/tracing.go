@@ -0,0 +1,131 @@
+package ws
+
+// Filename: internal/ws/tracing.go
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// Instrumentation scope of every span the handler starts
+const tracerName = "github.com/alexdev404/ws-main/internal/ws"
+
+// Attributes on the handler's spans
+const (
+	attrConnID      = attribute.Key("ws.conn_id")
+	attrCommand     = attribute.Key("ws.command")
+	attrErrorCode   = attribute.Key("ws.error_code")
+	attrPayloadSize = attribute.Key("ws.payload_size")
+	attrEncoding    = attribute.Key("ws.encoding")
+	attrCloseCode   = attribute.Key("ws.close_code")
+	attrOrigin      = attribute.Key("http.request.header.origin")
+	attrStatusCode  = attribute.Key("http.response.status_code")
+	attrClientAddr  = attribute.Key("client.address")
+	attrTenant      = attribute.Key("ws.tenant")
+)
+
+// The tracer for opts: a no-op one without Options.TracerProvider
+func newTracer(opts Options) trace.Tracer {
+	if opts.TracerProvider == nil {
+		return noop.NewTracerProvider().Tracer(tracerName)
+	}
+	return opts.TracerProvider.Tracer(tracerName)
+}
+
+// Default for Options.Propagator: W3C traceparent and baggage, which is
+// what browser instrumentation sends
+func defaultPropagator() propagation.TextMapPropagator {
+	return propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+}
+
+// Start the span covering an upgrade, as a child of any trace context the
+// client sent. Returns the client's context too, which the connection span
+// hangs off.
+func (h *Handler) startUpgrade(r *http.Request) (remote context.Context, span trace.Span) {
+	remote = r.Context()
+	if h.opts.TracerProvider != nil {
+		remote = h.opts.Propagator.Extract(remote, propagation.HeaderCarrier(r.Header))
+	}
+	_, span = h.tracer.Start(remote, "ws.upgrade", trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attrOrigin.String(r.Header.Get("Origin"))))
+	if h.opts.Tenant != "" {
+		span.SetAttributes(attrTenant.String(h.opts.Tenant))
+	}
+	return remote, span
+}
+
+// Refuse an upgrade with an HTTP error, saying why on its span
+func refuseUpgrade(w http.ResponseWriter, span trace.Span, msg string, status int) {
+	span.SetAttributes(attrStatusCode.Int(status))
+	span.SetStatus(codes.Error, msg)
+	http.Error(w, msg, status)
+}
+
+// End the upgrade span and start the one that lasts as long as c, linked
+// to it. Commands on c get spans of their own under the connection span.
+func (h *Handler) startConnection(remote context.Context, upgrade trace.Span, c *client) trace.Span {
+	upgrade.SetAttributes(attrStatusCode.Int(http.StatusSwitchingProtocols), attrConnID.String(c.session.conn.ID))
+	upgrade.End()
+	ctx, span := h.tracer.Start(remote, "ws.connection",
+		trace.WithLinks(trace.Link{SpanContext: upgrade.SpanContext()}),
+		trace.WithAttributes(
+			attrConnID.String(c.session.conn.ID),
+			attrClientAddr.String(c.session.conn.RemoteAddr),
+			attrOrigin.String(c.session.conn.Origin),
+			attrEncoding.String(c.encoding),
+		))
+	if h.opts.Tenant != "" {
+		span.SetAttributes(attrTenant.String(h.opts.Tenant))
+	}
+	if h.opts.TracerProvider != nil {
+		c.session.trace = &connTrace{tracer: h.tracer, ctx: ctx}
+	}
+	return span
+}
+
+// End a connection span with the code the connection closed with
+func endConnection(span trace.Span, code int) {
+	span.SetAttributes(attrCloseCode.Int(code))
+	span.End()
+}
+
+// Tracing state of one connection. A Session has none when tracing is off,
+// so commands then cost nothing extra.
+type connTrace struct {
+	tracer    trace.Tracer
+	ctx       context.Context // holds the connection span
+	frameSize int             // bytes in the frame being handled; set by the read loop
+}
+
+// Note the size of the frame whose commands are about to run
+func (t *connTrace) frame(size int) {
+	if t != nil {
+		t.frameSize = size
+	}
+}
+
+// Start the span for one command. Every command from a batch or NDJSON
+// frame reports the size of the whole frame.
+func (t *connTrace) startCommand(s *Session, req CommandRequest) trace.Span {
+	_, span := t.tracer.Start(t.ctx, "ws.command", trace.WithAttributes(
+		attrCommand.String(req.Command),
+		attrConnID.String(s.conn.ID),
+		attrPayloadSize.Int(t.frameSize),
+	))
+	return span
+}
+
+// End a command span, marking it failed when the reply is an error
+func endCommand(span trace.Span, resp CommandResponse) {
+	if resp.Code != "" {
+		span.SetAttributes(attrErrorCode.String(resp.Code))
+		span.SetStatus(codes.Error, resp.Error)
+	}
+	span.End()
+}
@@ -0,0 +1,130 @@
+// Filename: internal/ws/tracing_test.go
+
+package ws
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// The value of key on span, or an invalid Value if it isn't set
+func spanAttr(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTracing(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	url := startServer(t, NewHandler(Options{TracerProvider: provider}))
+
+	// As a browser with trace instrumentation would send it
+	const traceID, parentID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	header := http.Header{
+		"Origin":      {allowedOrigins[0]},
+		"Traceparent": {"00-" + traceID + "-" + parentID + "-01"},
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := conn.ReadMessage(); err != nil {
+		t.Fatalf("read welcome: %v", err)
+	}
+	addCmd := `{"command":"add","a":1,"b":2}`
+	roundTrip(t, conn, addCmd)
+	roundTrip(t, conn, `{"command":"divide","a":1,"b":0}`)
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	conn.Close()
+
+	// The connection span ends once the server has finished with it
+	byName := map[string][]sdktrace.ReadOnlySpan{}
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		byName = map[string][]sdktrace.ReadOnlySpan{}
+		for _, span := range rec.Ended() {
+			byName[span.Name()] = append(byName[span.Name()], span)
+		}
+		if len(byName["ws.connection"]) > 0 || time.Now().After(deadline) {
+			break
+		}
+	}
+	if len(byName["ws.upgrade"]) != 1 || len(byName["ws.connection"]) != 1 || len(byName["ws.command"]) != 2 {
+		t.Fatalf("got spans %v", byName)
+	}
+	upgrade, connection := byName["ws.upgrade"][0], byName["ws.connection"][0]
+
+	// Upgrade and connection both join the client's trace
+	for _, span := range []sdktrace.ReadOnlySpan{upgrade, connection} {
+		if got := span.SpanContext().TraceID().String(); got != traceID {
+			t.Errorf("%s trace: got %s expected %s", span.Name(), got, traceID)
+		}
+		if got := span.Parent().SpanID().String(); got != parentID || !span.Parent().IsRemote() {
+			t.Errorf("%s parent: got %s expected remote %s", span.Name(), got, parentID)
+		}
+	}
+	if got := spanAttr(upgrade, attrStatusCode).AsInt64(); got != http.StatusSwitchingProtocols {
+		t.Errorf("upgrade status: got %d", got)
+	}
+	if links := connection.Links(); len(links) != 1 || links[0].SpanContext.SpanID() != upgrade.SpanContext().SpanID() {
+		t.Errorf("connection links: got %v expected the upgrade", links)
+	}
+	connID := spanAttr(connection, attrConnID).AsString()
+	if connID == "" {
+		t.Error("connection span has no conn_id")
+	}
+	if got := spanAttr(connection, attrCloseCode).AsInt64(); got != websocket.CloseNormalClosure {
+		t.Errorf("close code: got %d expected %d", got, websocket.CloseNormalClosure)
+	}
+	if connection.EndTime().Before(upgrade.EndTime()) {
+		t.Error("connection span ended before the upgrade span")
+	}
+
+	// One span per command, under the connection
+	add, divide := byName["ws.command"][0], byName["ws.command"][1]
+	for _, span := range []sdktrace.ReadOnlySpan{add, divide} {
+		if span.Parent().SpanID() != connection.SpanContext().SpanID() {
+			t.Errorf("command parent: got %s expected the connection %s", span.Parent().SpanID(), connection.SpanContext().SpanID())
+		}
+		if got := spanAttr(span, attrConnID).AsString(); got != connID {
+			t.Errorf("command conn_id: got %q expected %q", got, connID)
+		}
+	}
+	if got := spanAttr(add, attrCommand).AsString(); got != "add" {
+		t.Errorf("command: got %q expected add", got)
+	}
+	if got := spanAttr(add, attrPayloadSize).AsInt64(); got != int64(len(addCmd)) {
+		t.Errorf("payload size: got %d expected %d", got, len(addCmd))
+	}
+	if add.Status().Code == codes.Error || spanAttr(add, attrErrorCode).AsString() != "" {
+		t.Errorf("add marked failed: %v", add.Status())
+	}
+	if got := spanAttr(divide, attrErrorCode).AsString(); got != ErrCodeDivByZero || divide.Status().Code != codes.Error {
+		t.Errorf("divide: got code %q status %v", got, divide.Status())
+	}
+}
+
+func TestTracingOff(t *testing.T) {
+	h := NewHandler(Options{})
+	conn := dial(t, startServer(t, h))
+	roundTrip(t, conn, `{"command":"add","a":1,"b":2}`)
+	if h.tracer == nil {
+		t.Fatal("no tracer")
+	}
+	for _, c := range h.hub.snapshot() {
+		if c.session.trace != nil {
+			t.Error("session traced without a TracerProvider")
+		}
+	}
+}
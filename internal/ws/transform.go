@@ -0,0 +1,45 @@
+package ws
+
+// Filename: internal/ws/transform.go
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+)
+
+// Error codes for the transform command
+const (
+	ErrCodeUnknownOp   = "ERR_UNKNOWN_OP"
+	ErrCodeMissingText = "ERR_MISSING_TEXT"
+)
+
+// The text operations of the transform command. upper and reverse are the
+// same as the UPPER: and REVERSE: text prefixes.
+var transformOps = map[string]func(string) string{
+	"upper":   func(s string) string { return string(appendUpper(nil, []byte(s))) },
+	"lower":   func(s string) string { return string(bytes.ToLower([]byte(s))) },
+	"reverse": func(s string) string { return string(appendReversed(nil, []byte(s))) },
+	// Unicode word boundaries, so "o'neil" and "ÉCOLE-normale" come out right.
+	// A Caser keeps state, so each call gets its own.
+	"title": func(s string) string { return cases.Title(language.Und).String(s) },
+}
+
+// Apply op to text: the JSON form of the text prefixes, plus lower and title
+func runTransform(_ *Session, req CommandRequest) CommandResponse {
+	op, ok := transformOps[req.Op]
+	if !ok {
+		return errorResponse(req.Command, ErrCodeUnknownOp,
+			fmt.Sprintf("Unknown op %q (want %s)", req.Op, strings.Join(transformOpNames, ", ")))
+	}
+	if req.Text == "" {
+		return errorResponse(req.Command, ErrCodeMissingText, "Missing text")
+	}
+	return CommandResponse{Command: req.Command, Text: op(req.Text)}
+}
+
+// The ops in the order error messages list them
+var transformOpNames = []string{"upper", "lower", "reverse", "title"}
@@ -0,0 +1,61 @@
+// Filename: internal/ws/transform_test.go
+
+package ws
+
+import (
+	"testing"
+)
+
+func TestTransformCommand(t *testing.T) {
+	tests := []struct {
+		op, text, want string
+	}{
+		{"upper", "crème brûlée", "CRÈME BRÛLÉE"},
+		{"upper", "東京 tower", "東京 TOWER"},
+		{"upper", "hi 👋🏽 there", "HI 👋🏽 THERE"},
+		{"lower", "ÀÉÎÕÜ ÇA", "àéîõü ça"},
+		{"lower", "日本語 ABC", "日本語 abc"},
+		{"lower", "🎉PARTY🎉", "🎉party🎉"},
+		{"reverse", "añb", "bña"},
+		{"reverse", "日本語", "語本日"},
+		{"reverse", "a👋b🎉", "🎉b👋a"},
+		{"reverse", "cafe\u0301 🇯🇵", "🇯🇵 e\u0301fac"},
+		{"title", "élan vital", "Élan Vital"},
+		{"title", "ÉCOLE-normale supérieure", "École-Normale Supérieure"},
+		{"title", "hello 世界 wide", "Hello 世界 Wide"},
+		{"title", "👋 hello o'neil", "👋 Hello O'neil"},
+	}
+	for _, tt := range tests {
+		resp := processCommand(DefaultRegistry, newSession(defaultHistorySize), CommandRequest{Command: "transform", Op: tt.op, Text: tt.text})
+		if resp.Error != "" || resp.Text != tt.want || resp.Result != nil {
+			t.Errorf("%s %q: got %+v expected %q", tt.op, tt.text, resp, tt.want)
+		}
+	}
+
+	for _, tt := range []struct {
+		req  CommandRequest
+		code string
+	}{
+		{CommandRequest{Command: "transform", Op: "shout", Text: "hi"}, ErrCodeUnknownOp},
+		{CommandRequest{Command: "transform", Text: "hi"}, ErrCodeUnknownOp},
+		{CommandRequest{Command: "transform", Op: "upper"}, ErrCodeMissingText},
+	} {
+		if resp := processCommand(DefaultRegistry, newSession(defaultHistorySize), tt.req); resp.Code != tt.code {
+			t.Errorf("%+v: got %+v expected %s", tt.req, resp, tt.code)
+		}
+	}
+}
+
+func TestTransformOverTheWire(t *testing.T) {
+	conn := dial(t, startServer(t, NewHandler(Options{})))
+	if got := roundTrip(t, conn, `{"command":"transform","op":"reverse","text":"añb👋"}`); got != `{"command":"transform","text":"👋bña"}` {
+		t.Errorf("transform: got %s", got)
+	}
+	// The prefixes it stands in for still work, and numeric replies carry no text
+	if got := roundTrip(t, conn, "UPPER:crème"); got != "CRÈME" {
+		t.Errorf("UPPER: got %s", got)
+	}
+	if got := roundTrip(t, conn, `{"command":"add","a":1,"b":2}`); got != `{"command":"add","result":3}` {
+		t.Errorf("add: got %s", got)
+	}
+}
@@ -0,0 +1,211 @@
+package ws
+
+// Filename: internal/ws/upload.go
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Error codes for uploads
+const (
+	ErrCodeUploadDisabled = "ERR_UPLOAD_DISABLED"
+	ErrCodeUploadTooLarge = "ERR_UPLOAD_TOO_LARGE"
+	ErrCodeUploadBusy     = "ERR_UPLOAD_IN_PROGRESS"
+	ErrCodeUploadChunk    = "ERR_UPLOAD_CHUNK"
+	ErrCodeUploadMismatch = "ERR_UPLOAD_MISMATCH"
+)
+
+// Default for Options.MaxUploadSize
+const defaultMaxUploadSize = 10 << 20
+
+// Each chunk frame starts with its index, a big-endian uint32 counting from 0
+const uploadChunkHeader = 4
+
+// Longest file name an upload may be stored under
+const maxUploadName = 128
+
+// An upload that has been announced and is waiting for its chunks. The
+// chunks are written to a temporary file in the upload directory, which is
+// renamed into place once the size and hash check out.
+type upload struct {
+	name    string // sanitized; the file it ends up as
+	size    int64  // announced length
+	chunks  int    // announced frame count
+	next    int    // index of the chunk expected next
+	written int64
+	want    []byte // expected sha256; nil when the client gave none
+	file    *os.File
+	hash    hash.Hash
+}
+
+// What "upload" reports when an upload is accepted and when it completes
+type uploadResult struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Chunks int    `json:"chunks"`
+	SHA256 string `json:"sha256,omitempty"` // on completion only
+}
+
+// Start an upload of name: the client then sends chunks binary frames
+// adding up to size bytes. Asking for a second upload while one is in
+// progress cancels both, since it's no longer clear whose chunks follow.
+func runUpload(s *Session, req CommandRequest) CommandResponse {
+	if s.uploadDir == "" {
+		return errorResponse(req.Command, ErrCodeUploadDisabled, "Uploads are not enabled on this server")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u := s.upload; u != nil {
+		s.discardUpload()
+		return errorResponse(req.Command, ErrCodeUploadBusy, fmt.Sprintf("Upload of %q was still in progress; both are cancelled", u.name))
+	}
+
+	name, ok := sanitizeUploadName(req.Name)
+	if !ok {
+		return errorResponse(req.Command, ErrCodeInvalidName, fmt.Sprintf("Invalid file name %q", req.Name))
+	}
+	if req.Size < 0 || req.Chunks < 1 || (req.Size > 0 && int64(req.Chunks) > req.Size) {
+		return errorResponse(req.Command, ErrCodeInvalidOperand, "size must be at least 0 and chunks between 1 and size")
+	}
+	if req.Size > s.maxUpload {
+		return errorResponse(req.Command, ErrCodeUploadTooLarge, fmt.Sprintf("Upload of %d bytes exceeds the %d byte limit", req.Size, s.maxUpload))
+	}
+	var want []byte
+	if req.SHA256 != "" {
+		var err error
+		if want, err = hex.DecodeString(req.SHA256); err != nil || len(want) != sha256.Size {
+			return errorResponse(req.Command, ErrCodeInvalidOperand, "sha256 must be 64 hex digits")
+		}
+	}
+
+	if err := os.MkdirAll(s.uploadDir, 0o755); err != nil {
+		s.log.Printf("upload: %v", err)
+		return errorResponse(req.Command, ErrCodeInternal, "Upload could not be started")
+	}
+	f, err := os.CreateTemp(s.uploadDir, ".upload-*")
+	if err != nil {
+		s.log.Printf("upload: %v", err)
+		return errorResponse(req.Command, ErrCodeInternal, "Upload could not be started")
+	}
+	s.upload = &upload{name: name, size: req.Size, chunks: req.Chunks, want: want, file: f, hash: sha256.New()}
+	s.log.Printf("upload of %s (%d bytes in %d chunks) started on %s", name, req.Size, req.Chunks, s.conn.ID)
+	return CommandResponse{Command: req.Command, Data: uploadResult{Name: name, Size: req.Size, Chunks: req.Chunks}}
+}
+
+// Reduce a client's file name to a safe base name in the upload
+// directory: no directories, no leading dots, nothing but letters,
+// digits, '.', '-' and '_'
+func sanitizeUploadName(name string) (string, bool) {
+	name = filepath.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, name)
+	name = strings.TrimLeft(name, ".")
+	if name == "" || len(name) > maxUploadName {
+		return "", false
+	}
+	return name, true
+}
+
+// Whether binary frames on this connection are upload chunks
+func (s *Session) uploading() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.upload != nil
+}
+
+// Drop the upload in progress, if any, and its temporary file
+func (s *Session) abortUpload() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.discardUpload()
+}
+
+// Caller holds s.mu
+func (s *Session) discardUpload() {
+	u := s.upload
+	if u == nil {
+		return
+	}
+	s.upload = nil
+	u.file.Close()
+	if err := os.Remove(u.file.Name()); err != nil {
+		s.log.Printf("upload: %v", err)
+	}
+}
+
+// Add one chunk frame to the upload in progress. The reply is an error if
+// the chunk is out of order or doesn't fit, which cancels the upload, or
+// the completion once the last chunk is in; false means there is nothing
+// to reply yet.
+func (s *Session) addChunk(frame []byte) (CommandResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.upload
+	fail := func(code, msg string) (CommandResponse, bool) {
+		s.discardUpload()
+		return errorResponse("upload", code, msg), true
+	}
+
+	if len(frame) < uploadChunkHeader {
+		return fail(ErrCodeUploadChunk, "Chunk frame is shorter than its index")
+	}
+	if i := binary.BigEndian.Uint32(frame); int64(i) != int64(u.next) {
+		return fail(ErrCodeUploadChunk, fmt.Sprintf("Chunk %d arrived but chunk %d was expected", i, u.next))
+	}
+	data := frame[uploadChunkHeader:]
+	if u.written+int64(len(data)) > u.size {
+		return fail(ErrCodeUploadMismatch, fmt.Sprintf("Chunks add up to more than the announced %d bytes", u.size))
+	}
+	if _, err := u.file.Write(data); err != nil {
+		s.log.Printf("upload: %v", err)
+		return fail(ErrCodeInternal, "Upload could not be written")
+	}
+	u.hash.Write(data)
+	u.written += int64(len(data))
+	if u.next++; u.next < u.chunks {
+		return CommandResponse{}, false
+	}
+
+	if u.written != u.size {
+		return fail(ErrCodeUploadMismatch, fmt.Sprintf("Got %d bytes but %d were announced", u.written, u.size))
+	}
+	sum := u.hash.Sum(nil)
+	if u.want != nil && !bytes.Equal(sum, u.want) {
+		return fail(ErrCodeUploadMismatch, "sha256 does not match the uploaded data")
+	}
+	if err := u.file.Close(); err != nil {
+		s.log.Printf("upload: %v", err)
+		return fail(ErrCodeInternal, "Upload could not be written")
+	}
+	if err := os.Rename(u.file.Name(), filepath.Join(s.uploadDir, u.name)); err != nil {
+		s.log.Printf("upload: %v", err)
+		return fail(ErrCodeInternal, "Upload could not be saved")
+	}
+	s.upload = nil
+	s.log.Printf("upload of %s (%d bytes) finished on %s", u.name, u.written, s.conn.ID)
+	return CommandResponse{Command: "upload", Done: true,
+		Data: uploadResult{Name: u.name, Size: u.written, Chunks: u.chunks, SHA256: hex.EncodeToString(sum)}}, true
+}
+
+// Feed a binary frame to the upload in progress and send whatever it
+// replies. Returns false once the connection is closing.
+func (c *client) handleUploadChunk(payload []byte) bool {
+	resp, ok := c.session.addChunk(payload)
+	if !ok {
+		return true
+	}
+	return c.sendEncoded(c.session.stamp(resp))
+}
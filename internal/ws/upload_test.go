@@ -0,0 +1,188 @@
+// Filename: internal/ws/upload_test.go
+
+package ws
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type uploadReply struct {
+	Command string       `json:"command"`
+	Done    bool         `json:"done"`
+	Data    uploadResult `json:"data"`
+	Code    string       `json:"code"`
+}
+
+// Send data as chunk number index
+func sendChunk(t *testing.T, conn *websocket.Conn, index uint32, data []byte) {
+	t.Helper()
+	frame := binary.BigEndian.AppendUint32(nil, index)
+	if err := conn.WriteMessage(websocket.BinaryMessage, append(frame, data...)); err != nil {
+		t.Fatalf("write chunk %d: %v", index, err)
+	}
+}
+
+func readUploadReply(t *testing.T, conn *websocket.Conn) uploadReply {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, msg, err := readData(conn)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	var got uploadReply
+	if err := json.Unmarshal(msg, &got); err != nil {
+		t.Fatalf("unmarshal %s: %v", msg, err)
+	}
+	return got
+}
+
+func startUpload(t *testing.T, conn *websocket.Conn, req string) uploadReply {
+	t.Helper()
+	var got uploadReply
+	if err := json.Unmarshal([]byte(rawRoundTrip(t, conn, req)), &got); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	return got
+}
+
+// The upload directory holds exactly these files, temporary ones included
+func assertUploadDir(t *testing.T, dir string, want ...string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		entries, _ := os.ReadDir(dir)
+		var got []string
+		for _, e := range entries {
+			got = append(got, e.Name())
+		}
+		if fmt.Sprint(got) == fmt.Sprint(want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("upload dir holds %v expected %v", got, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestUploadInChunks(t *testing.T) {
+	dir := t.TempDir()
+	conn := dial(t, startServer(t, NewHandler(Options{UploadDir: dir})))
+
+	data := make([]byte, 10000)
+	rand.Read(data)
+	sum := sha256.Sum256(data)
+	ack := startUpload(t, conn, fmt.Sprintf(`{"command":"upload","name":"../x y.bin","size":%d,"chunks":3,"sha256":%q}`,
+		len(data), hex.EncodeToString(sum[:])))
+	if ack.Code != "" || ack.Done || ack.Data.Name != "x_y.bin" {
+		t.Fatalf("ack: got %+v", ack)
+	}
+
+	sendChunk(t, conn, 0, data[:4000])
+	sendChunk(t, conn, 1, data[4000:8000])
+	sendChunk(t, conn, 2, data[8000:])
+	got := readUploadReply(t, conn)
+	if !got.Done || got.Data.Size != int64(len(data)) || got.Data.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Fatalf("completion: got %+v", got)
+	}
+	stored, err := os.ReadFile(filepath.Join(dir, "x_y.bin"))
+	if err != nil || string(stored) != string(data) {
+		t.Fatalf("stored file: %d bytes, %v", len(stored), err)
+	}
+	assertUploadDir(t, dir, "x_y.bin")
+
+	// Binary frames are echoed again once the upload is over
+	if err := conn.WriteMessage(websocket.BinaryMessage, []byte("hi")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if mt, msg, err := readData(conn); err != nil || mt != websocket.BinaryMessage || string(msg[binaryHeaderSize:]) != "hi" {
+		t.Errorf("echo: got %d %q %v", mt, msg, err)
+	}
+}
+
+func TestUploadSizeLimit(t *testing.T) {
+	dir := t.TempDir()
+	conn := dial(t, startServer(t, NewHandler(Options{UploadDir: dir, MaxUploadSize: 1000})))
+
+	if got := startUpload(t, conn, `{"command":"upload","name":"big.bin","size":1001,"chunks":1}`); got.Code != ErrCodeUploadTooLarge {
+		t.Fatalf("got %+v expected %s", got, ErrCodeUploadTooLarge)
+	}
+
+	// Sending more than was announced fails too
+	if got := startUpload(t, conn, `{"command":"upload","name":"liar.bin","size":10,"chunks":2}`); got.Code != "" {
+		t.Fatalf("ack: got %+v", got)
+	}
+	sendChunk(t, conn, 0, make([]byte, 11))
+	if got := readUploadReply(t, conn); got.Code != ErrCodeUploadMismatch {
+		t.Fatalf("got %+v expected %s", got, ErrCodeUploadMismatch)
+	}
+	assertUploadDir(t, dir)
+}
+
+func TestUploadAborted(t *testing.T) {
+	dir := t.TempDir()
+	conn := dial(t, startServer(t, NewHandler(Options{UploadDir: dir})))
+
+	// Out of order
+	startUpload(t, conn, `{"command":"upload","name":"a.bin","size":20,"chunks":2}`)
+	sendChunk(t, conn, 1, make([]byte, 10))
+	if got := readUploadReply(t, conn); got.Code != ErrCodeUploadChunk {
+		t.Fatalf("got %+v expected %s", got, ErrCodeUploadChunk)
+	}
+	assertUploadDir(t, dir)
+
+	// A second upload before the first is finished cancels both
+	startUpload(t, conn, `{"command":"upload","name":"b.bin","size":20,"chunks":2}`)
+	sendChunk(t, conn, 0, make([]byte, 10))
+	if got := startUpload(t, conn, `{"command":"upload","name":"c.bin","size":20,"chunks":2}`); got.Code != ErrCodeUploadBusy {
+		t.Fatalf("got %+v expected %s", got, ErrCodeUploadBusy)
+	}
+	assertUploadDir(t, dir)
+
+	// Hanging up halfway leaves nothing behind
+	startUpload(t, conn, `{"command":"upload","name":"d.bin","size":20,"chunks":2}`)
+	sendChunk(t, conn, 0, make([]byte, 10))
+	if got := startUpload(t, conn, `{"command":"stats"}`); got.Code != "" {
+		t.Fatalf("stats: got %+v", got)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Fatalf("expected one temporary file mid-upload, got %d", len(entries))
+	}
+	conn.Close()
+	assertUploadDir(t, dir)
+}
+
+func TestUploadDisabled(t *testing.T) {
+	conn := dial(t, startServer(t, NewHandler(Options{})))
+	if got := startUpload(t, conn, `{"command":"upload","name":"x.bin","size":1,"chunks":1}`); got.Code != ErrCodeUploadDisabled {
+		t.Errorf("got %+v expected %s", got, ErrCodeUploadDisabled)
+	}
+}
+
+func TestSanitizeUploadName(t *testing.T) {
+	for name, want := range map[string]string{
+		"report.pdf":          "report.pdf",
+		"../../etc/passwd":    "passwd",
+		`C:\Users\me\a b.txt`: "a_b.txt",
+		".hidden":             "hidden",
+		"naïve.txt":           "na_ve.txt",
+		"..":                  "",
+		"":                    "",
+	} {
+		got, ok := sanitizeUploadName(name)
+		if got != want || ok != (want != "") {
+			t.Errorf("%q: got %q, %v expected %q", name, got, ok, want)
+		}
+	}
+}
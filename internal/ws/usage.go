@@ -0,0 +1,109 @@
+package ws
+
+// Filename: internal/ws/usage.go
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Buckets for frames that never reached a registered command. Everything
+// else is keyed by registered name, so clients can't grow the map.
+const (
+	usageUnknown     = "unknown"
+	usageInvalidJSON = "invalid-json"
+)
+
+// How many times one command ran and how long it took
+type commandUsage struct {
+	count uint64
+	total time.Duration
+	max   time.Duration
+}
+
+// Per-command counters for every command processCommand runs, across all
+// of a handler's connections
+type usageStats struct {
+	mu       sync.Mutex
+	commands map[string]*commandUsage
+}
+
+func (u *usageStats) observe(name string, d time.Duration) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	cu, ok := u.commands[name]
+	if !ok {
+		cu = &commandUsage{}
+		u.commands[name] = cu
+	}
+	cu.count++
+	cu.total += d
+	cu.max = max(cu.max, d)
+}
+
+// One command's usage as reported by stats and the admin API
+type commandUsageInfo struct {
+	Count uint64  `json:"count"`
+	AvgMS float64 `json:"avg_ms"`
+	MaxMS float64 `json:"max_ms"`
+}
+
+// Copy of the counters, keyed by command name
+func (u *usageStats) snapshot() map[string]commandUsageInfo {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	out := make(map[string]commandUsageInfo, len(u.commands))
+	for name, cu := range u.commands {
+		out[name] = commandUsageInfo{
+			Count: cu.count,
+			AvgMS: ms(cu.total / time.Duration(cu.count)),
+			MaxMS: ms(cu.max),
+		}
+	}
+	return out
+}
+
+// One line for the log: "add=12/0.004ms divide=3/0.002ms ...", busiest first
+func (u *usageStats) summary() string {
+	snap := u.snapshot()
+	names := make([]string, 0, len(snap))
+	for name := range snap {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if snap[names[i]].Count != snap[names[j]].Count {
+			return snap[names[i]].Count > snap[names[j]].Count
+		}
+		return names[i] < names[j]
+	})
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s=%d/%.3fms", name, snap[name].Count, snap[name].AvgMS)
+	}
+	return strings.Join(parts, " ")
+}
+
+// Log the usage summary every Options.UsageLogInterval until Close
+func (h *Handler) logUsage() {
+	ticker := time.NewTicker(h.opts.UsageLogInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if line := h.stats.usage.summary(); line != "" {
+				h.opts.Logger.Printf("command usage: %s", line)
+			}
+		case <-h.stop:
+			return
+		}
+	}
+}
+
+// Reply to a frame that isn't valid JSON, counting it under usageInvalidJSON
+func invalidJSON(s *Session, msg string) CommandResponse {
+	s.stats.usage.observe(usageInvalidJSON, 0)
+	return s.stamp(errorResponse("", ErrCodeInvalidJSON, msg))
+}
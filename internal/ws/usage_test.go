@@ -0,0 +1,58 @@
+// Filename: internal/ws/usage_test.go
+
+package ws
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestCommandUsageCounts(t *testing.T) {
+	h := NewHandler(Options{})
+	conn := dial(t, startServer(t, h))
+	before := h.stats.usage.snapshot()
+
+	for _, msg := range []string{
+		`{"command":"add","a":1,"b":2}`,
+		`{"command":"add","a":3,"b":4}`,
+		`{"command":"divide","a":1,"b":0}`,
+		`[{"command":"add","a":1,"b":1},{"command":"divide","a":4,"b":2},{"command":"nope"}]`,
+		`{"command":"x-` + strings.Repeat("z", 50) + `"}`,
+		`{"command":`,
+		"hello", // echo isn't a command
+	} {
+		rawRoundTrip(t, conn, msg)
+	}
+
+	after := h.stats.usage.snapshot()
+	for name, expected := range map[string]uint64{"add": 3, "divide": 2, usageUnknown: 2, usageInvalidJSON: 1} {
+		if got := after[name].Count - before[name].Count; got != expected {
+			t.Errorf("%s: got %d expected %d", name, got, expected)
+		}
+	}
+	for _, name := range []string{"add", "divide", usageUnknown} {
+		if after[name].MaxMS <= 0 || after[name].AvgMS <= 0 {
+			t.Errorf("%s: got %+v expected nonzero durations", name, after[name])
+		}
+	}
+	for name := range after {
+		if strings.HasPrefix(name, "x-") || name == "nope" {
+			t.Errorf("unregistered command %q got its own bucket", name)
+		}
+	}
+
+	// The stats command carries the same numbers
+	var stats struct {
+		Data statsInfo `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(rawRoundTrip(t, conn, `{"command":"stats"}`)), &stats); err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	if got := stats.Data.Server.Commands["add"].Count; got < after["add"].Count {
+		t.Errorf("stats add count: got %d expected at least %d", got, after["add"].Count)
+	}
+	if line := h.stats.usage.summary(); !strings.Contains(line, "add=") || !strings.Contains(line, usageInvalidJSON+"=") {
+		t.Errorf("summary: got %q", line)
+	}
+}
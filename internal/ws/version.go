@@ -0,0 +1,118 @@
+package ws
+
+// Filename: internal/ws/version.go
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+// Set at link time, e.g.
+//
+//	go build -ldflags "-X github.com/alexdev404/ws-main/internal/ws.Version=v1.2.3
+//	  -X github.com/alexdev404/ws-main/internal/ws.Commit=$(git rev-parse HEAD)
+//	  -X github.com/alexdev404/ws-main/internal/ws.BuildDate=$(date -u +%FT%TZ)"
+//
+// Left empty, they are filled in from what the go command recorded in the
+// binary: the module version and the VCS revision and commit time.
+var (
+	Version   string
+	Commit    string
+	BuildDate string
+)
+
+// BuildInfo says which build of the server this is. GET /version, the
+// welcome frame and the "version" command all report it.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+	Modified  bool   `json:"modified,omitempty"` // built from a tree with uncommitted changes
+	GoVersion string `json:"go_version"`
+	Race      bool   `json:"race,omitempty"` // built with -race
+	CGO       bool   `json:"cgo"`
+}
+
+// What the go command recorded, read once
+var recordedBuild = sync.OnceValue(func() BuildInfo {
+	b := BuildInfo{GoVersion: runtime.Version()}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return b
+	}
+	if v := info.Main.Version; v != "" && v != "(devel)" {
+		b.Version = v
+	}
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			b.Commit = s.Value
+		case "vcs.time":
+			b.BuildDate = s.Value
+		case "vcs.modified":
+			b.Modified = s.Value == "true"
+		case "-race":
+			b.Race = s.Value == "true"
+		case "CGO_ENABLED":
+			b.CGO = s.Value == "1"
+		}
+	}
+	return b
+})
+
+// CurrentBuild reports this binary's build: the link-time variables where
+// set, otherwise what the go command recorded; "dev" when there is no
+// version at all
+func CurrentBuild() BuildInfo {
+	b := recordedBuild()
+	if Version != "" {
+		b.Version = Version
+	}
+	if Commit != "" {
+		b.Commit, b.Modified = Commit, false
+	}
+	if BuildDate != "" {
+		b.BuildDate = BuildDate
+	}
+	if b.Version == "" {
+		b.Version = "dev"
+	}
+	return b
+}
+
+// One line for the startup log
+func (b BuildInfo) String() string {
+	s := b.Version
+	if b.Commit != "" {
+		s += " commit " + b.Commit
+		if b.Modified {
+			s += " (modified)"
+		}
+	}
+	if b.BuildDate != "" {
+		s += " built " + b.BuildDate
+	}
+	s += fmt.Sprintf(" with %s, cgo=%t", b.GoVersion, b.CGO)
+	if b.Race {
+		s += ", race detector on"
+	}
+	return s
+}
+
+// ServeVersion answers GET /version with CurrentBuild as JSON
+func (h *Handler) ServeVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(CurrentBuild()); err != nil {
+		h.opts.Logger.Printf("version: encode: %v", err)
+	}
+}
+
+// Report the server's build, as GET /version does
+func runVersion(_ *Session, req CommandRequest) CommandResponse {
+	return CommandResponse{Command: req.Command, Data: CurrentBuild()}
+}
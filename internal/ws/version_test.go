@@ -0,0 +1,70 @@
+// Filename: internal/ws/version_test.go
+
+package ws
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestBuildInfoReportedEverywhere(t *testing.T) {
+	old := [3]string{Version, Commit, BuildDate}
+	t.Cleanup(func() { Version, Commit, BuildDate = old[0], old[1], old[2] })
+	Version, Commit, BuildDate = "v1.2.3", "0123abcd", "2024-05-01T12:00:00Z"
+	expected := BuildInfo{Version: "v1.2.3", Commit: "0123abcd", BuildDate: "2024-05-01T12:00:00Z",
+		GoVersion: runtime.Version(), Race: recordedBuild().Race, CGO: recordedBuild().CGO}
+
+	h := NewHandler(Options{})
+	mux := http.NewServeMux()
+	mux.Handle("/ws", h)
+	mux.HandleFunc("GET /version", h.ServeVersion)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	resp, err := http.Get(srv.URL + "/version")
+	if err != nil {
+		t.Fatalf("GET /version: %v", err)
+	}
+	defer resp.Body.Close()
+	var fromHTTP BuildInfo
+	if err := json.NewDecoder(resp.Body).Decode(&fromHTTP); err != nil || resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("GET /version: %v (%s)", err, resp.Header.Get("Content-Type"))
+	}
+	if fromHTTP != expected {
+		t.Errorf("GET /version: got %+v expected %+v", fromHTTP, expected)
+	}
+
+	conn, welcome := dialDecoded(t, "ws"+strings.TrimPrefix(srv.URL, "http")+"/ws")
+	if welcome.Build != expected {
+		t.Errorf("welcome frame: got %+v expected %+v", welcome.Build, expected)
+	}
+
+	var reply struct {
+		Data BuildInfo `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(roundTrip(t, conn, `{"command":"version"}`)), &reply); err != nil {
+		t.Fatalf("version command: %v", err)
+	}
+	if reply.Data != expected {
+		t.Errorf("version command: got %+v expected %+v", reply.Data, expected)
+	}
+}
+
+func TestBuildInfoDefaults(t *testing.T) {
+	old := [3]string{Version, Commit, BuildDate}
+	t.Cleanup(func() { Version, Commit, BuildDate = old[0], old[1], old[2] })
+	Version, Commit, BuildDate = "", "", ""
+
+	// A test binary has no module version, so there is none to fall back on
+	b := CurrentBuild()
+	if b.Version != "dev" || b.GoVersion != runtime.Version() {
+		t.Errorf("got %+v expected version dev", b)
+	}
+	if s := b.String(); !strings.HasPrefix(s, "dev") || !strings.Contains(s, runtime.Version()) {
+		t.Errorf("String: got %q", s)
+	}
+}
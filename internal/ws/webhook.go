@@ -0,0 +1,199 @@
+package ws
+
+// Filename: internal/ws/webhook.go
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Webhook delivery settings
+const (
+	defaultWebhookWorkers = 4
+	defaultWebhookTimeout = 5 * time.Second
+	webhookQueueSize      = 256 // calls waiting for a worker before new ones are dropped
+	webhookAttempts       = 3
+)
+
+// Header carrying "sha256=<hex HMAC of the body>" when Options.WebhookSecret is set
+const webhookSignatureHeader = "X-Webhook-Signature"
+
+// Wait before retry n (from 1) is webhookBackoff << (n-1)
+var webhookBackoff = 200 * time.Millisecond
+
+// Body POSTed to the webhook URLs
+type webhookPayload struct {
+	Event       string `json:"event"` // "connect" or "disconnect"
+	ConnID      string `json:"conn_id"`
+	RemoteAddr  string `json:"remote_addr"`
+	Origin      string `json:"origin"`
+	Nick        string `json:"nick,omitempty"`
+	ConnectedAt string `json:"connected_at"`
+	Timestamp   string `json:"timestamp"` // when the event happened
+
+	// Disconnect only
+	CloseCode   int    `json:"close_code,omitempty"`
+	CloseReason string `json:"close_reason,omitempty"`
+	Received    uint64 `json:"messages_received,omitempty"`
+	Sent        uint64 `json:"messages_sent,omitempty"`
+}
+
+type webhookCall struct {
+	url     string
+	payload webhookPayload
+}
+
+// webhooks posts connection events from a fixed pool of workers, so a slow
+// receiver delays other webhook calls but never a connection
+type webhooks struct {
+	connectURL    string
+	disconnectURL string
+	secret        []byte
+	client        *http.Client
+	calls         chan webhookCall
+	log           *log.Logger
+}
+
+// nil when neither URL is set
+func newWebhooks(opts Options) *webhooks {
+	if opts.ConnectWebhook == "" && opts.DisconnectWebhook == "" {
+		return nil
+	}
+	return &webhooks{
+		connectURL:    opts.ConnectWebhook,
+		disconnectURL: opts.DisconnectWebhook,
+		secret:        []byte(opts.WebhookSecret),
+		client:        &http.Client{Timeout: opts.WebhookTimeout},
+		calls:         make(chan webhookCall, webhookQueueSize),
+		log:           opts.Logger,
+	}
+}
+
+// Run n workers until stop is closed; calls still queued then are dropped,
+// and those in flight are cancelled
+func (w *webhooks) start(n int, stop <-chan struct{}, wg *sync.WaitGroup) {
+	ctx, cancel := context.WithCancel(context.Background())
+	wg.Add(n + 1)
+	go func() {
+		defer wg.Done()
+		<-stop
+		cancel()
+	}()
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case call := <-w.calls:
+					w.deliver(ctx, call)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+}
+
+// Queue a connect call for c, if configured
+func (w *webhooks) connected(c *client) {
+	if w == nil || w.connectURL == "" {
+		return
+	}
+	w.queue(w.connectURL, c.webhookPayload("connect"))
+}
+
+// Queue a disconnect call for c, if configured
+func (w *webhooks) disconnected(c *client) {
+	if w == nil || w.disconnectURL == "" {
+		return
+	}
+	p := c.webhookPayload("disconnect")
+	code, reason := c.finalClose()
+	p.CloseCode, p.CloseReason = code, reason
+	p.Received, p.Sent = atomic.LoadUint64(&c.session.seq), atomic.LoadUint64(&c.sent)
+	w.queue(w.disconnectURL, p)
+}
+
+func (w *webhooks) queue(url string, p webhookPayload) {
+	select {
+	case w.calls <- webhookCall{url: url, payload: p}:
+	default:
+		w.log.Printf("webhook %s for %s dropped: queue full", p.Event, p.ConnID)
+	}
+}
+
+func (c *client) webhookPayload(event string) webhookPayload {
+	s := c.session
+	return webhookPayload{
+		Event:       event,
+		ConnID:      s.conn.ID,
+		RemoteAddr:  s.conn.RemoteAddr,
+		Origin:      s.conn.Origin,
+		Nick:        s.Nick(),
+		ConnectedAt: s.conn.ConnectedAt.UTC().Format(serverTimeFormat),
+		Timestamp:   time.Now().UTC().Format(serverTimeFormat),
+	}
+}
+
+// POST call, retrying failures with backoff until ctx is cancelled. Errors
+// are only logged.
+func (w *webhooks) deliver(ctx context.Context, call webhookCall) {
+	body, err := json.Marshal(call.payload)
+	if err != nil {
+		w.log.Printf("webhook %s: encode: %v", call.payload.Event, err)
+		return
+	}
+	for attempt := 1; ; attempt++ {
+		err = w.post(ctx, call.url, body)
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+		if attempt == webhookAttempts {
+			break
+		}
+		select {
+		case <-time.After(webhookBackoff << (attempt - 1)):
+		case <-ctx.Done():
+			return
+		}
+	}
+	w.log.Printf("webhook %s for %s failed after %d attempts: %v",
+		call.payload.Event, call.payload.ConnID, webhookAttempts, err)
+}
+
+func (w *webhooks) post(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(w.secret) > 0 {
+		req.Header.Set(webhookSignatureHeader, "sha256="+signWebhook(w.secret, body))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
+
+// Hex HMAC-SHA256 of body, what receivers recompute to check the signature
+func signWebhook(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,115 @@
+// Filename: internal/ws/webhook_test.go
+
+package ws
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWebhooksRetryAndPayload(t *testing.T) {
+	defer func(b time.Duration) { webhookBackoff = b }(webhookBackoff)
+	webhookBackoff = 10 * time.Millisecond
+
+	// Fails the first call, then records every body
+	var mu sync.Mutex
+	calls := 0
+	bodies := make(chan []byte, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		calls++
+		first := calls == 1
+		mu.Unlock()
+		if r.Header.Get(webhookSignatureHeader) != "sha256="+signWebhook([]byte("shh"), body) {
+			t.Errorf("bad signature %q", r.Header.Get(webhookSignatureHeader))
+		}
+		if first {
+			http.Error(w, "try again", http.StatusInternalServerError)
+			return
+		}
+		bodies <- body
+	}))
+	t.Cleanup(receiver.Close)
+
+	h := NewHandler(Options{ConnectWebhook: receiver.URL, DisconnectWebhook: receiver.URL, WebhookSecret: "shh"})
+	t.Cleanup(h.Close)
+	conn := dial(t, startServer(t, h))
+
+	next := func() webhookPayload {
+		t.Helper()
+		select {
+		case body := <-bodies:
+			var p webhookPayload
+			if err := json.Unmarshal(body, &p); err != nil {
+				t.Fatalf("unmarshal %s: %v", body, err)
+			}
+			return p
+		case <-time.After(2 * time.Second):
+			t.Fatal("no webhook call")
+			return webhookPayload{}
+		}
+	}
+
+	// The connect call is the one that got a 500 first
+	connect := next()
+	if connect.Event != "connect" || connect.ConnID == "" || connect.Origin != allowedOrigins[0] || connect.RemoteAddr == "" || connect.ConnectedAt == "" {
+		t.Errorf("connect: got %+v", connect)
+	}
+
+	roundTrip(t, conn, "NICK:hooked")
+	_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "bye"))
+	conn.Close()
+
+	disconnect := next()
+	expected := webhookPayload{
+		Event: "disconnect", ConnID: connect.ConnID, RemoteAddr: connect.RemoteAddr, Origin: connect.Origin,
+		Nick: "hooked", ConnectedAt: connect.ConnectedAt, Timestamp: disconnect.Timestamp,
+		CloseCode: websocket.CloseGoingAway, CloseReason: "bye", Received: 1, Sent: disconnect.Sent,
+	}
+	if disconnect != expected || disconnect.Sent < 2 {
+		t.Errorf("disconnect: got %+v expected %+v", disconnect, expected)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != 3 {
+		t.Errorf("got %d calls expected 3 (one retried)", calls)
+	}
+}
+
+func TestCloseCancelsWebhookInFlight(t *testing.T) {
+	arrived := make(chan struct{}, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.ReadAll(r.Body) // so the server notices the caller hanging up
+		arrived <- struct{}{}
+		<-r.Context().Done()
+	}))
+	t.Cleanup(receiver.Close)
+
+	h := NewHandler(Options{ConnectWebhook: receiver.URL, WebhookTimeout: time.Minute})
+	dial(t, startServer(t, h))
+	select {
+	case <-arrived:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no webhook call")
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		h.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close waited for the webhook call")
+	}
+}
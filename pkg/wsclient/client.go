@@ -0,0 +1,460 @@
+// Package wsclient is a Go client for the websocket server in internal/ws.
+// It runs commands with request/response correlation, hands every frame
+// the server pushes on its own to the caller, answers the server's pings,
+// and can reconnect (resuming the session) when the connection drops.
+package wsclient
+
+// Filename: pkg/wsclient/client.go
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/alexdev404/ws-main/pkg/wsproto"
+)
+
+// Timeouts and defaults; the read timeout allows for the server's heartbeat
+const (
+	writeWait    = 5 * time.Second  // max time to complete a write
+	readWait     = 60 * time.Second // the server pings every 27s; two missed pings is dead
+	closeWait    = 2 * time.Second  // how long Close waits for the server's close frame
+	pushBuffer   = 64               // default Options.PushBuffer
+	minBackoff   = 100 * time.Millisecond
+	maxBackoff   = 10 * time.Second
+	requestIDTag = "wsclient-"
+)
+
+var (
+	// ErrClosed is returned by calls on a Client after Close, or after the
+	// connection is lost for good
+	ErrClosed = errors.New("wsclient: client closed")
+
+	// ErrConnectionLost is returned by Do when the connection dropped
+	// before the reply arrived; the command may or may not have run
+	ErrConnectionLost = errors.New("wsclient: connection lost")
+)
+
+// Options configures Dial. The zero value connects once, with no Origin.
+type Options struct {
+	// Header is sent with every upgrade request. The server checks Origin
+	// against its allowlist, so it usually needs to be set.
+	Header http.Header
+
+	// Dialer makes the connections; nil means websocket.DefaultDialer
+	Dialer *websocket.Dialer
+
+	// Reconnect redials after the connection drops, waiting MinBackoff
+	// at first and twice as long after each failure up to MaxBackoff. The
+	// last resume token is offered so the server can restore the session.
+	Reconnect  bool
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// PushBuffer is how many pushed frames are held for Pushes; a push
+	// that doesn't fit is dropped. 0 means 64.
+	PushBuffer int
+}
+
+// Push is a frame the server sent on its own rather than as the reply to
+// Do: the welcome on every (re)connection, broadcasts, ticks, presence,
+// direct messages, text echoes and stream frames.
+type Push struct {
+	MessageType int    // websocket.TextMessage or websocket.BinaryMessage
+	Type        string // the JSON frame's "type" or "command"; "" if it has neither
+	Data        []byte
+}
+
+// CommandError is the error Do returns for a response that carries one
+type CommandError struct {
+	Command string
+	Code    string // one of the server's ERR_ codes
+	Message string
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("%s: %s (%s)", e.Command, e.Message, e.Code)
+}
+
+// The fields of the server's welcome frame a client cares about
+type welcome struct {
+	ConnID      string `json:"conn_id"`
+	ResumeToken string `json:"resume_token"`
+}
+
+type result struct {
+	resp wsproto.CommandResponse
+	err  error
+}
+
+// Client is one logical connection to the server, possibly spanning
+// several websocket connections when Reconnect is set. It is safe for
+// concurrent use.
+type Client struct {
+	url  string
+	opts Options
+
+	writeMu sync.Mutex // gorilla/websocket allows one writer at a time
+
+	mu        sync.Mutex
+	conn      *websocket.Conn        // nil while reconnecting
+	connected chan struct{}          // closed while conn is usable
+	connID    string                 // from the latest welcome
+	token     string                 // resume token from the latest welcome
+	pending   map[string]chan result // request id -> waiting Do
+	closed    bool
+	err       error // why the client stopped; see Err
+
+	nextID atomic.Uint64
+	pushes chan Push
+	quit   chan struct{} // closed by Close
+	done   chan struct{} // closed when the read side has stopped for good
+}
+
+// Dial connects to the server at url (ws:// or wss://) and waits for its
+// welcome frame
+func Dial(ctx context.Context, url string, opts Options) (*Client, error) {
+	if opts.Dialer == nil {
+		opts.Dialer = websocket.DefaultDialer
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = minBackoff
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = max(maxBackoff, opts.MinBackoff)
+	}
+	if opts.PushBuffer <= 0 {
+		opts.PushBuffer = pushBuffer
+	}
+	c := &Client{
+		url:       url,
+		opts:      opts,
+		connected: make(chan struct{}),
+		pending:   make(map[string]chan result),
+		pushes:    make(chan Push, opts.PushBuffer),
+		quit:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	go c.run(conn)
+	return c, nil
+}
+
+// Open a websocket, resuming the last session if there was one, and read
+// its welcome
+func (c *Client) dial(ctx context.Context) (*websocket.Conn, error) {
+	u := c.url
+	if c.token != "" {
+		parsed, err := url.Parse(u)
+		if err != nil {
+			return nil, err
+		}
+		q := parsed.Query()
+		q.Set("resume", c.token)
+		parsed.RawQuery = q.Encode()
+		u = parsed.String()
+	}
+	conn, _, err := c.opts.Dialer.DialContext(ctx, u, c.opts.Header)
+	if err != nil {
+		return nil, err
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(writeWait))
+	mt, msg, err := conn.ReadMessage()
+	var w welcome
+	if err == nil {
+		err = json.Unmarshal(msg, &w)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("wsclient: read welcome: %w", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(readWait))
+	conn.SetPingHandler(func(appData string) error {
+		_ = conn.SetReadDeadline(time.Now().Add(readWait))
+		err := conn.WriteControl(websocket.PongMessage, []byte(appData), time.Now().Add(writeWait))
+		if errors.Is(err, websocket.ErrCloseSent) {
+			return nil
+		}
+		return err
+	})
+
+	c.mu.Lock()
+	if c.closed {
+		// Close was called while this dial was in flight
+		c.mu.Unlock()
+		conn.Close()
+		return nil, ErrClosed
+	}
+	c.conn, c.connID, c.token = conn, w.ConnID, w.ResumeToken
+	close(c.connected)
+	c.mu.Unlock()
+	c.push(Push{MessageType: mt, Type: "welcome", Data: msg})
+	return conn, nil
+}
+
+// Read until the connection drops, then reconnect or give up
+func (c *Client) run(conn *websocket.Conn) {
+	defer close(c.done)
+	defer close(c.pushes)
+	for {
+		err := c.read(conn)
+
+		c.mu.Lock()
+		c.conn, c.connected = nil, make(chan struct{})
+		closed := c.closed
+		c.mu.Unlock()
+		conn.Close()
+		c.failPending(ErrConnectionLost)
+		if closed || !c.opts.Reconnect {
+			c.shutdown(closed, err)
+			return
+		}
+		if conn = c.reconnect(); conn == nil {
+			return
+		}
+	}
+}
+
+// Redial with exponential backoff until it works or Close is called
+func (c *Client) reconnect() *websocket.Conn {
+	backoff := c.opts.MinBackoff
+	for {
+		select {
+		case <-time.After(backoff):
+		case <-c.quit:
+			return nil
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), c.opts.Dialer.HandshakeTimeout+writeWait)
+		conn, err := c.dial(ctx)
+		cancel()
+		if err == nil {
+			return conn
+		}
+		backoff = min(backoff*2, c.opts.MaxBackoff)
+	}
+}
+
+// Route every frame on conn to the Do waiting for it or to Pushes until
+// reading fails
+func (c *Client) read(conn *websocket.Conn) error {
+	for {
+		mt, msg, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(readWait))
+
+		var head struct {
+			Type    string `json:"type"`
+			Command string `json:"command"`
+			ID      string `json:"id"`
+		}
+		if mt != websocket.TextMessage || json.Unmarshal(msg, &head) != nil {
+			c.push(Push{MessageType: mt, Data: msg})
+			continue
+		}
+		if head.ID != "" && head.Command != "" && c.deliver(head.ID, msg) {
+			continue
+		}
+		typ := head.Type
+		if typ == "" {
+			typ = head.Command
+		}
+		c.push(Push{MessageType: mt, Type: typ, Data: msg})
+	}
+}
+
+// Hand msg to the Do waiting for id; false if nobody is
+func (c *Client) deliver(id string, msg []byte) bool {
+	c.mu.Lock()
+	ch, ok := c.pending[id]
+	delete(c.pending, id)
+	c.mu.Unlock()
+	if !ok {
+		return false
+	}
+	var r result
+	r.err = json.Unmarshal(msg, &r.resp)
+	ch <- r
+	return true
+}
+
+func (c *Client) push(p Push) {
+	select {
+	case c.pushes <- p:
+	default:
+	}
+}
+
+// Fail every Do still waiting for a reply
+func (c *Client) failPending(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, ch := range c.pending {
+		ch <- result{err: err}
+		delete(c.pending, id)
+	}
+}
+
+// Mark the client closed for good so calls waiting for a connection give
+// up. err ended the connection; it's only kept if Close didn't.
+func (c *Client) shutdown(byClose bool, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	if !byClose {
+		c.err = err
+	}
+}
+
+// The current connection, waiting for a reconnect if there's none
+func (c *Client) waitConn(ctx context.Context) (*websocket.Conn, error) {
+	for {
+		c.mu.Lock()
+		conn, connected, closed := c.conn, c.connected, c.closed
+		c.mu.Unlock()
+		if closed {
+			return nil, ErrClosed
+		}
+		if conn != nil {
+			return conn, nil
+		}
+		select {
+		case <-connected:
+		case <-c.done:
+			return nil, ErrClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (c *Client) write(ctx context.Context, messageType int, data []byte) error {
+	conn, err := c.waitConn(ctx)
+	if err != nil {
+		return err
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	deadline := time.Now().Add(writeWait)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = conn.SetWriteDeadline(deadline)
+	return conn.WriteMessage(messageType, data)
+}
+
+// SendText sends a text frame. Whatever the server answers, such as the
+// echo of plain text, arrives on Pushes.
+func (c *Client) SendText(ctx context.Context, text string) error {
+	return c.write(ctx, websocket.TextMessage, []byte(text))
+}
+
+// Do runs req and waits for its reply, matched by req.ID; an empty ID is
+// filled in. A reply carrying an error is returned along with a
+// *CommandError. Stream commands like "count" return their first frame
+// here; the rest arrive on Pushes.
+func (c *Client) Do(ctx context.Context, req wsproto.CommandRequest) (wsproto.CommandResponse, error) {
+	if req.ID == "" {
+		req.ID = requestIDTag + strconv.FormatUint(c.nextID.Add(1), 10)
+	}
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return wsproto.CommandResponse{}, err
+	}
+
+	ch := make(chan result, 1)
+	c.mu.Lock()
+	c.pending[req.ID] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, req.ID)
+		c.mu.Unlock()
+	}()
+
+	if err := c.write(ctx, websocket.TextMessage, payload); err != nil {
+		return wsproto.CommandResponse{}, err
+	}
+	select {
+	case r := <-ch:
+		if r.err == nil && r.resp.Error != "" {
+			r.err = &CommandError{Command: r.resp.Command, Code: r.resp.Code, Message: r.resp.Error}
+		}
+		return r.resp, r.err
+	case <-ctx.Done():
+		return wsproto.CommandResponse{}, ctx.Err()
+	}
+}
+
+// Pushes delivers the frames that aren't replies to Do. It is closed once
+// the client is closed or the connection is lost without Reconnect.
+func (c *Client) Pushes() <-chan Push {
+	return c.pushes
+}
+
+// Done is closed once the client has stopped for good: after Close, or
+// when the connection is lost without Reconnect
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err reports why the client stopped: nil if it was closed with Close,
+// otherwise the error that ended the connection, a *websocket.CloseError
+// when the server closed it. Only meaningful once Done is closed.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// ConnID is the server's name for the current (or last) connection
+func (c *Client) ConnID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connID
+}
+
+// Close sends a normal close frame, waits briefly for the server to answer
+// it, and releases the connection. Calls waiting on the client return
+// ErrClosed or ErrConnectionLost.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	conn := c.conn
+	close(c.quit)
+	c.mu.Unlock()
+
+	var err error
+	if conn != nil {
+		err = conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(writeWait))
+		select {
+		case <-c.done:
+		case <-time.After(closeWait):
+			conn.Close()
+		}
+	}
+	<-c.done
+	if errors.Is(err, websocket.ErrCloseSent) {
+		err = nil
+	}
+	return err
+}
@@ -0,0 +1,212 @@
+// Filename: pkg/wsclient/client_test.go
+
+package wsclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/alexdev404/ws-main/internal/ws"
+	"github.com/alexdev404/ws-main/pkg/wsproto"
+)
+
+const testOrigin = "http://localhost:4000"
+
+// Serve h under httptest and return its ws:// URL
+func startServer(t *testing.T, h http.Handler) string {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func dial(t *testing.T, url string, opts Options) *Client {
+	t.Helper()
+	opts.Header = http.Header{"Origin": {testOrigin}}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	c, err := Dial(ctx, url, opts)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func do(t *testing.T, c *Client, req wsproto.CommandRequest) (wsproto.CommandResponse, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	return c.Do(ctx, req)
+}
+
+// Wait for the next push of this type
+func nextPush(t *testing.T, c *Client, typ string) Push {
+	t.Helper()
+	timeout := time.After(3 * time.Second)
+	for {
+		select {
+		case p, ok := <-c.Pushes():
+			if !ok {
+				t.Fatalf("pushes closed waiting for %q", typ)
+			}
+			if p.Type == typ {
+				return p
+			}
+		case <-timeout:
+			t.Fatalf("no %q push", typ)
+		}
+	}
+}
+
+func TestDoRoundTrip(t *testing.T) {
+	c := dial(t, startServer(t, ws.NewHandler(ws.Options{})), Options{})
+	if p := nextPush(t, c, "welcome"); !strings.Contains(string(p.Data), c.ConnID()) {
+		t.Errorf("welcome %s doesn't name %s", p.Data, c.ConnID())
+	}
+
+	resp, err := do(t, c, wsproto.CommandRequest{Command: "add", A: wsproto.Num(2), B: wsproto.Num(3)})
+	if err != nil || resp.Result == nil || *resp.Result != 5 || !strings.HasPrefix(resp.ID, requestIDTag) {
+		t.Fatalf("add: got %+v, %v", resp, err)
+	}
+	resp, err = do(t, c, wsproto.CommandRequest{Command: "multiply", ID: "mine", A: wsproto.Operand{Ref: wsproto.Ans}, B: wsproto.Num(2)})
+	if err != nil || resp.ID != "mine" || *resp.Result != 10 {
+		t.Fatalf("multiply: got %+v, %v", resp, err)
+	}
+
+	_, err = do(t, c, wsproto.CommandRequest{Command: "divide", A: wsproto.Num(1), B: wsproto.Num(0)})
+	var cmdErr *CommandError
+	if !errors.As(err, &cmdErr) || cmdErr.Code != ws.ErrCodeDivByZero {
+		t.Errorf("divide by zero: got %v", err)
+	}
+
+	// Plain text comes back as a push
+	if err := c.SendText(context.Background(), "hello"); err != nil {
+		t.Fatalf("send text: %v", err)
+	}
+	if p := nextPush(t, c, ""); !strings.HasSuffix(string(p.Data), "hello") {
+		t.Errorf("echo: got %s", p.Data)
+	}
+}
+
+func TestConcurrentDo(t *testing.T) {
+	c := dial(t, startServer(t, ws.NewHandler(ws.Options{})), Options{})
+
+	var wg sync.WaitGroup
+	for g := 0; g < 10; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				a := float64(g*100 + i)
+				resp, err := do(t, c, wsproto.CommandRequest{Command: "add", A: wsproto.Num(a), B: wsproto.Num(1)})
+				if err != nil || resp.Result == nil || *resp.Result != a+1 {
+					t.Errorf("add %v+1: got %+v, %v", a, resp, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestReconnectResumesSession(t *testing.T) {
+	c := dial(t, startServer(t, ws.NewHandler(ws.Options{})), Options{Reconnect: true, MinBackoff: 10 * time.Millisecond})
+	first := c.ConnID()
+	if _, err := do(t, c, wsproto.CommandRequest{Command: "set", Name: "x", A: wsproto.Num(7)}); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	nextPush(t, c, "welcome")
+
+	// A frame over the server's read limit gets the connection closed with 1009
+	if err := c.SendText(context.Background(), strings.Repeat("x", 8<<10)); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	var w struct {
+		Resumed bool `json:"resumed"`
+	}
+	if err := json.Unmarshal(nextPush(t, c, "welcome").Data, &w); err != nil || !w.Resumed {
+		t.Fatalf("reconnect welcome: resumed=%v, %v", w.Resumed, err)
+	}
+	if c.ConnID() == first {
+		t.Errorf("still on %s after reconnecting", first)
+	}
+	resp, err := do(t, c, wsproto.CommandRequest{Command: "get", Name: "x"})
+	if err != nil || resp.Result == nil || *resp.Result != 7 {
+		t.Errorf("get after reconnect: got %+v, %v", resp, err)
+	}
+}
+
+func TestReconnectAfterKick(t *testing.T) {
+	h := ws.NewHandler(ws.Options{})
+	mux := http.NewServeMux()
+	mux.Handle("/ws", h)
+	mux.Handle("/admin/", h.AdminHandler("secret"))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	c := dial(t, "ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", Options{Reconnect: true, MinBackoff: 10 * time.Millisecond})
+	nextPush(t, c, "welcome")
+	req, _ := http.NewRequest(http.MethodDelete, srv.URL+"/admin/connections/"+c.ConnID(), nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil || res.StatusCode != http.StatusNoContent {
+		t.Fatalf("kick: %v %v", res, err)
+	}
+	res.Body.Close()
+
+	// Kicked sessions aren't resumable, so this is a fresh one
+	if p := nextPush(t, c, "welcome"); !strings.Contains(string(p.Data), `"resume_failed":true`) {
+		t.Errorf("reconnect welcome: got %s", p.Data)
+	}
+	if resp, err := do(t, c, wsproto.CommandRequest{Command: "add", A: wsproto.Num(1), B: wsproto.Num(1)}); err != nil || *resp.Result != 2 {
+		t.Errorf("add after reconnect: got %+v, %v", resp, err)
+	}
+}
+
+func TestCloseCompletesHandshake(t *testing.T) {
+	c := dial(t, startServer(t, ws.NewHandler(ws.Options{})), Options{Reconnect: true})
+	done := make(chan error, 1)
+	go func() { done <- c.Close() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("close: %v", err)
+		}
+	case <-time.After(closeWait):
+		t.Fatal("close waited for its timeout instead of the server's close frame")
+	}
+
+	if _, ok := <-c.Pushes(); ok {
+		for range c.Pushes() {
+		}
+	}
+	if _, err := do(t, c, wsproto.CommandRequest{Command: "ping"}); !errors.Is(err, ErrClosed) {
+		t.Errorf("do after close: got %v expected ErrClosed", err)
+	}
+}
+
+func TestErrReportsServerClose(t *testing.T) {
+	c := dial(t, startServer(t, ws.NewHandler(ws.Options{})), Options{})
+	if err := c.SendText(context.Background(), strings.Repeat("x", 8<<10)); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	select {
+	case <-c.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("client still running after the server closed")
+	}
+	var closeErr *websocket.CloseError
+	if !errors.As(c.Err(), &closeErr) || closeErr.Code != websocket.CloseMessageTooBig {
+		t.Errorf("got %v expected a 1009 close", c.Err())
+	}
+}
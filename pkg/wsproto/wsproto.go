@@ -0,0 +1,291 @@
+// Package wsproto holds the command types the websocket server speaks, so
+// the server and Go clients (see pkg/wsclient) encode them the same way.
+package wsproto
+
+// Filename: pkg/wsproto/wsproto.go
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/vmihailenco/msgpack/v5/msgpcode"
+)
+
+// Ans is the operand name that refers to the previous result on the connection
+const Ans = "ans"
+
+// CommandRequest is a JSON command sent by the client in a text frame
+type CommandRequest struct {
+	Command    string  `json:"command"`
+	ID         string  `json:"id,omitempty"`
+	A          Operand `json:"a"`
+	B          Operand `json:"b"`
+	AVar       string  `json:"a_var,omitempty"`
+	BVar       string  `json:"b_var,omitempty"`
+	Name       string  `json:"name,omitempty"`
+	Limit      int     `json:"limit,omitempty"`
+	From       string  `json:"from,omitempty"` // convert's source unit
+	To         string  `json:"to,omitempty"`   // convert's target unit, or dm's recipient
+	FromNum    Operand `json:"-"`              // count's lower bound, sent as a numeric "from"
+	ToNum      Operand `json:"-"`              // count's upper bound, sent as a numeric "to"
+	IntervalMS int     `json:"interval_ms"`
+	Enabled    *bool   `json:"enabled,omitempty"`
+	Text       string  `json:"text,omitempty"`
+	Op         string  `json:"op,omitempty"`        // transform's operation
+	Algo       string  `json:"algo,omitempty"`      // hash and hmac's digest
+	Key        string  `json:"key,omitempty"`       // hmac's key
+	URLSafe    bool    `json:"urlsafe,omitempty"`   // b64encode/b64decode use the URL-safe alphabet
+	Raw        bool    `json:"raw,omitempty"`       // b64decode returns hex, for bytes that aren't UTF-8
+	TZ         string  `json:"tz,omitempty"`        // time's IANA zone
+	Format     string  `json:"format,omitempty"`    // time's output format
+	Unit       string  `json:"unit,omitempty"`      // the trig commands' angle unit, rad or deg
+	AStr       string  `json:"a_str,omitempty"`     // a as a decimal string, for precise arithmetic
+	BStr       string  `json:"b_str,omitempty"`     // b as a decimal string, for precise arithmetic
+	Precision  int     `json:"precision,omitempty"` // mantissa bits for precise arithmetic
+	Mode       string  `json:"mode,omitempty"`      // "int" for int64 arithmetic; "" or "float" for float64
+	Timing     bool    `json:"timing,omitempty"`    // add duration_us to the reply
+	Size       int64   `json:"size,omitempty"`      // upload's length in bytes
+	Chunks     int     `json:"chunks,omitempty"`    // upload's binary frame count
+	SHA256     string  `json:"sha256,omitempty"`    // upload's expected hash, hex
+	Ack        bool    `json:"ack,omitempty"`       // broadcast and dm report back who acked
+	MsgID      string  `json:"msg_id,omitempty"`    // the message ack acknowledges
+	Offset     int     `json:"offset,omitempty"`    // who's first entry
+	Room       string  `json:"room,omitempty"`      // who's filter
+
+	// A retry with the same key gets the first attempt's response back,
+	// marked replayed, instead of running again
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+// "from" and "to" hold names for convert and dm but numbers for count, so
+// a string lands in From or To and a number in FromNum or ToNum
+type requestFields CommandRequest // CommandRequest without its methods
+
+type requestWire struct {
+	From interface{} `json:"from,omitempty"` // ahead of requestFields, to shadow its fields in msgpack
+	To   interface{} `json:"to,omitempty"`
+	requestFields
+}
+
+type requestRawMsgpack struct {
+	From msgpack.RawMessage `json:"from"`
+	To   msgpack.RawMessage `json:"to"`
+	requestFields
+}
+
+// The string if there is one, else the number, else nothing
+func wireBound(name string, num Operand) interface{} {
+	switch {
+	case name != "":
+		return name
+	case num != Operand{}:
+		return num
+	}
+	return nil
+}
+
+func (r CommandRequest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(requestWire{wireBound(r.From, r.FromNum), wireBound(r.To, r.ToNum), requestFields(r)})
+}
+
+func (r *CommandRequest) UnmarshalJSON(b []byte) error {
+	// Named after the type it decodes, since decode errors go back to
+	// clients and name it
+	type CommandRequest struct {
+		From json.RawMessage `json:"from"`
+		To   json.RawMessage `json:"to"`
+		requestFields
+	}
+	var raw CommandRequest
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	*(*requestFields)(r) = raw.requestFields
+	if err := splitJSONBound(raw.From, &r.From, &r.FromNum); err != nil {
+		return fmt.Errorf("from: %w", err)
+	}
+	if err := splitJSONBound(raw.To, &r.To, &r.ToNum); err != nil {
+		return fmt.Errorf("to: %w", err)
+	}
+	return nil
+}
+
+func splitJSONBound(b json.RawMessage, name *string, num *Operand) error {
+	b = bytes.TrimSpace(b)
+	switch {
+	case len(b) == 0 || bytes.Equal(b, []byte("null")):
+		return nil
+	case b[0] == '"':
+		return json.Unmarshal(b, name)
+	}
+	return num.UnmarshalJSON(b)
+}
+
+func (r CommandRequest) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return enc.Encode(requestWire{wireBound(r.From, r.FromNum), wireBound(r.To, r.ToNum), requestFields(r)})
+}
+
+func (r *CommandRequest) DecodeMsgpack(dec *msgpack.Decoder) error {
+	var raw requestRawMsgpack
+	if err := dec.Decode(&raw); err != nil {
+		return err
+	}
+	*r = CommandRequest(raw.requestFields)
+	if err := splitMsgpackBound(raw.From, &r.From, &r.FromNum); err != nil {
+		return fmt.Errorf("from: %w", err)
+	}
+	if err := splitMsgpackBound(raw.To, &r.To, &r.ToNum); err != nil {
+		return fmt.Errorf("to: %w", err)
+	}
+	return nil
+}
+
+func splitMsgpackBound(b msgpack.RawMessage, name *string, num *Operand) error {
+	switch {
+	case len(b) == 0 || b[0] == msgpcode.Nil:
+		return nil
+	case msgpcode.IsString(b[0]):
+		return msgpack.Unmarshal(b, name)
+	}
+	return msgpack.Unmarshal(b, num)
+}
+
+// CommandResponse is the JSON reply to a CommandRequest
+type CommandResponse struct {
+	Command   string      `json:"command"`
+	ID        string      `json:"id,omitempty"`
+	Result    *float64    `json:"result,omitempty"`
+	ResultStr string      `json:"result_str,omitempty"` // precise arithmetic's result, in decimal
+	IntResult *int64      `json:"int_result,omitempty"` // int mode's result
+	Remainder *int64      `json:"remainder,omitempty"`  // int mode division's remainder
+	Text      string      `json:"text,omitempty"`       // a string result, such as transform's or hash's
+	Done      bool        `json:"done,omitempty"`
+	Data      interface{} `json:"data,omitempty"`
+	Error     string      `json:"error,omitempty"`
+	Code      string      `json:"code,omitempty"`
+	Replayed  bool        `json:"replayed,omitempty"` // answered from the idempotency_key cache
+
+	ServerTime string `json:"server_time,omitempty"`
+
+	// Server-side time spent on the command, in microseconds; only when
+	// the request or the server asks for it
+	DurationUS *int64 `json:"duration_us,omitempty"`
+
+	// When the server read the frame being answered; only in debug mode
+	ReceivedAt string `json:"received_at,omitempty"`
+
+	// Numbers of the frame being answered: per connection (from 1) and
+	// across the server. Unset on frames the server pushes by itself.
+	Seq       uint64 `json:"seq,omitempty"`
+	GlobalSeq uint64 `json:"global_seq,omitempty"`
+}
+
+// Operand is a numeric command argument. Besides a JSON number it accepts
+// a string naming a value held by the session, such as "ans".
+type Operand struct {
+	Value float64
+	Ref   string // non-empty when the operand names a session value
+
+	// A whole number that fits in an int64 is also held exactly in Int,
+	// since Value can't tell 9007199254740993 from 9007199254740992
+	Int   int64
+	IsInt bool
+}
+
+// Num returns a literal numeric Operand
+func Num(v float64) Operand {
+	o := Operand{Value: v}
+	if v == math.Trunc(v) && v >= math.MinInt64 && v < math.MaxInt64 {
+		o.Int, o.IsInt = int64(v), true
+	}
+	return o
+}
+
+// Integer returns a literal Operand holding v exactly
+func Integer(v int64) Operand {
+	return Operand{Value: float64(v), Int: v, IsInt: true}
+}
+
+func (o *Operand) UnmarshalJSON(b []byte) error {
+	var ref string
+	if err := json.Unmarshal(b, &ref); err == nil {
+		*o = Operand{Ref: ref}
+		return nil
+	}
+	var v float64
+	if err := json.Unmarshal(b, &v); err != nil {
+		return fmt.Errorf("operand must be a number or %q", Ans)
+	}
+	if i, err := strconv.ParseInt(string(bytes.TrimSpace(b)), 10, 64); err == nil {
+		*o = Integer(i)
+		return nil
+	}
+	*o = Num(v)
+	return nil
+}
+
+func (o Operand) MarshalJSON() ([]byte, error) {
+	switch {
+	case o.Ref != "":
+		return json.Marshal(o.Ref)
+	case o.IsInt:
+		return json.Marshal(o.Int)
+	}
+	return json.Marshal(o.Value)
+}
+
+// Operands are a number or a string reference, same as in JSON
+func (o Operand) EncodeMsgpack(enc *msgpack.Encoder) error {
+	switch {
+	case o.Ref != "":
+		return enc.EncodeString(o.Ref)
+	case o.IsInt:
+		return enc.EncodeInt(o.Int)
+	}
+	return enc.EncodeFloat64(o.Value)
+}
+
+func (o *Operand) DecodeMsgpack(dec *msgpack.Decoder) error {
+	code, err := dec.PeekCode()
+	if err != nil {
+		return err
+	}
+	switch {
+	case code == msgpcode.Nil:
+		*o = Operand{}
+		return dec.DecodeNil()
+	case msgpcode.IsString(code):
+		ref, err := dec.DecodeString()
+		*o = Operand{Ref: ref}
+		return err
+	case msgpcode.IsFixedNum(code) || code >= msgpcode.Int8 && code <= msgpcode.Int64 || code >= msgpcode.Uint8 && code <= msgpcode.Uint32:
+		i, err := dec.DecodeInt64()
+		if err != nil {
+			return fmt.Errorf("operand must be a number or %q", Ans)
+		}
+		*o = Integer(i)
+		return nil
+	case code == msgpcode.Uint64:
+		u, err := dec.DecodeUint64()
+		if err != nil {
+			return fmt.Errorf("operand must be a number or %q", Ans)
+		}
+		if u > math.MaxInt64 {
+			*o = Num(float64(u))
+		} else {
+			*o = Integer(int64(u))
+		}
+		return nil
+	default:
+		v, err := dec.DecodeFloat64()
+		if err != nil {
+			return fmt.Errorf("operand must be a number or %q", Ans)
+		}
+		*o = Num(v)
+		return nil
+	}
+}